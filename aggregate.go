@@ -0,0 +1,274 @@
+package wendy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+var aggregateTimeoutError = errors.New("Timed out waiting for the answer to the aggregate query.")
+var aggregateMessageError = errors.New("An aggregate query message was malformed.")
+
+const (
+	aggregateRoot   = byte(iota) // a query routed towards its key, to be answered by the whole Cluster
+	aggregateQuery               // a query sent down the tree, to be answered by the Nodes sharing a prefix
+	aggregateAnswer              // the combined answer of a Node and the Nodes below it in the tree
+)
+
+// aggregateHeaderSize is the size of the header of aggregate Messages: the kind, the ID, the level, and the deadline.
+const aggregateHeaderSize = 1 + 8 + 1 + 8
+
+// aggregator is the pair of functions registered with HandleAggregate for a purpose.
+type aggregator struct {
+	answer func(query []byte) []byte
+	reduce func(a, b []byte) []byte
+}
+
+// aggregateChild is a Node a query is sent on to, and the level it should fan the query out from.
+type aggregateChild struct {
+	node  *Node
+	level int
+}
+
+// aggregateMessage is the payload of aggregate Messages.
+type aggregateMessage struct {
+	Kind     byte
+	ID       uint64 // matches answers to the queries they answer
+	Level    int    // the routing table row the Node should fan the query out from
+	Deadline int64  // when the answer is due, in nanoseconds since the Unix epoch
+	Value    []byte // the query, or the answer
+}
+
+func (m aggregateMessage) marshal() []byte {
+	data := make([]byte, aggregateHeaderSize, aggregateHeaderSize+len(m.Value))
+	data[0] = m.Kind
+	binary.BigEndian.PutUint64(data[1:], m.ID)
+	data[9] = byte(m.Level)
+	binary.BigEndian.PutUint64(data[10:], uint64(m.Deadline))
+	return append(data, m.Value...)
+}
+
+func unmarshalAggregateMessage(data []byte) (aggregateMessage, error) {
+	if len(data) < aggregateHeaderSize || data[0] > aggregateAnswer {
+		return aggregateMessage{}, aggregateMessageError
+	}
+	return aggregateMessage{
+		Kind:     data[0],
+		ID:       binary.BigEndian.Uint64(data[1:]),
+		Level:    int(data[9]),
+		Deadline: int64(binary.BigEndian.Uint64(data[10:])),
+		Value:    data[aggregateHeaderSize:],
+	}, nil
+}
+
+// HandleAggregate registers the functions that answer queries sent with Aggregate for the specified purpose. answer returns the current Node's own answer to a query, and reduce combines two answers into one; reduce should give the same result whatever order answers are combined in, such as a sum or a maximum. Every Node in the Cluster should register the same functions, or the Nodes that don't, and the Nodes below them in the tree, are left out of the answer.
+//
+// The purpose is used for the query and its answers, and is handled like a purpose passed to Handle, so its Messages aren't passed to OnDeliver. Passing a nil answer or reduce removes the functions registered for the purpose.
+func (c *Cluster) HandleAggregate(purpose byte, answer func(query []byte) []byte, reduce func(a, b []byte) []byte) error {
+	if purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	if answer == nil || reduce == nil {
+		c.lock.Lock()
+		delete(c.aggregators, purpose)
+		c.lock.Unlock()
+		return c.Handle(purpose, nil)
+	}
+	c.lock.Lock()
+	if c.aggregators == nil {
+		c.aggregators = map[byte]*aggregator{}
+	}
+	c.aggregators[purpose] = &aggregator{answer: answer, reduce: reduce}
+	c.lock.Unlock()
+	return c.Handle(purpose, c.onAggregateMessage)
+}
+
+func (c *Cluster) getAggregator(purpose byte) *aggregator {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.aggregators[purpose]
+}
+
+// Aggregate asks every Node in the Cluster a query, and returns their answers combined by the functions registered with HandleAggregate for the purpose. The query is routed towards key, and the Node responsible for it fans the query out down a tree built from the Nodes' routing tables: each Node sends it to one Node for each prefix longer than its own that it knows of, and answers with its own answer combined with theirs. Every Node is reached in O(log n) hops, and no Node has to hear from more than a routing table's worth of others, so queries like counts or maxima are cheap even in large Clusters.
+//
+// Aggregate waits until ctx is done, or for the network timeout set with SetNetworkTimeout if ctx has no deadline. Nodes that don't answer in time are left out of the answer, and counted in Stats. The current Node must have registered functions for the purpose with HandleAggregate.
+func (c *Cluster) Aggregate(ctx context.Context, purpose byte, key NodeID, query []byte) ([]byte, error) {
+	if purpose < FirstUserPurpose {
+		return nil, throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	if c.getAggregator(purpose) == nil {
+		return nil, throwInvalidArgumentError(fmt.Sprintf("No aggregate functions are registered for purpose %d.", purpose))
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Duration(c.getNetworkTimeout()) * time.Second)
+	}
+	id, answered := c.awaitAggregate()
+	defer c.forgetAggregate(id)
+	root := aggregateMessage{Kind: aggregateRoot, ID: id, Deadline: deadline.UnixNano(), Value: query}
+	err := c.routeMessage(c.NewMessage(purpose, key, root.marshal()))
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case answer := <-answered:
+		return answer, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(deadline)):
+		return nil, aggregateTimeoutError
+	case <-c.ctx.Done():
+		return nil, deadNodeError
+	}
+}
+
+// awaitAggregate picks an ID for a query, returning it with the channel its answer will be sent on.
+func (c *Cluster) awaitAggregate() (uint64, chan []byte) {
+	answered := make(chan []byte, 1)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.aggregates == nil {
+		c.aggregates = map[uint64]chan []byte{}
+	}
+	id := uint64(rand.Int63())
+	for c.aggregates[id] != nil {
+		id = uint64(rand.Int63())
+	}
+	c.aggregates[id] = answered
+	return id, answered
+}
+
+func (c *Cluster) forgetAggregate(id uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.aggregates, id)
+}
+
+// onAggregateMessage handles the queries and answers sent for a purpose registered with HandleAggregate.
+func (c *Cluster) onAggregateMessage(msg Message) {
+	agg, err := unmarshalAggregateMessage(msg.Value)
+	if err != nil {
+		c.warn("Discarding aggregate message from %s: %s", msg.Sender.ID, err)
+		return
+	}
+	if agg.Kind == aggregateAnswer {
+		c.lock.RLock()
+		answered, ok := c.aggregates[agg.ID]
+		c.lock.RUnlock()
+		if !ok {
+			c.debug("Discarding answer to aggregate query %d from %s: no longer waiting for it.", agg.ID, msg.Sender.ID)
+			return
+		}
+		select {
+		case answered <- agg.Value:
+		default:
+		}
+		return
+	}
+	// answering waits on other Nodes, which mustn't hold up the answers this handler passes on
+	go c.answerAggregate(msg, agg)
+}
+
+// answerAggregate answers a query for the Nodes below the current Node in the tree, and sends the answer back to the Node that asked.
+func (c *Cluster) answerAggregate(msg Message, query aggregateMessage) {
+	fns := c.getAggregator(msg.Purpose)
+	if fns == nil {
+		return
+	}
+	if query.Kind == aggregateRoot {
+		// leave time for the answer to get back to the Node that asked
+		query.Level = 0
+		query.Deadline = shortenDeadline(query.Deadline)
+	}
+	answer := c.gatherAggregate(msg.Purpose, fns, query)
+	response := aggregateMessage{Kind: aggregateAnswer, ID: query.ID, Value: answer}
+	reply := c.NewMessage(msg.Purpose, msg.Sender.ID, response.marshal())
+	reply.Direct = true
+	if msg.Sender.ID.Equals(c.self.ID) {
+		c.deliver(reply)
+		return
+	}
+	sender := msg.Sender
+	err := c.send(reply, &sender)
+	if err != nil {
+		c.debug("Couldn't answer aggregate query %d from %s: %s", query.ID, sender.ID, err)
+	}
+}
+
+// gatherAggregate combines the current Node's answer to a query with the answers of one Node for each prefix, starting at the query's level, that the current Node knows of. Each of those Nodes answers for the Nodes sharing its prefix in turn. Nodes that don't answer before the deadline are left out.
+func (c *Cluster) gatherAggregate(purpose byte, fns *aggregator, query aggregateMessage) []byte {
+	answer := fns.answer(query.Value)
+	children := c.aggregateChildren(query.Level)
+	if len(children) < 1 {
+		return answer
+	}
+	deadline := time.Unix(0, query.Deadline)
+	// leave time for the answers to get back up the tree
+	childDeadline := shortenDeadline(query.Deadline)
+	answers := make(chan []byte, len(children))
+	for _, child := range children {
+		go func(child aggregateChild) {
+			id, answered := c.awaitAggregate()
+			defer c.forgetAggregate(id)
+			sub := aggregateMessage{Kind: aggregateQuery, ID: id, Level: child.level, Deadline: childDeadline, Value: query.Value}
+			msg := c.NewMessage(purpose, child.node.ID, sub.marshal())
+			msg.Direct = true
+			err := c.send(msg, child.node)
+			if err != nil {
+				c.debug("Couldn't send aggregate query to %s: %s", child.node.ID, err)
+				answers <- nil
+				return
+			}
+			select {
+			case answer := <-answered:
+				answers <- answer
+			case <-time.After(time.Until(deadline)):
+				answers <- nil
+			}
+		}(child)
+	}
+	for range children {
+		sub := <-answers
+		if sub == nil {
+			atomic.AddUint64(&c.stats.AggregateMisses, 1)
+			continue
+		}
+		answer = fns.reduce(answer, sub)
+	}
+	return answer
+}
+
+// shortenDeadline returns a deadline three quarters of the way from now to the specified one, in nanoseconds since the Unix epoch.
+func shortenDeadline(deadline int64) int64 {
+	now := time.Now().UnixNano()
+	return now + (deadline-now)*3/4
+}
+
+// aggregateChildren returns the Nodes a query at the specified level is sent on to. Every Node sharing a prefix of at least level digits with the current Node shares a longer prefix with exactly one of them, so the children between them cover every other Node below the current Node in the tree.
+func (c *Cluster) aggregateChildren(level int) []aggregateChild {
+	nodes := c.table.list([]int{}, []int{})
+	nodes = append(nodes, c.leafset.list()...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	var children []aggregateChild
+	prefixes := map[[2]int]bool{}
+	for _, node := range nodes {
+		if node == nil || node.ID.Equals(c.self.ID) {
+			continue
+		}
+		row := c.table.row(node.ID)
+		if row < level {
+			continue
+		}
+		prefix := [2]int{row, c.table.col(node.ID, row)}
+		if prefixes[prefix] {
+			continue
+		}
+		prefixes[prefix] = true
+		children = append(children, aggregateChild{node: node, level: row + 1})
+	}
+	return children
+}
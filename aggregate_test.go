@@ -0,0 +1,126 @@
+package wendy
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// count answers aggregate queries with 1, and adds answers together
+func count(cluster *Cluster) error {
+	return cluster.HandleAggregate(FirstUserPurpose, func(query []byte) []byte {
+		return []byte("1")
+	}, func(a, b []byte) []byte {
+		x, _ := strconv.Atoi(string(a))
+		y, _ := strconv.Atoi(string(b))
+		return []byte(strconv.Itoa(x + y))
+	})
+}
+
+// Test that aggregate Messages survive being encoded and decoded, and malformed ones are refused
+func TestAggregateMessage(t *testing.T) {
+	msg := aggregateMessage{Kind: aggregateQuery, ID: 1234, Level: 7, Deadline: time.Now().UnixNano(), Value: []byte("how many?")}
+	decoded, err := unmarshalAggregateMessage(msg.marshal())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decoded.Kind != msg.Kind || decoded.ID != msg.ID || decoded.Level != msg.Level || decoded.Deadline != msg.Deadline || string(decoded.Value) != string(msg.Value) {
+		t.Errorf("Expected %+v, got %+v.", msg, decoded)
+	}
+	if _, err = unmarshalAggregateMessage(msg.marshal()[:10]); err != aggregateMessageError {
+		t.Errorf("Expected a truncated message to be refused, got %v.", err)
+	}
+}
+
+// Test that a query is sent on to one Node for each prefix at or below its level
+func TestAggregateChildren(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, seed := range []string{"this is some other Node for testing purposes only.", "this is a third Node for testing purposes only.", "this is a fourth Node for testing purposes only.", "this is a fifth Node for testing purposes only."} {
+		id, err := NodeIDFromBytes([]byte(seed))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 0), StateMask{Mask: all})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	children := cluster.aggregateChildren(0)
+	prefixes := map[[2]int]bool{}
+	for _, child := range children {
+		row := cluster.table.row(child.node.ID)
+		if child.level != row+1 {
+			t.Errorf("Expected %s to fan out from level %d, got %d.", child.node.ID, row+1, child.level)
+		}
+		prefix := [2]int{row, cluster.table.col(child.node.ID, row)}
+		if prefixes[prefix] {
+			t.Errorf("Expected one child for each prefix, got two for %v.", prefix)
+		}
+		prefixes[prefix] = true
+	}
+	if len(children) < 1 {
+		t.Errorf("Expected the query to be sent on to other Nodes.")
+	}
+	if children := cluster.aggregateChildren(digitCount(cluster.table.bits)); len(children) != 0 {
+		t.Errorf("Expected no Nodes below the last level, got %d.", len(children))
+	}
+}
+
+// Test that Aggregate combines the answers of every Node in the Cluster
+func TestClusterAggregate(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	var clusters []*Cluster
+	for _, seed := range []string{"this is a test Node for testing purposes only.", "this is some other Node for testing purposes only.", "this is a third Node for testing purposes only.", "this is a fourth Node for testing purposes only."} {
+		cluster, err := makeCluster(seed)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = count(cluster)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		go cluster.Listen()
+		defer cluster.Kill()
+		clusters = append(clusters, cluster)
+	}
+	waitListening(t, clusters...)
+	for _, cluster := range clusters {
+		for _, other := range clusters {
+			if cluster == other {
+				continue
+			}
+			err := cluster.insert(*other.self, StateMask{Mask: all})
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	answer, err := clusters[1].Aggregate(ctx, FirstUserPurpose, clusters[2].self.ID, []byte("how many?"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(answer) != "4" {
+		t.Errorf("Expected 4 Nodes to answer, got %s.", answer)
+	}
+	clusters[3].HandleAggregate(FirstUserPurpose, nil, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	answer, err = clusters[1].Aggregate(ctx, FirstUserPurpose, clusters[2].self.ID, []byte("how many?"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(answer) != "3" {
+		t.Errorf("Expected the Node without aggregate functions to be left out, got %s.", answer)
+	}
+	if _, err = clusters[1].Aggregate(ctx, FirstUserPurpose+1, clusters[2].self.ID, nil); err == nil {
+		t.Errorf("Expected a purpose without aggregate functions to be refused.")
+	}
+}
@@ -0,0 +1,166 @@
+package wendy
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+)
+
+const (
+	digestBitsPerNode = 10 // with digestHashes, gives a false positive rate of roughly 1%
+	digestHashes      = 7
+)
+
+// stateDigest is a Bloom filter of the IDs of the Nodes in a Node's leaf set and routing table. It is sent in place of the tables themselves during anti-entropy, so a peer can tell which of its own entries the sender is missing.
+//
+// Seed is chosen at random for each digest, so that an entry hidden by a false positive in one round will most likely be found in the next.
+type stateDigest struct {
+	Filter []byte `json:"filter"`
+	Seed   uint64 `json:"seed"`
+}
+
+func newStateDigest(ids []NodeID, seed uint64) stateDigest {
+	bits := len(ids) * digestBitsPerNode
+	if bits < 64 {
+		bits = 64
+	}
+	digest := stateDigest{
+		Filter: make([]byte, (bits+7)/8),
+		Seed:   seed,
+	}
+	for _, id := range ids {
+		digest.add(id)
+	}
+	return digest
+}
+
+// positions calls fn with each bit of the filter that represents id.
+func (d stateDigest) positions(id NodeID, fn func(byte int, mask byte)) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, d.Seed)
+	hash := fnv.New64a()
+	hash.Write(buf)
+	hash.Write(nodeIDBytes(id))
+	sum := hash.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	bits := uint32(len(d.Filter) * 8)
+	for i := uint32(0); i < digestHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		fn(int(bit/8), byte(1)<<(bit%8))
+	}
+}
+
+func (d stateDigest) add(id NodeID) {
+	d.positions(id, func(b int, mask byte) {
+		d.Filter[b] |= mask
+	})
+}
+
+// contains returns true if id was probably added to the digest, and false if it definitely wasn't.
+func (d stateDigest) contains(id NodeID) bool {
+	if len(d.Filter) == 0 {
+		return false
+	}
+	found := true
+	d.positions(id, func(b int, mask byte) {
+		if d.Filter[b]&mask == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// syncState runs a round of anti-entropy: it sends a digest of the leaf set and routing table to a random Node from them, which replies with any entries the digest shows we're missing. This repairs tables that have diverged because messages were lost.
+func (c *Cluster) syncState() {
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	ids := []NodeID{}
+	peers := []*Node{}
+	seen := map[NodeID]bool{}
+	for _, node := range nodes {
+		if node == nil || seen[node.ID] {
+			continue
+		}
+		seen[node.ID] = true
+		ids = append(ids, node.ID)
+		peers = append(peers, node)
+	}
+	if len(peers) == 0 {
+		c.debug("No Nodes to synchronise state with.")
+		return
+	}
+	data, err := c.marshal(newStateDigest(ids, uint64(rand.Int63())))
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	msg := c.NewMessage(NODE_SYNC, c.self.ID, data)
+	peer := peers[rand.Intn(len(peers))]
+	c.debug("Sending state digest to %s", peer.ID)
+	err = c.send(msg, peer)
+	if err == deadNodeError {
+		err = c.suspect(peer)
+	}
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
+
+// A node has sent us a digest of its state tables. We need to send it the entries of our leaf set and routing table that it doesn't have.
+func (c *Cluster) onStateDigest(msg Message) {
+	c.debug("%s sent a digest of its state tables.", msg.Sender.ID)
+	var digest stateDigest
+	err := c.unmarshal(msg.Value, &digest)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	state, err := c.dumpStateTables(StateMask{Mask: rT | lS})
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	state.Delta = true
+	known := func(node *Node) bool {
+		return node.ID.Equals(msg.Sender.ID) || digest.contains(node.ID)
+	}
+	missing := 0
+	for row := range state.RoutingTable {
+		for col, node := range state.RoutingTable[row] {
+			if node == nil {
+				continue
+			}
+			if known(node) {
+				state.RoutingTable[row][col] = nil
+			} else {
+				missing++
+			}
+		}
+	}
+	for side := range state.LeafSet {
+		for pos, node := range state.LeafSet[side] {
+			if node == nil {
+				continue
+			}
+			if known(node) {
+				state.LeafSet[side][pos] = nil
+			} else {
+				missing++
+			}
+		}
+	}
+	if missing == 0 {
+		c.debug("%s isn't missing any state.", msg.Sender.ID)
+		return
+	}
+	data, err := c.marshal(state)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	c.debug("Sending %d missing state table entries to %s", missing, msg.Sender.ID)
+	err = c.send(c.NewMessage(STAT_DATA, c.self.ID, data), &msg.Sender)
+	if err != nil && err != deadNodeError {
+		c.fanOutError(err)
+	}
+}
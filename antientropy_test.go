@@ -0,0 +1,101 @@
+package wendy
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test that a state digest contains the IDs added to it, and few others
+func TestStateDigest(t *testing.T) {
+	ids := []NodeID{}
+	for i := 0; i < 100; i++ {
+		id, err := NodeIDFromBytes([]byte(strconv.Itoa(i) + " is a test Node for testing purposes only."))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		ids = append(ids, id)
+	}
+	digest := newStateDigest(ids[:50], 42)
+	var buf bytes.Buffer
+	err := ProtobufCodec{}.NewEncoder(&buf).Encode(digest)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded stateDigest
+	err = ProtobufCodec{}.NewDecoder(&buf).Decode(&decoded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decoded.Seed != digest.Seed || !bytes.Equal(decoded.Filter, digest.Filter) {
+		t.Fatalf("Expected %+v, got %+v.", digest, decoded)
+	}
+	for _, id := range ids[:50] {
+		if !decoded.contains(id) {
+			t.Errorf("Expected digest to contain %s.", id)
+		}
+	}
+	falsePositives := 0
+	for _, id := range ids[50:] {
+		if decoded.contains(id) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 5 {
+		t.Errorf("Expected at most 5 false positives, got %d.", falsePositives)
+	}
+	if (stateDigest{}).contains(ids[0]) {
+		t.Errorf("Expected an empty digest to contain nothing.")
+	}
+}
+
+// Test that an anti-entropy round fills in leaf set entries a Node is missing
+func TestClusterAntiEntropy(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	missingID, err := NodeIDFromBytes([]byte("this is a missing Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		defer one.Kill()
+		one.Listen()
+	}()
+	go func() {
+		defer two.Kill()
+		two.Listen()
+	}()
+	waitListening(t, one, two)
+	_, err = one.leafset.insertNode(*two.self)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = two.leafset.insertNode(*one.self)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = two.leafset.insertNode(*NewNode(missingID, "127.0.0.1", "127.0.0.1", "testing", 1))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.syncState()
+	deadline := time.Now().Add(3 * time.Duration(one.getNetworkTimeout()) * time.Second)
+	for time.Now().Before(deadline) {
+		node, _ := one.leafset.getNode(missingID)
+		if node != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for %s to be synchronised.", missingID)
+}
@@ -0,0 +1,154 @@
+package wendy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditEventType identifies what an AuditRecord describes.
+type AuditEventType byte
+
+const (
+	AuditJoin              AuditEventType = iota // A Node joined the Cluster through the current Node
+	AuditExit                                    // A Node announced it was leaving the Cluster, and was removed from the state tables
+	AuditEviction                                // A Node was removed from the state tables without leaving, because it couldn't be reached, was banned, or was evicted; Reason says which
+	AuditCredentialFailure                       // A Message was discarded because its credentials, signature, or encryption didn't check out; Reason says which
+	AuditJoinRefused                             // A Node wasn't let into the Cluster through the current Node; Reason says why
+)
+
+// String returns the name of the AuditEventType.
+func (t AuditEventType) String() string {
+	switch t {
+	case AuditJoin:
+		return "join"
+	case AuditExit:
+		return "exit"
+	case AuditEviction:
+		return "eviction"
+	case AuditCredentialFailure:
+		return "credential_failure"
+	case AuditJoinRefused:
+		return "join_refused"
+	}
+	return fmt.Sprintf("AuditEventType(%d)", byte(t))
+}
+
+// MarshalText fulfills the TextMarshaler interface, so AuditEventTypes are written by name.
+func (t AuditEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// AuditRecord describes a change to who is in the Cluster, or an attempt to get in that failed, as seen by the current Node. Records are numbered from 1, in the order they were written, so gaps in a trail stand out.
+type AuditRecord struct {
+	Seq      uint64         `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Type     AuditEventType `json:"type"`
+	Observer string         `json:"observer"`          // the ID of the current Node, which wrote the record
+	NodeID   string         `json:"node_id,omitempty"` // the ID of the Node the record is about, as it claimed it
+	Address  string         `json:"address,omitempty"` // the address of the Node, or the address the Message came from
+	Reason   string         `json:"reason,omitempty"`
+}
+
+// AuditSink receives the AuditRecords written by a Cluster, and keeps them somewhere for review. WriteAudit is called for one record at a time, in order, on the goroutine that handled the event, so it shouldn't block for long.
+type AuditSink interface {
+	WriteAudit(record AuditRecord) error
+}
+
+// AuditFunc adapts an ordinary function into an AuditSink, for handing AuditRecords to a logger or shipping them elsewhere.
+type AuditFunc func(record AuditRecord) error
+
+// WriteAudit fulfills the AuditSink interface by calling f.
+func (f AuditFunc) WriteAudit(record AuditRecord) error {
+	return f(record)
+}
+
+// AuditFile is an AuditSink that appends AuditRecords to a file as JSON, one per line.
+type AuditFile struct {
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// OpenAuditFile opens the file at path for appending AuditRecords to, creating it, readable only by its owner, if it doesn't exist. Records already in the file are never changed. The file should be closed with Close once the Cluster has been killed.
+func OpenAuditFile(path string) (*AuditFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// WriteAudit fulfills the AuditSink interface, appending the record to the file.
+func (a *AuditFile) WriteAudit(record AuditRecord) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.encoder.Encode(record)
+}
+
+// Close closes the file.
+func (a *AuditFile) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.Close()
+}
+
+// SetAuditSinks has the current Node write an AuditRecord to each of sinks whenever a Node joins the Cluster through it, a Node is removed from its state tables, a Message is discarded because its credentials, signature, or encryption didn't check out, or a join is refused, replacing any sinks set before. This leaves a trail of who entered and left the Cluster, and who tried to, for security review. Each Node only records what it saw, so the trails of several Nodes may need to be read together.
+//
+// Records are written as the events happen, to every sink, in order. A sink that returns an error is reported to Applications' OnError, and the failure is counted in Stats; the record isn't written to it again. Calling SetAuditSinks with no sinks stops the trail.
+func (c *Cluster) SetAuditSinks(sinks ...AuditSink) {
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
+	c.auditSinks = sinks
+}
+
+// audit numbers a record and writes it to every sink set with SetAuditSinks. Records are written one at a time, so every sink sees them in the same order.
+func (c *Cluster) audit(record AuditRecord) {
+	errs := []error{}
+	c.auditLock.Lock()
+	if len(c.auditSinks) > 0 {
+		c.auditSeq++
+		record.Seq = c.auditSeq
+		record.Time = time.Now()
+		record.Observer = c.self.ID.String()
+		for _, sink := range c.auditSinks {
+			err := sink.WriteAudit(record)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	c.auditLock.Unlock()
+	// errors are reported once the lock is released, so OnError can call SetAuditSinks
+	for _, err := range errs {
+		atomic.AddUint64(&c.stats.AuditFailures, 1)
+		c.fanOutError(err)
+	}
+}
+
+// auditNode writes an AuditRecord about a Node.
+func (c *Cluster) auditNode(kind AuditEventType, node Node, reason string) {
+	c.audit(AuditRecord{Type: kind, NodeID: node.ID.String(), Address: c.self.address(&node), Reason: reason})
+}
+
+// auditExit writes an AuditRecord for a Node that was removed from the state tables, as an exit if it left, or an eviction if it didn't.
+func (c *Cluster) auditExit(node Node, reason ExitReason) {
+	kind := AuditEviction
+	if reason == ExitGraceful {
+		kind = AuditExit
+	}
+	c.auditNode(kind, node, reason.String())
+}
+
+// auditCredentialFailure writes an AuditRecord for a Message that was discarded because it couldn't be trusted. The Sender is what the Message claimed, so the record includes the address it really came from.
+func (c *Cluster) auditCredentialFailure(conn net.Conn, msg Message, reason string) {
+	record := AuditRecord{Type: AuditCredentialFailure, NodeID: msg.Sender.ID.String(), Reason: reason}
+	if conn != nil && conn.RemoteAddr() != nil {
+		record.Address = conn.RemoteAddr().String()
+	}
+	c.audit(record)
+}
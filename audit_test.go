@@ -0,0 +1,198 @@
+package wendy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// auditRecorder returns an AuditSink that sends the records written to it down a channel
+func auditRecorder() (AuditSink, chan AuditRecord) {
+	records := make(chan AuditRecord, 10)
+	return AuditFunc(func(record AuditRecord) error {
+		records <- record
+		return nil
+	}), records
+}
+
+// Test that Nodes joining and leaving are written to the audit trail, in order
+func TestClusterAudit(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	cluster.SetProbation(0)
+	// repairs fail, as there's no other Node to ask
+	errors := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	cluster.RegisterCallbackFor(errors, EventError)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	cluster.fanOutJoin(*node)
+	sink, records := auditRecorder()
+	cluster.SetAuditSinks(sink)
+	cluster.fanOutJoin(*node)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.onNodeExit(Message{Purpose: NODE_EXIT, Sender: *node})
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.BanNode(id, time.Minute)
+	expected := []AuditRecord{
+		{Seq: 1, Type: AuditJoin},
+		{Seq: 2, Type: AuditExit, Reason: "graceful"},
+		{Seq: 3, Type: AuditEviction, Reason: "banned"},
+	}
+	for _, want := range expected {
+		select {
+		case record := <-records:
+			if record.Seq != want.Seq || record.Type != want.Type || record.Reason != want.Reason {
+				t.Errorf("Expected record %d to be a %s (%q), got %+v.", want.Seq, want.Type, want.Reason, record)
+			}
+			if record.NodeID != id.String() || record.Observer != cluster.self.ID.String() {
+				t.Errorf("Expected record %d to be about %s, written by %s, got %+v.", want.Seq, id, cluster.self.ID, record)
+			}
+			if record.Address != "127.0.0.1:1" {
+				t.Errorf("Expected record %d to have the Node's address, got %q.", want.Seq, record.Address)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for record %d.", want.Seq)
+		}
+	}
+	select {
+	case record := <-records:
+		t.Errorf("Expected no more records, got %+v.", record)
+	default:
+	}
+}
+
+// Test that AuditFile appends records as JSON, one per line, leaving what was in the file alone
+func TestAuditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	err := os.WriteFile(path, []byte("an earlier record\n"), 0600)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	file, err := OpenAuditFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetAuditSinks(file)
+	node := NewNode(cluster.self.ID, "127.0.0.1", "127.0.0.1", "testing", 1)
+	cluster.auditExit(*node, ExitTimeout)
+	cluster.auditNode(AuditJoinRefused, *node, "refused by application")
+	err = file.Close()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lines := []string{}
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 3 || lines[0] != "an earlier record" {
+		t.Fatalf("Expected the earlier record and two new ones, got %q.", lines)
+	}
+	for i, want := range []string{"eviction", "join_refused"} {
+		var record map[string]interface{}
+		err = json.Unmarshal([]byte(lines[i+1]), &record)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if record["type"] != want || record["seq"] != float64(i+1) {
+			t.Errorf("Expected record %d to be a %s, got %s.", i+1, want, lines[i+1])
+		}
+	}
+}
+
+// Test that a sink failing to write a record is reported and counted, and doesn't stop the others
+func TestAuditSinkError(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	cluster.RegisterCallbackFor(callback, EventError)
+	failure := errors.New("The audit trail is full.")
+	sink, records := auditRecorder()
+	cluster.SetAuditSinks(AuditFunc(func(record AuditRecord) error {
+		return failure
+	}), sink)
+	cluster.auditExit(*cluster.self, ExitStale)
+	select {
+	case err := <-callback.errors:
+		if err != failure {
+			t.Errorf("Expected the sink's error to be reported, got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the error.")
+	}
+	select {
+	case record := <-records:
+		if record.Type != AuditEviction {
+			t.Errorf("Expected the other sink to get the eviction, got %+v.", record)
+		}
+	default:
+		t.Errorf("Expected the other sink to get the record.")
+	}
+	if failures := cluster.Stats().AuditFailures; failures != 1 {
+		t.Errorf("Expected 1 audit failure, got %d.", failures)
+	}
+}
+
+// Test that Messages with the wrong Credentials are written to the audit trail, with the address they came from
+func TestClusterAuditCredentialFailure(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.credentials = Passphrase("open sesame")
+	two.credentials = Passphrase("guess")
+	sink, records := auditRecorder()
+	one.SetAuditSinks(sink)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("let me in")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case record := <-records:
+		if record.Type != AuditCredentialFailure || record.Reason != "credentials" {
+			t.Errorf("Expected a credential failure, got %+v.", record)
+		}
+		if record.NodeID != two.self.ID.String() || record.Address == "" {
+			t.Errorf("Expected the record to name %s and where it sent from, got %+v.", two.self.ID, record)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the credential failure to be recorded.")
+	}
+}
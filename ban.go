@@ -0,0 +1,96 @@
+package wendy
+
+import "time"
+
+// BanNode evicts the Node with the specified ID from the state tables and bans it for the specified duration, for use while responding to an incident. While it's banned, Messages it sends, including attempts to join the Cluster, are discarded, it isn't inserted into the state tables no matter which Node lists it, and it's left out of the state tables sent to other Nodes. Banning a Node that is already banned replaces its ban.
+func (c *Cluster) BanNode(id NodeID, duration time.Duration) error {
+	if id.Equals(c.self.ID) {
+		return throwInvalidArgumentError("A Node can't ban itself.")
+	}
+	if duration <= 0 {
+		return throwInvalidArgumentError("Bans must last for a positive duration.")
+	}
+	c.lock.Lock()
+	c.bans[id] = time.Now().Add(duration)
+	c.lock.Unlock()
+	c.warn("Banned node %s for %s.", id, duration)
+	err := c.remove(id, ExitBanned)
+	if err != nil && err != nodeNotFoundError {
+		// the Node is gone either way; repairing the state tables will be retried on the next heartbeat sweep
+		c.fanOutError(err)
+	}
+	return nil
+}
+
+// UnbanNode lifts the ban on the Node with the specified ID early. It returns false if the Node wasn't banned. The Node still needs to contact the current Node, or be listed by another Node, to be inserted into the state tables again.
+func (c *Cluster) UnbanNode(id NodeID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.bans[id]
+	delete(c.bans, id)
+	delete(c.quarantine, id)
+	return ok
+}
+
+// BannedNodes returns the IDs of the Nodes that are currently banned, and when each ban ends.
+func (c *Cluster) BannedNodes() map[NodeID]time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	bans := map[NodeID]time.Time{}
+	now := time.Now()
+	for id, until := range c.bans {
+		if now.After(until) {
+			delete(c.bans, id)
+			continue
+		}
+		bans[id] = until
+	}
+	return bans
+}
+
+// banned returns true if the Node is banned, forgetting its ban if it has ended.
+func (c *Cluster) banned(id NodeID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	until, ok := c.bans[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(c.bans, id)
+		return false
+	}
+	return true
+}
+
+// withoutBanned clears banned Nodes from state tables about to be sent to another Node.
+func (c *Cluster) withoutBanned(state *stateTables) {
+	if len(c.BannedNodes()) == 0 {
+		return
+	}
+	if state.RoutingTable != nil {
+		for row := range state.RoutingTable {
+			for col, node := range state.RoutingTable[row] {
+				if node != nil && c.banned(node.ID) {
+					state.RoutingTable[row][col] = nil
+				}
+			}
+		}
+	}
+	if state.LeafSet != nil {
+		for side := range state.LeafSet {
+			for pos, node := range state.LeafSet[side] {
+				if node != nil && c.banned(node.ID) {
+					state.LeafSet[side][pos] = nil
+				}
+			}
+		}
+	}
+	if state.NeighborhoodSet != nil {
+		for pos, node := range state.NeighborhoodSet {
+			if node != nil && c.banned(node.ID) {
+				state.NeighborhoodSet[pos] = nil
+			}
+		}
+	}
+}
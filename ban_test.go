@@ -0,0 +1,128 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a Node can't ban itself, or ban a Node for no time at all
+func TestClusterBanNodeInvalid(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.BanNode(cluster.self.ID, time.Minute)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError banning the Node itself, got %v.", err)
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.BanNode(id, 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError banning a Node for no time, got %v.", err)
+	}
+}
+
+// Test that banned Nodes are evicted, aren't inserted or sent to other Nodes, and can be unbanned
+func TestClusterBanNode(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetProbation(0)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.BanNode(id, time.Minute)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a banned Node to be evicted, got %v.", err)
+	}
+	if _, ok := cluster.BannedNodes()[id]; !ok {
+		t.Errorf("Expected %s to be listed as banned.", id)
+	}
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a banned Node not to be inserted, got %v.", err)
+	}
+	// bypass the ban, as if the Node had been inserted while it was being banned
+	_, err = cluster.leafset.insertNode(*node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	state, err := cluster.dumpStateTables(StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, side := range state.LeafSet {
+		for _, entry := range side {
+			if entry != nil && entry.ID.Equals(id) {
+				t.Errorf("Expected a banned Node to be left out of the state tables sent to other Nodes.")
+			}
+		}
+	}
+	if !cluster.UnbanNode(id) {
+		t.Errorf("Expected unbanning a banned Node to return true.")
+	}
+	if cluster.UnbanNode(id) {
+		t.Errorf("Expected unbanning a Node that isn't banned to return false.")
+	}
+	if len(cluster.BannedNodes()) != 0 {
+		t.Errorf("Expected no banned Nodes, got %d.", len(cluster.BannedNodes()))
+	}
+}
+
+// Test that Messages from banned Nodes are discarded
+func TestClusterBanNodeMessages(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	time.Sleep(10 * time.Millisecond)
+	err = one.BanNode(two.self.ID, time.Minute)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.SendToIP(two.NewMessage(HEARTBEAT, two.self.ID, []byte{}), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-callback.onHeartbeat:
+		t.Errorf("Expected a Message from a banned Node to be discarded.")
+	case <-time.After(100 * time.Millisecond):
+	}
+	one.UnbanNode(two.self.ID)
+	err = two.SendToIP(two.NewMessage(HEARTBEAT, two.self.ID, []byte{}), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-callback.onHeartbeat:
+	case <-time.After(time.Second):
+		t.Errorf("Timeout waiting for a Message from an unbanned Node.")
+	}
+}
@@ -0,0 +1,186 @@
+package wendy
+
+import (
+	"fmt"
+)
+
+// Event identifies one of the callbacks a Cluster makes to its Applications. Events can be combined with |, to register an Application for several of them at once.
+type Event uint
+
+const (
+	EventError              Event = 1 << iota // OnError
+	EventDeliver                              // OnDeliver
+	EventForward                              // OnForward
+	EventNewLeaves                            // OnNewLeaves
+	EventNodeJoin                             // OnNodeJoin
+	EventNodeExit                             // OnNodeExit, or OnNodeExitWithReason for Applications that fulfill ExitReasonHandler
+	EventHeartbeat                            // OnHeartbeat
+	EventRejectedConnection                   // OnRejectedConnection, for Applications that fulfill RejectedConnectionHandler
+	EventJoined                               // OnJoined, for Applications that fulfill JoinedHandler
+	EventHandoff                              // OnHandoff, for Applications that fulfill HandoffHandler
+	EventJoinRequest                          // OnJoinRequest, for Applications that fulfill JoinRequestHandler
+	EventDeadLetter                           // OnDeadLetter, for Applications that fulfill DeadLetterHandler
+
+	EventAll = EventError | EventDeliver | EventForward | EventNewLeaves | EventNodeJoin | EventNodeExit | EventHeartbeat | EventRejectedConnection | EventJoined | EventHandoff | EventJoinRequest | EventDeadLetter
+)
+
+// defaultCallbackQueueSize is the number of callbacks that may wait for each Application before the Cluster blocks.
+const defaultCallbackQueueSize = 64
+
+// CallbackHandle identifies an Application registered with a Cluster, so that it can later be unregistered.
+type CallbackHandle uint64
+
+// registration is an Application registered with a Cluster, along with the queue its callbacks are dispatched through.
+type registration struct {
+	handle CallbackHandle
+	app    Application
+	events Event
+	queue  chan func()
+	done   chan struct{} // closed when the Application is unregistered
+}
+
+// RegisterCallback allows anything that fulfills the Application interface to be hooked into the Wendy's callbacks. The returned CallbackHandle can be passed to UnregisterCallback to unhook it.
+//
+// Each Application's callbacks are called in order from a goroutine of its own, so a slow or panicking Application doesn't hold up the Cluster or other Applications. If an Application panics, the panic is recovered and passed to OnError as an error. OnForward is the exception: as its result decides whether a Message is forwarded, it is called synchronously.
+func (c *Cluster) RegisterCallback(app Application) CallbackHandle {
+	return c.RegisterCallbackFor(app, EventAll)
+}
+
+// RegisterCallbackFor hooks an Application into only the callbacks for the specified events; the Application's other methods are never called. Note that an Application that isn't registered for EventForward doesn't get a say in whether Messages are forwarded.
+func (c *Cluster) RegisterCallbackFor(app Application, events Event) CallbackHandle {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.lastHandle++
+	reg := &registration{
+		handle: c.lastHandle,
+		app:    app,
+		events: events,
+		queue:  make(chan func(), c.callbackQueueSize),
+		done:   make(chan struct{}),
+	}
+	c.applications = append(c.applications, reg)
+	go c.dispatch(reg)
+	return reg.handle
+}
+
+// UnregisterCallback unhooks the Application registered with the specified CallbackHandle, so that it receives no further callbacks. A callback that is already running is not interrupted, but callbacks still waiting in the Application's queue are discarded. It returns false if no Application is registered with the handle.
+func (c *Cluster) UnregisterCallback(handle CallbackHandle) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, reg := range c.applications {
+		if reg.handle == handle {
+			close(reg.done)
+			c.applications = append(c.applications[:i], c.applications[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SetCallbackQueueSize sets how many callbacks may wait to be called for each Application. Once an Application's queue is full, the Cluster blocks until the Application catches up, so memory use stays bounded. It applies to Applications registered after it is called; by default, 64 callbacks may wait.
+func (c *Cluster) SetCallbackQueueSize(size int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	c.callbackQueueSize = size
+}
+
+// callbacks returns the Applications registered for the specified event.
+func (c *Cluster) callbacks(event Event) []Application {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	apps := []Application{}
+	for _, reg := range c.applications {
+		if reg.events&event != 0 {
+			apps = append(apps, reg.app)
+		}
+	}
+	return apps
+}
+
+// notify queues fn to be called with each Application registered for the specified event.
+func (c *Cluster) notify(event Event, fn func(app Application)) {
+	c.lock.RLock()
+	regs := []*registration{}
+	for _, reg := range c.applications {
+		if reg.events&event != 0 {
+			regs = append(regs, reg)
+		}
+	}
+	c.lock.RUnlock()
+	for _, reg := range regs {
+		app := reg.app
+		callback := func() {
+			defer c.recoverCallback(event)
+			fn(app)
+		}
+		select {
+		case reg.queue <- callback:
+		case <-reg.done:
+		case <-c.ctx.Done():
+		}
+	}
+}
+
+// dispatch calls an Application's queued callbacks, until the Application is unregistered or the Cluster is killed.
+func (c *Cluster) dispatch(reg *registration) {
+	for {
+		select {
+		case callback := <-reg.queue:
+			callback()
+		case <-reg.done:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// recoverCallback recovers from a panic in an Application's callback, passing it to OnError. A panic in OnError itself is only logged, so it can't cause another.
+func (c *Cluster) recoverCallback(event Event) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := fmt.Errorf("Application callback panicked: %v", r)
+	if event == EventError {
+		c.err(err.Error())
+		return
+	}
+	c.fanOutError(err)
+}
+
+// callForward calls an Application's OnForward. If it panics, the Message is forwarded as if it had returned true.
+func (c *Cluster) callForward(app Application, msg *Message, id NodeID) (forward bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.fanOutError(fmt.Errorf("Application callback panicked: %v", r))
+			forward = true
+		}
+	}()
+	return app.OnForward(msg, id)
+}
+
+// admitJoin asks each Application that fulfills JoinRequestHandler whether the Node that sent a join message may join through the current Node. It returns false if any of them refuse.
+func (c *Cluster) admitJoin(msg Message) bool {
+	for _, app := range c.callbacks(EventJoinRequest) {
+		handler, ok := app.(JoinRequestHandler)
+		if ok && !c.callJoinRequest(handler, msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// callJoinRequest calls an Application's OnJoinRequest. If it panics, the join is refused.
+func (c *Cluster) callJoinRequest(handler JoinRequestHandler, msg Message) (admit bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.fanOutError(fmt.Errorf("Application callback panicked: %v", r))
+			admit = false
+		}
+	}()
+	return handler.OnJoinRequest(msg.Sender, msg.Credentials)
+}
@@ -0,0 +1,195 @@
+package wendy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type panickingCallback struct {
+	*testCallback
+}
+
+func (p *panickingCallback) OnDeliver(msg Message) {
+	panic("can't handle " + string(msg.Value))
+}
+
+func (p *panickingCallback) OnForward(msg *Message, next NodeID) bool {
+	panic("can't forward " + string(msg.Value))
+}
+
+// Test that Applications only receive the events they registered for, and none once unregistered
+func TestClusterUnregisterCallback(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	all := newTestCallback(t)
+	scoped := newTestCallback(t)
+	allHandle := cluster.RegisterCallback(all)
+	scopedHandle := cluster.RegisterCallbackFor(scoped, EventDeliver)
+	if allHandle == scopedHandle {
+		t.Fatalf("Expected distinct handles, got %d twice.", allHandle)
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	cluster.deliver(msg)
+	cluster.forward(msg, cluster.self.ID)
+	for _, ch := range []chan Message{all.onDeliver, scoped.onDeliver} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting on message delivery.")
+		}
+	}
+	if len(all.onForward) != 1 || len(scoped.onForward) != 0 {
+		t.Errorf("Expected only the Application registered for forwards to be asked, got %d and %d.", len(all.onForward), len(scoped.onForward))
+	}
+	if !cluster.UnregisterCallback(scopedHandle) {
+		t.Fatalf("Expected handle %d to be registered.", scopedHandle)
+	}
+	if cluster.UnregisterCallback(scopedHandle) {
+		t.Errorf("Expected handle %d not to be registered twice.", scopedHandle)
+	}
+	cluster.deliver(msg)
+	select {
+	case <-all.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	select {
+	case <-scoped.onDeliver:
+		t.Errorf("Expected an unregistered Application not to receive deliveries.")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+// Test that a panicking Application is reported through OnError without affecting other Applications
+func TestClusterCallbackPanic(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetLogLevel(LogLevelError + 1)
+	errs := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	cluster.RegisterCallbackFor(errs, EventError|EventDeliver)
+	cluster.RegisterCallbackFor(&panickingCallback{testCallback: newTestCallback(t)}, EventDeliver|EventForward)
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	if !cluster.forward(msg, cluster.self.ID) {
+		t.Errorf("Expected a panicking OnForward not to stop the Message being forwarded.")
+	}
+	cluster.deliver(msg)
+	select {
+	case <-errs.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	for _, expected := range []string{"can't forward", "can't handle"} {
+		select {
+		case err := <-errs.errors:
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected an error containing %q, got %q.", expected, err.Error())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for the panic to be reported.")
+		}
+	}
+}
+
+type joinRequestCallback struct {
+	*testCallback
+	refuse      NodeID
+	credentials chan []byte
+}
+
+func (j *joinRequestCallback) OnJoinRequest(node Node, credentials []byte) bool {
+	select {
+	case j.credentials <- credentials:
+	default:
+	}
+	return !node.ID.Equals(j.refuse)
+}
+
+type panickingJoinCallback struct {
+	*testCallback
+}
+
+func (p *panickingJoinCallback) OnJoinRequest(node Node, credentials []byte) bool {
+	panic("can't decide on " + node.ID.String())
+}
+
+// Test that OnJoinRequest decides whether a join is admitted, and that a panic refuses it
+func TestClusterJoinRequest(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetLogLevel(LogLevelError + 1)
+	refused, err := NodeIDFromBytes([]byte("this is a Node that isn't welcome here."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := &joinRequestCallback{testCallback: newTestCallback(t), refuse: refused, credentials: make(chan []byte, 10)}
+	cluster.RegisterCallbackFor(callback, EventJoinRequest)
+	msg := cluster.NewMessage(NODE_JOIN, cluster.self.ID, nil)
+	msg.Credentials = []byte("open sesame")
+	if !cluster.admitJoin(msg) {
+		t.Errorf("Expected the join to be admitted.")
+	}
+	if credentials := <-callback.credentials; string(credentials) != "open sesame" {
+		t.Errorf("Expected OnJoinRequest to receive the join's Credentials, got %q.", credentials)
+	}
+	msg.Sender.ID = refused
+	if cluster.admitJoin(msg) {
+		t.Errorf("Expected the join to be refused.")
+	}
+	errs := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	cluster.RegisterCallbackFor(errs, EventError)
+	cluster.RegisterCallbackFor(&panickingJoinCallback{testCallback: newTestCallback(t)}, EventJoinRequest)
+	msg.Sender.ID = cluster.self.ID
+	if cluster.admitJoin(msg) {
+		t.Errorf("Expected a panicking OnJoinRequest to refuse the join.")
+	}
+	select {
+	case err := <-errs.errors:
+		if !strings.Contains(err.Error(), "can't decide") {
+			t.Errorf("Expected the panic to be reported, got %q.", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the panic to be reported.")
+	}
+}
+
+// Test that a Node refused by OnJoinRequest isn't sent any state tables
+func TestClusterJoinRequestRefused(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.RegisterCallback(&joinRequestCallback{testCallback: newTestCallback(t), refuse: two.self.ID, credentials: make(chan []byte, 10)})
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = two.Join(one.self.LocalIP, one.self.Port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if two.isJoined() {
+		t.Errorf("Expected the refused Node not to join.")
+	}
+	if nodes := two.leafset.list(); countNodes(nodes) != 0 {
+		t.Errorf("Expected the refused Node not to be sent any state tables, got %d Nodes.", countNodes(nodes))
+	}
+	if refused := one.Stats().RefusedJoins; refused != 1 {
+		t.Errorf("Expected 1 refused join, got %d.", refused)
+	}
+}
@@ -0,0 +1,152 @@
+package wendy
+
+import (
+	"errors"
+)
+
+var noCapableNodeError = errors.New("No Node advertising the capability is known.")
+
+// capableMessage is the payload of Messages sent with SendToCapable: the capability a Node needs to have to receive the Message, and the Message's own purpose and value.
+type capableMessage struct {
+	Capability string
+	Direct     bool // true once the Message has been sent straight to a capable Node, rather than routed towards its key
+	Purpose    byte
+	Value      []byte
+}
+
+// SetCapabilities sets what the current Node advertises it can do, such as "storage" or "gpu", replacing any capabilities set before. Like Load and Metadata, capabilities are sent to other Nodes with every Message and kept in their state tables alongside the Node, so SendToCapable can find the Nodes that have them. Virtual Nodes added to the Cluster advertise the same capabilities.
+func (c *Cluster) SetCapabilities(capabilities ...string) {
+	c.self.setCapabilities(capabilities)
+	for _, v := range c.getVirtualNodes() {
+		v.self.setCapabilities(capabilities)
+	}
+}
+
+// Capabilities returns the capabilities the current Node advertises, sorted.
+func (c *Cluster) Capabilities() []string {
+	return c.self.clone().Capabilities
+}
+
+// SendToCapable routes a Message towards key, like Send, but only delivers it to a Node that advertises capability. If the Node responsible for key has the capability, it receives the Message; otherwise, that Node sends the Message on to whichever Node it knows of with the capability that is closest to key. If it knows of none, the Message is dropped, and the error is reported to its Applications' OnError. The Message is delivered to Applications with its own purpose, which must not be reserved, and its Key set to key. Applications' OnForward isn't called for the Nodes the Message passes through. If Nodes sign their Messages, a Message sent on to a capable Node is signed by, and delivered with the Sender of, the Node responsible for key.
+func (c *Cluster) SendToCapable(key NodeID, capability string, msg Message) error {
+	if msg.Purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	data, err := c.marshal(capableMessage{Capability: capability, Purpose: msg.Purpose, Value: msg.Value})
+	if err != nil {
+		return err
+	}
+	msg.Purpose = NODE_CAPS
+	msg.Key = key
+	msg.Value = data
+	return c.forwardCapable(msg)
+}
+
+// forwardCapable sends a capable Message on to its next hop, or delivers it if the current Node is responsible for its key and has the capability. If the next hop doesn't respond, it's suspected, and the next hop is chosen again, up to maxFailovers times.
+func (c *Cluster) forwardCapable(msg Message) error {
+	var env capableMessage
+	err := c.unmarshal(msg.Value, &env)
+	if err != nil {
+		return err
+	}
+	tried := map[NodeID]bool{}
+	for {
+		next, direct, err := c.capableHop(msg.Key, env)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			delivered := msg
+			delivered.Purpose = env.Purpose
+			delivered.Value = env.Value
+			c.deliver(delivered)
+			return nil
+		}
+		if tried[next.ID] {
+			return deadNodeError
+		}
+		out := msg
+		if direct && !env.Direct {
+			direct := env
+			direct.Direct = true
+			out.Value, err = c.marshal(direct)
+			if err != nil {
+				return err
+			}
+			if c.getSigningKey() != nil {
+				// the Sender's signature doesn't cover the new payload, so the Message is sent on as the current Node's own
+				out.Sender = *c.self
+				out.Credentials = c.marshalCredentials()
+			}
+		}
+		err = c.checkHops(out)
+		if err != nil {
+			c.warn(err.Error())
+			return err
+		}
+		err = c.send(out, next)
+		if err != deadNodeError {
+			return err
+		}
+		tried[next.ID] = true
+		err = c.suspect(next)
+		if err != nil {
+			c.fanOutError(err)
+		}
+		if len(tried) > maxFailovers {
+			return deadNodeError
+		}
+	}
+}
+
+// capableHop returns the Node a capable Message should be sent to next, and whether it is being sent straight to a capable Node, or nil if the current Node should deliver it. Messages are routed towards their key until they reach the Node responsible for it; if that Node doesn't have the capability, it sends the Message straight to the capable Node closest to the key that it knows of.
+func (c *Cluster) capableHop(key NodeID, env capableMessage) (*Node, bool, error) {
+	capable := c.self.HasCapability(env.Capability)
+	if env.Direct {
+		if !capable {
+			return nil, false, noCapableNodeError
+		}
+		return nil, false, nil
+	}
+	target, err := c.Route(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if target != nil {
+		return target, false, nil
+	}
+	if capable {
+		return nil, false, nil
+	}
+	target = c.closestCapable(key, env.Capability)
+	if target == nil {
+		return nil, false, noCapableNodeError
+	}
+	c.debug("Not capable of %q. Sending message %s to %s instead.", env.Capability, key, target.ID)
+	return target, true, nil
+}
+
+// closestCapable returns the Node in the state tables that advertises capability and is closest to key, or nil if none of them advertise it.
+func (c *Cluster) closestCapable(key NodeID, capability string) *Node {
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	var best *Node
+	for _, node := range nodes {
+		if node == nil || !node.HasCapability(capability) {
+			continue
+		}
+		if best == nil || node.ID.distance(key).absLess(best.ID.distance(key)) {
+			best = node
+		}
+	}
+	return best
+}
+
+// A Node is sending a Message through us that may only be delivered to a Node with a capability.
+func (c *Cluster) onCapableMessage(msg Message) {
+	err := c.forwardCapable(msg)
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
@@ -0,0 +1,122 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a Node's capabilities are sorted and deduplicated when set, and sent with its Messages
+func TestClusterSetCapabilities(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetCapabilities("storage", "gpu", "storage")
+	capabilities := cluster.Capabilities()
+	if len(capabilities) != 2 || capabilities[0] != "gpu" || capabilities[1] != "storage" {
+		t.Errorf("Expected capabilities [gpu storage], got %v.", capabilities)
+	}
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("testing"))
+	if !msg.Sender.HasCapability("gpu") || msg.Sender.HasCapability("compute") {
+		t.Errorf("Expected the Message's Sender to advertise %v, got %v.", capabilities, msg.Sender.Capabilities)
+	}
+	cluster.SetCapabilities()
+	if len(cluster.Capabilities()) != 0 {
+		t.Errorf("Expected no capabilities, got %v.", cluster.Capabilities())
+	}
+}
+
+// Test that the Node responsible for a key that lacks the capability picks the closest capable Node it knows of
+func TestClusterCapableHop(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	near := NewNode(NodeID{cluster.self.ID[0] + 2, cluster.self.ID[1]}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	far := NewNode(NodeID{cluster.self.ID[0] + 4, cluster.self.ID[1]}, "127.0.0.1", "127.0.0.1", "testing", 2)
+	far.Capabilities = []string{"gpu"}
+	for _, node := range []*Node{near, far} {
+		_, err = cluster.neighborhoodset.insertNode(*node, 0)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	env := capableMessage{Capability: "gpu"}
+	next, direct, err := cluster.capableHop(cluster.self.ID, env)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if next == nil || !next.ID.Equals(far.ID) || !direct {
+		t.Errorf("Expected the Message to be sent straight to %s, got %v.", far.ID, next)
+	}
+	cluster.SetCapabilities("gpu")
+	next, _, err = cluster.capableHop(cluster.self.ID, env)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if next != nil {
+		t.Errorf("Expected a capable Node to deliver the Message itself, got %s.", next.ID)
+	}
+	env.Capability = "storage"
+	_, _, err = cluster.capableHop(cluster.self.ID, env)
+	if err != noCapableNodeError {
+		t.Errorf("Expected noCapableNodeError, got %v.", err)
+	}
+	env.Direct = true
+	_, _, err = cluster.capableHop(cluster.self.ID, env)
+	if err != noCapableNodeError {
+		t.Errorf("Expected a Message sent straight to a Node without the capability to be refused, got %v.", err)
+	}
+}
+
+// Test that a Message sent with SendToCapable is delivered to a capable Node, with its own purpose and value
+func TestClusterSendToCapable(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCallback := newTestCallback(t)
+	one.RegisterCallback(oneCallback)
+	twoCallback := newTestCallback(t)
+	two.RegisterCallback(twoCallback)
+	two.SetCapabilities("gpu")
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := one.NewMessage(FirstUserPurpose+1, NodeID{}, []byte("testing"))
+	err = one.SendToCapable(one.self.ID, "gpu", msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case delivered := <-twoCallback.onDeliver:
+		if delivered.Purpose != FirstUserPurpose+1 || string(delivered.Value) != "testing" || !delivered.Key.Equals(one.self.ID) || !delivered.Sender.ID.Equals(one.self.ID) {
+			t.Errorf("Expected the Message sent, got %+v.", delivered)
+		}
+	case <-oneCallback.onDeliver:
+		t.Errorf("Expected a Node without the capability not to receive the Message.")
+	case <-time.After(time.Second):
+		t.Errorf("Timed out waiting for the Message to be delivered.")
+	}
+	err = one.SendToCapable(one.self.ID, "gpu", one.NewMessage(NODE_JOIN, NodeID{}, nil))
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a reserved purpose, got %v.", err)
+	}
+}
@@ -0,0 +1,149 @@
+package wendy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync/atomic"
+	"time"
+)
+
+// joinChallengeTimeout is how long a joining Node has to answer a challenge before its join is forgotten.
+const joinChallengeTimeout = 30 * time.Second
+
+// The first byte of a NODE_AUTH Message's Value says what it is: a join challenge, an answer to one, or new Credentials being distributed. The rest is the nonce, the answer, or the sealed secret.
+const (
+	authChallenge = byte(iota)
+	authAnswer
+	authRotate
+)
+
+// joinChallenge is a join message waiting on the joining Node to answer the nonce it was sent.
+type joinChallenge struct {
+	nonce   []byte
+	msg     Message
+	expires time.Time
+}
+
+// SetJoinChallenge sets whether the current Node challenges Nodes that join the Cluster through it before sending them any state tables. When it's enabled, a join message is answered with a random nonce, and the joining Node has to send back an HMAC-SHA256 of the nonce, keyed with the secret in the Cluster's Credentials, within 30 seconds; only then is the join handled. Joins that aren't answered correctly are dropped, and counted in Stats, so a Node without the Credentials learns nothing about the Cluster, even by replaying a join message it captured.
+//
+// Every Node a join message is routed through challenges the joining Node, if it has this enabled. Joining Nodes answer challenges on their own, whether or not they have it enabled, until they've joined. The Cluster's Credentials must be a Passphrase or HMACCredentials, or implement Marshal, for there to be a secret to key the answer with.
+func (c *Cluster) SetJoinChallenge(enabled bool) error {
+	if enabled && len(credentialSecret(c.getCredentials())) < 1 {
+		return throwInvalidArgumentError("The Cluster's Credentials have no secret to challenge joining Nodes with.")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.joinChallenge = enabled
+	return nil
+}
+
+func (c *Cluster) getJoinChallenge() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.joinChallenge
+}
+
+// joinAnswer returns the answer to the nonce a Node sent a joining Node, binding it to both of their IDs so it can't be used to join as another Node, or through another.
+func joinAnswer(secret, nonce []byte, joiner, challenger NodeID) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(nonce)
+	mac.Write(nodeIDBytes(joiner))
+	mac.Write(nodeIDBytes(challenger))
+	return mac.Sum(nil)
+}
+
+// challengeJoin holds on to a join message, and sends its Sender a nonce to answer before it's handled.
+func (c *Cluster) challengeJoin(msg Message) {
+	nonce := make([]byte, 32)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	now := time.Now()
+	c.lock.Lock()
+	for id, pending := range c.joinChallenges {
+		if now.After(pending.expires) {
+			delete(c.joinChallenges, id)
+		}
+	}
+	c.joinChallenges[msg.Sender.ID] = &joinChallenge{nonce: nonce, msg: msg, expires: now.Add(joinChallengeTimeout)}
+	c.lock.Unlock()
+	c.debug("Challenging %s before letting it join.", msg.Sender.ID)
+	err = c.send(c.NewMessage(NODE_AUTH, msg.Sender.ID, append([]byte{authChallenge}, nonce...)), &msg.Sender)
+	if err != nil {
+		c.lock.Lock()
+		delete(c.joinChallenges, msg.Sender.ID)
+		c.lock.Unlock()
+		if err != deadNodeError {
+			c.fanOutError(err)
+		}
+	}
+}
+
+// A Node is challenging us to join through it, answering our challenge, or distributing new Credentials.
+func (c *Cluster) onAuthMessage(msg Message) {
+	if len(msg.Value) < 1 {
+		c.warn("Discarding empty auth message from %s.", msg.Sender.ID)
+		return
+	}
+	value := msg.Value[1:]
+	switch msg.Value[0] {
+	case authChallenge:
+		c.answerJoinChallenge(msg.Sender, value)
+	case authAnswer:
+		c.checkJoinAnswer(msg.Sender, value)
+	case authRotate:
+		c.onRotateSecret(msg.Sender, value)
+	default:
+		c.warn("Discarding auth message of unknown kind %d from %s.", msg.Value[0], msg.Sender.ID)
+	}
+}
+
+// answerJoinChallenge proves to a Node we're joining through that we have the Credentials, so it will send us its state tables.
+func (c *Cluster) answerJoinChallenge(challenger Node, nonce []byte) {
+	if c.isJoined() {
+		c.warn("Ignoring join challenge from %s; already joined.", challenger.ID)
+		return
+	}
+	secret := credentialSecret(c.sendingCredentials())
+	if len(secret) < 1 {
+		c.warn("Can't answer join challenge from %s without a secret in the Credentials.", challenger.ID)
+		return
+	}
+	answer := joinAnswer(secret, nonce, c.self.ID, challenger.ID)
+	err := c.send(c.NewMessage(NODE_AUTH, challenger.ID, append([]byte{authAnswer}, answer...)), &challenger)
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
+
+// checkJoinAnswer handles the join of a Node that answered our challenge, if the answer is right.
+func (c *Cluster) checkJoinAnswer(joiner Node, answer []byte) {
+	c.lock.Lock()
+	pending := c.joinChallenges[joiner.ID]
+	delete(c.joinChallenges, joiner.ID)
+	c.lock.Unlock()
+	if pending == nil || time.Now().After(pending.expires) {
+		atomic.AddUint64(&c.stats.FailedChallenges, 1)
+		c.warn("Discarding answer from %s; it wasn't challenged, or answered too late.", joiner.ID)
+		c.auditNode(AuditJoinRefused, joiner, "challenge expired")
+		return
+	}
+	correct := false
+	for _, credentials := range c.acceptedCredentials() {
+		secret := credentialSecret(credentials)
+		if len(secret) > 0 && hmac.Equal(joinAnswer(secret, pending.nonce, joiner.ID, c.self.ID), answer) {
+			correct = true
+		}
+	}
+	if !correct {
+		atomic.AddUint64(&c.stats.FailedChallenges, 1)
+		c.recordMalformed(joiner.ID)
+		c.warn("%s answered its join challenge wrong. Not letting it join.", joiner.ID)
+		c.auditNode(AuditJoinRefused, joiner, "challenge failed")
+		return
+	}
+	c.onNodeJoin(pending.msg)
+}
@@ -0,0 +1,120 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that join challenges can only be enabled when the Credentials have a secret to answer them with
+func TestSetJoinChallenge(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.SetJoinChallenge(true) == nil {
+		t.Errorf("Expected an error enabling join challenges without Credentials.")
+	}
+	err = cluster.SetJoinChallenge(false)
+	if err != nil {
+		t.Errorf("Expected join challenges to be disabled without Credentials, got %v.", err)
+	}
+	cluster.credentials = HMACCredentials{Secret: []byte("open sesame")}
+	err = cluster.SetJoinChallenge(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !cluster.getJoinChallenge() {
+		t.Errorf("Expected join challenges to be enabled.")
+	}
+}
+
+// Test that joins answered wrong, late, or without being challenged are dropped and counted
+func TestJoinAnswer(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	joiner, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.credentials = Passphrase("open sesame")
+	nonce := []byte("a nonce for testing purposes only")
+	challenge := func(expires time.Time) {
+		cluster.lock.Lock()
+		cluster.joinChallenges[joiner.self.ID] = &joinChallenge{nonce: nonce, msg: joiner.NewMessage(NODE_JOIN, joiner.self.ID, nil), expires: expires}
+		cluster.lock.Unlock()
+	}
+	cluster.checkJoinAnswer(*joiner.self, joinAnswer([]byte("open sesame"), nonce, joiner.self.ID, cluster.self.ID))
+	challenge(time.Now().Add(time.Minute))
+	cluster.checkJoinAnswer(*joiner.self, joinAnswer([]byte("guess"), nonce, joiner.self.ID, cluster.self.ID))
+	challenge(time.Now().Add(time.Minute))
+	cluster.checkJoinAnswer(*joiner.self, joinAnswer([]byte("open sesame"), nonce, cluster.self.ID, joiner.self.ID))
+	challenge(time.Now().Add(-time.Second))
+	cluster.checkJoinAnswer(*joiner.self, joinAnswer([]byte("open sesame"), nonce, joiner.self.ID, cluster.self.ID))
+	if failed := cluster.Stats().FailedChallenges; failed != 4 {
+		t.Errorf("Expected 4 failed challenges, got %d.", failed)
+	}
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+	if len(cluster.joinChallenges) != 0 {
+		t.Errorf("Expected answered challenges to be forgotten, got %d left.", len(cluster.joinChallenges))
+	}
+}
+
+// Test that a Node sharing the Credentials can join through a Node that challenges it, and one without them isn't sent any state tables
+func TestClusterJoinChallenge(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.credentials = Passphrase("open sesame")
+	two.credentials = Passphrase("open sesame")
+	err = one.SetJoinChallenge(true)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	go three.Listen()
+	defer three.Kill()
+	waitListening(t, one, two, three)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, []string{two.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = two.leafset.getNode(one.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// three replays the passphrase it captured, but has no secret to answer the challenge with
+	msg := three.NewMessage(NODE_JOIN, three.self.ID, nil)
+	msg.Credentials = []byte("open sesame")
+	err = three.SendToIP(msg, three.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if three.isJoined() {
+		t.Errorf("Expected the Node without the secret not to join.")
+	}
+	if nodes := three.leafset.list(); countNodes(nodes) != 0 {
+		t.Errorf("Expected the Node without the secret not to be sent any state tables, got %d Nodes.", countNodes(nodes))
+	}
+}
@@ -1,7 +1,9 @@
 package wendy
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/cipher"
+	"crypto/ed25519"
 	"errors"
 	"io"
 	"log"
@@ -10,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -39,72 +42,179 @@ func (m StateMask) includeNS() bool {
 }
 
 type stateTables struct {
-	RoutingTable    *[32][16]*Node `json:"rt,omitempty"`
-	LeafSet         *[2][16]*Node  `json:"ls,omitempty"`
-	NeighborhoodSet *[32]*Node     `json:"ns,omitempty"`
-	EOL             bool           `json:"eol,omitempty"`
+	RoutingTable    [][]*Node     `json:"rt,omitempty"`
+	LeafSet         *[2][16]*Node `json:"ls,omitempty"`
+	NeighborhoodSet *[32]*Node    `json:"ns,omitempty"`
+	EOL             bool          `json:"eol,omitempty"`
+	Delta           bool          `json:"delta,omitempty"`
 }
 
-type proximityCache struct {
-	cache  map[NodeID]int64
-	ticker <-chan time.Time
-	*sync.RWMutex
+// connSet tracks the inbound connections being handled, so they can be closed and waited on when the Cluster stops listening.
+type connSet struct {
+	conns map[net.Conn]struct{}
+	lock  *sync.Mutex
+	wg    *sync.WaitGroup
 }
 
-func newProximityCache() *proximityCache {
-	return &proximityCache{
-		cache:   map[NodeID]int64{},
-		ticker:  time.Tick(1 * time.Hour),
-		RWMutex: new(sync.RWMutex),
+func newConnSet() *connSet {
+	return &connSet{
+		conns: map[net.Conn]struct{}{},
+		lock:  new(sync.Mutex),
+		wg:    new(sync.WaitGroup),
 	}
 }
 
+func (s *connSet) add(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+}
+
+// done removes a connection once its handler has returned.
+func (s *connSet) done(conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, conn)
+	s.wg.Done()
+}
+
+func (s *connSet) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+func (s *connSet) closeAll() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *connSet) wait() {
+	s.wg.Wait()
+}
+
 // Cluster holds the information about the state of the network. It is the main interface to the distributed network of Nodes.
 type Cluster struct {
 	self               *Node
 	table              *routingTable
 	leafset            *leafSet
 	neighborhoodset    *neighborhoodSet
-	kill               chan bool
+	ctx                context.Context // canceled when the Cluster is killed
+	kill               context.CancelFunc
 	lastStateUpdate    time.Time
-	applications       []Application
+	applications       []*registration
+	callbackQueueSize  int
+	lastHandle         CallbackHandle
 	log                *log.Logger
 	logLevel           int
 	heartbeatFrequency int
 	networkTimeout     int
+	connectTimeout     time.Duration
+	writeTimeout       time.Duration
+	readTimeout        time.Duration
 	credentials        Credentials
+	signingKey         ed25519.PrivateKey
 	joined             bool
+	joinedCh           chan struct{} // closed when the Node has joined
 	lock               *sync.RWMutex
+	stateLock          *sync.RWMutex // held for reading while the state tables change, and for writing while they're snapshotted
 	proximityCache     *proximityCache
+	transport          Transport
+	bindAddress        string
+	retryPolicy        RetryPolicy
+	limiter            *rateLimiter
+	stats              *Stats
+	queue              *sendQueue
+	handlers           chan struct{}
+	codec              Codec
+	encoders           *sync.Pool
+	nat                NAT
+	natMappedPort      int
+	natRenewAt         time.Time
+	seeds              []string
+	drainTimeout       time.Duration
+	active             *connSet   // the connections being handled, while the Cluster is listening
+	joinResult         chan error // receives the outcome of announcing presence, while JoinAndWait is waiting
+	listeningSince     time.Time
+	lastHeartbeat      time.Time // when the last heartbeat sweep finished
+	interceptors       []Interceptor
+	rejoinPolicy       RetryPolicy
+	stateStore         StateStore
+	indirectProbes     int
+	staleTimeout       time.Duration
+	probes             map[NodeID]chan struct{} // closed when a Node being probed indirectly is reported alive
+	probation          time.Duration
+	quarantine         map[NodeID]*quarantineEntry
+	bans               map[NodeID]time.Time
+	reputations        map[NodeID]*Reputation
+	minReputation      float64
+	maxLoad            int                         // the Load above which the routing table's choice is avoided, or 0 if Load is ignored
+	traces             map[uint64]chan traceRoute  // receives each trace started with Trace when it's done
+	stateRequests      map[NodeID]chan stateTables // receives the reply to each RequestState call
+	events             chan ClusterEvent
+	eventsClosed       bool
+	maxHops            int
+	forwarded          map[uint64]forwarded // the Messages forwarded recently, for detecting routing loops
+	maintainFrequency  time.Duration        // how often the routing table is refreshed, or 0 if it isn't
+	rejoining          bool                 // true while the Node is rejoining the Cluster after losing contact with it
+	purposeHandlers    map[byte]*purposeHandler
+	purposes           map[byte]string // the names purposes were registered under
+	host               *Cluster        // the Cluster serving this one, if it is a virtual Node
+	virtualNodes       []*Cluster
+	joinChallenge      bool
+	joinChallenges     map[NodeID]*joinChallenge
+	payloadCipher      cipher.AEAD
+	rotation           *credentialRotation
+	idDifficulty       int
+	clusterID          string
+	epoch              uint64
+	auditLock          sync.Mutex
+	auditSinks         []AuditSink
+	auditSeq           uint64
+	throttle           *senderThrottle
+	acks               map[string]chan error // the Messages sent with SendReliable that are waiting to be acknowledged
+	delivered          map[string]time.Time  // when each Message sent with SendReliable was delivered, within the dedup window
+	deliveredOrder     []string
+	dedupWindow        time.Duration
+	orderedDelivery    bool
+	reorderWait        time.Duration
+	sequences          map[NodeID]*sendSequence  // the last sequence number used for each key, while ordered delivery is enabled
+	streams            map[string]*orderedStream // the ordered Messages waiting to be delivered, by Sender and key
+	aggregators        map[byte]*aggregator
+	aggregates         map[uint64]chan []byte  // receives the answer to each aggregate query the Node is waiting on
+	replies            map[string]chan Message // receives the reply to each Request the Node is waiting on, by the ID of the request
+	outbox             Outbox
+	outboxed           map[NodeID]bool // the Nodes the Outbox holds Messages for
+	scheduleStore      ScheduleStore
+	scheduled          map[uint64]*time.Timer // the timer for each send scheduled with SendAt, or nil until it's started
 }
 
 func (c *Cluster) newLeaves(leaves []*Node) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
 	c.debug("Sending newLeaves notifications.")
-	for i, app := range c.applications {
+	c.emit(ClusterEvent{Type: LeafSetChanged, Leaves: leaves})
+	c.notify(EventNewLeaves, func(app Application) {
 		app.OnNewLeaves(leaves)
-		c.debug("Sent newLeaves notification %d of %d.", i+1, len(c.applications))
-	}
+	})
 	c.debug("Sent newLeaves notifications.")
 }
 
 func (c *Cluster) fanOutJoin(node Node) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	for _, app := range c.applications {
-		c.debug("Announcing node join.")
+	c.debug("Announcing node join.")
+	c.emit(ClusterEvent{Type: NodeJoined, Node: node.clone()})
+	c.auditNode(AuditJoin, node, "")
+	c.notify(EventNodeJoin, func(app Application) {
 		app.OnNodeJoin(node)
-		c.debug("Announced node join.")
-	}
+	})
 }
 
 func (c *Cluster) forward(msg Message, id NodeID) bool {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
 	forward := true
-	for _, app := range c.applications {
-		f := app.OnForward(&msg, id)
+	for _, app := range c.callbacks(EventForward) {
+		f := c.callForward(app, &msg, id)
 		if forward {
 			forward = f
 		}
@@ -113,12 +223,64 @@ func (c *Cluster) forward(msg Message, id NodeID) bool {
 }
 
 func (c *Cluster) marshalCredentials() []byte {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	if c.credentials == nil {
+	credentials := c.sendingCredentials()
+	if credentials == nil {
 		return []byte{}
 	}
-	return c.credentials.Marshal()
+	return credentials.Marshal()
+}
+
+// signCredentials sets the Credentials of a Message the current Node sent, if the Credentials it's sent with, the Cluster's or those set with WithCredentials, authenticate each Message. Messages forwarded for other Nodes are left alone.
+func (c *Cluster) signCredentials(msg *Message) {
+	sending := msg.credentials
+	if sending == nil {
+		sending = c.sendingCredentials()
+	}
+	credentials, ok := sending.(MessageCredentials)
+	if !ok || !msg.Sender.ID.Equals(c.self.ID) {
+		return
+	}
+	msg.Credentials = credentials.SignMessage(*msg)
+}
+
+// validCredentials returns true if the Message's Credentials, and the connection it was read from, grant it access to the Cluster, or the Cluster has no Credentials. While the Credentials are being rotated, either the previous or the new ones grant access.
+func (c *Cluster) validCredentials(conn net.Conn, msg Message) bool {
+	for _, credentials := range c.acceptedCredentials() {
+		if grantsAccess(credentials, conn, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func grantsAccess(credentials Credentials, conn net.Conn, msg Message) bool {
+	if credentials == nil {
+		return true
+	}
+	if connCredentials, ok := credentials.(ConnCredentials); ok && !connCredentials.ValidConn(conn) {
+		return false
+	}
+	contextCredentials, hasContext := credentials.(ContextCredentials)
+	if hasContext && !contextCredentials.ValidContext(credentialContext(conn, msg), msg.Credentials) {
+		return false
+	}
+	if msgCredentials, ok := credentials.(MessageCredentials); ok {
+		return msgCredentials.VerifyMessage(msg, msg.Credentials)
+	}
+	if hasContext {
+		// ValidContext is checked instead of Valid
+		return true
+	}
+	return credentials.Valid(msg.Credentials)
+}
+
+// credentialContext describes the Message read from conn for ContextCredentials.
+func credentialContext(conn net.Conn, msg Message) CredentialContext {
+	ctx := CredentialContext{NodeID: msg.Sender.ID, Purpose: msg.Purpose}
+	if conn != nil {
+		ctx.RemoteAddr = conn.RemoteAddr()
+	}
+	return ctx
 }
 
 func (c *Cluster) getNetworkTimeout() int {
@@ -127,25 +289,49 @@ func (c *Cluster) getNetworkTimeout() int {
 	return c.networkTimeout
 }
 
-func (c *Cluster) cacheProximity(id NodeID, proximity int64) {
-	c.proximityCache.Lock()
-	defer c.proximityCache.Unlock()
-	c.proximityCache.cache[id] = proximity
+// getTimeouts returns the connect, write, and read timeouts, using the network timeout for any that haven't been set.
+func (c *Cluster) getTimeouts() (connect, write, read time.Duration) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	fallback := time.Duration(c.networkTimeout) * time.Second
+	connect, write, read = c.connectTimeout, c.writeTimeout, c.readTimeout
+	if connect <= 0 {
+		connect = fallback
+	}
+	if write <= 0 {
+		write = fallback
+	}
+	if read <= 0 {
+		read = fallback
+	}
+	return connect, write, read
 }
 
-func (c *Cluster) getCachedProximity(id NodeID) int64 {
-	c.proximityCache.RLock()
-	defer c.proximityCache.RUnlock()
-	if proximity, set := c.proximityCache.cache[id]; set {
-		return proximity
-	}
-	return -1
+func (c *Cluster) getTransport() Transport {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.transport
+}
+
+func (c *Cluster) getBindAddress() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.bindAddress
+}
+
+// Joined returns a channel that is closed once the Node has joined the Cluster and announced its presence, at which point its state tables are populated and it can begin serving traffic.
+func (c *Cluster) Joined() <-chan struct{} {
+	return c.joinedCh
 }
 
-func (c *Cluster) clearProximityCache() {
-	c.proximityCache.Lock()
-	defer c.proximityCache.Unlock()
-	c.proximityCache.cache = map[NodeID]int64{}
+// hasJoined returns true if the Node has ever joined the Cluster, even if it has since lost contact with it.
+func (c *Cluster) hasJoined() bool {
+	select {
+	case <-c.joinedCh:
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *Cluster) isJoined() bool {
@@ -166,7 +352,7 @@ func (c *Cluster) String() string {
 
 // GetIP returns the IP address to use when communicating with a Node.
 func (c *Cluster) GetIP(node Node) string {
-	return c.self.GetIP(node)
+	return c.self.address(&node)
 }
 
 // SetLogger sets the log.Logger that the Cluster, along with its child routingTable and leafSet, will write to.
@@ -194,78 +380,291 @@ func (c *Cluster) SetHeartbeatFrequency(freq int) {
 	c.heartbeatFrequency = freq
 }
 
+// SetDigitBits sets b, the number of bits in each digit NodeIDs are routed by. Each hop sends a Message to a Node whose ID shares at least one more digit with the Message's key, so larger digits mean fewer hops, at the cost of a routing table with 2^b columns in each row: about log base 2^b of the number of Nodes hops are taken, and (2^b - 1) times that many Nodes are kept in the routing table. b can be between 1 and 8; by default, it's 4, and NodeIDs are routed as 32 hexadecimal digits.
+//
+// Every Node in the Cluster must use the same b. It must be set before the Node joins the Cluster or learns of any other Node.
+func (c *Cluster) SetDigitBits(b int) error {
+	if b < minDigitBits || b > maxDigitBits {
+		return throwInvalidArgumentError("Digits must have between 1 and 8 bits.")
+	}
+	if c.hasJoined() || len(c.table.list([]int{}, []int{})) > 0 {
+		return throwInvalidArgumentError("The digit size must be set before the Node joins the Cluster.")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	table := newRoutingTableBits(c.self, b)
+	table.log = c.table.log
+	table.logLevel = c.table.logLevel
+	c.table = table
+	return nil
+}
+
 // SetNetworkTimeout sets the number of seconds before which network requests will be considered timed out and killed.
 func (c *Cluster) SetNetworkTimeout(timeout int) {
 	c.networkTimeout = timeout
 }
 
+// SetTimeouts sets the individual timeouts for network requests, overriding SetNetworkTimeout. connect limits how long establishing a connection may take, write limits how long sending a Message may take, and read limits how long the Cluster will wait for a response, or for an inbound Message to arrive. Each timer starts when its phase begins, so a slow write doesn't count against the read timeout. A timeout of zero uses the network timeout.
+func (c *Cluster) SetTimeouts(connect, write, read time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.connectTimeout = connect
+	c.writeTimeout = write
+	c.readTimeout = read
+}
+
+// SetMaxHandlers limits how many inbound connections the Cluster will handle at once. When the limit is reached, the Cluster stops accepting connections until a handler finishes, leaving further connections waiting in the operating system's accept queue. This keeps memory use bounded under load. It should be called before Listen. A max of 0 removes the limit, which is the default.
+func (c *Cluster) SetMaxHandlers(max int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if max <= 0 {
+		c.handlers = nil
+		return
+	}
+	c.handlers = make(chan struct{}, max)
+}
+
+func (c *Cluster) getHandlers() chan struct{} {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.handlers
+}
+
+// SetTransport sets the Transport that the Cluster will use to send and receive Messages. It should be called before Listen; by default, a Cluster uses TCPTransport.
+func (c *Cluster) SetTransport(transport Transport) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.transport = transport
+}
+
+// SetBindAddress sets the IP address the Cluster will listen on. It should be called before Listen. By default, the Cluster listens on all interfaces, accepting both IPv4 and IPv6 connections where the system supports it.
+//
+// Use "0.0.0.0" to listen on all interfaces using only IPv4, or "::" to listen on all interfaces using IPv6 (and IPv4, on dual-stack systems).
+func (c *Cluster) SetBindAddress(address string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.bindAddress = address
+}
+
+// SetBindInterface sets the Cluster to listen on the address of the named network interface, e.g. "eth0". The first IPv4 address of the interface is used; if it has none, its first IPv6 address is used instead. It should be called before Listen.
+func (c *Cluster) SetBindInterface(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			c.SetBindAddress(ipnet.IP.String())
+			return nil
+		}
+		if v6 == nil && !ipnet.IP.IsLinkLocalUnicast() {
+			v6 = ipnet.IP
+		}
+	}
+	if v6 == nil {
+		return noInterfaceAddressError
+	}
+	c.SetBindAddress(v6.String())
+	return nil
+}
+
 // NewCluster creates a new instance of a connection to the network and intialises the state tables and channels it requires.
 func NewCluster(self *Node, credentials Credentials) *Cluster {
+	ctx, kill := context.WithCancel(context.Background())
 	return &Cluster{
 		self:               self,
 		table:              newRoutingTable(self),
 		leafset:            newLeafSet(self),
 		neighborhoodset:    newNeighborhoodSet(self),
-		kill:               make(chan bool),
+		ctx:                ctx,
+		kill:               kill,
 		lastStateUpdate:    time.Now(),
-		applications:       []Application{},
+		applications:       []*registration{},
+		callbackQueueSize:  defaultCallbackQueueSize,
+		purposeHandlers:    map[byte]*purposeHandler{},
+		purposes:           map[byte]string{},
+		indirectProbes:     defaultIndirectProbes,
+		probes:             map[NodeID]chan struct{}{},
+		probation:          defaultProbation,
+		quarantine:         map[NodeID]*quarantineEntry{},
+		bans:               map[NodeID]time.Time{},
+		reputations:        map[NodeID]*Reputation{},
+		traces:             map[uint64]chan traceRoute{},
+		stateRequests:      map[NodeID]chan stateTables{},
+		maxHops:            defaultMaxHops,
+		maintainFrequency:  defaultMaintenanceFrequency,
+		forwarded:          map[uint64]forwarded{},
+		joinChallenges:     map[NodeID]*joinChallenge{},
 		log:                log.New(os.Stdout, "wendy("+self.ID.String()+") ", log.LstdFlags),
 		logLevel:           LogLevelWarn,
 		heartbeatFrequency: 300,
 		networkTimeout:     10,
 		credentials:        credentials,
 		joined:             false,
+		joinedCh:           make(chan struct{}),
 		lock:               new(sync.RWMutex),
+		stateLock:          new(sync.RWMutex),
 		proximityCache:     newProximityCache(),
+		transport:          TCPTransport{},
+		stats:              new(Stats),
+		codec:              JSONCodec{},
+		encoders:           newEncodePool(JSONCodec{}),
 	}
 }
 
 // Stop gracefully shuts down the local connection to the Cluster, removing the local Node from the Cluster and preventing it from receiving or sending further messages.
 //
-// Before it disconnects the Node, Stop contacts every Node it knows of to warn them of its departure. If a graceful disconnect is not necessary, Kill should be used instead. Nodes will remove the Node from their state tables next time they attempt to contact it.
-func (c *Cluster) Stop() {
+// Stop first waits for the connections being handled and the Messages queued to be sent to finish, and saves the state tables if a StateStore is set. It then gives Applications that fulfill HandoffHandler the chance to hand off the keys the Node is responsible for, and contacts every Node it knows of, concurrently, to warn them of its departure, before it disconnects the Node. Each of those steps is given up to the drain timeout set with SetDrainTimeout; if any runs out of time, the Node is disconnected anyway and a DrainError describing the unfinished work is returned. If a graceful disconnect is not necessary, Kill should be used instead. Nodes will remove the Node from their state tables next time they attempt to contact it.
+func (c *Cluster) Stop() error {
+	timeout := c.getDrainTimeout()
+	var drainErr DrainError
+	c.debug("Waiting for handlers and queued sends to finish.")
+	drainErr.Handlers, drainErr.Sends = c.drain(time.Now().Add(timeout))
+	c.saveState()
+	c.debug("Handing off keys.")
+	deadline := time.Now().Add(timeout)
+	drainErr.Handoffs = c.handoff(deadline)
+	for _, v := range c.getVirtualNodes() {
+		drainErr.Handoffs += v.handoff(deadline)
+	}
 	c.debug("Sending graceful exit message.")
+	deadline = time.Now().Add(timeout)
+	drainErr.Exits = c.sendExits(deadline)
+	for _, v := range c.getVirtualNodes() {
+		drainErr.Exits += v.sendExits(deadline)
+	}
+	c.releaseNAT()
+	c.Kill()
+	if drainErr != (DrainError{}) {
+		return drainErr
+	}
+	return nil
+}
+
+// SetDrainTimeout sets how long Stop will wait for in-flight work to finish, then for keys to be handed off, and then for other Nodes to be told of the exit. A timeout of zero uses the network timeout.
+func (c *Cluster) SetDrainTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.drainTimeout = timeout
+}
+
+func (c *Cluster) getDrainTimeout() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.drainTimeout <= 0 {
+		return time.Duration(c.networkTimeout) * time.Second
+	}
+	return c.drainTimeout
+}
+
+// drain waits until no connections are being handled and no Messages are queued, or until deadline, and returns the number of each that remain.
+func (c *Cluster) drain(deadline time.Time) (handlers, sends int) {
+	for {
+		c.lock.RLock()
+		active := c.active
+		c.lock.RUnlock()
+		handlers = 0
+		if active != nil {
+			handlers = active.len()
+		}
+		sends = c.pendingSends()
+		if (handlers == 0 && sends == 0) || !time.Now().Before(deadline) {
+			return handlers, sends
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sendExits tells every Node in the state tables that this Node is leaving, and returns the number that couldn't be told before deadline. Nodes that turn out to be dead already are not counted.
+func (c *Cluster) sendExits(deadline time.Time) int {
 	msg := c.NewMessage(NODE_EXIT, c.self.ID, []byte{})
 	nodes := c.table.list([]int{}, []int{})
 	nodes = append(nodes, c.leafset.list()...)
 	nodes = append(nodes, c.neighborhoodset.list()...)
+	sent := map[NodeID]bool{}
+	results := make(chan error, len(nodes))
 	for _, node := range nodes {
-		err := c.send(msg, node)
-		if err != nil {
-			c.fanOutError(err)
+		if node == nil || sent[node.ID] {
+			continue
+		}
+		sent[node.ID] = true
+		go func(node *Node) {
+			results <- c.send(msg, node)
+		}(node)
+	}
+	waiting := len(sent)
+	failed := 0
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for waiting > 0 {
+		select {
+		case err := <-results:
+			waiting--
+			// a Node that's already gone doesn't need to be told
+			if err != nil && err != deadNodeError {
+				c.fanOutError(err)
+				failed++
+			}
+		case <-timer.C:
+			return failed + waiting
 		}
 	}
-	c.Kill()
+	return failed
 }
 
 // Kill shuts down the local connection to the Cluster, removing the local Node from the Cluster and preventing it from receiving or sending further messages.
 //
-// Unlike Stop, Kill immediately disconnects the Node without sending a message to let other Nodes know of its exit.
+// Unlike Stop, Kill immediately disconnects the Node without sending a message to let other Nodes know of its exit. Kill doesn't block, even if the Cluster isn't listening. Once a Cluster has been killed, it can't listen again.
 func (c *Cluster) Kill() {
 	c.debug("Exiting the cluster.")
-	c.kill <- true
+	c.kill()
 }
 
-// RegisterCallback allows anything that fulfills the Application interface to be hooked into the Wendy's callbacks.
-func (c *Cluster) RegisterCallback(app Application) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	c.applications = append(c.applications, app)
-}
-
-// Listen starts the Cluster listening for events, including all the individual listeners for each state sub-object.
+// Listen starts the Cluster listening for events, including all the individual listeners for each state sub-object. It blocks until the Cluster is killed.
 //
 // Note that Listen does *not* join a Node to the Cluster. The Node must announce its presence before the Node is considered active in the Cluster.
 func (c *Cluster) Listen() error {
-	portstr := strconv.Itoa(c.self.Port)
-	c.debug("Listening on port %d", c.self.Port)
-	ln, err := net.Listen("tcp", ":"+portstr)
+	return c.Serve(context.Background())
+}
+
+// Serve starts the Cluster listening for events, like Listen, until ctx is canceled or the Cluster is killed. It then stops accepting connections, closes the connections it is handling, and waits for their handlers to return before returning itself.
+func (c *Cluster) Serve(ctx context.Context) error {
+	portstr := strconv.Itoa(c.self.getPort())
+	address := net.JoinHostPort(c.getBindAddress(), portstr)
+	c.debug("Listening on %s", address)
+	ln, err := c.getTransport().Listen(address)
 	if err != nil {
 		return err
 	}
+	return c.serve(ctx, ln)
+}
+
+// ListenOn starts the Cluster listening for events on a listener supplied by the caller, instead of having the Cluster's Transport create one. This is useful for, e.g., socket activation, TLS listeners, or tests. The listener is closed when ListenOn returns.
+//
+// If the Port of the current Node is 0, it will be set to the port the listener is bound to. Like Listen, ListenOn blocks until the Cluster is killed, and does *not* join the Node to the Cluster.
+func (c *Cluster) ListenOn(ln net.Listener) error {
+	return c.serve(context.Background(), ln)
+}
+
+func (c *Cluster) serve(ctx context.Context, ln net.Listener) error {
 	defer ln.Close()
-	// save bound port back to Node in case where port is autoconfigured by OS
-	if c.self.Port == 0 {
+	if c.host != nil {
+		return virtualNodeListenError
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(c.ctx, cancel)()
+	// save bound port back to Node in case where port is autoconfigured by OS, before the Cluster is seen to be listening
+	if c.self.getPort() == 0 {
 		c.debug("Port set to 0")
 		colonPos := strings.LastIndex(ln.Addr().String(), ":")
 		if colonPos == -1 {
@@ -278,43 +677,139 @@ func (c *Cluster) Listen() error {
 			return errors.New("Couldn't record autoconfigured port: " + err.Error())
 		}
 		c.debug("Setting port to %d", port)
-		c.self.Port = int(port)
+		c.self.setPort(int(port))
+		for _, v := range c.getVirtualNodes() {
+			v.self.setPort(int(port))
+		}
 	}
+	c.answerPings(ctx, net.JoinHostPort(c.getBindAddress(), strconv.Itoa(c.self.getPort())))
+	handlers := c.getHandlers()
+	release := func() {
+		if handlers != nil {
+			<-handlers
+		}
+	}
+	active := newConnSet()
+	c.setListening(active, time.Now())
+	defer c.setListening(nil, time.Time{})
 	connections := make(chan net.Conn)
 	go func(ln net.Listener, ch chan net.Conn) {
 		for {
+			if handlers != nil {
+				// wait for a free handler before accepting, so excess connections queue in the OS instead of in memory
+				select {
+				case handlers <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
 			conn, err := ln.Accept()
 			if err != nil {
-				c.fanOutError(err)
+				release()
+				if ctx.Err() == nil {
+					c.fanOutError(err)
+				}
 				return
 			}
 			c.debug("Connection received.")
-			ch <- conn
+			select {
+			case ch <- conn:
+			case <-ctx.Done():
+				conn.Close()
+				release()
+				return
+			}
 		}
 	}(ln, connections)
+	// the timer isn't reset by other events, so heartbeats are still sent while the Cluster is busy
+	heartbeat := time.After(time.Duration(c.heartbeatFrequency) * time.Second)
+	maintenance := c.maintenanceTimer()
 	for {
 		select {
-		case <-c.kill:
+		case <-ctx.Done():
+			c.debug("Closing listener and %d connections.", active.len())
+			ln.Close()
+			active.closeAll()
+			active.wait()
 			return nil
-		case <-time.After(time.Duration(c.heartbeatFrequency) * time.Second):
+		case <-heartbeat:
+			heartbeat = time.After(time.Duration(c.heartbeatFrequency) * time.Second)
 			c.debug("Sending heartbeats.")
 			go c.sendHeartbeats()
+			go c.syncState()
+			go c.reconcileLeaves()
+			go c.evictStale()
+			go c.probeRTTs()
+			go c.renewNAT()
+			for _, v := range c.getVirtualNodes() {
+				go v.sendHeartbeats()
+				go v.syncState()
+				go v.reconcileLeaves()
+				go v.evictStale()
+				go v.probeRTTs()
+			}
+			break
+		case <-maintenance:
+			maintenance = c.maintenanceTimer()
+			c.debug("Maintaining routing table.")
+			go c.maintainTable()
+			for _, v := range c.getVirtualNodes() {
+				go v.maintainTable()
+			}
 			break
 		case conn := <-connections:
+			active.add(conn)
+			if !c.allowConnection(conn) {
+				go func(conn net.Conn) {
+					defer active.done(conn)
+					defer release()
+					c.rejectConnection(conn)
+				}(conn)
+				break
+			}
 			c.debug("Handling connection.")
-			go c.handleClient(conn)
-			break
-		case <-c.proximityCache.ticker:
-			c.debug("Emptying proximity cache...")
-			go c.clearProximityCache()
+			go func(conn net.Conn) {
+				defer active.done(conn)
+				defer release()
+				c.handleClient(conn)
+			}(conn)
 			break
 		}
 	}
 	return nil
 }
 
-// Send routes a message through the Cluster.
+// setListening records the connections being handled while the Cluster is listening, or nil once it stops, for the Cluster and its virtual Nodes.
+func (c *Cluster) setListening(active *connSet, since time.Time) {
+	c.lock.Lock()
+	c.active = active
+	if active != nil {
+		c.listeningSince = since
+	}
+	c.lock.Unlock()
+	for _, v := range c.getVirtualNodes() {
+		v.lock.Lock()
+		v.active = active
+		if active != nil {
+			v.listeningSince = since
+		}
+		v.lock.Unlock()
+	}
+}
+
+// Send routes a message through the Cluster. If the Node it's routed to doesn't respond, that Node is suspected, and the message is routed through the next-best Node instead. Purposes below 16 are reserved for Wendy's own Messages, and sending a Message with one returns an InvalidArgumentError. A Message whose Expires time has passed isn't sent, and Nodes discard Messages that expire before they're delivered.
 func (c *Cluster) Send(msg Message) error {
+	if msg.Purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	if msg.expired() {
+		return messageExpiredError
+	}
+	return c.routeMessage(c.sequence(msg))
+}
+
+// routeMessage routes a message through the Cluster, whatever its purpose.
+func (c *Cluster) routeMessage(msg Message) error {
 	c.debug("Getting target for message %s", msg.Key)
 	target, err := c.Route(msg.Key)
 	if err != nil {
@@ -322,18 +817,20 @@ func (c *Cluster) Send(msg Message) error {
 	}
 	if target == nil {
 		c.debug("Couldn't find a target. Delivering message %s", msg.Key)
-		if msg.Purpose > NODE_ANN {
+		if msg.Purpose >= FirstUserPurpose {
 			c.deliver(msg)
 		}
 		return nil
 	}
+	err = c.checkHops(msg)
+	if err != nil {
+		c.warn(err.Error())
+		return err
+	}
+	msg = c.recordHop(msg, target)
 	forward := c.forward(msg, target.ID)
 	if forward {
-		err = c.send(msg, target)
-		if err == deadNodeError {
-			err = c.remove(target.ID)
-		}
-		return err
+		return c.sendWithFailover(msg, target)
 	}
 	c.debug("Message %s wasn't forwarded because callback terminated it.", msg.Key)
 	return nil
@@ -368,7 +865,7 @@ func (c *Cluster) Route(key NodeID) (*Node, error) {
 	}
 	if target != nil {
 		c.debug("Target acquired in routing table.")
-		return target, nil
+		return c.lightHop(key, c.reputableHop(key, target)), nil
 	}
 	return nil, nil
 }
@@ -377,21 +874,140 @@ func (c *Cluster) Route(key NodeID) (*Node, error) {
 //
 // The IP and port passed to Join should be those of a known Node in the Cluster. The algorithm assumes that the known Node is close in proximity to the current Node, but that is not a hard requirement.
 func (c *Cluster) Join(ip string, port int) error {
+	err := c.configureNAT()
+	if err != nil {
+		return err
+	}
+	return c.sendJoin(net.JoinHostPort(ip, strconv.Itoa(port)))
+}
+
+// JoinDNS expresses a Node's desire to join the Cluster, using a DNS name to find the Nodes it can contact. This lets every Node be pointed at a single, stable hostname.
+//
+// If name has SRV records, their targets and ports are tried in the order of their priority and weight. Otherwise, each address (A or AAAA record) name resolves to is tried, using the specified port. Addresses are tried in order until one of them accepts the join message; if none of them do, the last error encountered is returned.
+func (c *Cluster) JoinDNS(name string, port int) error {
+	addresses, err := seedAddresses(name, port)
+	if err != nil {
+		return err
+	}
+	err = c.configureNAT()
+	if err != nil {
+		return err
+	}
+	return c.joinAny(addresses)
+}
+
+// SetSeeds sets the addresses, in "host:port" form, of the known Nodes that JoinSeeds will try to join the Cluster through.
+func (c *Cluster) SetSeeds(addresses []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.seeds = append([]string{}, addresses...)
+}
+
+// JoinSeeds expresses a Node's desire to join the Cluster through the addresses set with SetSeeds. Addresses are tried in order until one of them accepts the join message; if none of them do, the last error encountered is returned.
+func (c *Cluster) JoinSeeds() error {
+	c.lock.RLock()
+	seeds := c.seeds
+	c.lock.RUnlock()
+	err := c.configureNAT()
+	if err != nil {
+		return err
+	}
+	return c.joinAny(seeds)
+}
+
+// JoinAndWait expresses a Node's desire to join the Cluster through seeds, the "host:port" addresses of known Nodes, and blocks until the join has completed and the Node has announced its presence. Seeds are tried in order until one of them accepts the join message.
+//
+// An error is returned if none of the seeds accept the join message, if announcing the Node's presence fails, or if ctx is done before the join completes. If the Node has already joined the Cluster, JoinAndWait returns immediately.
+func (c *Cluster) JoinAndWait(ctx context.Context, seeds []string) error {
+	if c.isJoined() {
+		return nil
+	}
+	result := make(chan error, 1)
+	c.lock.Lock()
+	c.joinResult = result
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if c.joinResult == result {
+			c.joinResult = nil
+		}
+	}()
+	err := c.configureNAT()
+	if err != nil {
+		return err
+	}
+	err = c.joinAny(seeds)
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// finishJoin reports the outcome of announcing the Node's presence to a waiting JoinAndWait call, if there is one.
+func (c *Cluster) finishJoin(err error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.joinResult == nil {
+		return
+	}
+	select {
+	case c.joinResult <- err:
+	default:
+	}
+}
+
+func (c *Cluster) joinAny(addresses []string) error {
+	err := noSeedsError
+	for _, address := range addresses {
+		err = c.sendJoin(address)
+		if err == nil {
+			return nil
+		}
+		c.warn("Couldn't join through %s: %s", address, err.Error())
+	}
+	return err
+}
+
+func (c *Cluster) sendJoin(address string) error {
 	credentials := c.marshalCredentials()
-	c.debug("Sending join message to %s:%d", ip, port)
+	c.debug("Sending join message to %s", address)
 	msg := c.NewMessage(NODE_JOIN, c.self.ID, credentials)
-	address := ip + ":" + strconv.Itoa(port)
 	return c.SendToIP(msg, address)
 }
 
+// seedAddresses resolves a DNS name to the addresses of the Nodes it points to, preferring SRV records.
+func seedAddresses(name string, port int) ([]string, error) {
+	addresses := []string{}
+	_, srvs, err := net.LookupSRV("", "", name)
+	if err == nil && len(srvs) > 0 {
+		for _, srv := range srvs {
+			addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return addresses, nil
+	}
+	hosts, err := net.LookupHost(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range hosts {
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return addresses, nil
+}
+
 func (c *Cluster) fanOutError(err error) {
 	c.debug(err.Error())
-	c.lock.RLock()
-	defer c.lock.RUnlock()
 	c.err(err.Error())
-	for _, app := range c.applications {
+	c.emit(ClusterEvent{Type: ErrorRaised, Err: err})
+	c.notify(EventError, func(app Application) {
 		app.OnError(err)
-	}
+	})
 }
 
 func (c *Cluster) sendHeartbeats() {
@@ -399,7 +1015,8 @@ func (c *Cluster) sendHeartbeats() {
 	nodes := c.table.list([]int{}, []int{})
 	nodes = append(nodes, c.leafset.list()...)
 	nodes = append(nodes, c.neighborhoodset.list()...)
-	sent := map[NodeID]bool{}
+	sent := map[NodeID]<-chan error{}
+	targets := map[NodeID]*Node{}
 	for _, node := range nodes {
 		if node == nil {
 			continue
@@ -408,58 +1025,149 @@ func (c *Cluster) sendHeartbeats() {
 			continue
 		}
 		c.debug("Sending heartbeat to %s", node.ID)
-		err := c.send(msg, node)
-		if err == deadNodeError {
-			err = c.remove(node.ID)
-			if err != nil {
-				c.fanOutError(err)
-			}
-			continue
-		}
-		sent[node.ID] = true
+		sent[node.ID] = c.sendAsync(msg, node)
+		targets[node.ID] = node
 	}
+	c.awaitSends(sent, targets)
+	c.lock.Lock()
+	c.lastHeartbeat = time.Now()
+	c.lock.Unlock()
+	c.saveState()
+	go c.rejoinIfIsolated()
 }
 
 func (c *Cluster) deliver(msg Message) {
-	if msg.Purpose <= NODE_ANN {
+	if msg.Purpose < FirstUserPurpose {
 		c.warn("Received utility message %s to the deliver function. Purpose was %d.", msg.Key, msg.Purpose)
 		return
 	}
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	for _, app := range c.applications {
-		app.OnDeliver(msg)
+	msg = c.recordHop(msg, nil)
+	if len(msg.InReplyTo) > 0 && c.onReply(msg) {
+		return
+	}
+	if len(msg.ID) > 0 {
+		first := c.firstDelivery(msg.ID)
+		// acknowledge duplicates too, in case the first acknowledgement was lost
+		c.acknowledge(msg, false)
+		if !first {
+			atomic.AddUint64(&c.stats.DuplicateMessages, 1)
+			c.debug("Discarding message %s: already delivered.", msg.Key)
+			return
+		}
 	}
+	if msg.Seq > 0 {
+		c.reorder(msg)
+		return
+	}
+	c.deliverNow(msg)
+}
+
+// deliverNow hands a Message to the purpose handler registered for it, or the Applications registered with the Cluster.
+func (c *Cluster) deliverNow(msg Message) {
+	c.emit(ClusterEvent{Type: MessageDelivered, Message: &msg})
+	if c.handle(msg) {
+		return
+	}
+	c.notify(EventDeliver, func(app Application) {
+		app.OnDeliver(msg)
+	})
 }
 
 func (c *Cluster) handleClient(conn net.Conn) {
 	defer conn.Close()
+	_, _, readTimeout := c.getTimeouts()
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
 	var msg Message
-	decoder := json.NewDecoder(conn)
+	reader := getReader(conn)
+	defer releaseReader(reader)
+	decoder := c.getCodec().NewDecoder(reader)
 	err := decoder.Decode(&msg)
 	if err != nil {
 		c.fanOutError(err)
 		return
 	}
-	valid := c.credentials == nil
-	if !valid {
-		valid = c.credentials.Valid(msg.Credentials)
+	if !msg.verifyChecksum() {
+		atomic.AddUint64(&c.stats.CorruptMessages, 1)
+		c.warn("Discarding message %s from %s: checksum mismatch.", msg.Key, conn.RemoteAddr())
+		return
 	}
-	if !valid {
+	c.virtualNodeFor(msg).receive(conn, msg)
+}
+
+// receive handles a Message read from conn by the Cluster, or by the Cluster serving it if it is a virtual Node.
+func (c *Cluster) receive(conn net.Conn, msg Message) {
+	_, writeTimeout, _ := c.getTimeouts()
+	if !c.sameCluster(msg) {
+		atomic.AddUint64(&c.stats.ForeignMessages, 1)
+		id, epoch := c.ClusterID()
+		c.warn("Discarding message %s from %s: it was sent in cluster %q, epoch %d, not %q, epoch %d.", msg.Key, msg.Sender.ID, msg.ClusterID, msg.Epoch, id, epoch)
+		return
+	}
+	err := c.decrypt(&msg)
+	if err != nil {
+		atomic.AddUint64(&c.stats.DecryptionFailures, 1)
+		c.warn("Discarding message %s from %s: couldn't decrypt its value: %s", msg.Key, msg.Sender.ID, err.Error())
+		c.auditCredentialFailure(conn, msg, "undecryptable")
+		return
+	}
+	if !c.validCredentials(conn, msg) {
 		c.warn("Credentials did not match. Supplied credentials: %s", msg.Credentials)
+		c.auditCredentialFailure(conn, msg, "credentials")
+		return
+	}
+	if !c.verifySignature(msg) {
+		atomic.AddUint64(&c.stats.BadSignatures, 1)
+		c.warn("Discarding message %s: not signed by its sender, %s.", msg.Key, msg.Sender.ID)
+		c.auditCredentialFailure(conn, msg, "signature")
+		return
+	}
+	if c.banned(msg.Sender.ID) || (msg.Purpose == NODE_JOIN && c.banned(msg.Key)) {
+		c.warn("Discarding message %s from banned node %s.", msg.Key, msg.Sender.ID)
+		if msg.Purpose == NODE_JOIN {
+			c.auditNode(AuditJoinRefused, msg.Sender, "banned")
+		}
+		return
+	}
+	if !c.allowSender(msg.Sender) {
+		c.debug("Discarding message %s from %s: over the sender rate limit.", msg.Key, msg.Sender.ID)
 		return
 	}
 	if msg.Purpose != NODE_JOIN {
 		node, _ := c.get(msg.Sender.ID)
 		if node != nil {
 			node.updateLastHeardFrom()
+			node.advertise(msg.Sender)
 		}
 	}
+	if c.holding(msg.Sender.ID) {
+		go c.releaseOutbox(msg.Sender)
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 	conn.Write([]byte(`{"status": "Received."}`))
 	c.debug("Got message with purpose %v", msg.Purpose)
+	if msg.Ack {
+		c.onAck(msg)
+		return
+	}
 	msg.Hop = msg.Hop + 1
 	switch msg.Purpose {
 	case NODE_JOIN:
+		if !msg.Sender.hasWork(c.getIDDifficulty()) {
+			atomic.AddUint64(&c.stats.InsufficientWork, 1)
+			c.warn("Refusing join from %s, whose ID doesn't meet the difficulty.", msg.Key)
+			c.auditNode(AuditJoinRefused, msg.Sender, "insufficient work")
+			break
+		}
+		if !c.admitJoin(msg) {
+			atomic.AddUint64(&c.stats.RefusedJoins, 1)
+			c.warn("An Application refused to let %s join.", msg.Key)
+			c.auditNode(AuditJoinRefused, msg.Sender, "refused by application")
+			break
+		}
+		if c.getJoinChallenge() {
+			c.challengeJoin(msg)
+			break
+		}
 		c.onNodeJoin(msg)
 		break
 	case NODE_ANN:
@@ -469,11 +1177,10 @@ func (c *Cluster) handleClient(conn net.Conn) {
 		c.onNodeExit(msg)
 		break
 	case HEARTBEAT:
-		c.lock.RLock()
-		defer c.lock.RUnlock()
-		for _, app := range c.applications {
+		c.emit(ClusterEvent{Type: HeartbeatReceived, Node: msg.Sender.clone()})
+		c.notify(EventHeartbeat, func(app Application) {
 			app.OnHeartbeat(msg.Sender)
-		}
+		})
 		break
 	case STAT_DATA:
 		c.onStateReceived(msg)
@@ -487,6 +1194,30 @@ func (c *Cluster) handleClient(conn net.Conn) {
 	case NODE_REPR:
 		c.onRepairRequest(msg)
 		break
+	case NODE_SYNC:
+		c.onStateDigest(msg)
+		break
+	case NODE_PROBE:
+		c.onProbeRequest(msg)
+		break
+	case NODE_ALIVE:
+		c.onProbeReply(msg)
+		break
+	case NODE_TRACE:
+		c.onTrace(msg)
+		break
+	case NODE_TRACED:
+		c.onTraceReceived(msg)
+		break
+	case NODE_LEAVES:
+		c.onLeafReconcile(msg)
+		break
+	case NODE_CAPS:
+		c.onCapableMessage(msg)
+		break
+	case NODE_AUTH:
+		c.onAuthMessage(msg)
+		break
 	default:
 		c.onMessageReceived(msg)
 	}
@@ -499,14 +1230,26 @@ func (c *Cluster) send(msg Message, destination *Node) error {
 	if c.self == nil {
 		return errors.New("Can't send from a nil node.")
 	}
-	address := c.GetIP(*destination)
+	address := c.self.address(destination)
+	msg.Destination = destination.ID
 	c.debug("Sending message %s with purpose %d to %s", msg.Key, msg.Purpose, address)
-	start := time.Now()
+	policy := c.getRetryPolicy()
 	err := c.SendToIP(msg, address)
+	for attempt := 1; err == deadNodeError && attempt < policy.Attempts; attempt++ {
+		delay := policy.delay(attempt)
+		c.debug("No response from %s, retrying in %s", address, delay)
+		time.Sleep(delay)
+		err = c.SendToIP(msg, address)
+	}
+	c.recordSend(destination.ID, err)
+	if err != nil {
+		c.emit(ClusterEvent{Type: SendFailed, Node: destination.clone(), Message: &msg, Err: err})
+	}
 	if err == nil {
-		proximity := time.Since(start)
-		destination.setProximity(int64(proximity))
 		destination.updateLastHeardFrom()
+		if c.holding(destination.ID) {
+			go c.releaseOutbox(*destination)
+		}
 	}
 	return err
 }
@@ -514,19 +1257,36 @@ func (c *Cluster) send(msg Message, destination *Node) error {
 // SendToIP sends a message directly to an IP using the Wendy networking logic.
 func (c *Cluster) SendToIP(msg Message, address string) error {
 	c.debug("Sending message %s", string(msg.Value))
-	conn, err := net.DialTimeout("tcp", address, time.Duration(c.getNetworkTimeout())*time.Second)
+	err := c.intercept(&msg, address)
+	if err != nil {
+		return err
+	}
+	connectTimeout, writeTimeout, readTimeout := c.getTimeouts()
+	conn, err := c.getTransport().Dial(address, connectTimeout)
 	if err != nil {
 		c.debug(err.Error())
 		return deadNodeError
 	}
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(time.Duration(c.getNetworkTimeout()) * time.Second))
-	encoder := json.NewEncoder(conn)
-	err = encoder.Encode(msg)
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.signCredentials(&msg)
+	c.sign(&msg)
+	err = c.encrypt(&msg)
+	if err != nil {
+		return err
+	}
+	msg.Checksum = msg.checksum()
+	buf, err := c.encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(buf.Bytes())
+	buf.release()
 	if err != nil {
 		return err
 	}
 	c.debug("Sent message %s  with purpose %d to %s", msg.Key, msg.Purpose, address)
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
 	_, err = conn.Read(nil)
 	if err != nil {
 		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
@@ -549,7 +1309,7 @@ func (c *Cluster) onNodeJoin(msg Message) {
 		Rows: []int{},
 		Cols: []int{},
 	}
-	row := c.self.ID.CommonPrefixLen(msg.Key)
+	row := c.table.row(msg.Key)
 	if msg.Hop == 1 {
 		// send only the matching routing table rows
 		for i := 0; i < row; i++ {
@@ -568,6 +1328,10 @@ func (c *Cluster) onNodeJoin(msg Message) {
 	if err != nil {
 		c.fanOutError(err)
 	}
+	if next != nil && next.ID.Equals(msg.Key) {
+		// a Node rejoining after losing contact may still be in the state tables; it's no use routing its join message back to it
+		next = nil
+	}
 	eol := false
 	if next == nil {
 		// also send leaf set, if I'm the last node to get the message
@@ -580,8 +1344,11 @@ func (c *Cluster) onNodeJoin(msg Message) {
 			c.fanOutError(err)
 		}
 	}
+	if eol {
+		return
+	}
 	// forward the message on to the next destination
-	err = c.Send(msg)
+	err = c.routeMessage(msg)
 	if err != nil {
 		c.fanOutError(err)
 	}
@@ -605,7 +1372,7 @@ func (c *Cluster) onNodeAnnounce(msg Message) {
 	}
 	if conflicts > 0 {
 		c.debug("Uh oh, %s hit a race condition. Resending state.", msg.Key)
-		err := c.sendRaceNotification(msg.Sender, StateMask{Mask: conflicts})
+		err := c.sendRaceNotification(msg.Sender, StateMask{Mask: conflicts}, msg.RTVersion, msg.LSVersion, msg.NSVersion)
 		if err != nil {
 			c.fanOutError(err)
 		}
@@ -622,7 +1389,7 @@ func (c *Cluster) onNodeAnnounce(msg Message) {
 
 func (c *Cluster) onNodeExit(msg Message) {
 	c.debug("Node %s left. :(", msg.Sender.ID)
-	err := c.remove(msg.Sender.ID)
+	err := c.remove(msg.Sender.ID, ExitGraceful)
 	if err != nil {
 		c.fanOutError(err)
 		return
@@ -630,23 +1397,27 @@ func (c *Cluster) onNodeExit(msg Message) {
 }
 
 func (c *Cluster) onStateReceived(msg Message) {
-	err := c.insertMessage(msg)
+	var state stateTables
+	err := c.unmarshal(msg.Value, &state)
 	if err != nil {
 		c.debug(err.Error())
 		c.fanOutError(err)
+		return
 	}
-	var state stateTables
-	err = json.Unmarshal(msg.Value, &state)
+	if c.onStateReply(msg, state) {
+		return
+	}
+	err = c.insertMessage(msg)
 	if err != nil {
 		c.debug(err.Error())
 		c.fanOutError(err)
-		return
 	}
 	c.debug("State received. EOL is %v, isJoined is %v.", state.EOL, c.isJoined())
 	if !c.isJoined() && state.EOL {
 		c.debug("Haven't announced presence yet... waiting %d seconds", (2 * c.getNetworkTimeout()))
 		time.Sleep(time.Duration(2*c.getNetworkTimeout()) * time.Second)
 		err = c.announcePresence()
+		c.finishJoin(err)
 		if err != nil {
 			c.fanOutError(err)
 		}
@@ -660,12 +1431,26 @@ func (c *Cluster) onStateReceived(msg Message) {
 func (c *Cluster) onStateRequested(msg Message) {
 	c.debug("%s wants to know about my state tables!", msg.Sender.ID)
 	var mask StateMask
-	err := json.Unmarshal(msg.Value, &mask)
+	err := c.unmarshal(msg.Value, &mask)
 	if err != nil {
 		c.fanOutError(err)
 		return
 	}
-	c.sendStateTables(msg.Sender, mask, false)
+	snapshot := c.snapshot(mask)
+	state := snapshot.state
+	c.withoutBanned(&state)
+	data, err := c.marshal(state)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	// reply with the request's key, so the Node can match the reply to its request
+	reply := c.NewMessage(STAT_DATA, msg.Key, data)
+	snapshot.stamp(&reply)
+	err = c.send(reply, &msg.Sender)
+	if err != nil && err != deadNodeError {
+		c.fanOutError(err)
+	}
 }
 
 func (c *Cluster) onRaceCondition(msg Message) {
@@ -683,7 +1468,7 @@ func (c *Cluster) onRaceCondition(msg Message) {
 func (c *Cluster) onRepairRequest(msg Message) {
 	c.debug("Helping to repair %s", msg.Sender.ID)
 	var mask StateMask
-	err := json.Unmarshal(msg.Value, &mask)
+	err := c.unmarshal(msg.Value, &mask)
 	if err != nil {
 		c.fanOutError(err)
 		return
@@ -693,40 +1478,73 @@ func (c *Cluster) onRepairRequest(msg Message) {
 
 func (c *Cluster) onMessageReceived(msg Message) {
 	c.debug("Received message %s", msg.Key)
-	err := c.Send(msg)
+	if msg.expired() {
+		c.dropExpired(msg)
+		return
+	}
+	if msg.Direct {
+		c.deliver(msg)
+		return
+	}
+	err := c.routeMessage(msg)
 	if err != nil {
 		c.fanOutError(err)
+		c.deadLetter(msg, deadLetterReason(err), err)
 	}
 }
 
 func (c *Cluster) dumpStateTables(tables StateMask) (stateTables, error) {
-	var state stateTables
-	if tables.includeRT() {
-		routingTable := c.table.export(tables.Rows, tables.Cols)
-		state.RoutingTable = &routingTable
+	state := c.snapshot(tables).state
+	c.withoutBanned(&state)
+	return state, nil
+}
+
+// dumpStateDelta dumps only the entries of the state tables that were added at or after the specified version of each table. Deltas never describe Nodes that were removed from the tables; those are discovered when they fail to respond.
+func (c *Cluster) dumpStateDelta(tables StateMask, rtVersion, lsVersion, nsVersion uint64) (stateTables, error) {
+	state, err := c.dumpStateTables(tables)
+	if err != nil {
+		return state, err
 	}
-	if tables.includeLS() {
-		leafSet := c.leafset.export()
-		state.LeafSet = &leafSet
+	state.Delta = true
+	if state.RoutingTable != nil {
+		for row := range state.RoutingTable {
+			for col, node := range state.RoutingTable[row] {
+				if node != nil && node.tableVersion < rtVersion {
+					state.RoutingTable[row][col] = nil
+				}
+			}
+		}
 	}
-	if tables.includeNS() {
-		neighborhoodSet := c.neighborhoodset.export()
-		state.NeighborhoodSet = &neighborhoodSet
+	if state.LeafSet != nil {
+		for side := range state.LeafSet {
+			for pos, node := range state.LeafSet[side] {
+				if node != nil && node.tableVersion < lsVersion {
+					state.LeafSet[side][pos] = nil
+				}
+			}
+		}
+	}
+	if state.NeighborhoodSet != nil {
+		for pos, node := range state.NeighborhoodSet {
+			if node != nil && node.tableVersion < nsVersion {
+				state.NeighborhoodSet[pos] = nil
+			}
+		}
 	}
 	return state, nil
 }
 
 func (c *Cluster) sendStateTables(node Node, tables StateMask, eol bool) error {
-	state, err := c.dumpStateTables(tables)
-	if err != nil {
-		return err
-	}
+	snapshot := c.snapshot(tables)
+	state := snapshot.state
+	c.withoutBanned(&state)
 	state.EOL = eol
-	data, err := json.Marshal(state)
+	data, err := c.marshal(state)
 	if err != nil {
 		return err
 	}
 	msg := c.NewMessage(STAT_DATA, c.self.ID, data)
+	snapshot.stamp(&msg)
 	target, err := c.get(node.ID)
 	if err != nil {
 		if _, ok := err.(IdentityError); !ok && err != nodeNotFoundError {
@@ -739,12 +1557,13 @@ func (c *Cluster) sendStateTables(node Node, tables StateMask, eol bool) error {
 	return c.send(msg, target)
 }
 
-func (c *Cluster) sendRaceNotification(node Node, tables StateMask) error {
-	state, err := c.dumpStateTables(tables)
+// sendRaceNotification sends a Node the entries of our state tables that were added since the versions of the tables it last saw.
+func (c *Cluster) sendRaceNotification(node Node, tables StateMask, rtVersion, lsVersion, nsVersion uint64) error {
+	state, err := c.dumpStateDelta(tables, rtVersion, lsVersion, nsVersion)
 	if err != nil {
 		return err
 	}
-	data, err := json.Marshal(state)
+	data, err := c.marshal(state)
 	if err != nil {
 		return err
 	}
@@ -767,7 +1586,7 @@ func (c *Cluster) announcePresence() error {
 	if err != nil {
 		return err
 	}
-	data, err := json.Marshal(state)
+	data, err := c.marshal(state)
 	if err != nil {
 		return err
 	}
@@ -775,7 +1594,8 @@ func (c *Cluster) announcePresence() error {
 	nodes := c.table.list([]int{}, []int{})
 	nodes = append(nodes, c.leafset.list()...)
 	nodes = append(nodes, c.neighborhoodset.list()...)
-	sent := map[NodeID]bool{}
+	sent := map[NodeID]<-chan error{}
+	targets := map[NodeID]*Node{}
 	for _, node := range nodes {
 		if node == nil {
 			continue
@@ -790,33 +1610,44 @@ func (c *Cluster) announcePresence() error {
 		msg.LSVersion = node.leafsetVersion
 		msg.RTVersion = node.routingTableVersion
 		msg.NSVersion = node.neighborhoodSetVersion
-		err := c.send(msg, node)
-		if err == deadNodeError {
-			err = c.remove(node.ID)
-			if err != nil {
-				c.fanOutError(err)
-			}
-			continue
-		}
-		sent[node.ID] = true
+		sent[node.ID] = c.sendAsync(msg, node)
+		targets[node.ID] = node
 	}
+	c.awaitSends(sent, targets)
 	c.lock.Lock()
-	defer c.lock.Unlock()
+	// a Node that rejoins after losing contact with the Cluster has joined before, and joinedCh is already closed
+	first := !c.hasJoined()
 	c.joined = true
+	if first {
+		close(c.joinedCh)
+	}
+	c.lock.Unlock()
+	if first {
+		c.fanOutJoined()
+	}
 	return nil
 }
 
+func (c *Cluster) fanOutJoined() {
+	c.emit(ClusterEvent{Type: ClusterJoined})
+	c.notify(EventJoined, func(app Application) {
+		if handler, ok := app.(JoinedHandler); ok {
+			handler.OnJoined()
+		}
+	})
+}
+
 func (c *Cluster) repairLeafset(id NodeID) error {
+	mask := StateMask{Mask: lS}
 	target, err := c.leafset.getNextNode(id)
 	if err != nil {
 		if err == nodeNotFoundError {
-			c.warn("No node found when trying to repair the leafset. Was there a catastrophe?")
-		} else {
-			return err
+			c.debug("No node found when trying to repair the leafset. Escalating.")
+			return c.escalateRepair(mask)
 		}
+		return err
 	}
-	mask := StateMask{Mask: lS}
-	data, err := json.Marshal(mask)
+	data, err := c.marshal(mask)
 	if err != nil {
 		return err
 	}
@@ -825,18 +1656,22 @@ func (c *Cluster) repairLeafset(id NodeID) error {
 }
 
 func (c *Cluster) repairTable(id NodeID) error {
-	row := c.self.ID.CommonPrefixLen(id)
+	row := c.table.row(id)
 	reqRow := row
-	col := int(id.Digit(row))
+	col := c.table.col(id, row)
 	targets := []*Node{}
-	for len(targets) < 1 && row < len(c.table.nodes) {
+	for len(targets) < 1 && row < c.table.rowCount() {
 		targets = c.table.list([]int{row}, []int{})
 		if len(targets) < 1 {
 			row = row + 1
 		}
 	}
 	mask := StateMask{Mask: rT, Rows: []int{reqRow}, Cols: []int{col}}
-	data, err := json.Marshal(mask)
+	if len(targets) < 1 {
+		c.debug("No node found when trying to repair the routing table. Escalating.")
+		return c.escalateRepair(mask)
+	}
+	data, err := c.marshal(mask)
 	if err != nil {
 		return err
 	}
@@ -853,7 +1688,11 @@ func (c *Cluster) repairTable(id NodeID) error {
 func (c *Cluster) repairNeighborhood() error {
 	targets := c.neighborhoodset.list()
 	mask := StateMask{Mask: nS}
-	data, err := json.Marshal(mask)
+	if countNodes(targets) < 1 {
+		c.debug("No node found when trying to repair the neighborhood set. Escalating.")
+		return c.escalateRepair(mask)
+	}
+	data, err := c.marshal(mask)
 	if err != nil {
 		return err
 	}
@@ -870,53 +1709,47 @@ func (c *Cluster) repairNeighborhood() error {
 func (c *Cluster) updateProximity(node *Node) error {
 	proximity := c.getCachedProximity(node.ID)
 	if proximity < 0 {
-		msg := c.NewMessage(HEARTBEAT, c.self.ID, []byte{})
 		c.debug("Checking proximity to %s", node.ID)
-		err := c.send(msg, node)
+		rtt, err := c.ping(node)
 		if err != nil {
 			return err
 		}
 		c.debug("Proximity to %s checked.", node.ID)
-		c.cacheProximity(node.ID, node.getRawProximity())
-		c.debug("Proximity to %s cached.", node.ID)
+		proximity = int64(rtt)
+		if smoothed := c.getCachedProximity(node.ID); smoothed >= 0 {
+			proximity = smoothed
+		}
 	}
+	node.setProximity(proximity)
 	return nil
 }
 
 func (c *Cluster) insertMessage(msg Message) error {
 	var state stateTables
-	err := json.Unmarshal(msg.Value, &state)
+	err := c.unmarshal(msg.Value, &state)
 	if err != nil {
-		c.debug("Error unmarshalling JSON: %s", err.Error())
+		c.debug("Error unmarshalling state tables: %s", err.Error())
 		return err
 	}
 	sender := &msg.Sender
 	c.debug("Updating versions for %s. RT: %d, LS: %d, NS: %d.", sender.ID.String(), msg.RTVersion, msg.LSVersion, msg.NSVersion)
 	sender.updateVersions(msg.RTVersion, msg.LSVersion, msg.NSVersion)
-	err = c.insert(*sender, StateMask{Mask: all})
-	if err != nil {
-		return err
-	}
+	// the sender just contacted us, so it's alive even if we removed it recently
+	c.release(sender.ID)
+	// the whole payload is inserted at once, so the state tables change, and Applications are told of the new leaf set, only once
+	updates := []stateUpdate{{*sender, StateMask{Mask: all}}}
 	if state.NeighborhoodSet != nil {
 		for _, node := range state.NeighborhoodSet {
-			if node == nil {
-				continue
-			}
-			err = c.insert(*node, StateMask{Mask: nS})
-			if err != nil {
-				return err
+			if node != nil {
+				updates = append(updates, stateUpdate{*node, StateMask{Mask: nS}})
 			}
 		}
 	}
 	if state.LeafSet != nil {
 		for _, side := range state.LeafSet {
 			for _, node := range side {
-				if node == nil {
-					continue
-				}
-				err = c.insert(*node, StateMask{Mask: lS | nS})
-				if err != nil {
-					return err
+				if node != nil {
+					updates = append(updates, stateUpdate{*node, StateMask{Mask: lS | nS}})
 				}
 			}
 		}
@@ -924,109 +1757,167 @@ func (c *Cluster) insertMessage(msg Message) error {
 	if state.RoutingTable != nil {
 		for _, row := range state.RoutingTable {
 			for _, node := range row {
-				if node == nil {
-					continue
-				}
-				err = c.insert(*node, StateMask{Mask: rT | nS})
-				if err != nil {
-					return err
+				if node != nil {
+					updates = append(updates, stateUpdate{*node, StateMask{Mask: rT | nS}})
 				}
 			}
 		}
 	}
-	return nil
+	return c.insertBatch(updates)
 }
 
 func (c *Cluster) insert(node Node, tables StateMask) error {
-	if node.IsZero() {
-		return nil
-	}
-	if node.ID.Equals(c.self.ID) {
-		c.debug("Skipping inserting myself.")
-		return nil
-	}
-	c.debug("Inserting node %s", node.ID)
-	if node.getRawProximity() <= 0 && (tables.includeNS() || tables.includeRT()) {
-		c.debug("Updating proximity")
-		c.updateProximity(&node)
-		c.debug("Updated proximity")
-		c.debug("Inserting node %s in routing table.", node.ID)
-		resp, err := c.table.insertNode(node, node.getRawProximity())
-		if err != nil && err != rtDuplicateInsertError {
-			c.err("Error inserting node: %s", err.Error())
-			return err
+	return c.insertBatch([]stateUpdate{{node, tables}})
+}
+
+// stateUpdate is a Node to insert, and the state tables to insert it into.
+type stateUpdate struct {
+	node   Node
+	tables StateMask
+}
+
+// insertBatch inserts each Node into the state tables specified for it. Proximities are measured first; then every table is changed at once, so the version of each table is incremented no more than once for the whole batch, and Applications are told of the new leaf set no more than once. The first error inserting into any table is returned, after the rest of the batch has been inserted.
+func (c *Cluster) insertBatch(updates []stateUpdate) error {
+	var rt, ls, ns []*Node
+	for _, update := range updates {
+		node, tables := update.node, update.tables
+		if node.IsZero() {
+			continue
 		}
-		if resp != nil && err != rtDuplicateInsertError {
-			c.debug("Inserted node %s in routing table.", resp.ID)
+		if node.ID.Equals(c.self.ID) {
+			c.debug("Skipping inserting myself.")
+			continue
 		}
-		if err == rtDuplicateInsertError {
-			c.debug(err.Error())
+		if c.banned(node.ID) {
+			c.debug("Skipping inserting banned node %s.", node.ID)
+			continue
 		}
-	}
-	if tables.includeLS() {
-		c.debug("Inserting node %s in leaf set.", node.ID)
-		resp, err := c.leafset.insertNode(node)
-		if err != nil && err != lsDuplicateInsertError {
-			return err
+		if c.getSigningKey() != nil && !node.identityBound() {
+			c.debug("Skipping inserting node %s, whose ID isn't derived from its public key.", node.ID)
+			continue
 		}
-		if resp != nil && err != lsDuplicateInsertError {
-			c.debug("Inserted node %s in leaf set.", resp.ID)
-			c.newLeaves(c.leafset.list())
+		if !node.hasWork(c.getIDDifficulty()) {
+			c.debug("Skipping inserting node %s, whose ID doesn't meet the difficulty.", node.ID)
+			continue
 		}
-		c.debug("At the end of the leafset insert block.")
-		if err == lsDuplicateInsertError {
-			c.debug(err.Error())
+		if c.quarantined(node.ID) {
+			c.debug("Node %s was removed recently. Checking on it before inserting it.", node.ID)
+			go c.readmit(node, tables)
+			continue
 		}
-	}
-	if tables.includeNS() {
-		c.debug("Inserting node %s in neighborhood set.", node.ID)
-		resp, err := c.neighborhoodset.insertNode(node, node.getRawProximity())
-		if err != nil && err != nsDuplicateInsertError {
-			return err
+		c.debug("Inserting node %s", node.ID)
+		if node.getRawProximity() <= 0 && (tables.includeNS() || tables.includeRT()) {
+			c.debug("Updating proximity")
+			c.updateProximity(&node)
+			c.debug("Updated proximity")
+			rt = append(rt, c.copyForInsert(node))
 		}
-		if resp != nil && err != nsDuplicateInsertError {
-			c.debug("Inserted node %s in neighborhood set.", resp.ID)
+		if tables.includeLS() {
+			ls = append(ls, node.clone())
 		}
-		if err == nsDuplicateInsertError {
-			c.debug(err.Error())
+		if tables.includeNS() {
+			ns = append(ns, c.copyForInsert(node))
 		}
 	}
-	return nil
-}
-
-func (c *Cluster) remove(id NodeID) error {
-	resp, err := c.table.removeNode(id)
-	if err != nil {
-		return err
+	if len(rt)+len(ls)+len(ns) == 0 {
+		return nil
 	}
-	if resp != nil {
-		err = c.repairTable(resp.ID)
+	c.stateLock.RLock()
+	rtNodes, rtErr := c.table.insertBatch(rt)
+	lsNodes, lsErr := c.leafset.insertBatch(ls)
+	nsNodes, nsErr := c.neighborhoodset.insertBatch(ns)
+	c.stateLock.RUnlock()
+	for _, node := range rtNodes {
+		c.debug("Inserted node %s in routing table.", node.ID)
+	}
+	for _, node := range lsNodes {
+		c.debug("Inserted node %s in leaf set.", node.ID)
+	}
+	for _, node := range nsNodes {
+		c.debug("Inserted node %s in neighborhood set.", node.ID)
+	}
+	if len(lsNodes) > 0 {
+		c.newLeaves(c.leafset.list())
+	}
+	for _, err := range []error{rtErr, lsErr, nsErr} {
 		if err != nil {
+			c.err("Error inserting node: %s", err.Error())
 			return err
 		}
 	}
-	resp, err = c.leafset.removeNode(id)
-	if err != nil {
-		return err
+	return nil
+}
+
+// copyForInsert copies a Node for insertion into a table ordered by proximity, keeping the proximity the copy would otherwise lose.
+func (c *Cluster) copyForInsert(node Node) *Node {
+	copied := node.clone()
+	copied.setProximity(node.getRawProximity())
+	return copied
+}
+
+// remove removes the Node from every state table and tries to repair them, then quarantines it so stale state tables from other Nodes don't put it straight back. The Node is removed from every table even if repairing one fails, as it does when no other Node is left to ask, so a Node that loses contact with the Cluster ends up with empty state tables; the first repair error is returned. If the Node was in any of the state tables, Applications are told it exited for the specified reason.
+func (c *Cluster) remove(id NodeID, reason ExitReason) error {
+	var repairErr error
+	var removed *Node
+	defer func() {
+		if removed != nil {
+			c.nodeExited(*removed, reason)
+		}
+	}()
+	c.quarantineNode(id)
+	// the Node is removed from every table before any is repaired, so a snapshot never sees it in some tables but not others
+	c.stateLock.RLock()
+	rtResp, rtErr := c.table.removeNode(id)
+	lsResp, lsErr := c.leafset.removeNode(id)
+	nsResp, nsErr := c.neighborhoodset.removeNode(id)
+	c.stateLock.RUnlock()
+	if rtErr != nil && rtErr != nodeNotFoundError {
+		// Nodes heard of through the leaf set may not be in the routing table
+		return rtErr
+	}
+	if rtResp != nil {
+		removed = rtResp
+		err := c.repairTable(rtResp.ID)
+		if err != nil && repairErr == nil {
+			repairErr = err
+		}
 	}
-	if resp != nil {
-		err = c.repairLeafset(resp.ID)
-		if err != nil {
-			return err
+	if lsErr != nil {
+		return lsErr
+	}
+	if lsResp != nil {
+		removed = lsResp
+		err := c.repairLeafset(lsResp.ID)
+		if err != nil && repairErr == nil {
+			repairErr = err
 		}
 		c.newLeaves(c.leafset.list())
 	}
-	resp, err = c.neighborhoodset.removeNode(id)
-	if err != nil {
-		return err
+	if nsErr != nil {
+		return nsErr
 	}
-	if resp != nil {
-		err = c.repairNeighborhood()
-		if err != nil {
-			return err
+	if nsResp != nil {
+		removed = nsResp
+		err := c.repairNeighborhood()
+		if err != nil && repairErr == nil {
+			repairErr = err
 		}
 	}
-	return nil
+	return repairErr
+}
+
+// nodeExited tells Applications that a Node was removed from the state tables, and why.
+func (c *Cluster) nodeExited(node Node, reason ExitReason) {
+	c.debug("Node %s exited: %s", node.ID, reason)
+	c.emit(ClusterEvent{Type: NodeRemoved, Node: node.clone(), Reason: reason})
+	c.auditExit(node, reason)
+	c.notify(EventNodeExit, func(app Application) {
+		if handler, ok := app.(ExitReasonHandler); ok {
+			handler.OnNodeExitWithReason(node, reason)
+			return
+		}
+		app.OnNodeExit(node)
+	})
 }
 
 func (c *Cluster) get(id NodeID) (*Node, error) {
@@ -0,0 +1,27 @@
+package wendy
+
+// SetClusterID sets the ID of the Cluster the current Node belongs to, and the epoch of that Cluster, keeping Nodes from different Clusters, or from before a Cluster was reset, from merging their state tables. The ID and epoch are sent with every Message, and Messages sent with any other ID or epoch are discarded, and counted in Stats. To reset a Cluster, restart its Nodes with the next epoch; Nodes still running the old one are cut off, rather than filling the new state tables with Nodes from before the reset.
+//
+// Every Node in the Cluster must set the same ID and epoch; by default, the ID is empty and the epoch is 0. Virtual Nodes added to the Cluster use the same ID and epoch.
+func (c *Cluster) SetClusterID(id string, epoch uint64) {
+	c.lock.Lock()
+	c.clusterID = id
+	c.epoch = epoch
+	c.lock.Unlock()
+	for _, v := range c.getVirtualNodes() {
+		v.SetClusterID(id, epoch)
+	}
+}
+
+// ClusterID returns the ID and epoch of the Cluster the current Node belongs to, as set with SetClusterID.
+func (c *Cluster) ClusterID() (string, uint64) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.clusterID, c.epoch
+}
+
+// sameCluster returns true if the Message was sent with the current Node's Cluster ID and epoch.
+func (c *Cluster) sameCluster(msg Message) bool {
+	id, epoch := c.ClusterID()
+	return msg.ClusterID == id && msg.Epoch == epoch
+}
@@ -0,0 +1,85 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that the Cluster ID and epoch are sent with every Message, and shared with virtual Nodes
+func TestSetClusterID(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetClusterID("production", 2)
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, nil)
+	if msg.ClusterID != "production" || msg.Epoch != 2 {
+		t.Errorf("Expected the Message to be sent in cluster production, epoch 2, got %q, epoch %d.", msg.ClusterID, msg.Epoch)
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	v, err := cluster.AddVirtualNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if clusterID, epoch := v.ClusterID(); clusterID != "production" || epoch != 2 {
+		t.Errorf("Expected the virtual Node to be in cluster production, epoch 2, got %q, epoch %d.", clusterID, epoch)
+	}
+	cluster.SetClusterID("production", 3)
+	if _, epoch := v.ClusterID(); epoch != 3 {
+		t.Errorf("Expected the virtual Node to move to epoch 3, got %d.", epoch)
+	}
+}
+
+// Test that Messages from another Cluster, or another epoch, are discarded
+func TestClusterIDIsolation(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	one.SetClusterID("production", 2)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	senders := []struct {
+		clusterID string
+		epoch     uint64
+		delivered bool
+	}{
+		{"production", 2, true},
+		{"production", 1, false},
+		{"staging", 2, false},
+		{"", 0, false},
+	}
+	for _, sender := range senders {
+		two, err := makeCluster("this is some other Node for testing purposes only.")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		two.SetClusterID(sender.clusterID, sender.epoch)
+		err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte(sender.clusterID)), two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case msg := <-callback.onDeliver:
+			if !sender.delivered {
+				t.Errorf("Expected the Message from cluster %q, epoch %d, to be discarded, got %+v.", sender.clusterID, sender.epoch, msg)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if sender.delivered {
+				t.Errorf("Expected the Message from cluster %q, epoch %d, to be delivered.", sender.clusterID, sender.epoch)
+			}
+		}
+	}
+	if foreign := one.Stats().ForeignMessages; foreign != 3 {
+		t.Errorf("Expected 3 foreign Messages, got %d.", foreign)
+	}
+}
@@ -0,0 +1,69 @@
+package wendy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Codec is an interface that can be fulfilled to control how Messages, and the state information carried inside them, are encoded when they are sent between Nodes. Every Node in the Cluster must use the same Codec.
+//
+// NewEncoder returns an Encoder that writes encoded values to w. The Cluster encodes a Message for each connection it opens, and also encodes the state tables and StateMasks it places in the Value of its own Messages. Encoders are reused, so each value an Encoder writes must be decodable on its own by a new Decoder.
+//
+// NewDecoder returns a Decoder that reads values encoded by the Encoder from r.
+type Codec interface {
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Encoder writes encoded values to a stream. It is fulfilled by, e.g., *json.Encoder.
+type Encoder interface {
+	Encode(v interface{}) error
+}
+
+// Decoder reads encoded values from a stream. It is fulfilled by, e.g., *json.Decoder.
+type Decoder interface {
+	Decode(v interface{}) error
+}
+
+// JSONCodec is an implementation of Codec that encodes values as JSON. It is the Codec used by a Cluster unless another is specified with SetCodec.
+type JSONCodec struct{}
+
+// NewEncoder returns a json.Encoder writing to w.
+func (j JSONCodec) NewEncoder(w io.Writer) Encoder {
+	return json.NewEncoder(w)
+}
+
+// NewDecoder returns a json.Decoder reading from r.
+func (j JSONCodec) NewDecoder(r io.Reader) Decoder {
+	return json.NewDecoder(r)
+}
+
+// SetCodec sets the Codec the Cluster uses to encode Messages. It should be called before Listen; by default, a Cluster uses JSONCodec.
+func (c *Cluster) SetCodec(codec Codec) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.codec = codec
+	c.encoders = newEncodePool(codec)
+}
+
+func (c *Cluster) getCodec() Codec {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.codec
+}
+
+// marshal encodes v with the Cluster's Codec, for use as the Value of a Message.
+func (c *Cluster) marshal(v interface{}) ([]byte, error) {
+	buf, err := c.encode(v)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.release()
+	return append([]byte{}, buf.Bytes()...), nil
+}
+
+// unmarshal decodes the Value of a Message encoded by marshal into v.
+func (c *Cluster) unmarshal(data []byte, v interface{}) error {
+	return c.getCodec().NewDecoder(bytes.NewReader(data)).Decode(v)
+}
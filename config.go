@@ -0,0 +1,194 @@
+package wendy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config describes a Node and the Cluster it belongs to, so Nodes can be configured from a file instead of in Go.
+//
+// Fields left empty keep the defaults NewCluster uses. Timeouts are written as strings understood by time.ParseDuration, e.g. "1.5s". A sample configuration:
+//
+//	{
+//		"id": "this is a Node that was configured from a file",
+//		"local_ip": "10.0.0.2",
+//		"global_ip": "203.0.113.2",
+//		"port": 8080,
+//		"region": "us-east",
+//		"seeds": ["10.0.0.1:8080", "10.0.0.3:8080"],
+//		"connect_timeout": "2s",
+//		"passphrase": "open sesame",
+//		"codec": "protobuf"
+//	}
+type Config struct {
+	ID                 string   `json:"id"` // at least 16 bytes, passed to NodeIDFromBytes
+	LocalIP            string   `json:"local_ip,omitempty"`
+	GlobalIP           string   `json:"global_ip,omitempty"`
+	LocalIPv6          string   `json:"local_ipv6,omitempty"`
+	GlobalIPv6         string   `json:"global_ipv6,omitempty"`
+	Port               int      `json:"port,omitempty"`
+	GlobalPort         int      `json:"global_port,omitempty"`
+	Region             string   `json:"region,omitempty"` // e.g. "europe/eu-west-1/eu-west-1a"
+	BindAddress        string   `json:"bind_address,omitempty"`
+	BindInterface      string   `json:"bind_interface,omitempty"`      // overrides BindAddress
+	Seeds              []string `json:"seeds,omitempty"`               // "host:port" addresses used by JoinSeeds
+	ClusterID          string   `json:"cluster_id,omitempty"`          // every Node in the Cluster must agree; see SetClusterID
+	Epoch              uint64   `json:"epoch,omitempty"`               // raised whenever the Cluster is reset
+	StatePath          string   `json:"state_path,omitempty"`          // a file to save the state tables to, for WarmStart
+	DigitBits          int      `json:"digit_bits,omitempty"`          // the number of bits in each routing digit; every Node must agree
+	HeartbeatFrequency int      `json:"heartbeat_frequency,omitempty"` // in seconds
+	NetworkTimeout     int      `json:"network_timeout,omitempty"`     // in seconds
+	ConnectTimeout     Duration `json:"connect_timeout,omitempty"`
+	WriteTimeout       Duration `json:"write_timeout,omitempty"`
+	ReadTimeout        Duration `json:"read_timeout,omitempty"`
+	MaxHandlers        int      `json:"max_handlers,omitempty"`
+	Passphrase         string   `json:"passphrase,omitempty"`
+	HMACSecret         string   `json:"hmac_secret,omitempty"`
+	TLSCert            string   `json:"tls_cert,omitempty"`
+	TLSKey             string   `json:"tls_key,omitempty"`
+	TLSCA              string   `json:"tls_ca,omitempty"`
+	Codec              string   `json:"codec,omitempty"`     // "json" (the default), "protobuf", or "gob"
+	LogLevel           string   `json:"log_level,omitempty"` // "debug", "warn" (the default), or "error"
+}
+
+// Duration is a time.Duration that is written in configuration files as a string, e.g. "1.5s".
+type Duration time.Duration
+
+// UnmarshalJSON fulfills the Unmarshaler interface, parsing the Duration with time.ParseDuration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON fulfills the Marshaler interface, writing the Duration in the form UnmarshalJSON reads.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads the Config in the file at path and creates a Cluster from it. The returned Cluster is ready to Listen, after which JoinSeeds will join it to the Cluster through the configured seeds.
+//
+// The file must be JSON. YAML files are accepted only if they are written in YAML's JSON-compatible flow style, as Wendy doesn't include a YAML parser. Unknown fields are an error, so typos don't silently leave options unset.
+func LoadConfig(path string) (*Cluster, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var config Config
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+	if err != nil {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext == ".yaml" || ext == ".yml" {
+			return nil, throwInvalidArgumentError("YAML configuration must be written in JSON-compatible flow style: " + err.Error())
+		}
+		return nil, err
+	}
+	return config.NewCluster()
+}
+
+// NewCluster creates a Cluster for the Node described by the Config.
+func (config Config) NewCluster() (*Cluster, error) {
+	id, err := NodeIDFromBytes([]byte(config.ID))
+	if err != nil {
+		return nil, err
+	}
+	var codec Codec
+	switch strings.ToLower(config.Codec) {
+	case "", "json":
+		codec = JSONCodec{}
+	case "protobuf":
+		codec = ProtobufCodec{}
+	case "gob":
+		codec = GobCodec{}
+	default:
+		return nil, throwInvalidArgumentError("Unknown codec " + config.Codec + ".")
+	}
+	logLevel := LogLevelWarn
+	switch strings.ToLower(config.LogLevel) {
+	case "", "warn":
+	case "debug":
+		logLevel = LogLevelDebug
+	case "error":
+		logLevel = LogLevelError
+	default:
+		return nil, throwInvalidArgumentError("Unknown log level " + config.LogLevel + ".")
+	}
+	node := NewNode(id, config.LocalIP, config.GlobalIP, config.Region, config.Port)
+	node.LocalIPv6 = config.LocalIPv6
+	node.GlobalIPv6 = config.GlobalIPv6
+	node.GlobalPort = config.GlobalPort
+	var credentials Credentials
+	set := 0
+	for _, secret := range []string{config.Passphrase, config.HMACSecret, config.TLSCert + config.TLSKey + config.TLSCA} {
+		if secret != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, throwInvalidArgumentError("Only one of passphrase, hmac_secret, and the tls_ files can be set.")
+	}
+	if config.Passphrase != "" {
+		credentials = Passphrase(config.Passphrase)
+	}
+	if config.HMACSecret != "" {
+		credentials = HMACCredentials{Secret: []byte(config.HMACSecret)}
+	}
+	var membership *MutualTLS
+	if config.TLSCert != "" || config.TLSKey != "" || config.TLSCA != "" {
+		if config.TLSCert == "" || config.TLSKey == "" || config.TLSCA == "" {
+			return nil, throwInvalidArgumentError("tls_cert, tls_key, and tls_ca must all be set to use mutual TLS.")
+		}
+		membership, err = LoadMutualTLS(config.TLSCert, config.TLSKey, config.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		credentials = membership
+	}
+	cluster := NewCluster(node, credentials)
+	if membership != nil {
+		cluster.SetTransport(membership)
+	}
+	cluster.SetLogLevel(logLevel)
+	cluster.SetCodec(codec)
+	cluster.SetSeeds(config.Seeds)
+	cluster.SetClusterID(config.ClusterID, config.Epoch)
+	if config.StatePath != "" {
+		cluster.SetStateStore(FileStateStore(config.StatePath))
+	}
+	if config.DigitBits > 0 {
+		err = cluster.SetDigitBits(config.DigitBits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.HeartbeatFrequency > 0 {
+		cluster.SetHeartbeatFrequency(config.HeartbeatFrequency)
+	}
+	if config.NetworkTimeout > 0 {
+		cluster.SetNetworkTimeout(config.NetworkTimeout)
+	}
+	cluster.SetTimeouts(time.Duration(config.ConnectTimeout), time.Duration(config.WriteTimeout), time.Duration(config.ReadTimeout))
+	cluster.SetMaxHandlers(config.MaxHandlers)
+	cluster.SetBindAddress(config.BindAddress)
+	if config.BindInterface != "" {
+		err = cluster.SetBindInterface(config.BindInterface)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cluster, nil
+}
@@ -0,0 +1,86 @@
+package wendy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return path
+}
+
+// Test that LoadConfig creates a Cluster using the settings in the file
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, "wendy.json", `{
+		"id": "this is a test Node for testing purposes only.",
+		"local_ip": "10.0.0.2",
+		"global_ip": "203.0.113.2",
+		"port": 8080,
+		"region": "testing",
+		"seeds": ["10.0.0.1:8080", "10.0.0.3:8080"],
+		"cluster_id": "production",
+		"epoch": 2,
+		"network_timeout": 3,
+		"connect_timeout": "1.5s",
+		"passphrase": "open sesame",
+		"codec": "protobuf",
+		"log_level": "debug"
+	}`)
+	cluster, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	self := cluster.self
+	if !self.ID.Equals(id) || self.LocalIP != "10.0.0.2" || self.GlobalIP != "203.0.113.2" || self.Port != 8080 || self.Region != "testing" {
+		t.Errorf("Expected the Node to be configured, got %+v.", *self)
+	}
+	if len(cluster.seeds) != 2 || cluster.seeds[1] != "10.0.0.3:8080" {
+		t.Errorf("Expected two seeds, got %v.", cluster.seeds)
+	}
+	if clusterID, epoch := cluster.ClusterID(); clusterID != "production" || epoch != 2 {
+		t.Errorf("Expected cluster production, epoch 2, got %q, epoch %d.", clusterID, epoch)
+	}
+	connect, write, _ := cluster.getTimeouts()
+	if connect != 1500*time.Millisecond || write != 3*time.Second {
+		t.Errorf("Expected timeouts of 1.5s and 3s, got %s and %s.", connect, write)
+	}
+	if cluster.credentials == nil || !cluster.credentials.Valid([]byte("open sesame")) {
+		t.Errorf("Expected the passphrase to be used as credentials.")
+	}
+	if _, ok := cluster.getCodec().(ProtobufCodec); !ok {
+		t.Errorf("Expected a ProtobufCodec, got %T.", cluster.getCodec())
+	}
+	if cluster.logLevel != LogLevelDebug {
+		t.Errorf("Expected log level %d, got %d.", LogLevelDebug, cluster.logLevel)
+	}
+}
+
+// Test that LoadConfig rejects files with mistakes in them
+func TestLoadConfigInvalid(t *testing.T) {
+	configs := map[string]string{
+		"unknown.json":  `{"id": "this is a test Node for testing purposes only.", "prot": 8080}`,
+		"codec.json":    `{"id": "this is a test Node for testing purposes only.", "codec": "xml"}`,
+		"duration.json": `{"id": "this is a test Node for testing purposes only.", "read_timeout": "soon"}`,
+		"id.json":       `{"id": "too short"}`,
+		"secrets.json":  `{"id": "this is a test Node for testing purposes only.", "passphrase": "open sesame", "hmac_secret": "open sesame"}`,
+		"tls.json":      `{"id": "this is a test Node for testing purposes only.", "tls_cert": "node.crt"}`,
+		"block.yaml":    "id: this is a test Node for testing purposes only.\n",
+	}
+	for name, contents := range configs {
+		_, err := LoadConfig(writeConfig(t, name, contents))
+		if err == nil {
+			t.Errorf("Expected an error loading %s.", name)
+		}
+	}
+}
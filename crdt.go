@@ -0,0 +1,417 @@
+package wendy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+var crdtKindError = errors.New("The key holds a different kind of value.")
+var crdtValueError = errors.New("A replicated data type's value was malformed.")
+
+const (
+	crdtCounter     = byte(iota) // a PN-counter: each Node's increments and decrements
+	crdtORSet                    // an observed-remove set: each element's tags, and whether they were removed
+	crdtLWWRegister              // a last-writer-wins register: the value, when it was written, and by whom
+)
+
+// orSetTagSize is the size of the tag an ORSet gives each element each time it's added.
+const orSetTagSize = 16
+
+// mergeCRDT merges two values of the same replicated data type, returning an error if they're of different kinds, or malformed.
+func mergeCRDT(a, b []byte) ([]byte, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return nil, crdtValueError
+	}
+	if a[0] != b[0] {
+		return nil, crdtKindError
+	}
+	switch a[0] {
+	case crdtCounter:
+		x, err := unmarshalCounter(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := unmarshalCounter(b)
+		if err != nil {
+			return nil, err
+		}
+		return x.merge(y).marshal(), nil
+	case crdtORSet:
+		x, err := unmarshalORSet(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := unmarshalORSet(b)
+		if err != nil {
+			return nil, err
+		}
+		return x.merge(y).marshal(), nil
+	case crdtLWWRegister:
+		x, err := unmarshalLWWRegister(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := unmarshalLWWRegister(b)
+		if err != nil {
+			return nil, err
+		}
+		return x.merge(y).marshal(), nil
+	}
+	return nil, crdtValueError
+}
+
+// readCRDT reads the value of a replicated data type of the specified kind from a KVStore, returning nil if the key has no value, and the VectorClock to write it back with.
+func (s *KVStore) readCRDT(ctx context.Context, key string, kind byte) ([]byte, VectorClock, error) {
+	record, err := s.read(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !record.Found || record.Deleted {
+		return nil, record.Clock, nil
+	}
+	if !record.CRDT || len(record.Value) == 0 || record.Value[0] != kind {
+		return nil, nil, crdtKindError
+	}
+	return record.Value, record.Clock, nil
+}
+
+// writeCRDT writes the value of a replicated data type to a KVStore, replacing the one read with clock.
+func (s *KVStore) writeCRDT(ctx context.Context, key string, value []byte, clock VectorClock) error {
+	return s.write(ctx, key, nil, kvRecord{Found: true, CRDT: true, Version: time.Now().UnixNano(), Clock: clock, Value: value})
+}
+
+// Counter is a counter stored under a KVStore's key, which any Node can add to at the same time without losing updates. Each Node's additions and subtractions are kept apart, and concurrent updates are merged by taking each Node's latest, so every replica converges on the same total.
+//
+// A Node's update is based on its last read of the Counter, so its updates are only kept if its reads see its earlier ones, as they do with the default Consistency.
+type Counter struct {
+	store *KVStore
+	key   string
+}
+
+// Counter returns the Counter stored under key. It's created the first time it's added to.
+func (s *KVStore) Counter(key string) *Counter {
+	return &Counter{store: s, key: key}
+}
+
+// Add adds delta, which may be negative, to the Counter.
+func (c *Counter) Add(ctx context.Context, delta int64) error {
+	value, clock, err := c.store.readCRDT(ctx, c.key, crdtCounter)
+	if err != nil {
+		return err
+	}
+	counter := counterState{}
+	if value != nil {
+		counter, err = unmarshalCounter(value)
+		if err != nil {
+			return err
+		}
+	}
+	id := c.store.cluster.self.ID
+	totals := counter[id]
+	if delta >= 0 {
+		totals[0] += uint64(delta)
+	} else {
+		totals[1] += uint64(-delta)
+	}
+	counter[id] = totals
+	return c.store.writeCRDT(ctx, c.key, counter.marshal(), clock)
+}
+
+// Value returns the Counter's total, which is 0 if it has never been added to.
+func (c *Counter) Value(ctx context.Context) (int64, error) {
+	value, _, err := c.store.readCRDT(ctx, c.key, crdtCounter)
+	if err != nil || value == nil {
+		return 0, err
+	}
+	counter, err := unmarshalCounter(value)
+	if err != nil {
+		return 0, err
+	}
+	return counter.total(), nil
+}
+
+// counterState holds the total each Node has added to and subtracted from a Counter.
+type counterState map[NodeID][2]uint64
+
+func (c counterState) total() int64 {
+	var total int64
+	for _, totals := range c {
+		total += int64(totals[0]) - int64(totals[1])
+	}
+	return total
+}
+
+func (c counterState) merge(other counterState) counterState {
+	merged := counterState{}
+	for _, state := range []counterState{c, other} {
+		for id, totals := range state {
+			latest := merged[id]
+			for i := range totals {
+				if totals[i] > latest[i] {
+					latest[i] = totals[i]
+				}
+			}
+			merged[id] = latest
+		}
+	}
+	return merged
+}
+
+func (c counterState) marshal() []byte {
+	data := make([]byte, 1, 1+len(c)*32)
+	data[0] = crdtCounter
+	entry := make([]byte, 32)
+	for id, totals := range c {
+		binary.BigEndian.PutUint64(entry, id[0])
+		binary.BigEndian.PutUint64(entry[8:], id[1])
+		binary.BigEndian.PutUint64(entry[16:], totals[0])
+		binary.BigEndian.PutUint64(entry[24:], totals[1])
+		data = append(data, entry...)
+	}
+	return data
+}
+
+func unmarshalCounter(data []byte) (counterState, error) {
+	if len(data) < 1 || data[0] != crdtCounter || (len(data)-1)%32 != 0 {
+		return nil, crdtValueError
+	}
+	counter := counterState{}
+	for data = data[1:]; len(data) > 0; data = data[32:] {
+		id := NodeID{binary.BigEndian.Uint64(data), binary.BigEndian.Uint64(data[8:])}
+		counter[id] = [2]uint64{binary.BigEndian.Uint64(data[16:]), binary.BigEndian.Uint64(data[24:])}
+	}
+	return counter, nil
+}
+
+// ORSet is a set of elements stored under a KVStore's key, which any Node can add to and remove from at the same time. Each time an element is added, it's tagged uniquely, and removing it removes the tags that were read. An element added concurrently with its removal is kept, as the removal didn't know of its new tag.
+//
+// Removed tags are kept, so the replicas can tell an element that was removed from one they haven't heard of, and a set that's added to and removed from forever grows forever.
+type ORSet struct {
+	store *KVStore
+	key   string
+}
+
+// ORSet returns the ORSet stored under key. It's created the first time it's added to.
+func (s *KVStore) ORSet(key string) *ORSet {
+	return &ORSet{store: s, key: key}
+}
+
+// Add adds element to the ORSet.
+func (o *ORSet) Add(ctx context.Context, element []byte) error {
+	if len(element) > 0xffff {
+		return throwInvalidArgumentError("An ORSet's elements must be shorter than 64KiB.")
+	}
+	tag, err := newMessageID()
+	if err != nil {
+		return err
+	}
+	return o.update(ctx, func(set orSetState) {
+		if set[string(element)] == nil {
+			set[string(element)] = map[string]bool{}
+		}
+		set[string(element)][string(tag)] = false
+	})
+}
+
+// Remove removes element from the ORSet, if it's there.
+func (o *ORSet) Remove(ctx context.Context, element []byte) error {
+	return o.update(ctx, func(set orSetState) {
+		for tag := range set[string(element)] {
+			set[string(element)][tag] = true
+		}
+	})
+}
+
+// Members returns the elements in the ORSet, in lexical order.
+func (o *ORSet) Members(ctx context.Context) ([][]byte, error) {
+	set, _, err := o.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return set.members(), nil
+}
+
+// Contains returns true if element is in the ORSet.
+func (o *ORSet) Contains(ctx context.Context, element []byte) (bool, error) {
+	set, _, err := o.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return set.contains(string(element)), nil
+}
+
+func (o *ORSet) read(ctx context.Context) (orSetState, VectorClock, error) {
+	value, clock, err := o.store.readCRDT(ctx, o.key, crdtORSet)
+	if err != nil || value == nil {
+		return orSetState{}, clock, err
+	}
+	set, err := unmarshalORSet(value)
+	return set, clock, err
+}
+
+// update reads the ORSet, changes it with change, and writes it back.
+func (o *ORSet) update(ctx context.Context, change func(orSetState)) error {
+	set, clock, err := o.read(ctx)
+	if err != nil {
+		return err
+	}
+	change(set)
+	return o.store.writeCRDT(ctx, o.key, set.marshal(), clock)
+}
+
+// orSetState maps each element of an ORSet to its tags, and whether each has been removed.
+type orSetState map[string]map[string]bool
+
+func (o orSetState) contains(element string) bool {
+	for _, removed := range o[element] {
+		if !removed {
+			return true
+		}
+	}
+	return false
+}
+
+func (o orSetState) members() [][]byte {
+	elements := []string{}
+	for element := range o {
+		if o.contains(element) {
+			elements = append(elements, element)
+		}
+	}
+	sort.Strings(elements)
+	members := make([][]byte, len(elements))
+	for i, element := range elements {
+		members[i] = []byte(element)
+	}
+	return members
+}
+
+func (o orSetState) merge(other orSetState) orSetState {
+	merged := orSetState{}
+	for _, set := range []orSetState{o, other} {
+		for element, tags := range set {
+			if merged[element] == nil {
+				merged[element] = map[string]bool{}
+			}
+			for tag, removed := range tags {
+				merged[element][tag] = merged[element][tag] || removed
+			}
+		}
+	}
+	return merged
+}
+
+// marshal encodes each tag of each element as the element's length, the element, the tag, and whether it was removed.
+func (o orSetState) marshal() []byte {
+	data := []byte{crdtORSet}
+	for element, tags := range o {
+		for tag, removed := range tags {
+			entry := make([]byte, 2, 2+len(element)+orSetTagSize+1)
+			binary.BigEndian.PutUint16(entry, uint16(len(element)))
+			entry = append(entry, element...)
+			entry = append(entry, tag...)
+			if removed {
+				entry = append(entry, 1)
+			} else {
+				entry = append(entry, 0)
+			}
+			data = append(data, entry...)
+		}
+	}
+	return data
+}
+
+func unmarshalORSet(data []byte) (orSetState, error) {
+	if len(data) < 1 || data[0] != crdtORSet {
+		return nil, crdtValueError
+	}
+	set := orSetState{}
+	for data = data[1:]; len(data) > 0; {
+		if len(data) < 2 {
+			return nil, crdtValueError
+		}
+		length := int(binary.BigEndian.Uint16(data))
+		if len(data) < 2+length+orSetTagSize+1 {
+			return nil, crdtValueError
+		}
+		element := string(data[2 : 2+length])
+		tag := string(data[2+length : 2+length+orSetTagSize])
+		if set[element] == nil {
+			set[element] = map[string]bool{}
+		}
+		set[element][tag] = data[2+length+orSetTagSize] != 0
+		data = data[2+length+orSetTagSize+1:]
+	}
+	return set, nil
+}
+
+// LWWRegister is a single value stored under a KVStore's key, where the latest write wins, by its writer's clock, with ties going to the Node with the highest NodeID. Unlike the KVStore's own values, it never needs a MergeFunc.
+type LWWRegister struct {
+	store *KVStore
+	key   string
+}
+
+// LWWRegister returns the LWWRegister stored under key. It's created the first time it's set.
+func (s *KVStore) LWWRegister(key string) *LWWRegister {
+	return &LWWRegister{store: s, key: key}
+}
+
+// Set sets the LWWRegister's value.
+func (r *LWWRegister) Set(ctx context.Context, value []byte) error {
+	_, clock, err := r.store.readCRDT(ctx, r.key, crdtLWWRegister)
+	if err != nil {
+		return err
+	}
+	register := lwwRegisterState{Time: time.Now().UnixNano(), Writer: r.store.cluster.self.ID, Value: value}
+	return r.store.writeCRDT(ctx, r.key, register.marshal(), clock)
+}
+
+// Get returns the LWWRegister's value, and whether it has been set.
+func (r *LWWRegister) Get(ctx context.Context) ([]byte, bool, error) {
+	value, _, err := r.store.readCRDT(ctx, r.key, crdtLWWRegister)
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	register, err := unmarshalLWWRegister(value)
+	if err != nil {
+		return nil, false, err
+	}
+	return register.Value, true, nil
+}
+
+// lwwRegisterState is the value of an LWWRegister, with when it was written, and by which Node.
+type lwwRegisterState struct {
+	Time   int64
+	Writer NodeID
+	Value  []byte
+}
+
+func (r lwwRegisterState) merge(other lwwRegisterState) lwwRegisterState {
+	if other.Time > r.Time || (other.Time == r.Time && r.Writer.absLess(other.Writer)) {
+		return other
+	}
+	return r
+}
+
+func (r lwwRegisterState) marshal() []byte {
+	data := make([]byte, 1+8+16, 1+8+16+len(r.Value))
+	data[0] = crdtLWWRegister
+	binary.BigEndian.PutUint64(data[1:], uint64(r.Time))
+	binary.BigEndian.PutUint64(data[9:], r.Writer[0])
+	binary.BigEndian.PutUint64(data[17:], r.Writer[1])
+	return append(data, r.Value...)
+}
+
+func unmarshalLWWRegister(data []byte) (lwwRegisterState, error) {
+	if len(data) < 1+8+16 || data[0] != crdtLWWRegister {
+		return lwwRegisterState{}, crdtValueError
+	}
+	return lwwRegisterState{
+		Time:   int64(binary.BigEndian.Uint64(data[1:])),
+		Writer: NodeID{binary.BigEndian.Uint64(data[9:]), binary.BigEndian.Uint64(data[17:])},
+		Value:  data[1+8+16:],
+	}, nil
+}
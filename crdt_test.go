@@ -0,0 +1,131 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+)
+
+// Test that concurrent updates to each replicated data type converge, whichever order they're merged in
+func TestCRDTMerge(t *testing.T) {
+	a, b := NodeID{0, 1}, NodeID{0, 2}
+	x := counterState{a: {5, 1}, b: {2, 0}}
+	y := counterState{a: {3, 2}, b: {4, 0}}
+	for _, merged := range []counterState{x.merge(y), y.merge(x)} {
+		if total := merged.total(); total != 7 {
+			t.Errorf("Expected the merged counter to total 7, got %d from %v.", total, merged)
+		}
+	}
+	// one Node removes an element while another adds it again
+	tag1, tag2, tag3 := "tag1............", "tag2............", "tag3............"
+	removed := orSetState{"apple": {tag1: true}, "pear": {tag2: false}}
+	readded := orSetState{"apple": {tag1: false, tag3: false}}
+	for _, merged := range []orSetState{removed.merge(readded), readded.merge(removed)} {
+		members := merged.members()
+		if len(members) != 2 || string(members[0]) != "apple" || string(members[1]) != "pear" {
+			t.Errorf("Expected apple and pear, got %q.", members)
+		}
+		if !merged["apple"][tag1] {
+			t.Errorf("Expected the removed tag to stay removed, got %v.", merged["apple"])
+		}
+	}
+	early := lwwRegisterState{Time: 1, Writer: b, Value: []byte("early")}
+	late := lwwRegisterState{Time: 2, Writer: a, Value: []byte("late")}
+	tie := lwwRegisterState{Time: 2, Writer: b, Value: []byte("tie")}
+	if string(early.merge(late).Value) != "late" || string(late.merge(early).Value) != "late" {
+		t.Errorf("Expected the latest write to win.")
+	}
+	if string(late.merge(tie).Value) != "tie" || string(tie.merge(late).Value) != "tie" {
+		t.Errorf("Expected the highest NodeID to win a tie.")
+	}
+	for _, value := range [][]byte{x.marshal(), removed.marshal(), late.marshal()} {
+		merged, err := mergeCRDT(value, value)
+		if err != nil {
+			t.Errorf("Expected %v to merge with itself, got %v.", value, err)
+		}
+		if len(merged) != len(value) {
+			t.Errorf("Expected merging %v with itself to survive a round trip, got %v.", value, merged)
+		}
+	}
+	_, err := mergeCRDT(x.marshal(), late.marshal())
+	if err != crdtKindError {
+		t.Errorf("Expected %v merging different kinds, got %v.", crdtKindError, err)
+	}
+	_, err = mergeCRDT([]byte{crdtORSet, 0, 5, 'a'}, removed.marshal())
+	if err != crdtValueError {
+		t.Errorf("Expected %v merging a truncated set, got %v.", crdtValueError, err)
+	}
+}
+
+// Test that each replicated data type can be used through a KVStore, and that concurrent writes to it are merged by its replicas
+func TestKVStoreCRDTs(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewKVStore(FirstUserPurpose, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := context.Background()
+	counter := store.Counter("views")
+	for _, delta := range []int64{3, 4, -2} {
+		err = counter.Add(ctx, delta)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	// another Node adds to the counter without having read it
+	other := counterState{NodeID{0, 1}: {10, 0}}
+	store.answer(marshalKVRequest(kvPut, "views", nil, kvRecord{Found: true, CRDT: true, Version: 1, Clock: VectorClock{NodeID{0, 1}: 1}, Value: other.marshal()}))
+	total, err := counter.Value(ctx)
+	if err != nil || total != 15 {
+		t.Errorf("Expected a total of 15, got %d, %v.", total, err)
+	}
+
+	set := store.ORSet("fruit")
+	for _, fruit := range []string{"apple", "pear", "plum"} {
+		err = set.Add(ctx, []byte(fruit))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	err = set.Remove(ctx, []byte("pear"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	members, err := set.Members(ctx)
+	if err != nil || len(members) != 2 || string(members[0]) != "apple" || string(members[1]) != "plum" {
+		t.Errorf("Expected apple and plum, got %q, %v.", members, err)
+	}
+	contains, err := set.Contains(ctx, []byte("pear"))
+	if err != nil || contains {
+		t.Errorf("Expected pear to be removed, got %v, %v.", contains, err)
+	}
+
+	register := store.LWWRegister("leader")
+	_, found, err := register.Get(ctx)
+	if err != nil || found {
+		t.Errorf("Expected a register that was never set to be empty, got %v, %v.", found, err)
+	}
+	err = register.Set(ctx, []byte("me"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, found, err := register.Get(ctx)
+	if err != nil || !found || string(value) != "me" {
+		t.Errorf("Expected me, got %s, %v, %v.", value, found, err)
+	}
+
+	err = store.Put(ctx, "plain", []byte("value"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = store.Counter("plain").Add(ctx, 1)
+	if err != crdtKindError {
+		t.Errorf("Expected %v adding to a plain value, got %v.", crdtKindError, err)
+	}
+	_, err = store.ORSet("views").Members(ctx)
+	if err != crdtKindError {
+		t.Errorf("Expected %v reading a counter as a set, got %v.", crdtKindError, err)
+	}
+}
@@ -0,0 +1,189 @@
+package wendy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// policyCredentials are ContextCredentials that only grant access to Messages from allowed IPs, with the secret for their purpose, or the Passphrase for purposes without one
+type policyCredentials struct {
+	Passphrase
+	allowed  string
+	secrets  map[byte]string
+	lock     *sync.Mutex
+	contexts []CredentialContext
+}
+
+func (p *policyCredentials) Valid(supplied []byte) bool {
+	return false
+}
+
+func (p *policyCredentials) ValidContext(ctx CredentialContext, supplied []byte) bool {
+	p.lock.Lock()
+	p.contexts = append(p.contexts, ctx)
+	allowed := p.allowed
+	p.lock.Unlock()
+	addr, ok := ctx.RemoteAddr.(*net.TCPAddr)
+	if !ok || addr.IP.String() != allowed {
+		return false
+	}
+	if secret, ok := p.secrets[ctx.Purpose]; ok {
+		return string(supplied) == secret
+	}
+	return p.Passphrase.Valid(supplied)
+}
+
+func (p *policyCredentials) lastContext() CredentialContext {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.contexts) < 1 {
+		return CredentialContext{}
+	}
+	return p.contexts[len(p.contexts)-1]
+}
+
+// addrConn is a net.Conn that only knows where it came from
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (a addrConn) RemoteAddr() net.Addr {
+	return a.remote
+}
+
+// Test that ContextCredentials are checked instead of Valid, with where the Message came from, who it claims to be from, and its purpose
+func TestContextCredentials(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	credentials := &policyCredentials{
+		Passphrase: "open sesame",
+		allowed:    "10.0.0.1",
+		secrets:    map[byte]string{FirstUserPurpose: "user secret"},
+		lock:       new(sync.Mutex),
+	}
+	allowed := addrConn{remote: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}}
+	stranger := addrConn{remote: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8080}}
+	cases := []struct {
+		conn     net.Conn
+		purpose  byte
+		supplied string
+		granted  bool
+	}{
+		{allowed, HEARTBEAT, "open sesame", true},
+		{allowed, HEARTBEAT, "user secret", false},
+		{allowed, FirstUserPurpose, "user secret", true},
+		{allowed, FirstUserPurpose, "open sesame", false},
+		{stranger, HEARTBEAT, "open sesame", false},
+	}
+	for _, c := range cases {
+		msg := Message{Purpose: c.purpose, Sender: Node{ID: id}, Credentials: []byte(c.supplied)}
+		if granted := grantsAccess(credentials, c.conn, msg); granted != c.granted {
+			t.Errorf("Expected purpose %d from %s with %q to be granted access: %v, got %v.", c.purpose, c.conn.RemoteAddr(), c.supplied, c.granted, granted)
+		}
+		ctx := credentials.lastContext()
+		if !ctx.NodeID.Equals(id) || ctx.Purpose != c.purpose || ctx.RemoteAddr != c.conn.RemoteAddr() {
+			t.Errorf("Expected the context to describe the Message, got %+v.", ctx)
+		}
+	}
+}
+
+// Test that a Cluster checks ContextCredentials against the address Messages really came from
+func TestClusterContextCredentials(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	policy := &policyCredentials{Passphrase: "open sesame", allowed: "127.0.0.1", lock: new(sync.Mutex)}
+	one.credentials = policy
+	two.credentials = Passphrase("open sesame")
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("local")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "local" {
+			t.Errorf("Expected the Message from an allowed address, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+	ctx := policy.lastContext()
+	if !ctx.NodeID.Equals(two.self.ID) || ctx.Purpose != FirstUserPurpose || ctx.RemoteAddr == nil {
+		t.Errorf("Expected the context to describe the Message from %s, got %+v.", two.self.ID, ctx)
+	}
+	policy.lock.Lock()
+	policy.allowed = "10.0.0.1"
+	policy.lock.Unlock()
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("elsewhere")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message from an address that isn't allowed to be refused, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Test that a Message sent with its own Credentials is accepted by Nodes that accept them, instead of the Cluster's
+func TestClusterMessageWithCredentials(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tenant := HMACCredentials{Secret: []byte("tenant secret")}
+	one.credentials = tenant
+	two.credentials = Passphrase("open sesame")
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("cluster")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message with the Cluster's Credentials to be refused, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("tenant")).WithCredentials(tenant)
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "tenant" {
+			t.Errorf("Expected the Message with the tenant's Credentials, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+}
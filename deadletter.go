@@ -0,0 +1,61 @@
+package wendy
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// DeadLetterReason describes why a Message was given up on.
+type DeadLetterReason byte
+
+const (
+	DeadLetterExpired     DeadLetterReason = iota // The Message's Expires time passed before it could be delivered
+	DeadLetterUnreachable                         // The Node the Message was sent to, and the Nodes tried in its place, didn't respond, however many times they were tried
+	DeadLetterUnroutable                          // The Message couldn't be routed, because it took too many hops, was caught in a routing loop, or no route could be found
+)
+
+// String returns a description of the DeadLetterReason.
+func (r DeadLetterReason) String() string {
+	switch r {
+	case DeadLetterExpired:
+		return "expired"
+	case DeadLetterUnreachable:
+		return "unreachable"
+	case DeadLetterUnroutable:
+		return "unroutable"
+	}
+	return fmt.Sprintf("DeadLetterReason(%d)", byte(r))
+}
+
+// DeadLetterHandler is an interface that an Application can optionally fulfill to receive the Messages the current Node gives up on, so it can compensate for them instead of losing them silently.
+//
+// OnDeadLetter is called when the current Node discards a Message with a purpose of FirstUserPurpose or above that it was forwarding or holding for another Node, because it expired, couldn't reach the next Node, or couldn't be routed. It receives the Message, why it was given up on, and the error that caused it, if any. Messages that fail to send from the Node that sent them aren't dead letters, as Send and the like return the error instead.
+type DeadLetterHandler interface {
+	OnDeadLetter(msg Message, reason DeadLetterReason, err error)
+}
+
+// deadLetter reports a Message the current Node has given up on to Applications that fulfill DeadLetterHandler, and to Events. Wendy's own Messages aren't reported.
+func (c *Cluster) deadLetter(msg Message, reason DeadLetterReason, err error) {
+	if msg.Purpose < FirstUserPurpose {
+		return
+	}
+	atomic.AddUint64(&c.stats.DeadLetters, 1)
+	c.debug("Giving up on message %s from %s: %s", msg.Key, msg.Sender.ID, reason)
+	c.emit(ClusterEvent{Type: DeadLettered, Message: &msg, DeadLetter: reason, Err: err})
+	c.notify(EventDeadLetter, func(app Application) {
+		if handler, ok := app.(DeadLetterHandler); ok {
+			handler.OnDeadLetter(msg, reason, err)
+		}
+	})
+}
+
+// deadLetterReason returns the reason a Message that couldn't be routed because of err is given up on.
+func deadLetterReason(err error) DeadLetterReason {
+	switch err {
+	case deadNodeError:
+		return DeadLetterUnreachable
+	case messageExpiredError:
+		return DeadLetterExpired
+	}
+	return DeadLetterUnroutable
+}
@@ -0,0 +1,75 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// deadLetterCallback is a testCallback that records the Messages given up on
+type deadLetterCallback struct {
+	*testCallback
+	letters chan Message
+	reasons chan DeadLetterReason
+}
+
+func (d *deadLetterCallback) OnDeadLetter(msg Message, reason DeadLetterReason, err error) {
+	d.letters <- msg
+	d.reasons <- reason
+}
+
+// Test that Messages a Node gives up on while forwarding them are passed to DeadLetterHandlers and Events
+func TestDeadLetter(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := &deadLetterCallback{testCallback: newTestCallback(t), letters: make(chan Message, 10), reasons: make(chan DeadLetterReason, 10)}
+	cluster.RegisterCallbackFor(callback, EventDeadLetter)
+	events := cluster.Events()
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetMaxHops(1)
+	expired := cluster.NewMessage(FirstUserPurpose, id, []byte("expired"))
+	expired.Expires = time.Now().Add(-time.Minute)
+	unroutable := cluster.NewMessage(FirstUserPurpose, id, []byte("unroutable"))
+	unroutable.Hop = 5
+	// Wendy's own Messages aren't dead letters
+	heartbeat := cluster.NewMessage(HEARTBEAT, id, []byte("heartbeat"))
+	heartbeat.Expires = expired.Expires
+	cluster.onMessageReceived(heartbeat)
+	for _, c := range []struct {
+		msg    Message
+		reason DeadLetterReason
+	}{{expired, DeadLetterExpired}, {unroutable, DeadLetterUnroutable}} {
+		cluster.onMessageReceived(c.msg)
+		select {
+		case msg := <-callback.letters:
+			if string(msg.Value) != string(c.msg.Value) {
+				t.Errorf("Expected %s to be a dead letter, got %s.", c.msg.Value, msg.Value)
+			}
+			if reason := <-callback.reasons; reason != c.reason {
+				t.Errorf("Expected %s to be %s, got %s.", c.msg.Value, c.reason, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s to be a dead letter.", c.msg.Value)
+		}
+		for event := range events {
+			if event.Type != DeadLettered {
+				continue
+			}
+			if string(event.Message.Value) != string(c.msg.Value) || event.DeadLetter != c.reason {
+				t.Errorf("Expected a %s event for %s, got %+v.", c.reason, c.msg.Value, event)
+			}
+			break
+		}
+	}
+	if letters := cluster.Stats().DeadLetters; letters != 2 {
+		t.Errorf("Expected 2 dead letters, got %d.", letters)
+	}
+}
@@ -0,0 +1,130 @@
+package wendy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+)
+
+// payloadKeyInfo is mixed into the key derived from the Cluster's Credentials, so it isn't the same key used anywhere else.
+const payloadKeyInfo = "wendy payload encryption"
+
+var payloadCiphertextError = errors.New("Message Value was too short to have been encrypted.")
+
+// SetPayloadEncryption sets whether the Value of every Message the current Node sends is encrypted, with AES-256-GCM, using a key derived from the secret in the Cluster's Credentials. This keeps application data, and the state tables Nodes exchange, confidential on networks and Transports that don't encrypt them. The rest of the Message, such as its Purpose and Key, is sent in the clear, but tampering with it is detected, except for the fields that change as it's forwarded: its Checksum, Destination, Hop, Path, and Signature, the last of which SetSigningKey protects.
+//
+// Every Node in the Cluster must enable it, with the same Credentials: Messages whose Value can't be decrypted are discarded, and counted in Stats. Values are encrypted for each hop, so Nodes forwarding a Message can read it, but Nodes sharing the Credentials could anyway. The Cluster's Credentials must be a Passphrase or HMACCredentials, or implement Marshal, for there to be a secret to derive the key from.
+func (c *Cluster) SetPayloadEncryption(enabled bool) error {
+	var aead, previous cipher.AEAD
+	if enabled {
+		var err error
+		aead, err = payloadCipherFor(c.getCredentials())
+		if err != nil {
+			return err
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.payloadCipher = aead
+	if c.rotation != nil {
+		if enabled {
+			// a rotation may be under way, in which case the previous Credentials need a key too; if they have no secret, the new key is used
+			previous, _ = payloadCipherFor(c.rotation.previous)
+		}
+		c.rotation.previousCipher = previous
+	}
+	return nil
+}
+
+func (c *Cluster) getPayloadCipher() cipher.AEAD {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.payloadCipher
+}
+
+// sendingCipher returns the cipher the current Node encrypts the Values of its Messages with: the one for the previous Credentials, early in a rotation, or the one for the Cluster's.
+func (c *Cluster) sendingCipher() cipher.AEAD {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.rotation != nil && c.rotation.previousCipher != nil && time.Now().Before(c.rotation.switchAt) {
+		return c.rotation.previousCipher
+	}
+	return c.payloadCipher
+}
+
+// acceptedCiphers returns the ciphers Values can be decrypted with: the one for the Cluster's Credentials, and the one for the previous Credentials during a rotation. It returns nil if payload encryption is disabled.
+func (c *Cluster) acceptedCiphers() []cipher.AEAD {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.payloadCipher == nil {
+		return nil
+	}
+	if c.rotation != nil && c.rotation.previousCipher != nil && time.Now().Before(c.rotation.until) {
+		return []cipher.AEAD{c.payloadCipher, c.rotation.previousCipher}
+	}
+	return []cipher.AEAD{c.payloadCipher}
+}
+
+// payloadCipherFor returns the cipher Values are encrypted with by Nodes sharing credentials, with a key derived from their secret.
+func payloadCipherFor(credentials Credentials) (cipher.AEAD, error) {
+	secret := credentialSecret(credentials)
+	if len(secret) < 1 {
+		return nil, throwInvalidArgumentError("The Cluster's Credentials have no secret to derive an encryption key from.")
+	}
+	key, err := hkdf.Key(sha256.New, secret, nil, payloadKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// payloadData returns the parts of a Message that aren't encrypted but are authenticated with its Value, so its Value can't be moved to another Message, and the Message can't be altered: every field its Sender would sign, except the Value itself.
+func payloadData(msg Message) []byte {
+	msg.Value = nil
+	return msg.signingDigest()
+}
+
+// encrypt replaces the Value of a Message about to be sent with a random nonce followed by the encrypted Value, if payload encryption is enabled.
+func (c *Cluster) encrypt(msg *Message) error {
+	aead := c.sendingCipher()
+	if aead == nil {
+		return nil
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(msg.Value)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	msg.Value = aead.Seal(nonce, nonce, msg.Value, payloadData(*msg))
+	return nil
+}
+
+// decrypt replaces the Value of a Message that was received with the Value it was sent with, if payload encryption is enabled. An error is returned if the Value wasn't encrypted with the Cluster's key, or the previous one during a rotation, or the Message was tampered with.
+func (c *Cluster) decrypt(msg *Message) error {
+	aeads := c.acceptedCiphers()
+	if aeads == nil {
+		return nil
+	}
+	var err error
+	for _, aead := range aeads {
+		if len(msg.Value) < aead.NonceSize()+aead.Overhead() {
+			return payloadCiphertextError
+		}
+		nonce, sealed := msg.Value[:aead.NonceSize()], msg.Value[aead.NonceSize():]
+		var value []byte
+		value, err = aead.Open(nil, nonce, sealed, payloadData(*msg))
+		if err == nil {
+			msg.Value = value
+			return nil
+		}
+	}
+	return err
+}
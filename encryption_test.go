@@ -0,0 +1,147 @@
+package wendy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Test that payload encryption needs a secret, and that Values only decrypt with the same key, for the same Message
+func TestPayloadEncryption(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if one.SetPayloadEncryption(true) == nil {
+		t.Errorf("Expected an error enabling payload encryption without Credentials.")
+	}
+	msg := one.NewMessage(FirstUserPurpose, one.self.ID, []byte("top secret"))
+	plain := msg
+	err = one.encrypt(&plain)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(plain.Value) != "top secret" {
+		t.Errorf("Expected the Value not to be encrypted while payload encryption is disabled.")
+	}
+	one.credentials = Passphrase("open sesame")
+	two.credentials = HMACCredentials{Secret: []byte("a different secret")}
+	for _, cluster := range []*Cluster{one, two} {
+		err = cluster.SetPayloadEncryption(true)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	sealed := msg
+	err = one.encrypt(&sealed)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if bytes.Contains(sealed.Value, []byte("top secret")) {
+		t.Errorf("Expected the Value to be encrypted.")
+	}
+	again := msg
+	err = one.encrypt(&again)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if bytes.Equal(sealed.Value, again.Value) {
+		t.Errorf("Expected each encryption to use a new nonce.")
+	}
+	opened := sealed
+	err = one.decrypt(&opened)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(opened.Value) != "top secret" {
+		t.Errorf("Expected %q, got %q.", "top secret", opened.Value)
+	}
+	moved := sealed
+	moved.Purpose = FirstUserPurpose + 1
+	if one.decrypt(&moved) == nil {
+		t.Errorf("Expected a Value moved to a Message with a different Purpose not to decrypt.")
+	}
+	altered := sealed
+	altered.Headers = map[string]string{"tenant": "someone else"}
+	if one.decrypt(&altered) == nil {
+		t.Errorf("Expected a Value whose Message's Headers were altered not to decrypt.")
+	}
+	forwarded := sealed
+	forwarded.Hop = 3
+	forwarded.Destination = two.self.ID
+	forwarded.Path = []TraceHop{{}}
+	err = one.decrypt(&forwarded)
+	if err != nil {
+		t.Errorf("Expected a Value to decrypt after its Message was forwarded, got %v.", err)
+	}
+	stranger := sealed
+	if two.decrypt(&stranger) == nil {
+		t.Errorf("Expected a Value encrypted with a different secret not to decrypt.")
+	}
+	short := msg
+	short.Value = []byte("short")
+	if one.decrypt(&short) != payloadCiphertextError {
+		t.Errorf("Expected an error decrypting a Value too short to be encrypted.")
+	}
+}
+
+// Test that Nodes with payload encryption enabled exchange Messages, and discard those that weren't encrypted
+func TestClusterPayloadEncryption(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, cluster := range []*Cluster{one, two, three} {
+		cluster.credentials = Passphrase("open sesame")
+	}
+	for _, cluster := range []*Cluster{one, two} {
+		err = cluster.SetPayloadEncryption(true)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("encrypted")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "encrypted" {
+			t.Errorf("Expected the decrypted Value, got %q.", msg.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+	err = three.SendToIP(three.NewMessage(FirstUserPurpose, one.self.ID, []byte("plaintext")), three.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the unencrypted Message to be discarded, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if failures := one.Stats().DecryptionFailures; failures != 1 {
+		t.Errorf("Expected 1 decryption failure, got %d.", failures)
+	}
+}
@@ -0,0 +1,106 @@
+package wendy
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// eventBufferSize is the number of ClusterEvents that can wait to be read from the channel returned by Events before new ones are dropped.
+const eventBufferSize = 256
+
+// ClusterEventType identifies what a ClusterEvent describes.
+type ClusterEventType byte
+
+const (
+	NodeJoined        ClusterEventType = iota // A Node joined the Cluster through the current Node; Node is set
+	NodeRemoved                               // A Node was removed from the state tables; Node and Reason are set
+	LeafSetChanged                            // The leaf set changed; Leaves is set
+	MessageDelivered                          // A Message reached the current Node, its destination; Message is set
+	SendFailed                                // A Message couldn't be sent to another Node; Node, Message, and Err are set
+	HeartbeatReceived                         // Another Node sent a heartbeat; Node is set
+	ErrorRaised                               // An error was passed to OnError; Err is set
+	ClusterJoined                             // The current Node finished joining the Cluster
+	RepairFailed                              // A state table couldn't be repaired, as no Node, including the seeds, was left to ask; Err is set
+	SenderThrottled                           // A Node went over the limit set with SetSenderRateLimit, and its Messages are being discarded; Node is set
+	DeadLettered                              // The current Node gave up on a Message it was forwarding or holding; Message and DeadLetter are set, and Err if there was one
+)
+
+// String returns the name of the ClusterEventType.
+func (t ClusterEventType) String() string {
+	switch t {
+	case NodeJoined:
+		return "NodeJoined"
+	case NodeRemoved:
+		return "NodeRemoved"
+	case LeafSetChanged:
+		return "LeafSetChanged"
+	case MessageDelivered:
+		return "MessageDelivered"
+	case SendFailed:
+		return "SendFailed"
+	case HeartbeatReceived:
+		return "HeartbeatReceived"
+	case ErrorRaised:
+		return "ErrorRaised"
+	case ClusterJoined:
+		return "ClusterJoined"
+	case RepairFailed:
+		return "RepairFailed"
+	case SenderThrottled:
+		return "SenderThrottled"
+	case DeadLettered:
+		return "DeadLettered"
+	}
+	return fmt.Sprintf("ClusterEventType(%d)", byte(t))
+}
+
+// ClusterEvent describes something that happened in the Cluster. Which fields are set depends on its Type.
+type ClusterEvent struct {
+	Type    ClusterEventType
+	Time    time.Time
+	Node    *Node
+	Reason  ExitReason
+	Leaves  []*Node
+	Message *Message
+	Err     error
+	// why the Message was given up on, for DeadLettered events
+	DeadLetter DeadLetterReason
+}
+
+// Events returns a channel that receives a ClusterEvent for each notable thing that happens in the Cluster, for monitoring and tooling that doesn't need to implement Application. Every call returns the same channel, which is closed when the Cluster is killed; events are only recorded once Events has been called.
+//
+// Events are never waited on: if the channel's buffer of 256 events is full, new events are dropped and counted in Stats.
+func (c *Cluster) Events() <-chan ClusterEvent {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.events == nil {
+		c.events = make(chan ClusterEvent, eventBufferSize)
+		go c.closeEvents()
+	}
+	return c.events
+}
+
+// closeEvents closes the events channel once the Cluster is killed.
+func (c *Cluster) closeEvents() {
+	<-c.ctx.Done()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	close(c.events)
+	c.eventsClosed = true
+}
+
+// emit sends a ClusterEvent to the channel returned by Events, if it has been called.
+func (c *Cluster) emit(event ClusterEvent) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.events == nil || c.eventsClosed {
+		return
+	}
+	event.Time = time.Now()
+	select {
+	case c.events <- event:
+	default:
+		atomic.AddUint64(&c.stats.DroppedEvents, 1)
+	}
+}
@@ -0,0 +1,99 @@
+package wendy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next ClusterEvent of the specified type, skipping any others
+func nextEvent(t *testing.T, events <-chan ClusterEvent, eventType ClusterEventType) ClusterEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for a %s event.", eventType)
+		}
+	}
+}
+
+// Test that the Cluster's activity is reported on the channel returned by Events, which is closed when the Cluster is killed
+func TestClusterEvents(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	events := cluster.Events()
+	if cluster.Events() != events {
+		t.Errorf("Expected every call to Events to return the same channel.")
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// nothing listens on port 1
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	event := nextEvent(t, events, LeafSetChanged)
+	if len(event.Leaves) == 0 || event.Time.IsZero() {
+		t.Errorf("Expected a timestamped event with the new leaves, got %+v.", event)
+	}
+	cluster.routeMessage(cluster.NewMessage(byte(16), id, []byte("hello")))
+	event = nextEvent(t, events, SendFailed)
+	if event.Node == nil || !event.Node.ID.Equals(id) || event.Err != deadNodeError || event.Message == nil {
+		t.Errorf("Expected a failed send to %s, got %+v.", id, event)
+	}
+	event = nextEvent(t, events, NodeRemoved)
+	if event.Node == nil || !event.Node.ID.Equals(id) || event.Reason != ExitTimeout {
+		t.Errorf("Expected %s to be removed after a timeout, got %+v.", id, event)
+	}
+	cluster.deliver(cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello")))
+	event = nextEvent(t, events, MessageDelivered)
+	if event.Message == nil || string(event.Message.Value) != "hello" {
+		t.Errorf("Expected the delivered Message, got %+v.", event)
+	}
+	cluster.fanOutError(errors.New("testing"))
+	event = nextEvent(t, events, ErrorRaised)
+	if event.Err == nil || event.Err.Error() != "testing" {
+		t.Errorf("Expected the error raised, got %+v.", event)
+	}
+	cluster.Kill()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timeout waiting for the events channel to be closed.")
+		}
+	}
+}
+
+// Test that events are dropped and counted when nobody is reading them
+func TestClusterEventsDropped(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.emit(ClusterEvent{Type: ClusterJoined})
+	if stats := cluster.Stats(); stats.DroppedEvents != 0 {
+		t.Errorf("Expected events not to be recorded before Events is called, got %d dropped.", stats.DroppedEvents)
+	}
+	cluster.Events()
+	for i := 0; i < eventBufferSize+2; i++ {
+		cluster.emit(ClusterEvent{Type: ClusterJoined})
+	}
+	if stats := cluster.Stats(); stats.DroppedEvents != 2 {
+		t.Errorf("Expected 2 dropped events, got %d.", stats.DroppedEvents)
+	}
+}
@@ -0,0 +1,81 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// exitReasonCallback is a testCallback that records why Nodes exited
+type exitReasonCallback struct {
+	*testCallback
+	reasons chan ExitReason
+}
+
+func (e *exitReasonCallback) OnNodeExitWithReason(node Node, reason ExitReason) {
+	e.onNodeExit <- node
+	e.reasons <- reason
+}
+
+// Test that Applications are told when Nodes are removed from the state tables, and why
+func TestClusterNodeExitReason(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	callback := &exitReasonCallback{testCallback: newTestCallback(t), reasons: make(chan ExitReason, 10)}
+	// repairs fail, as there's no other Node to ask
+	errors := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	cluster.RegisterCallbackFor(callback, EventNodeExit)
+	cluster.RegisterCallbackFor(errors, EventError|EventNodeExit)
+	cluster.SetProbation(0)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	exit := func(reason ExitReason, remove func()) {
+		err := cluster.insert(*node, StateMask{Mask: all})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		remove()
+		select {
+		case exited := <-callback.onNodeExit:
+			if !exited.ID.Equals(id) {
+				t.Errorf("Expected %s to exit, got %s.", id, exited.ID)
+			}
+			if got := <-callback.reasons; got != reason {
+				t.Errorf("Expected reason %s, got %s.", reason, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for %s exit.", reason)
+		}
+		select {
+		case exited := <-errors.onNodeExit:
+			if !exited.ID.Equals(id) {
+				t.Errorf("Expected OnNodeExit to be called with %s, got %s.", id, exited.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for OnNodeExit.")
+		}
+	}
+	exit(ExitGraceful, func() {
+		cluster.onNodeExit(Message{Purpose: NODE_EXIT, Sender: *node})
+	})
+	exit(ExitTimeout, func() {
+		cluster.suspect(node)
+	})
+	exit(ExitEvicted, func() {
+		cluster.EvictNode(id)
+	})
+	exit(ExitBanned, func() {
+		cluster.BanNode(id, time.Minute)
+	})
+	cluster.remove(id, ExitTimeout)
+	select {
+	case <-callback.onNodeExit:
+		t.Errorf("Expected no exit for a Node that wasn't in the state tables.")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
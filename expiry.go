@@ -0,0 +1,24 @@
+package wendy
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var messageExpiredError = errors.New("The Message expired before it could be delivered.")
+
+// expired returns true if the Message has an Expires time, and it has passed.
+func (m Message) expired() bool {
+	return !m.Expires.IsZero() && time.Now().After(m.Expires)
+}
+
+// dropExpired discards a Message that expired on its way through the Cluster. If it was sent with SendReliable, its Sender is told, so it stops resending it. Either way, it's a dead letter.
+func (c *Cluster) dropExpired(msg Message) {
+	atomic.AddUint64(&c.stats.ExpiredMessages, 1)
+	c.debug("Discarding message %s from %s: expired at %s.", msg.Key, msg.Sender.ID, msg.Expires)
+	if len(msg.ID) > 0 {
+		c.acknowledge(msg, true)
+	}
+	c.deadLetter(msg, DeadLetterExpired, messageExpiredError)
+}
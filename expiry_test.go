@@ -0,0 +1,86 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that expired Messages aren't sent
+func TestSendExpired(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	cluster.RegisterCallback(callback)
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("stale"))
+	msg.Expires = time.Now().Add(-time.Second)
+	err = cluster.Send(msg)
+	if err != messageExpiredError {
+		t.Errorf("Expected messageExpiredError, got %v.", err)
+	}
+	msg.Expires = time.Now().Add(time.Minute)
+	err = cluster.Send(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-callback.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message that hasn't expired to be delivered.")
+	}
+}
+
+// Test that Nodes discard Messages that expired on the way, and tell the Sender if it's waiting for an acknowledgement
+func TestClusterExpired(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	acked := make(chan error, 1)
+	two.lock.Lock()
+	two.acks = map[string]chan error{"stale": acked}
+	two.lock.Unlock()
+	msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("stale"))
+	msg.Expires = time.Now().Add(-time.Second)
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg.ID = []byte("stale")
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case err = <-acked:
+		if err != messageExpiredError {
+			t.Errorf("Expected the Sender to be told the Message expired, got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Sender to be told the Message expired.")
+	}
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the expired Messages to be discarded, got %+v.", msg)
+	default:
+	}
+	if expired := one.Stats().ExpiredMessages; expired != 2 {
+		t.Errorf("Expected 2 expired Messages, got %d.", expired)
+	}
+}
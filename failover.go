@@ -0,0 +1,73 @@
+package wendy
+
+import (
+	"sync/atomic"
+)
+
+// maxFailovers is the number of other Nodes a Message is tried through, one after another, when the Node it was routed to doesn't respond.
+const maxFailovers = 3
+
+// sendWithFailover sends a Message to the Node chosen as its next hop, through the send queue so it waits its turn by Priority. If that Node doesn't respond, it's suspected, and the Message is sent through the next-best Node instead, until one responds or maxFailovers Nodes have been tried. If no other Node is closer to the Message's key than the current Node, the current Node is responsible for it, and it's delivered.
+func (c *Cluster) sendWithFailover(msg Message, target *Node) error {
+	tried := map[NodeID]bool{}
+	for {
+		err := <-c.sendAsync(msg, target)
+		if err != deadNodeError {
+			return err
+		}
+		tried[target.ID] = true
+		err = c.suspect(target)
+		if err != nil {
+			// the Node was removed, but repairing the state tables failed; that doesn't stop the Message being routed around it
+			c.fanOutError(err)
+		}
+		if len(tried) > maxFailovers {
+			return deadNodeError
+		}
+		next, err := c.failoverHop(msg.Key, tried)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			c.debug("Couldn't reach %s, and no other Node is closer. Delivering message %s", target.ID, msg.Key)
+			if msg.Purpose >= FirstUserPurpose {
+				c.deliver(msg)
+			}
+			return nil
+		}
+		c.debug("Couldn't reach %s. Routing message %s through %s instead.", target.ID, msg.Key, next.ID)
+		atomic.AddUint64(&c.stats.RouteFailovers, 1)
+		target = next
+	}
+}
+
+// failoverHop returns the Node a Message should be routed through now that the Nodes in tried have failed to respond to it, or nil if the current Node should deliver it. Nodes that didn't respond are usually removed from the state tables, so Route's choice is used if it hasn't been tried. Otherwise, because Route picked a Node another Node could still reach, or found no Node to replace the one removed, the next hop is the Node numerically closest to the key among those that haven't been tried, share at least as long a prefix with the key as the current Node, and are closer to it.
+func (c *Cluster) failoverHop(key NodeID, tried map[NodeID]bool) (*Node, error) {
+	target, err := c.Route(key)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil && !tried[target.ID] {
+		return target, nil
+	}
+	row := c.table.row(key)
+	diff := c.self.ID.distance(key)
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	var best *Node
+	for _, node := range nodes {
+		if node == nil || tried[node.ID] {
+			continue
+		}
+		if node.ID.commonPrefixLenBits(key, c.table.bits) < row || !node.ID.distance(key).absLess(diff) {
+			continue
+		}
+		if best == nil || node.ID.distance(key).absLess(best.ID.distance(key)) {
+			best = node
+		}
+	}
+	if best == nil && target != nil {
+		return nil, deadNodeError
+	}
+	return best, nil
+}
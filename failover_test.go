@@ -0,0 +1,84 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a Message routed to a Node that doesn't respond is sent through the next-best Node instead
+func TestClusterSendFailover(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetIndirectProbes(0)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	twoCB := newTestCallback(t)
+	two.RegisterCallback(twoCB)
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, two)
+	// the dead Node is the closest to the key, and two is next
+	key := two.self.ID
+	key[1] ^= 1
+	err = one.insert(*NewNode(key, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.Send(one.NewMessage(byte(16), key, []byte("hello")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-twoCB.onDeliver:
+		if string(msg.Value) != "hello" {
+			t.Errorf("Expected %s to receive %s, got %s.", two.self.ID, "hello", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the Message to fail over to %s.", two.self.ID)
+	}
+	if _, err = one.leafset.getNode(key); err != nodeNotFoundError {
+		t.Errorf("Expected the dead Node to be removed, got %v.", err)
+	}
+	if stats := one.Stats(); stats.RouteFailovers != 1 {
+		t.Errorf("Expected 1 failover, got %d.", stats.RouteFailovers)
+	}
+}
+
+// Test that a Message is delivered by the current Node when the only Node closer to its key doesn't respond
+func TestClusterSendFailoverDelivers(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	callback := newTestCallback(t)
+	cluster.RegisterCallbackFor(callback, EventDeliver)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.Send(cluster.NewMessage(byte(16), id, []byte("hello")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "hello" {
+			t.Errorf("Expected to receive %s, got %s.", "hello", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the Message to be delivered once the Node closer to its key was removed.")
+	}
+}
@@ -0,0 +1,148 @@
+package wendy
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+)
+
+var gobStateTablesError = errors.New("Gob state tables had an entry out of range.")
+
+// GobCodec is an implementation of Codec that encodes values using encoding/gob, for Clusters made up entirely of Go programs. Every Node in the Cluster must use a GobCodec.
+//
+// Each value is written as a complete gob stream, including its type information, so it can be decoded on its own. That overhead makes GobCodec a poor fit for small Messages, where ProtobufCodec is both smaller and faster.
+type GobCodec struct{}
+
+// NewEncoder returns an Encoder that writes gob streams to w.
+func (g GobCodec) NewEncoder(w io.Writer) Encoder {
+	return gobEncoder{w: w}
+}
+
+// NewDecoder returns a Decoder that reads gob streams from r.
+func (g GobCodec) NewDecoder(r io.Reader) Decoder {
+	// gob reads past the end of a value unless its reader can read a byte at a time, so every value is read through the same bufio.Reader
+	return gobDecoder{r: bufio.NewReader(r)}
+}
+
+// gobStateTables is the form state tables are sent in, as gob can't encode the nil Nodes in their empty positions.
+type gobStateTables struct {
+	RoutingTable    []gobEntry
+	LeafSet         []gobEntry
+	NeighborhoodSet []gobEntry
+	Tables          byte // a mask of the tables that were included
+	EOL             bool
+	Delta           bool
+}
+
+type gobEntry struct {
+	Row, Col int
+	Node     Node
+}
+
+type gobEncoder struct {
+	w io.Writer
+}
+
+func (e gobEncoder) Encode(v interface{}) error {
+	switch value := v.(type) {
+	case stateTables:
+		v = newGobStateTables(value)
+	case *stateTables:
+		v = newGobStateTables(*value)
+	}
+	return gob.NewEncoder(e.w).Encode(v)
+}
+
+type gobDecoder struct {
+	r *bufio.Reader
+}
+
+func (d gobDecoder) Decode(v interface{}) error {
+	state, ok := v.(*stateTables)
+	if !ok {
+		return gob.NewDecoder(d.r).Decode(v)
+	}
+	var encoded gobStateTables
+	err := gob.NewDecoder(d.r).Decode(&encoded)
+	if err != nil {
+		return err
+	}
+	return encoded.stateTables(state)
+}
+
+func newGobStateTables(state stateTables) gobStateTables {
+	encoded := gobStateTables{
+		EOL:   state.EOL,
+		Delta: state.Delta,
+	}
+	if state.RoutingTable != nil {
+		encoded.Tables |= rT
+		for row := range state.RoutingTable {
+			for col, node := range state.RoutingTable[row] {
+				if node != nil {
+					encoded.RoutingTable = append(encoded.RoutingTable, gobEntry{Row: row, Col: col, Node: *node})
+				}
+			}
+		}
+	}
+	if state.LeafSet != nil {
+		encoded.Tables |= lS
+		for side := range state.LeafSet {
+			for pos, node := range state.LeafSet[side] {
+				if node != nil {
+					encoded.LeafSet = append(encoded.LeafSet, gobEntry{Row: side, Col: pos, Node: *node})
+				}
+			}
+		}
+	}
+	if state.NeighborhoodSet != nil {
+		encoded.Tables |= nS
+		for pos, node := range state.NeighborhoodSet {
+			if node != nil {
+				encoded.NeighborhoodSet = append(encoded.NeighborhoodSet, gobEntry{Col: pos, Node: *node})
+			}
+		}
+	}
+	return encoded
+}
+
+func (g gobStateTables) stateTables(state *stateTables) error {
+	*state = stateTables{
+		EOL:   g.EOL,
+		Delta: g.Delta,
+	}
+	mask := StateMask{Mask: g.Tables}
+	if mask.includeRT() {
+		state.RoutingTable = [][]*Node{}
+		for _, entry := range g.RoutingTable {
+			node := entry.Node
+			var ok bool
+			state.RoutingTable, ok = setTableEntry(state.RoutingTable, entry.Row, entry.Col, &node)
+			if !ok {
+				return gobStateTablesError
+			}
+		}
+	}
+	if mask.includeLS() {
+		state.LeafSet = new([2][16]*Node)
+		for _, entry := range g.LeafSet {
+			if entry.Row < 0 || entry.Row >= len(state.LeafSet) || entry.Col < 0 || entry.Col >= len(state.LeafSet[entry.Row]) {
+				return gobStateTablesError
+			}
+			node := entry.Node
+			state.LeafSet[entry.Row][entry.Col] = &node
+		}
+	}
+	if mask.includeNS() {
+		state.NeighborhoodSet = new([32]*Node)
+		for _, entry := range g.NeighborhoodSet {
+			if entry.Col < 0 || entry.Col >= len(state.NeighborhoodSet) {
+				return gobStateTablesError
+			}
+			node := entry.Node
+			state.NeighborhoodSet[entry.Col] = &node
+		}
+	}
+	return nil
+}
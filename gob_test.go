@@ -0,0 +1,111 @@
+package wendy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test that Messages, state tables, and StateMasks survive a round trip through the GobCodec
+func TestGobCodec(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sender := NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080)
+	msg := Message{
+		Purpose:   NODE_ANN,
+		Sender:    *sender,
+		Key:       id,
+		Value:     []byte("hello, world"),
+		LSVersion: 1,
+		Hop:       4,
+	}
+	state := stateTables{
+		RoutingTable: newTableRows(defaultDigitBits),
+		LeafSet:      new([2][16]*Node),
+		EOL:          true,
+	}
+	state.RoutingTable[3][7] = sender
+	state.LeafSet[1][2] = sender
+	mask := StateMask{Mask: rT | nS, Rows: []int{1, 2}, Cols: []int{3}}
+	var buf bytes.Buffer
+	codec := GobCodec{}
+	for _, v := range []interface{}{msg, state, &mask} {
+		err = codec.NewEncoder(&buf).Encode(v)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	decoder := codec.NewDecoder(&buf)
+	var decodedMsg Message
+	err = decoder.Decode(&decodedMsg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decodedMsg.Purpose != msg.Purpose || !decodedMsg.Key.Equals(msg.Key) || string(decodedMsg.Value) != string(msg.Value) || decodedMsg.LSVersion != 1 || decodedMsg.Hop != 4 {
+		t.Fatalf("Expected %+v, got %+v.", msg, decodedMsg)
+	}
+	if s := decodedMsg.Sender; !s.ID.Equals(id) || s.LocalIP != "10.0.0.1" || s.GlobalIP != "203.0.113.1" || s.Region != "testing" || s.Port != 8080 {
+		t.Fatalf("Expected sender %+v, got %+v.", *sender, s)
+	}
+	var decodedState stateTables
+	err = decoder.Decode(&decodedState)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !decodedState.EOL || decodedState.NeighborhoodSet != nil || decodedState.RoutingTable == nil || decodedState.LeafSet == nil {
+		t.Fatalf("Expected tables to be preserved, got %+v.", decodedState)
+	}
+	if decodedState.RoutingTable[3][7] == nil || !decodedState.RoutingTable[3][7].ID.Equals(id) {
+		t.Fatalf("Expected the routing table entry to be preserved.")
+	}
+	if decodedState.LeafSet[1][2] == nil || decodedState.LeafSet[1][2].Port != 8080 {
+		t.Fatalf("Expected the leaf set entry to be preserved.")
+	}
+	var decodedMask StateMask
+	err = decoder.Decode(&decodedMask)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(mask, decodedMask) {
+		t.Fatalf("Expected %+v, got %+v.", mask, decodedMask)
+	}
+}
+
+// Test that a Cluster can deliver messages using the GobCodec
+func TestClusterGobCodec(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetCodec(GobCodec{})
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetCodec(GobCodec{})
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		if string(received.Value) != "hello, world" {
+			t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
@@ -0,0 +1,510 @@
+package wendy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	grpcPath        = "/wendy.Transport/Stream"
+	grpcContentType = "application/grpc"
+	grpcHeaderLen   = 5               // compressed flag (1 byte), message length (4 bytes)
+	grpcMaxChunk    = 64 * 1024       // the largest payload written in one gRPC message
+	grpcMaxMessage  = 4 * 1024 * 1024 // the largest gRPC message that will be read, matching the gRPC default
+)
+
+var grpcListenerClosedError = errors.New("gRPC listener closed.")
+var grpcConnClosedError = errors.New("gRPC stream closed.")
+var grpcMessageError = errors.New("gRPC peer sent an invalid message.")
+
+type grpcTimeoutError struct{}
+
+func (e grpcTimeoutError) Error() string   { return "gRPC stream timed out." }
+func (e grpcTimeoutError) Timeout() bool   { return true }
+func (e grpcTimeoutError) Temporary() bool { return true }
+
+// GRPCTransport is an implementation of Transport that exchanges Messages over gRPC, so Clusters can be deployed behind load balancers and service meshes that already handle gRPC traffic.
+//
+// Each connection is a call to a bidirectional streaming RPC, described by the following protobuf definition:
+//
+//	package wendy;
+//
+//	service Transport {
+//		rpc Stream(stream Chunk) returns (stream Chunk);
+//	}
+//
+//	message Chunk {
+//		bytes data = 1;
+//	}
+//
+// Calls to the same address share a single HTTP/2 connection. If TLSConfig is nil, HTTP/2 is spoken without TLS ("h2c"); otherwise, TLSConfig is used both to listen and to dial. Every Node in the Cluster must use a GRPCTransport.
+type GRPCTransport struct {
+	TLSConfig *tls.Config
+	client    *http.Client
+	lock      *sync.Mutex
+}
+
+// NewGRPCTransport creates a GRPCTransport that uses the specified TLS configuration. If tlsConfig is nil, connections are not encrypted.
+func NewGRPCTransport(tlsConfig *tls.Config) *GRPCTransport {
+	return &GRPCTransport{
+		TLSConfig: tlsConfig,
+		lock:      new(sync.Mutex),
+	}
+}
+
+func (t *GRPCTransport) getClient() *http.Client {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.client == nil {
+		transport := &http.Transport{
+			Protocols:       new(http.Protocols),
+			TLSClientConfig: t.TLSConfig,
+		}
+		if t.TLSConfig == nil {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		} else {
+			transport.Protocols.SetHTTP2(true)
+		}
+		t.client = &http.Client{Transport: transport}
+	}
+	return t.client
+}
+
+func (t *GRPCTransport) scheme() string {
+	if t.TLSConfig == nil {
+		return "http"
+	}
+	return "https"
+}
+
+// Dial starts a streaming call to the specified address, giving up if the call hasn't been answered after timeout has elapsed.
+func (t *GRPCTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "POST", t.scheme()+"://"+address+grpcPath, reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("TE", "trailers")
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := t.getClient().Do(req)
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, grpcTimeoutError{}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, errors.New("gRPC call refused: " + resp.Status)
+	}
+	remote, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		remote = &net.TCPAddr{}
+	}
+	conn := newGRPCConn(resp.Body, writer, nil, &net.TCPAddr{}, remote)
+	conn.onClose = func() {
+		writer.Close()
+		resp.Body.Close()
+		cancel()
+	}
+	return conn, nil
+}
+
+// Listen starts serving the streaming call on the specified address.
+func (t *GRPCTransport) Listen(address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	listener := &grpcListener{
+		addr:  ln.Addr(),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	listener.server = &http.Server{
+		Handler:   listener,
+		Protocols: new(http.Protocols),
+	}
+	if t.TLSConfig == nil {
+		listener.server.Protocols.SetUnencryptedHTTP2(true)
+	} else {
+		config := t.TLSConfig.Clone()
+		config.NextProtos = []string{"h2"}
+		listener.server.Protocols.SetHTTP2(true)
+		ln = tls.NewListener(ln, config)
+	}
+	go listener.server.Serve(ln)
+	return listener, nil
+}
+
+type grpcListener struct {
+	addr      net.Addr
+	server    *http.Server
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ServeHTTP answers each streaming call, handing a net.Conn for it to Accept and holding the call open until that net.Conn is closed.
+func (l *grpcListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != grpcPath {
+		w.Header().Set("Content-Type", grpcContentType)
+		w.Header().Set("Grpc-Status", "12")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.ProtoMajor != 2 || len(r.Header.Get("Content-Type")) < len(grpcContentType) || r.Header.Get("Content-Type")[:len(grpcContentType)] != grpcContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", grpcContentType)
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+	controller.Flush()
+	write := func(p []byte) error {
+		_, err := w.Write(p)
+		if err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+	remote, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		remote = &net.TCPAddr{}
+	}
+	conn := newGRPCConn(r.Body, nil, write, l.addr, remote)
+	conn.setWriteDeadline = controller.SetWriteDeadline
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		return
+	}
+	select {
+	case <-conn.closed:
+	case <-l.done:
+	}
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func (l *grpcListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, grpcListenerClosedError
+	}
+}
+
+func (l *grpcListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.server.Close()
+}
+
+func (l *grpcListener) Addr() net.Addr {
+	return l.addr
+}
+
+// grpcConn is one end of a streaming call. Reads are served by a goroutine decoding the incoming Chunks, so that read deadlines can be honoured; writes encode Chunks to either a pipe (when dialing) or a flushing write function (when answering).
+type grpcConn struct {
+	chunks           chan []byte
+	readErr          error
+	buf              []byte
+	pipe             *io.PipeWriter
+	write            func([]byte) error
+	onClose          func()
+	setWriteDeadline func(time.Time) error
+	closed           chan struct{}
+	closeOnce        sync.Once
+	local, remote    net.Addr
+	readDeadline     time.Time
+	writeDeadline    time.Time
+	lock             *sync.Mutex
+}
+
+func newGRPCConn(body io.Reader, pipe *io.PipeWriter, write func([]byte) error, local, remote net.Addr) *grpcConn {
+	conn := &grpcConn{
+		chunks: make(chan []byte, 16),
+		pipe:   pipe,
+		write:  write,
+		closed: make(chan struct{}),
+		local:  local,
+		remote: remote,
+		lock:   new(sync.Mutex),
+	}
+	if pipe != nil {
+		conn.write = func(p []byte) error {
+			_, err := pipe.Write(p)
+			return err
+		}
+	}
+	go conn.readLoop(body)
+	return conn
+}
+
+func (c *grpcConn) readLoop(body io.Reader) {
+	defer close(c.chunks)
+	header := make([]byte, grpcHeaderLen)
+	for {
+		_, err := io.ReadFull(body, header)
+		if err != nil {
+			c.setReadErr(err)
+			return
+		}
+		if header[0] != 0 {
+			// compression is never negotiated, so compressed messages are a protocol violation
+			c.setReadErr(grpcMessageError)
+			return
+		}
+		length := binary.BigEndian.Uint32(header[1:])
+		if length > grpcMaxMessage {
+			c.setReadErr(grpcMessageError)
+			return
+		}
+		message := make([]byte, length)
+		_, err = io.ReadFull(body, message)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			c.setReadErr(err)
+			return
+		}
+		data, err := decodeGRPCChunk(message)
+		if err != nil {
+			c.setReadErr(err)
+			return
+		}
+		if len(data) == 0 {
+			continue
+		}
+		select {
+		case c.chunks <- data:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *grpcConn) setReadErr(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readErr = err
+}
+
+// encodeGRPCChunk frames data as a Chunk message in a gRPC length-prefixed message.
+func encodeGRPCChunk(data []byte) []byte {
+	field := make([]byte, 1+binary.MaxVarintLen64)
+	field[0] = 0x0a // field 1, length-delimited
+	n := binary.PutUvarint(field[1:], uint64(len(data)))
+	field = field[:1+n]
+	frame := make([]byte, grpcHeaderLen, grpcHeaderLen+len(field)+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(field)+len(data)))
+	frame = append(frame, field...)
+	return append(frame, data...)
+}
+
+// decodeGRPCChunk returns the data field of a Chunk message, skipping any fields it doesn't know.
+func decodeGRPCChunk(message []byte) ([]byte, error) {
+	var data []byte
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return nil, grpcMessageError
+		}
+		message = message[n:]
+		switch key & 7 {
+		case 0:
+			_, n = binary.Uvarint(message)
+			if n <= 0 {
+				return nil, grpcMessageError
+			}
+			message = message[n:]
+		case 1:
+			if len(message) < 8 {
+				return nil, grpcMessageError
+			}
+			message = message[8:]
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || uint64(len(message)-n) < length {
+				return nil, grpcMessageError
+			}
+			if key>>3 == 1 {
+				data = message[n : n+int(length)]
+			}
+			message = message[n+int(length):]
+		case 5:
+			if len(message) < 4 {
+				return nil, grpcMessageError
+			}
+			message = message[4:]
+		default:
+			return nil, grpcMessageError
+		}
+	}
+	return data, nil
+}
+
+func (c *grpcConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(c.buf) == 0 {
+		c.lock.Lock()
+		deadline := c.readDeadline
+		c.lock.Unlock()
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, grpcTimeoutError{}
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				c.lock.Lock()
+				defer c.lock.Unlock()
+				if c.readErr == nil {
+					return 0, io.EOF
+				}
+				return 0, c.readErr
+			}
+			c.buf = chunk
+		case <-timeout:
+			return 0, grpcTimeoutError{}
+		case <-c.closed:
+			return 0, grpcConnClosedError
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *grpcConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := len(p)
+		if size > grpcMaxChunk {
+			size = grpcMaxChunk
+		}
+		err := c.writeFrame(encodeGRPCChunk(p[:size]))
+		if err != nil {
+			return written, err
+		}
+		written += size
+		p = p[size:]
+	}
+	return written, nil
+}
+
+// writeFrame writes a single frame, giving up when the write deadline passes.
+func (c *grpcConn) writeFrame(frame []byte) error {
+	select {
+	case <-c.closed:
+		return grpcConnClosedError
+	default:
+	}
+	c.lock.Lock()
+	deadline := c.writeDeadline
+	c.lock.Unlock()
+	if deadline.IsZero() || c.setWriteDeadline != nil {
+		// answering streams have their write deadlines enforced by the HTTP server
+		return c.write(frame)
+	}
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return grpcTimeoutError{}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.write(frame)
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// a frame may have been partially written, so the stream can't be used again
+		c.Close()
+		return grpcTimeoutError{}
+	}
+}
+
+// CloseWrite ends the outgoing half of a dialed stream, while leaving the incoming half open.
+func (c *grpcConn) CloseWrite() error {
+	if c.pipe == nil {
+		return nil
+	}
+	return c.pipe.Close()
+}
+
+func (c *grpcConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *grpcConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *grpcConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *grpcConn) SetDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	if c.setWriteDeadline != nil {
+		return c.setWriteDeadline(t)
+	}
+	return nil
+}
+
+func (c *grpcConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *grpcConn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeDeadline = t
+	if c.setWriteDeadline != nil {
+		return c.setWriteDeadline(t)
+	}
+	return nil
+}
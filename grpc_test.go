@@ -0,0 +1,123 @@
+package wendy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Test that data survives a round trip over a gRPC stream
+func TestGRPCTransportRoundTrip(t *testing.T) {
+	transport := NewGRPCTransport(nil)
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	go muxEchoServer(ln)
+	conn, err := transport.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	payload := bytes.Repeat([]byte("a large state table transfer "), 10000)
+	_, err = conn.Write(payload)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = conn.(*grpcConn).CloseWrite()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	echo, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(echo, payload) {
+		t.Fatalf("Expected %d bytes to be echoed, got %d.", len(payload), len(echo))
+	}
+}
+
+// Test that Chunks are decoded regardless of unknown fields
+func TestGRPCChunkEncoding(t *testing.T) {
+	frame := encodeGRPCChunk([]byte("hello, world"))
+	message := append([]byte{0x10, 0x96, 0x01}, frame[grpcHeaderLen:]...)
+	data, err := decodeGRPCChunk(message)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(data) != "hello, world" {
+		t.Fatalf("Expected %s, got %s.", "hello, world", string(data))
+	}
+	_, err = decodeGRPCChunk([]byte{0x0a, 0x05, 'h'})
+	if err != grpcMessageError {
+		t.Fatalf("Expected grpcMessageError, got %v instead.", err)
+	}
+}
+
+// Test that reads on a gRPC stream respect read deadlines
+func TestGRPCConnReadDeadline(t *testing.T) {
+	transport := NewGRPCTransport(nil)
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			time.Sleep(200 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+	conn, err := transport.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if _, ok := err.(grpcTimeoutError); !ok {
+		t.Fatalf("Expected a timeout error, got %v instead.", err)
+	}
+}
+
+// Test that a Cluster can deliver messages over a GRPCTransport
+func TestClusterGRPCTransport(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetTransport(NewGRPCTransport(nil))
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetTransport(NewGRPCTransport(nil))
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	for i := 0; i < 2; i++ {
+		msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+		err = two.SendToIP(msg, two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case received := <-oneCB.onDeliver:
+			if string(received.Value) != "hello, world" {
+				t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting on message delivery.")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package wendy
+
+// purposeHandler is a function registered with Handle, along with the queue its calls are dispatched through.
+type purposeHandler struct {
+	fn  func(Message)
+	reg *registration
+}
+
+// Handle registers fn to be called with every Message of the specified purpose that is delivered to the current Node. Messages that have a handler are passed to it instead of to the OnDeliver method of the Cluster's Applications, so applications need not switch on Purpose inside OnDeliver.
+//
+// Like Application callbacks, fn is called from a goroutine of its own, in the order Messages are delivered, and a panic in fn is recovered and passed to OnError. Registering a handler for a purpose replaces any handler already registered for it; passing a nil fn removes it. Purposes below 16 are reserved for Wendy's own Messages and can't be handled.
+func (c *Cluster) Handle(purpose byte, fn func(Message)) error {
+	if purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if old, ok := c.purposeHandlers[purpose]; ok {
+		close(old.reg.done)
+		delete(c.purposeHandlers, purpose)
+	}
+	if fn == nil {
+		return nil
+	}
+	handler := &purposeHandler{
+		fn: fn,
+		reg: &registration{
+			queue: make(chan func(), c.callbackQueueSize),
+			done:  make(chan struct{}),
+		},
+	}
+	c.purposeHandlers[purpose] = handler
+	go c.dispatch(handler.reg)
+	return nil
+}
+
+// handle queues msg to be passed to the handler registered for its purpose, returning false if there is none.
+func (c *Cluster) handle(msg Message) bool {
+	c.lock.RLock()
+	handler, ok := c.purposeHandlers[msg.Purpose]
+	c.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	callback := func() {
+		defer c.recoverCallback(EventDeliver)
+		handler.fn(msg)
+	}
+	select {
+	case handler.reg.queue <- callback:
+	case <-handler.reg.done:
+	case <-c.ctx.Done():
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that Messages with a registered handler are passed to it instead of OnDeliver
+func TestClusterHandle(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cb := newTestCallback(t)
+	cluster.RegisterCallback(cb)
+	handled := make(chan Message, 10)
+	err = cluster.Handle(byte(17), func(msg Message) {
+		handled <- msg
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.deliver(cluster.NewMessage(byte(17), cluster.self.ID, []byte("handled")))
+	cluster.deliver(cluster.NewMessage(byte(16), cluster.self.ID, []byte("delivered")))
+	select {
+	case msg := <-handled:
+		if string(msg.Value) != "handled" {
+			t.Errorf("Expected the handler to receive %s, got %s.", "handled", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on the handler.")
+	}
+	select {
+	case msg := <-cb.onDeliver:
+		if string(msg.Value) != "delivered" {
+			t.Errorf("Expected OnDeliver to receive %s, got %s.", "delivered", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	err = cluster.Handle(byte(17), nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.deliver(cluster.NewMessage(byte(17), cluster.self.ID, []byte("unhandled")))
+	select {
+	case msg := <-cb.onDeliver:
+		if string(msg.Value) != "unhandled" {
+			t.Errorf("Expected OnDeliver to receive %s, got %s.", "unhandled", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	if len(handled) != 0 {
+		t.Errorf("Expected a removed handler not to be called.")
+	}
+	err = cluster.Handle(NODE_JOIN, func(msg Message) {})
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError handling a reserved purpose, got %v.", err)
+	}
+}
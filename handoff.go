@@ -0,0 +1,99 @@
+package wendy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// KeyRange is a range of keys in the node space, running up from Start to End, inclusive. If End is less than Start, the range wraps around the end of the node space.
+type KeyRange struct {
+	Start NodeID
+	End   NodeID
+	Owner Node // the Node that will be closest to the keys once the current Node has left
+}
+
+// Contains returns true if the key is within the KeyRange.
+func (r KeyRange) Contains(key NodeID) bool {
+	return !r.End.sub(r.Start).absLess(key.sub(r.Start))
+}
+
+// handoffRanges returns the ranges of keys the current Node is closest to, as far as it knows, along with the Node each range will pass to once it has left: keys below the current Node pass to its nearest neighbour below, and keys above it to its nearest neighbour above. It returns no ranges if the current Node doesn't know of any other Node.
+func (c *Cluster) handoffRanges() []KeyRange {
+	self := c.self.ID
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	var below, above *Node
+	for _, node := range nodes {
+		if node == nil || node.ID.Equals(self) {
+			continue
+		}
+		if below == nil || self.sub(node.ID).absLess(self.sub(below.ID)) {
+			below = node
+		}
+		if above == nil || node.ID.sub(self).absLess(above.ID.sub(self)) {
+			above = node
+		}
+	}
+	if below == nil {
+		return []KeyRange{}
+	}
+	// keys past the midpoint from a neighbour are closer to the current Node than to the neighbour
+	start := below.ID.midpoint(self).add(NodeID{0, 1})
+	end := self.midpoint(above.ID)
+	if below.ID.Equals(above.ID) {
+		return []KeyRange{{Start: start, End: end, Owner: *below.clone()}}
+	}
+	boundary := below.ID.midpoint(above.ID)
+	return []KeyRange{
+		{Start: start, End: boundary, Owner: *below.clone()},
+		{Start: boundary.add(NodeID{0, 1}), End: end, Owner: *above.clone()},
+	}
+}
+
+// handoff calls OnHandoff for each Application that fulfills HandoffHandler, concurrently, and returns the number of calls that failed or hadn't returned by deadline.
+func (c *Cluster) handoff(deadline time.Time) int {
+	handlers := []HandoffHandler{}
+	for _, app := range c.callbacks(EventHandoff) {
+		if handler, ok := app.(HandoffHandler); ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	if len(handlers) == 0 {
+		return 0
+	}
+	ranges := c.handoffRanges()
+	if len(ranges) == 0 {
+		c.debug("No other Node to hand off keys to.")
+		return 0
+	}
+	ctx, cancel := context.WithDeadline(c.ctx, deadline)
+	defer cancel()
+	results := make(chan error, len(handlers))
+	for _, handler := range handlers {
+		go func(handler HandoffHandler) {
+			defer func() {
+				if r := recover(); r != nil {
+					results <- fmt.Errorf("Application callback panicked: %v", r)
+				}
+			}()
+			results <- handler.OnHandoff(ctx, append([]KeyRange{}, ranges...))
+		}(handler)
+	}
+	waiting := len(handlers)
+	failed := 0
+	for waiting > 0 {
+		select {
+		case err := <-results:
+			waiting--
+			if err != nil {
+				c.fanOutError(err)
+				failed++
+			}
+		case <-ctx.Done():
+			return failed + waiting
+		}
+	}
+	return failed
+}
@@ -0,0 +1,120 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type handoffCallback struct {
+	*testCallback
+	ranges chan []KeyRange
+	block  bool
+}
+
+func (h *handoffCallback) OnHandoff(ctx context.Context, ranges []KeyRange) error {
+	h.ranges <- ranges
+	if h.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func handoffID(t *testing.T, first byte) NodeID {
+	id, err := NodeIDFromBytes([]byte{first, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return id
+}
+
+// Test that the keys a Node is responsible for are split between its nearest neighbours
+func TestClusterHandoffRanges(t *testing.T) {
+	cluster := NewCluster(NewNode(handoffID(t, 0x50), "127.0.0.1", "127.0.0.1", "testing", 8080), nil)
+	if ranges := cluster.handoffRanges(); len(ranges) != 0 {
+		t.Errorf("Expected no ranges without other Nodes, got %d.", len(ranges))
+	}
+	above := NewNode(handoffID(t, 0x60), "127.0.0.1", "127.0.0.1", "testing", 8080)
+	_, err := cluster.leafset.insertNode(*above)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ranges := cluster.handoffRanges()
+	if len(ranges) != 1 {
+		t.Fatalf("Expected %d range with one neighbour, got %d.", 1, len(ranges))
+	}
+	for _, key := range []byte{0x00, 0x50, 0x57, 0xe0} {
+		if !ranges[0].Contains(handoffID(t, key)) {
+			t.Errorf("Expected key %x to be handed off.", key)
+		}
+	}
+	for _, key := range []byte{0x59, 0x60, 0xd0} {
+		if ranges[0].Contains(handoffID(t, key)) {
+			t.Errorf("Expected key %x not to be handed off.", key)
+		}
+	}
+	below := NewNode(handoffID(t, 0x40), "127.0.0.1", "127.0.0.1", "testing", 8080)
+	_, err = cluster.leafset.insertNode(*below)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ranges = cluster.handoffRanges()
+	if len(ranges) != 2 {
+		t.Fatalf("Expected %d ranges with two neighbours, got %d.", 2, len(ranges))
+	}
+	if !ranges[0].Owner.ID.Equals(below.ID) || !ranges[1].Owner.ID.Equals(above.ID) {
+		t.Errorf("Expected ranges to pass to %s and %s, got %s and %s.", below.ID, above.ID, ranges[0].Owner.ID, ranges[1].Owner.ID)
+	}
+	for key, expected := range map[byte]int{0x47: -1, 0x49: 0, 0x50: 0, 0x51: 1, 0x57: 1, 0x59: -1} {
+		found := -1
+		for i, r := range ranges {
+			if r.Contains(handoffID(t, key)) {
+				found = i
+			}
+		}
+		if found != expected {
+			t.Errorf("Expected key %x to be in range %d, got %d.", key, expected, found)
+		}
+	}
+}
+
+// Test that Stop waits for OnHandoff, and reports handoffs that run out of time
+func TestClusterStopHandoff(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		cluster, err := makeCluster("this is a test Node for testing purposes only.")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cluster.SetDrainTimeout(50 * time.Millisecond)
+		id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		_, err = cluster.leafset.insertNode(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		callback := &handoffCallback{testCallback: newTestCallback(t), ranges: make(chan []KeyRange, 1), block: block}
+		cluster.RegisterCallbackFor(callback, EventHandoff)
+		err = cluster.Stop()
+		select {
+		case ranges := <-callback.ranges:
+			if len(ranges) != 1 || !ranges[0].Owner.ID.Equals(id) {
+				t.Errorf("Expected one range passing to %s, got %+v.", id, ranges)
+			}
+		default:
+			t.Fatalf("Expected Stop to call OnHandoff.")
+		}
+		if !block {
+			if err != nil {
+				t.Errorf("Expected a finished handoff not to cause an error, got %v.", err)
+			}
+			continue
+		}
+		drainErr, ok := err.(DrainError)
+		if !ok || drainErr.Handoffs != 1 {
+			t.Errorf("Expected a DrainError with one unfinished handoff, got %v.", err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package wendy
+
+import (
+	"time"
+)
+
+// Health describes the state of a Cluster, for use in monitoring and health checks.
+type Health struct {
+	Listening           bool
+	Joined              bool
+	LastHeartbeat       time.Time // when the last heartbeat sweep finished; zero if none has
+	LeafSetSize         int
+	RoutingTableSize    int
+	NeighborhoodSetSize int
+	ActiveHandlers      int // inbound connections being handled
+	PendingSends        int // Messages queued to be sent
+}
+
+// Ready returns true if the Node has joined the Cluster and is listening for Messages, meaning it can serve traffic. It is suitable for use as a readiness probe.
+func (c *Cluster) Ready() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.joined && c.active != nil
+}
+
+// Healthy returns true if the Cluster is listening and its heartbeat sweeps are running on schedule, along with details of its state. It is suitable for use as a liveness probe.
+//
+// A sweep is considered overdue once twice the heartbeat frequency, plus the network timeout, has passed since the last one finished, or since the Cluster started listening if none has.
+func (c *Cluster) Healthy() (bool, Health) {
+	c.lock.RLock()
+	health := Health{
+		Listening:     c.active != nil,
+		Joined:        c.joined,
+		LastHeartbeat: c.lastHeartbeat,
+	}
+	if c.active != nil {
+		health.ActiveHandlers = c.active.len()
+	}
+	since := c.listeningSince
+	if c.lastHeartbeat.After(since) {
+		since = c.lastHeartbeat
+	}
+	overdue := time.Duration(2*c.heartbeatFrequency+c.networkTimeout) * time.Second
+	c.lock.RUnlock()
+	health.LeafSetSize = countNodes(c.leafset.list())
+	health.RoutingTableSize = countNodes(c.table.list([]int{}, []int{}))
+	health.NeighborhoodSetSize = countNodes(c.neighborhoodset.list())
+	health.PendingSends = c.pendingSends()
+	return health.Listening && time.Since(since) <= overdue, health
+}
+
+func countNodes(nodes []*Node) int {
+	count := 0
+	for _, node := range nodes {
+		if node != nil {
+			count++
+		}
+	}
+	return count
+}
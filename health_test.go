@@ -0,0 +1,55 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that Ready and Healthy reflect whether the Cluster is listening and joined
+func TestClusterHealth(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.insertNode(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	healthy, health := cluster.Healthy()
+	if healthy || health.Listening || cluster.Ready() {
+		t.Errorf("Expected a Cluster that isn't listening to be neither healthy nor ready.")
+	}
+	if health.LeafSetSize != 1 || health.RoutingTableSize != 0 {
+		t.Errorf("Expected one Node in the leaf set, got %+v.", health)
+	}
+	go cluster.Listen()
+	defer cluster.Kill()
+	time.Sleep(10 * time.Millisecond)
+	healthy, health = cluster.Healthy()
+	if !healthy || !health.Listening {
+		t.Errorf("Expected a listening Cluster to be healthy, got %+v.", health)
+	}
+	if cluster.Ready() {
+		t.Errorf("Expected a Cluster that hasn't joined not to be ready.")
+	}
+	cluster.lock.Lock()
+	cluster.joined = true
+	cluster.listeningSince = time.Now().Add(-time.Hour)
+	cluster.lock.Unlock()
+	if !cluster.Ready() {
+		t.Errorf("Expected a listening, joined Cluster to be ready.")
+	}
+	healthy, _ = cluster.Healthy()
+	if healthy {
+		t.Errorf("Expected a Cluster with an overdue heartbeat sweep to be unhealthy.")
+	}
+	cluster.sendHeartbeats()
+	healthy, health = cluster.Healthy()
+	if !healthy || health.LastHeartbeat.IsZero() {
+		t.Errorf("Expected a heartbeat sweep to make the Cluster healthy, got %+v.", health)
+	}
+}
@@ -0,0 +1,61 @@
+package wendy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+)
+
+// defaultHMACSkew is how far the time a Message was signed can be from the receiving Node's clock, unless HMACCredentials.MaxSkew is set.
+const defaultHMACSkew = 5 * time.Minute
+
+// HMACCredentials is an implementation of Credentials that grants access to the Cluster if the Node shares the same Secret, without ever sending the Secret. Each Message is sent with the time it was signed and an HMAC-SHA256, keyed with the Secret, of that time and every field of the Message that doesn't change as it's sent and forwarded, the same fields its Signature covers, so a Message altered on the way is refused. Messages signed more than MaxSkew before or after the receiving Node's clock are refused, so a captured Message can only be replayed for that long; if MaxSkew isn't set, it's 5 minutes. Nodes' clocks need to be kept roughly in sync.
+//
+// HMACCredentials only authenticates Messages, not Nodes: Marshal returns nothing, and Valid refuses everything.
+type HMACCredentials struct {
+	Secret  []byte
+	MaxSkew time.Duration
+}
+
+// Valid returns false, as HMACCredentials can only verify Messages, with VerifyMessage.
+func (h HMACCredentials) Valid(supplied []byte) bool {
+	return false
+}
+
+// Marshal returns nil, so the Secret is never sent.
+func (h HMACCredentials) Marshal() []byte {
+	return nil
+}
+
+// SignMessage returns the time, followed by the HMAC of the Message and that time.
+func (h HMACCredentials) SignMessage(msg Message) []byte {
+	var signed [8]byte
+	binary.BigEndian.PutUint64(signed[:], uint64(time.Now().UnixNano()))
+	return append(signed[:], h.mac(msg, signed[:])...)
+}
+
+// VerifyMessage returns true if supplied was returned by SignMessage for the Message, with the same Secret, within MaxSkew of now.
+func (h HMACCredentials) VerifyMessage(msg Message, supplied []byte) bool {
+	if len(supplied) != 8+sha256.Size {
+		return false
+	}
+	signed := time.Unix(0, int64(binary.BigEndian.Uint64(supplied)))
+	skew := h.MaxSkew
+	if skew <= 0 {
+		skew = defaultHMACSkew
+	}
+	if since := time.Since(signed); since > skew || since < -skew {
+		return false
+	}
+	return hmac.Equal(supplied[8:], h.mac(msg, supplied[:8]))
+}
+
+func (h HMACCredentials) mac(msg Message, signed []byte) []byte {
+	// the Credentials are what's being computed, so they're left out
+	msg.Credentials = nil
+	mac := hmac.New(sha256.New, h.Secret)
+	mac.Write(msg.signingDigest())
+	mac.Write(signed)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,110 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that HMACCredentials only accept Messages signed with the same secret, recently, and unchanged
+func TestHMACCredentials(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	credentials := HMACCredentials{Secret: []byte("open sesame")}
+	if credentials.Marshal() != nil {
+		t.Errorf("Expected the secret never to be sent.")
+	}
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("testing"))
+	supplied := credentials.SignMessage(msg)
+	if credentials.Valid(supplied) {
+		t.Errorf("Expected Valid to refuse credentials without a Message.")
+	}
+	if !credentials.VerifyMessage(msg, supplied) {
+		t.Errorf("Expected the signed Message to be accepted.")
+	}
+	msg.Hop = 3
+	msg.Credentials = supplied
+	if !credentials.VerifyMessage(msg, supplied) {
+		t.Errorf("Expected a forwarded Message to be accepted.")
+	}
+	tampered := msg
+	tampered.Purpose = FirstUserPurpose + 1
+	if credentials.VerifyMessage(tampered, supplied) {
+		t.Errorf("Expected a Message with a different Purpose to be refused.")
+	}
+	tampered = msg
+	tampered.Value = []byte("tampered")
+	if credentials.VerifyMessage(tampered, supplied) {
+		t.Errorf("Expected a Message with a different Value to be refused.")
+	}
+	tampered = msg
+	tampered.Headers = map[string]string{"tenant": "other"}
+	if credentials.VerifyMessage(tampered, supplied) {
+		t.Errorf("Expected a Message with different Headers to be refused.")
+	}
+	tampered = msg
+	tampered.Sender.ID = NodeID{1, 2}
+	if credentials.VerifyMessage(tampered, supplied) {
+		t.Errorf("Expected a Message with a different Sender to be refused.")
+	}
+	if (HMACCredentials{Secret: []byte("guess")}).VerifyMessage(msg, supplied) {
+		t.Errorf("Expected a Message signed with a different secret to be refused.")
+	}
+	if credentials.VerifyMessage(msg, supplied[1:]) {
+		t.Errorf("Expected truncated credentials to be refused.")
+	}
+	strict := HMACCredentials{Secret: credentials.Secret, MaxSkew: time.Millisecond}
+	time.Sleep(5 * time.Millisecond)
+	if strict.VerifyMessage(msg, supplied) {
+		t.Errorf("Expected a Message signed too long ago to be refused.")
+	}
+}
+
+// Test that Nodes sharing an HMAC secret exchange Messages, and refuse Nodes that don't share it
+func TestClusterHMACCredentials(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.credentials = HMACCredentials{Secret: []byte("open sesame")}
+	two.credentials = HMACCredentials{Secret: []byte("open sesame")}
+	three.credentials = Passphrase("open sesame")
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("shared")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "shared" {
+			t.Errorf("Expected the Message from the Node sharing the secret, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+	err = three.SendToIP(three.NewMessage(FirstUserPurpose, one.self.ID, []byte("passphrase")), three.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message with the wrong credentials to be refused, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
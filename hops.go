@@ -0,0 +1,87 @@
+package wendy
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxHops is the number of hops a Message can take before it's dropped, unless SetMaxHops is called. Routing normally takes a handful of hops; join messages, which skip ahead a hop for each routing table row they're sent, are allowed a hop for each row on top of the limit.
+const defaultMaxHops = 64
+
+// forwardedWindow is how long the Cluster remembers forwarding a Message, to notice it coming back.
+const forwardedWindow = time.Minute
+
+// forwardedLimit is the number of forwarded Messages remembered before the ones older than forwardedWindow are forgotten.
+const forwardedLimit = 4096
+
+// forwarded records when, and after how many hops, the Cluster forwarded a Message.
+type forwarded struct {
+	hop int
+	at  time.Time
+}
+
+// SetMaxHops sets the number of hops a Message can take before a Node drops it instead of forwarding it. Dropped Messages are reported to Applications' OnError as a RoutingError, and counted in Stats. A value of 0 removes the limit; by default, Messages can take 64 hops.
+//
+// Whatever the limit, a Message that comes back to a Node that has already forwarded it, having taken more hops since, is caught in a routing loop, and is dropped and reported the same way.
+func (c *Cluster) SetMaxHops(hops int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if hops < 0 {
+		hops = 0
+	}
+	c.maxHops = hops
+}
+
+func (c *Cluster) getMaxHops() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maxHops
+}
+
+// checkHops returns a RoutingError if the Message has exceeded the hop limit or is caught in a routing loop, and otherwise records that it is being forwarded.
+func (c *Cluster) checkHops(msg Message) error {
+	max := c.getMaxHops()
+	if max > 0 && msg.Purpose == NODE_JOIN {
+		max += c.table.rowCount()
+	}
+	if max > 0 && msg.Hop > max {
+		atomic.AddUint64(&c.stats.HopLimitExceeded, 1)
+		return RoutingError{Key: msg.Key, Purpose: msg.Purpose, Hop: msg.Hop}
+	}
+	id := forwardedID(msg)
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if seen, ok := c.forwarded[id]; ok && now.Sub(seen.at) < forwardedWindow && msg.Hop > seen.hop {
+		atomic.AddUint64(&c.stats.RoutingLoops, 1)
+		return RoutingError{Key: msg.Key, Purpose: msg.Purpose, Hop: msg.Hop, Loop: true}
+	}
+	if len(c.forwarded) >= forwardedLimit {
+		for key, seen := range c.forwarded {
+			if now.Sub(seen.at) >= forwardedWindow {
+				delete(c.forwarded, key)
+			}
+		}
+	}
+	// if every remembered Message is recent, this one isn't remembered, which can only cause a loop to be missed
+	if len(c.forwarded) < forwardedLimit {
+		c.forwarded[id] = forwarded{hop: msg.Hop, at: now}
+	}
+	return nil
+}
+
+// forwardedID identifies a Message by the fields that don't change as it's forwarded. The same Message sent again by its sender starts over at the same hop count, so it isn't mistaken for a loop.
+func forwardedID(msg Message) uint64 {
+	h := fnv.New64a()
+	var buf [33]byte
+	binary.BigEndian.PutUint64(buf[0:], msg.Sender.ID[0])
+	binary.BigEndian.PutUint64(buf[8:], msg.Sender.ID[1])
+	binary.BigEndian.PutUint64(buf[16:], msg.Key[0])
+	binary.BigEndian.PutUint64(buf[24:], msg.Key[1])
+	buf[32] = msg.Purpose
+	h.Write(buf[:])
+	h.Write(msg.Value)
+	return h.Sum64()
+}
@@ -0,0 +1,95 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that Messages are dropped once they exceed the hop limit, unless it's disabled
+func TestClusterCheckHopsLimit(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello"))
+	msg.Hop = defaultMaxHops + 1
+	err = cluster.checkHops(msg)
+	if e, ok := err.(RoutingError); !ok || e.Loop || e.Hop != msg.Hop {
+		t.Errorf("Expected a RoutingError for exceeding the hop limit, got %v.", err)
+	}
+	if stats := cluster.Stats(); stats.HopLimitExceeded != 1 {
+		t.Errorf("Expected 1 Message over the hop limit, got %d.", stats.HopLimitExceeded)
+	}
+	cluster.SetMaxHops(0)
+	err = cluster.checkHops(msg)
+	if err != nil {
+		t.Errorf("Expected no hop limit, got %v.", err)
+	}
+}
+
+// Test that a Message coming back to a Node that forwarded it is dropped, but the same Message sent again isn't
+func TestClusterCheckHopsLoop(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello"))
+	msg.Hop = 1
+	err = cluster.checkHops(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.checkHops(msg)
+	if err != nil {
+		t.Errorf("Expected a Message sent again to be forwarded, got %v.", err)
+	}
+	msg.Hop = 3
+	err = cluster.checkHops(msg)
+	if e, ok := err.(RoutingError); !ok || !e.Loop {
+		t.Errorf("Expected a RoutingError for a routing loop, got %v.", err)
+	}
+	if stats := cluster.Stats(); stats.RoutingLoops != 1 {
+		t.Errorf("Expected 1 routing loop, got %d.", stats.RoutingLoops)
+	}
+	other := cluster.NewMessage(byte(16), cluster.self.ID, []byte("goodbye"))
+	other.Hop = 3
+	err = cluster.checkHops(other)
+	if err != nil {
+		t.Errorf("Expected a different Message to be forwarded, got %v.", err)
+	}
+}
+
+// Test that received Messages over the hop limit are reported to OnError instead of being forwarded
+func TestClusterHopLimitReported(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 1)}
+	cluster.RegisterCallback(callback)
+	cluster.SetMaxHops(2)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), id, []byte("hello"))
+	msg.Hop = 3
+	cluster.onMessageReceived(msg)
+	select {
+	case err = <-callback.errors:
+		if _, ok := err.(RoutingError); !ok {
+			t.Errorf("Expected a RoutingError, got %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the RoutingError.")
+	}
+	select {
+	case <-callback.onForward:
+		t.Errorf("Expected the Message not to be forwarded.")
+	default:
+	}
+}
@@ -0,0 +1,72 @@
+package wendy
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"sync"
+)
+
+// NodeIDFromPublicKey derives a NodeID from an Ed25519 public key, as the first 16 bytes of the key's SHA-256 hash. A Node that signs its Messages must use the NodeID derived from its key, so no other Node can claim its ID without its private key.
+func NodeIDFromPublicKey(key ed25519.PublicKey) NodeID {
+	sum := sha256.Sum256(key)
+	id, _ := NodeIDFromBytes(sum[:])
+	return id
+}
+
+// SetSigningKey has the current Node sign every Message it sends with key, and only accept Messages signed by their Sender. The Node's ID must be derived from key's public key with NodeIDFromPublicKey, and every Node in the Cluster should set a signing key of its own. The public key is sent with every Message, and listed with the Node in the state tables sent to other Nodes, so a Node can check any Message's signature, and that the Sender's ID belongs to the key that signed it, without having heard of the Sender before. Nodes whose ID isn't derived from their public key aren't inserted into the state tables.
+//
+// Messages forwarded for other Nodes keep their Sender's signature, which covers everything but their Hop, Path, Destination, and Checksum. Virtual Nodes don't share the current Node's key, and need a key of their own.
+func (c *Cluster) SetSigningKey(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return throwInvalidArgumentError("The signing key must be an Ed25519 private key.")
+	}
+	public := key.Public().(ed25519.PublicKey)
+	if !NodeIDFromPublicKey(public).Equals(c.self.ID) {
+		return throwInvalidArgumentError("The Node's ID must be derived from the signing key with NodeIDFromPublicKey.")
+	}
+	c.lock.Lock()
+	c.signingKey = key
+	c.lock.Unlock()
+	c.self.setPublicKey(public)
+	return nil
+}
+
+func (c *Cluster) getSigningKey() ed25519.PrivateKey {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.signingKey
+}
+
+// sign sets the Signature of a Message the current Node sent, if it has a signing key. Messages forwarded for other Nodes are left alone.
+func (c *Cluster) sign(msg *Message) {
+	key := c.getSigningKey()
+	if key == nil || !msg.Sender.ID.Equals(c.self.ID) {
+		return
+	}
+	msg.Signature = ed25519.Sign(key, msg.signingDigest())
+}
+
+// verifySignature returns false if the current Node has a signing key, and the Message wasn't signed by its Sender with the key its ID is derived from.
+func (c *Cluster) verifySignature(msg Message) bool {
+	if c.getSigningKey() == nil {
+		return true
+	}
+	if !msg.Sender.identityBound() || len(msg.Signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(msg.Sender.PublicKey, msg.signingDigest(), msg.Signature)
+}
+
+// identityBound returns true if the Node has an Ed25519 public key, and its ID is derived from it.
+func (self Node) identityBound() bool {
+	return len(self.PublicKey) == ed25519.PublicKeySize && NodeIDFromPublicKey(self.PublicKey).Equals(self.ID)
+}
+
+func (self *Node) setPublicKey(key ed25519.PublicKey) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.PublicKey = key
+}
@@ -0,0 +1,188 @@
+package wendy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func makeSignedCluster() (*Cluster, error) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	node := NewNode(NodeIDFromPublicKey(key.Public().(ed25519.PublicKey)), "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(node, nil)
+	cluster.SetHeartbeatFrequency(10)
+	cluster.SetNetworkTimeout(1)
+	cluster.SetLogLevel(LogLevelDebug)
+	return cluster, cluster.SetSigningKey(key)
+}
+
+// Test that a signing key is only accepted if the Node's ID is derived from it
+func TestClusterSetSigningKey(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.SetSigningKey(key)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a key the Node's ID isn't derived from, got %v.", err)
+	}
+	err = cluster.SetSigningKey(ed25519.PrivateKey("too short"))
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a malformed key, got %v.", err)
+	}
+	signed, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !signed.self.identityBound() {
+		t.Errorf("Expected the Node to advertise the public key its ID is derived from.")
+	}
+}
+
+// Test that signed Messages survive a round trip through the ProtobufCodec and being forwarded, but not tampering or spoofing
+func TestClusterVerifySignature(t *testing.T) {
+	cluster, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("testing"))
+	if cluster.verifySignature(msg) {
+		t.Errorf("Expected an unsigned Message to be rejected.")
+	}
+	cluster.sign(&msg)
+	var buf bytes.Buffer
+	err = ProtobufCodec{}.NewEncoder(&buf).Encode(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded Message
+	err = ProtobufCodec{}.NewDecoder(&buf).Decode(&decoded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	decoded.Hop = 3
+	decoded.Destination = NodeID{1, 2}
+	decoded.Path = []TraceHop{{ID: cluster.self.ID, Time: time.Now()}}
+	if !cluster.verifySignature(decoded) {
+		t.Errorf("Expected a signed Message to be accepted after being encoded and forwarded.")
+	}
+	if !decoded.verifyChecksum() {
+		t.Errorf("Expected the Signature to be left out of the Checksum.")
+	}
+	tampered := decoded
+	tampered.Value = []byte("tampered")
+	if cluster.verifySignature(tampered) {
+		t.Errorf("Expected a tampered Message to be rejected.")
+	}
+	other, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	spoofed := other.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("testing"))
+	spoofed.Sender.ID = cluster.self.ID
+	spoofed.Signature = ed25519.Sign(other.getSigningKey(), spoofed.signingDigest())
+	if cluster.verifySignature(spoofed) {
+		t.Errorf("Expected a Message claiming another Node's ID to be rejected.")
+	}
+	// forwarding a Message doesn't sign it on the Sender's behalf
+	forwarded := other.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("testing"))
+	cluster.sign(&forwarded)
+	if forwarded.Signature != nil {
+		t.Errorf("Expected a Message sent by another Node not to be signed.")
+	}
+}
+
+// Test that a Node that signs its Messages doesn't insert Nodes whose IDs aren't derived from their public keys
+func TestClusterInsertIdentityBound(t *testing.T) {
+	cluster, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	unbound := NewNode(NodeID{other.self.ID[0], other.self.ID[1] + 1}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	unbound.PublicKey = other.self.PublicKey
+	err = cluster.insert(*unbound, StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(unbound.ID)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a Node with the wrong public key not to be inserted, got %v.", err)
+	}
+	err = cluster.insert(*other.self, StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(other.self.ID)
+	if err != nil {
+		t.Errorf("Expected a Node with its public key to be inserted, got %v.", err)
+	}
+}
+
+// Test that Nodes that sign their Messages deliver each other's, and discard Messages that aren't signed
+func TestClusterSignedMessages(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeSignedCluster()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	unsigned, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.Send(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("signed")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "signed" {
+			t.Errorf("Expected the signed Message, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the signed Message to be delivered.")
+	}
+	// claim to be two, without its key
+	spoofed := unsigned.NewMessage(FirstUserPurpose, one.self.ID, []byte("spoofed"))
+	spoofed.Sender = *two.self
+	err = unsigned.SendToIP(spoofed, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the unsigned Message to be discarded, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if one.Stats().BadSignatures != 1 {
+		t.Errorf("Expected 1 Message with a bad signature, got %d.", one.Stats().BadSignatures)
+	}
+}
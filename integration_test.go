@@ -1,6 +1,9 @@
 package wendy
 
 import (
+	"context"
+	"io"
+	"net"
 	"testing"
 	"time"
 )
@@ -79,6 +82,15 @@ func (t *testCallback) OnHeartbeat(node Node) {
 	}
 }
 
+type joinedCallback struct {
+	*testCallback
+	onJoined chan struct{}
+}
+
+func (j *joinedCallback) OnJoined() {
+	j.onJoined <- struct{}{}
+}
+
 func makeCluster(idBytes string) (*Cluster, error) {
 	id, err := NodeIDFromBytes([]byte(idBytes))
 	if err != nil {
@@ -92,6 +104,25 @@ func makeCluster(idBytes string) (*Cluster, error) {
 	return cluster, nil
 }
 
+// waitListening waits for each Cluster to start listening, by which time it has recorded the port the OS gave it, failing the test if one takes longer than a second.
+func waitListening(t *testing.T, clusters ...*Cluster) {
+	deadline := time.Now().Add(time.Second)
+	for _, cluster := range clusters {
+		for {
+			cluster.lock.RLock()
+			listening := cluster.active != nil
+			cluster.lock.RUnlock()
+			if listening {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %s to start listening.", cluster.self.ID)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
 // Test joining two nodes
 func TestClusterJoinTwo(t *testing.T) {
 	if testing.Short() {
@@ -343,3 +374,324 @@ L:
 	}
 	return
 }
+
+// Test joining through a DNS name, skipping seeds that can't be reached
+func TestClusterJoinDNS(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.joinAny([]string{})
+	if err != noSeedsError {
+		t.Fatalf("Expected noSeedsError, got %v instead.", err)
+	}
+	go func() {
+		defer one.Kill()
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	addresses, err := seedAddresses("localhost", one.self.Port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.joinAny(append([]string{"127.0.0.1:1"}, addresses...))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.JoinDNS("localhost", one.self.Port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+// Test that state deltas only include Nodes added since the specified versions
+func TestClusterStateDelta(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	first_id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	second_id, err := NodeIDFromBytes([]byte("yet another Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.table.insertNode(*NewNode(first_id, "127.0.0.1", "127.0.0.1", "testing", 1), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.neighborhoodset.insertNode(*NewNode(first_id, "127.0.0.1", "127.0.0.1", "testing", 1), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	rtVersion := cluster.self.routingTableVersion
+	nsVersion := cluster.self.neighborhoodSetVersion
+	_, err = cluster.table.insertNode(*NewNode(second_id, "127.0.0.1", "127.0.0.1", "testing", 2), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	state, err := cluster.dumpStateDelta(StateMask{Mask: all}, rtVersion+1, 0, nsVersion+1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !state.Delta {
+		t.Errorf("Expected the state to be marked as a delta.")
+	}
+	rt := []*Node{}
+	for _, row := range state.RoutingTable {
+		for _, node := range row {
+			if node != nil {
+				rt = append(rt, node)
+			}
+		}
+	}
+	if len(rt) != 1 || !rt[0].ID.Equals(second_id) {
+		t.Errorf("Expected only %s in the routing table delta, got %v.", second_id, rt)
+	}
+	for _, node := range state.NeighborhoodSet {
+		if node != nil {
+			t.Errorf("Expected an empty neighborhood set delta, got %s.", node.ID)
+		}
+	}
+	full, err := cluster.table.getNode(first_id)
+	if err != nil || full == nil {
+		t.Errorf("Expected the delta not to modify the routing table.")
+	}
+}
+
+// Test that the state tables received in a Message are inserted at once, changing each table's version and the leaf set only once
+func TestClusterInsertMessageBatch(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	cluster.RegisterCallback(callback)
+	sender, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.proximityCache.observe(sender.self.ID, time.Millisecond, false)
+	var leaves [2][16]*Node
+	for i, name := range []string{"yet another Node for testing purposes only.", "a fourth Node for testing purposes only, too.", "a fifth Node for testing purposes only, as well."} {
+		id, err := NodeIDFromBytes([]byte(name))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cluster.proximityCache.observe(id, time.Duration(i+2)*time.Millisecond, false)
+		leaves[0][i] = NewNode(id, "127.0.0.1", "127.0.0.1", "testing", i+2)
+	}
+	data, err := sender.marshal(stateTables{LeafSet: &leaves})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	lsVersion := cluster.self.leafsetVersion
+	nsVersion := cluster.self.neighborhoodSetVersion
+	err = cluster.insertMessage(sender.NewMessage(STAT_DATA, sender.self.ID, data))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(cluster.leafset.list()) != 4 {
+		t.Errorf("Expected 4 Nodes in the leaf set, got %d.", len(cluster.leafset.list()))
+	}
+	if len(cluster.neighborhoodset.list()) != 4 {
+		t.Errorf("Expected 4 Nodes in the neighborhood set, got %d.", len(cluster.neighborhoodset.list()))
+	}
+	if cluster.self.leafsetVersion != lsVersion+1 || cluster.self.neighborhoodSetVersion != nsVersion+1 {
+		t.Errorf("Expected each table's version to be incremented once, got leaf set %d to %d and neighborhood set %d to %d.", lsVersion, cluster.self.leafsetVersion, nsVersion, cluster.self.neighborhoodSetVersion)
+	}
+	select {
+	case leaves := <-callback.onNewLeaves:
+		if len(leaves) != 4 {
+			t.Errorf("Expected to be told of 4 leaves, got %d.", len(leaves))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the new leaves.")
+	}
+	select {
+	case <-callback.onNewLeaves:
+		t.Errorf("Expected to be told of the new leaves only once.")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Test that canceling the context passed to Serve closes open connections and stops the Cluster
+func TestClusterServeCancel(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- cluster.Serve(ctx)
+	}()
+	waitListening(t, cluster)
+	conn, err := net.Dial("tcp", cluster.GetIP(*cluster.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-served:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for Serve to return.")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("Expected the connection to be closed, got %v.", err)
+	}
+}
+
+// Test that Kill doesn't block when the Cluster isn't listening
+func TestClusterKillNotListening(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	killed := make(chan struct{})
+	go func() {
+		cluster.Kill()
+		close(killed)
+	}()
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for Kill to return.")
+	}
+	err = cluster.Listen()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+// Test that Stop reports the handlers it couldn't wait for
+func TestClusterStopDrainTimeout(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetTimeouts(0, 0, 5*time.Second)
+	cluster.SetDrainTimeout(50 * time.Millisecond)
+	go cluster.Listen()
+	waitListening(t, cluster)
+	conn, err := net.Dial("tcp", cluster.GetIP(*cluster.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond)
+	err = cluster.Stop()
+	drainErr, ok := err.(DrainError)
+	if !ok {
+		t.Fatalf("Expected a DrainError, got %v.", err)
+	}
+	if drainErr.Handlers != 1 || drainErr.Sends != 0 || drainErr.Exits != 0 {
+		t.Errorf("Expected one unfinished handler, got %+v.", drainErr)
+	}
+}
+
+// Test that Stop doesn't count Nodes that are already dead as unfinished
+func TestClusterStopDeadNodes(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.insertNode(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.Stop()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+// Test that JoinAndWait returns once the join has completed
+func TestClusterJoinAndWait(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	joined := &joinedCallback{testCallback: newTestCallback(t), onJoined: make(chan struct{}, 2)}
+	two.RegisterCallback(joined)
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-two.Joined():
+		t.Fatalf("Expected Joined not to be closed before joining.")
+	default:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, []string{two.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !two.isJoined() {
+		t.Fatalf("Expected JoinAndWait not to return before the Node joined.")
+	}
+	select {
+	case <-two.Joined():
+	default:
+		t.Fatalf("Expected Joined to be closed after joining.")
+	}
+	select {
+	case <-joined.onJoined:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for OnJoined to be called.")
+	}
+	_, err = two.leafset.getNode(one.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+// Test that JoinAndWait returns an error when no seed can be reached
+func TestClusterJoinAndWaitUnreachable(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = cluster.JoinAndWait(ctx, []string{"127.0.0.1:1"})
+	if err != deadNodeError {
+		t.Fatalf("Expected %v, got %v.", deadNodeError, err)
+	}
+	err = cluster.JoinAndWait(ctx, []string{})
+	if err != noSeedsError {
+		t.Fatalf("Expected %v, got %v.", noSeedsError, err)
+	}
+}
@@ -0,0 +1,27 @@
+package wendy
+
+// Interceptor is a function that is called with every Message the Cluster sends, including the Cluster's own protocol Messages, immediately before it is encoded. It receives a pointer to the Message, which can be modified, and the address the Message is being sent to. If it returns an error, the Message is not sent, and the send fails with that error.
+//
+// Interceptors are called each time a send is attempted, so a Message that is retried passes through them again, starting from the unmodified Message.
+type Interceptor func(msg *Message, address string) error
+
+// AddInterceptor adds an Interceptor that will be called for every Message the Cluster sends. Interceptors are called in the order they were added, each receiving the Message as modified by the ones before it.
+func (c *Cluster) AddInterceptor(interceptor Interceptor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.interceptors = append(c.interceptors, interceptor)
+}
+
+// intercept passes msg through each Interceptor, stopping at the first error.
+func (c *Cluster) intercept(msg *Message, address string) error {
+	c.lock.RLock()
+	interceptors := c.interceptors
+	c.lock.RUnlock()
+	for _, interceptor := range interceptors {
+		err := interceptor(msg, address)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package wendy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Test that Interceptors can modify outgoing Messages and stop them being sent
+func TestClusterInterceptors(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	addresses := []string{}
+	two.AddInterceptor(func(msg *Message, address string) error {
+		addresses = append(addresses, address)
+		msg.Value = append(msg.Value, []byte(", intercepted")...)
+		return nil
+	})
+	two.AddInterceptor(func(msg *Message, address string) error {
+		msg.Value = append(msg.Value, []byte(" twice")...)
+		return nil
+	})
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		if string(received.Value) != "hello, world, intercepted twice" {
+			t.Fatalf("Expected %s, got %s.", "hello, world, intercepted twice", string(received.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	if len(addresses) != 1 || addresses[0] != two.GetIP(*one.self) {
+		t.Errorf("Expected the Interceptor to see address %s, got %v.", two.GetIP(*one.self), addresses)
+	}
+	refused := errors.New("refused by interceptor")
+	two.AddInterceptor(func(msg *Message, address string) error {
+		return refused
+	})
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != refused {
+		t.Fatalf("Expected %v, got %v.", refused, err)
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		t.Fatalf("Expected the Message not to be sent, but %s was delivered.", string(received.Value))
+	case <-time.After(50 * time.Millisecond):
+	}
+}
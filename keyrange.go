@@ -0,0 +1,25 @@
+package wendy
+
+// Range returns the range of keys the current Node is closest to, and so receives Messages for, running up from low to high, inclusive. If high is less than low, the range wraps around the end of the node space; a Node that doesn't know of any other Node is closest to every key. The range is worked out from the leaf set whenever it changes, so it is only as accurate as the leaf set.
+func (c *Cluster) Range() (low, high NodeID) {
+	_, _, low, high = c.leafset.neighbours()
+	return low, high
+}
+
+// Predecessor returns a copy of the closest Node below the current Node on the ring, wrapping around the node space. It returns false if the leaf set is empty.
+func (c *Cluster) Predecessor() (Node, bool) {
+	predecessor, _, _, _ := c.leafset.neighbours()
+	if predecessor == nil {
+		return Node{}, false
+	}
+	return *predecessor.clone(), true
+}
+
+// Successor returns a copy of the closest Node above the current Node on the ring, wrapping around the node space. It returns false if the leaf set is empty.
+func (c *Cluster) Successor() (Node, bool) {
+	_, successor, _, _ := c.leafset.neighbours()
+	if successor == nil {
+		return Node{}, false
+	}
+	return *successor.clone(), true
+}
@@ -0,0 +1,64 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that the range of keys a Node owns, and its neighbours on the ring, follow changes to the leaf set
+func TestClusterRange(t *testing.T) {
+	self := NewNode(NodeID{0x8000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(self, nil)
+	low, high := cluster.Range()
+	if !(KeyRange{Start: low, End: high}).Contains(NodeID{0, 0}) || !(KeyRange{Start: low, End: high}).Contains(self.ID) {
+		t.Errorf("Expected a Node on its own to own every key, got %s to %s.", low, high)
+	}
+	if _, ok := cluster.Predecessor(); ok {
+		t.Errorf("Expected a Node on its own not to have a predecessor.")
+	}
+	if _, ok := cluster.Successor(); ok {
+		t.Errorf("Expected a Node on its own not to have a successor.")
+	}
+	below := NewNode(NodeID{0x4000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	_, err := cluster.leafset.insertNode(*below)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// with only one other Node, it is both neighbours
+	predecessor, ok := cluster.Predecessor()
+	if !ok || !predecessor.ID.Equals(below.ID) {
+		t.Errorf("Expected %s to be the predecessor, got %s.", below.ID, predecessor.ID)
+	}
+	successor, ok := cluster.Successor()
+	if !ok || !successor.ID.Equals(below.ID) {
+		t.Errorf("Expected %s to be the successor, got %s.", below.ID, successor.ID)
+	}
+	low, high = cluster.Range()
+	if !low.Equals(NodeID{0x6000000000000000, 1}) || !high.Equals(NodeID{0xe000000000000000, 0}) {
+		t.Errorf("Expected a range of %s to %s, got %s to %s.", NodeID{0x6000000000000000, 1}, NodeID{0xe000000000000000, 0}, low, high)
+	}
+	above := NewNode(NodeID{0xa000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 2)
+	_, err = cluster.leafset.insertNode(*above)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	successor, ok = cluster.Successor()
+	if !ok || !successor.ID.Equals(above.ID) {
+		t.Errorf("Expected %s to be the successor, got %s.", above.ID, successor.ID)
+	}
+	low, high = cluster.Range()
+	if !low.Equals(NodeID{0x6000000000000000, 1}) || !high.Equals(NodeID{0x9000000000000000, 0}) {
+		t.Errorf("Expected a range of %s to %s, got %s to %s.", NodeID{0x6000000000000000, 1}, NodeID{0x9000000000000000, 0}, low, high)
+	}
+	_, err = cluster.leafset.removeNode(below.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	predecessor, ok = cluster.Predecessor()
+	if !ok || !predecessor.ID.Equals(above.ID) {
+		t.Errorf("Expected %s to be the predecessor once %s was removed, got %s.", above.ID, below.ID, predecessor.ID)
+	}
+	low, high = cluster.Range()
+	if !low.Equals(NodeID{0x1000000000000000, 1}) || !high.Equals(NodeID{0x9000000000000000, 0}) {
+		t.Errorf("Expected a range of %s to %s, got %s to %s.", NodeID{0x1000000000000000, 1}, NodeID{0x9000000000000000, 0}, low, high)
+	}
+}
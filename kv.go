@@ -0,0 +1,631 @@
+package wendy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var kvQuorumError = errors.New("Too few of the key's replicas answered to reach a quorum.")
+var kvMessageError = errors.New("A key-value store message was malformed.")
+var kvLeasedError = errors.New("The key is leased by another holder.")
+
+// kvSweepInterval is how often a replica discards the records that have expired.
+const kvSweepInterval = time.Minute
+
+const (
+	kvPut = byte(iota) // stores a record, resolved with the one stored
+	kvGet              // asks for the record stored
+)
+
+const (
+	kvFound   = 1 << iota // the record exists
+	kvDeleted             // the record marks the key as deleted
+	kvCRDT                // the record's value is a Counter, ORSet, or LWWRegister
+)
+
+// kvRecordHeaderSize is the size of the header of an encoded record: its flags, its version, its expiry, the length of its holder, and the number of entries in its clock.
+const kvRecordHeaderSize = 1 + 8 + 8 + 1 + 2
+
+// MergeFunc merges the values of a KVStore's key written concurrently, returning the value to store in their place. The value written latest, by its writer's clock, is passed as newer. It's called on each replica that holds one value when the other arrives, and on Nodes that read both, so it must give the same result wherever it's called.
+type MergeFunc func(key string, older, newer []byte) []byte
+
+// kvRecord is the value a replica stores under a key.
+type kvRecord struct {
+	Found   bool
+	Deleted bool
+	CRDT    bool
+	Version int64  // when the value was written, in nanoseconds since the Unix epoch, by the writer's clock
+	Expires int64  // when the replicas discard the record, in nanoseconds since the Unix epoch; 0 if it never expires
+	Holder  []byte // the hash of the token of the lease on the key, if it's leased; replicas never store or send the token itself, so their answers can't be used to take over the lease
+	Clock   VectorClock
+	Value   []byte
+}
+
+// expired returns true if the record has an expiry, and it has passed.
+func (r kvRecord) expired(now time.Time) bool {
+	return r.Expires != 0 && now.UnixNano() > r.Expires
+}
+
+// leasedTo returns false if the record is leased, and the lease isn't held with token.
+func (r kvRecord) leasedTo(token []byte) bool {
+	return len(r.Holder) == 0 || string(r.Holder) == string(leaseHolder(token))
+}
+
+// leaseHolder returns the Holder of the records leased with token: its SHA-256 hash, or nil if there's no token.
+func leaseHolder(token []byte) []byte {
+	if len(token) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(token)
+	return sum[:]
+}
+
+// resolve returns the record to keep out of r and other, and whether it differs from other. A record that descends the other by its clock is kept; when they're concurrent, the newer one is kept, with a clock that descends both. Unless either is deleted, its value is merged with the older one's: as replicated data types, if both hold the same kind, or by merge, if it's set.
+func (r kvRecord) resolve(key string, other kvRecord, merge MergeFunc) (kvRecord, bool) {
+	switch {
+	case !r.Found:
+		return other, false
+	case !other.Found:
+		return r, true
+	case other.Clock.Descends(r.Clock):
+		return other, false
+	case r.Clock.Descends(other.Clock):
+		return r, true
+	}
+	older, newer := other, r
+	if other.newer(r) {
+		older, newer = r, other
+	}
+	resolved := newer
+	resolved.Clock = r.Clock.Join(other.Clock)
+	switch {
+	case older.Deleted || newer.Deleted:
+	case older.CRDT && newer.CRDT:
+		value, err := mergeCRDT(older.Value, newer.Value)
+		if err == nil {
+			resolved.Value = value
+		}
+	case merge != nil:
+		resolved.Value = merge(key, older.Value, newer.Value)
+	}
+	return resolved, true
+}
+
+// newer returns true if r should replace other, when neither descends the other. The latest write wins; deleting wins a tie.
+func (r kvRecord) newer(other kvRecord) bool {
+	if !other.Found {
+		return r.Found
+	}
+	if r.Version != other.Version {
+		return r.Version > other.Version
+	}
+	return r.Deleted && !other.Deleted
+}
+
+func (r kvRecord) marshal() []byte {
+	data := make([]byte, kvRecordHeaderSize, kvRecordHeaderSize+len(r.Holder)+len(r.Clock)*vectorClockEntrySize+len(r.Value))
+	if r.Found {
+		data[0] |= kvFound
+	}
+	if r.Deleted {
+		data[0] |= kvDeleted
+	}
+	if r.CRDT {
+		data[0] |= kvCRDT
+	}
+	binary.BigEndian.PutUint64(data[1:], uint64(r.Version))
+	binary.BigEndian.PutUint64(data[9:], uint64(r.Expires))
+	data[17] = byte(len(r.Holder))
+	binary.BigEndian.PutUint16(data[18:], uint16(len(r.Clock)))
+	data = append(data, r.Holder...)
+	data = append(data, r.Clock.marshal()...)
+	return append(data, r.Value...)
+}
+
+func unmarshalKVRecord(data []byte) (kvRecord, error) {
+	if len(data) < kvRecordHeaderSize {
+		return kvRecord{}, kvMessageError
+	}
+	holder := kvRecordHeaderSize + int(data[17])
+	clock := holder + int(binary.BigEndian.Uint16(data[18:]))*vectorClockEntrySize
+	if len(data) < clock {
+		return kvRecord{}, kvMessageError
+	}
+	record := kvRecord{
+		Found:   data[0]&kvFound != 0,
+		Deleted: data[0]&kvDeleted != 0,
+		CRDT:    data[0]&kvCRDT != 0,
+		Version: int64(binary.BigEndian.Uint64(data[1:])),
+		Expires: int64(binary.BigEndian.Uint64(data[9:])),
+		Value:   data[clock:],
+	}
+	if holder > kvRecordHeaderSize {
+		record.Holder = data[kvRecordHeaderSize:holder]
+	}
+	var err error
+	record.Clock, err = unmarshalVectorClock(data[holder:clock])
+	return record, err
+}
+
+// marshalKVRequest encodes a request to a replica: the operation, the key, the token of the Lease the key is held with, if any, and the record to store, if any.
+func marshalKVRequest(op byte, key string, token []byte, record kvRecord) []byte {
+	data := make([]byte, 3, 3+len(key)+1+len(token)+kvRecordHeaderSize+len(record.Holder)+len(record.Clock)*vectorClockEntrySize+len(record.Value))
+	data[0] = op
+	binary.BigEndian.PutUint16(data[1:], uint16(len(key)))
+	data = append(data, key...)
+	data = append(data, byte(len(token)))
+	data = append(data, token...)
+	return append(data, record.marshal()...)
+}
+
+func unmarshalKVRequest(data []byte) (byte, string, []byte, kvRecord, error) {
+	if len(data) < 3 {
+		return 0, "", nil, kvRecord{}, kvMessageError
+	}
+	op := data[0]
+	length := int(binary.BigEndian.Uint16(data[1:]))
+	if op > kvGet || len(data) < 3+length+1 {
+		return 0, "", nil, kvRecord{}, kvMessageError
+	}
+	key := string(data[3 : 3+length])
+	data = data[3+length:]
+	if len(data) < 1+int(data[0]) {
+		return 0, "", nil, kvRecord{}, kvMessageError
+	}
+	var token []byte
+	if data[0] > 0 {
+		token = data[1 : 1+int(data[0])]
+	}
+	record, err := unmarshalKVRecord(data[1+int(data[0]):])
+	return op, key, token, record, err
+}
+
+// HashKey returns the NodeID a key-value store stores key under: the first 128 bits of its SHA-256 hash.
+func HashKey(key string) NodeID {
+	sum := sha256.Sum256([]byte(key))
+	id, _ := NodeIDFromBytes(sum[:])
+	return id
+}
+
+// Consistency sets how many of a key's replicas a KVStore waits for, trading latency for consistency. Waiting for fewer replicas returns sooner, and survives more of them failing, but a read may miss a write if the replicas it heard from and those the write reached don't overlap: they always do when Write plus Read is more than the number of replicas, as it is for the default of a majority each. Counts above the number of replicas wait for all of them.
+type Consistency struct {
+	Write        int  // how many replicas must store a write before it returns; a majority if 0
+	Read         int  // how many replicas must answer a read before it returns; a majority if 0
+	NoReadRepair bool // whether reads leave the replicas that answered with an older value alone
+}
+
+// quorum returns how many of the replicas to wait for, when count were asked for.
+func (c Consistency) quorum(count, replicas int) int {
+	if count <= 0 {
+		return replicas/2 + 1
+	}
+	if count > replicas {
+		return replicas
+	}
+	return count
+}
+
+// Lease is a KVStore's key held by the Node that acquired it with Acquire, until it expires or is released. While it's held, only the holder can write to the key.
+type Lease struct {
+	Key     string
+	Expires time.Time // when the replicas free the key, unless the Lease is renewed, by the holder's clock
+	token   []byte
+	value   []byte
+}
+
+// KVEntry is a value the current Node stores as a replica of its key, as returned by Scan.
+type KVEntry struct {
+	Key     string
+	Value   []byte // the encoded value, for Counters, ORSets, and LWWRegisters
+	Clock   VectorClock
+	Expires time.Time // the zero time if the value doesn't expire
+	Owner   bool      // whether the current Node is the closest to the key, and first in its ReplicaSet
+	Replica bool      // whether the current Node is still in the key's ReplicaSet; values it was given before Nodes joined closer to the key may no longer be
+}
+
+// KVStore is a key-value store replicated across the Cluster. Each value is stored on the Nodes in the ReplicaSet of its key's hash, and by default written to and read from a majority of them, so it survives all but the last of those Nodes failing. Every Node in the Cluster must create a KVStore with the same purpose and number of replicas, to store its share of the values.
+//
+// Values are kept in memory, each with a VectorClock. A write replaces the values it descends: those written before it by the same Node, or read by GetVersion before it was written with PutVersion. When two writes to the same key are concurrent, the one that started latest, by its writer's clock, wins, unless a MergeFunc is set with SetMerge to combine them. Reads repair replicas that missed the latest write, but values aren't moved when Nodes join or leave the Cluster, so a key whose replicas all change is lost.
+//
+// Values written with a TTL, and keys held by a Lease, expire by each replica's own clock: expired values are treated as missing, and each replica discards them from memory every minute, so values that are abandoned don't build up. Clocks should be roughly in sync, as they are for Message Expires times.
+type KVStore struct {
+	cluster     *Cluster
+	purpose     byte
+	replicas    int
+	consistency Consistency
+	*kvReplica  // shared with the KVStores returned by WithConsistency
+}
+
+// kvReplica is the part of a KVStore the current Node keeps as a replica.
+type kvReplica struct {
+	lock    sync.RWMutex
+	records map[string]kvRecord
+	merge   MergeFunc
+}
+
+// NewKVStore returns a KVStore whose values are stored on replicas Nodes each, and whose Messages have the specified purpose. The purpose is handled like a purpose passed to Handle, so its Messages aren't passed to OnDeliver.
+func (c *Cluster) NewKVStore(purpose byte, replicas int) (*KVStore, error) {
+	if replicas < 1 {
+		return nil, throwInvalidArgumentError("A key-value store must keep at least one replica of each value.")
+	}
+	store := &KVStore{cluster: c, purpose: purpose, replicas: replicas, kvReplica: &kvReplica{records: map[string]kvRecord{}}}
+	err := c.Handle(purpose, store.onMessage)
+	if err != nil {
+		return nil, err
+	}
+	go store.sweep()
+	return store, nil
+}
+
+// WithConsistency returns a KVStore that reads and writes the same values, waiting for as many of each key's replicas as consistency sets, so the trade between latency and consistency can be made for each operation:
+//
+//	err := store.WithConsistency(Consistency{Write: 1}).Put(ctx, key, value)
+func (s *KVStore) WithConsistency(consistency Consistency) *KVStore {
+	store := *s
+	store.consistency = consistency
+	return &store
+}
+
+// SetMerge sets the MergeFunc that combines values written to the same key concurrently, instead of keeping the latest. Every Node in the Cluster must set the same MergeFunc. It's called while the KVStore is locked, so it mustn't use the KVStore itself. Passing nil keeps the latest value again, which is the default.
+func (s *KVStore) SetMerge(merge MergeFunc) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.merge = merge
+}
+
+func (s *KVStore) getMerge() MergeFunc {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.merge
+}
+
+// Put stores value under key, returning once a majority of the key's replicas have stored it, or as many as set with WithConsistency. If ctx has no deadline, Put waits for up to the network timeout set with SetNetworkTimeout.
+func (s *KVStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.write(ctx, key, nil, kvRecord{Found: true, Version: time.Now().UnixNano(), Value: value})
+}
+
+// PutTTL stores value under key like Put, but the replicas discard it once ttl has passed, unless it's written again first.
+func (s *KVStore) PutTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return throwInvalidArgumentError("A value's TTL must be positive.")
+	}
+	now := time.Now()
+	return s.write(ctx, key, nil, kvRecord{Found: true, Version: now.UnixNano(), Expires: now.Add(ttl).UnixNano(), Value: value})
+}
+
+// Acquire stores value under key and leases the key to the current Node for ttl, returning once a majority of the key's replicas have granted the Lease, or as many as set with WithConsistency. A Lease is only exclusive if its Write count is more than half the replicas. Until the Lease expires or is released, writes to the key without it fail, and Acquire fails for everyone else, so a Lease suits electing a leader or claiming a job. Abandoned Leases expire on their own. If the key is already leased, an error is returned.
+func (s *KVStore) Acquire(ctx context.Context, key string, value []byte, ttl time.Duration) (Lease, error) {
+	if ttl <= 0 {
+		return Lease{}, throwInvalidArgumentError("A lease's TTL must be positive.")
+	}
+	token, err := newMessageID()
+	if err != nil {
+		return Lease{}, err
+	}
+	lease, err := s.lease(ctx, Lease{Key: key, token: token, value: value}, ttl)
+	if err == kvLeasedError {
+		// free the replicas that granted the Lease before the others refused it
+		go s.Release(context.Background(), lease)
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	return lease, nil
+}
+
+// Renew extends a Lease to expire once ttl has passed, returning the renewed Lease. If the Lease expired and the key was leased by someone else in the meantime, an error is returned.
+func (s *KVStore) Renew(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error) {
+	if ttl <= 0 {
+		return Lease{}, throwInvalidArgumentError("A lease's TTL must be positive.")
+	}
+	return s.lease(ctx, lease, ttl)
+}
+
+// Release gives up a Lease, deleting the value stored under its key, so others can acquire it straight away.
+func (s *KVStore) Release(ctx context.Context, lease Lease) error {
+	now := time.Now().UnixNano()
+	return s.write(ctx, lease.Key, lease.token, kvRecord{Found: true, Deleted: true, Version: now, Expires: now, Holder: leaseHolder(lease.token)})
+}
+
+// lease writes the record of a Lease, due to expire once ttl has passed.
+func (s *KVStore) lease(ctx context.Context, lease Lease, ttl time.Duration) (Lease, error) {
+	now := time.Now()
+	lease.Expires = now.Add(ttl)
+	err := s.write(ctx, lease.Key, lease.token, kvRecord{Found: true, Version: now.UnixNano(), Expires: lease.Expires.UnixNano(), Holder: leaseHolder(lease.token), Value: lease.value})
+	return lease, err
+}
+
+// PutVersion stores value under key like Put, but with a VectorClock descending clock, which should be the VectorClock returned by GetVersion. The value replaces the one that was read, and any written before it, instead of being merged with them, while values written concurrently since the read are still merged with it.
+func (s *KVStore) PutVersion(ctx context.Context, key string, value []byte, clock VectorClock) error {
+	return s.write(ctx, key, nil, kvRecord{Found: true, Version: time.Now().UnixNano(), Clock: clock, Value: value})
+}
+
+// Delete removes the value stored under key, returning once a majority of the key's replicas have removed it, or as many as set with WithConsistency, like Put.
+func (s *KVStore) Delete(ctx context.Context, key string) error {
+	return s.write(ctx, key, nil, kvRecord{Found: true, Deleted: true, Version: time.Now().UnixNano()})
+}
+
+// Get returns the value stored under key, and whether there is one, once a majority of the key's replicas have answered, or as many as set with WithConsistency, taking the latest value any of them has. Replicas that answer with an older value are sent the latest one, unless the Consistency's NoReadRepair is set. If ctx has no deadline, Get waits for up to the network timeout set with SetNetworkTimeout.
+func (s *KVStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, _, found, err := s.GetVersion(ctx, key)
+	return value, found, err
+}
+
+// GetVersion returns the value stored under key like Get, along with its VectorClock, which can be passed to PutVersion to replace the value. Values the replicas answer with that were written concurrently are merged with the MergeFunc set with SetMerge, and the VectorClock descends all of them. If there's no value, the VectorClock is still returned if the key was deleted, so writing it again with PutVersion replaces the deletion.
+func (s *KVStore) GetVersion(ctx context.Context, key string) ([]byte, VectorClock, bool, error) {
+	latest, err := s.read(ctx, key)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !latest.Found || latest.Deleted {
+		return nil, latest.Clock, false, nil
+	}
+	return latest.Value, latest.Clock, true, nil
+}
+
+// read asks the replicas of a key for its record, returning the one they resolve to once as many as the Consistency sets have answered, and repairing those that answered with one it descends. Expired records are returned as missing.
+func (s *KVStore) read(ctx context.Context, key string) (kvRecord, error) {
+	replicas, err := s.replicaSet(key)
+	if err != nil {
+		return kvRecord{}, err
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	need := s.consistency.quorum(s.consistency.Read, len(replicas))
+	records := s.ask(ctx, replicas, marshalKVRequest(kvGet, key, nil, kvRecord{}), need, func(kvRecord) bool { return true })
+	merge := s.getMerge()
+	var latest kvRecord
+	answered := 0
+	for _, record := range records {
+		if record == nil {
+			continue
+		}
+		answered++
+		latest, _ = record.resolve(key, latest, merge)
+	}
+	if answered < need {
+		return kvRecord{}, kvQuorumError
+	}
+	for i, record := range records {
+		if record != nil && !record.Clock.Descends(latest.Clock) && !s.consistency.NoReadRepair {
+			go s.repair(replicas[i], key, latest)
+		}
+	}
+	if latest.expired(time.Now()) {
+		return kvRecord{Clock: latest.Clock}, nil
+	}
+	return latest, nil
+}
+
+// write sends a record to every replica of its key, returning once as many as the Consistency sets have stored it. Replicas refuse records sent without token, if the key is held by a Lease, unless it's the Lease's.
+func (s *KVStore) write(ctx context.Context, key string, token []byte, record kvRecord) error {
+	replicas, err := s.replicaSet(key)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	record.Clock = record.Clock.tick(s.cluster.self.ID, record.Version)
+	need := s.consistency.quorum(s.consistency.Write, len(replicas))
+	accepted := func(answer kvRecord) bool {
+		return answer.leasedTo(token)
+	}
+	stored, leased := 0, 0
+	for _, answer := range s.ask(ctx, replicas, marshalKVRequest(kvPut, key, token, record), need, accepted) {
+		switch {
+		case answer == nil:
+		case !answer.leasedTo(token):
+			leased++
+		default:
+			stored++
+		}
+	}
+	if stored < need {
+		if leased > 0 {
+			return kvLeasedError
+		}
+		return kvQuorumError
+	}
+	return nil
+}
+
+func (s *KVStore) replicaSet(key string) ([]Node, error) {
+	if len(key) > 0xffff {
+		return nil, throwInvalidArgumentError("A key-value store's keys must be shorter than 64KiB.")
+	}
+	return s.cluster.ReplicaSet(HashKey(key), s.replicas)
+}
+
+// withTimeout gives ctx the network timeout as a deadline, if it doesn't have one.
+func (s *KVStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(s.cluster.getNetworkTimeout())*time.Second)
+}
+
+// ask sends a request to every replica at once, returning once need of them have given an answer that accept returns true for, or every replica has answered or ctx is done. Each one's answer is returned in the same order as the replicas, or nil for those that didn't answer in time. Requests that are still waiting when ask returns are left to finish on their own. The current Node answers for itself without sending anything.
+func (s *KVStore) ask(ctx context.Context, replicas []Node, request []byte, need int, accept func(kvRecord) bool) []*kvRecord {
+	type answer struct {
+		i      int
+		record *kvRecord
+	}
+	c := s.cluster
+	answers := make(chan answer, len(replicas))
+	for i := range replicas {
+		if replicas[i].ID.Equals(c.self.ID) {
+			local := answer{i: i}
+			record, err := unmarshalKVRecord(s.answer(request))
+			if err == nil {
+				local.record = &record
+			}
+			answers <- local
+			continue
+		}
+		go func(i int) {
+			node := replicas[i]
+			reply, err := c.requestNode(ctx, c.NewMessage(s.purpose, node.ID, request), &node)
+			if err != nil {
+				c.debug("Replica %s didn't answer: %s", node.ID, err)
+				answers <- answer{i: i}
+				return
+			}
+			record, err := unmarshalKVRecord(reply.Value)
+			if err != nil {
+				c.warn("Discarding key-value store answer from %s: %s", node.ID, err)
+				answers <- answer{i: i}
+				return
+			}
+			answers <- answer{i: i, record: &record}
+		}(i)
+	}
+	records := make([]*kvRecord, len(replicas))
+	accepted := 0
+	for range replicas {
+		a := <-answers
+		records[a.i] = a.record
+		if a.record != nil && accept(*a.record) {
+			accepted++
+			if accepted >= need {
+				break
+			}
+		}
+	}
+	return records
+}
+
+// repair sends the latest record for a key to a replica that answered with one it descends.
+func (s *KVStore) repair(replica Node, key string, latest kvRecord) {
+	c := s.cluster
+	c.debug("Repairing key %q on %s", key, replica.ID)
+	ctx, cancel := s.withTimeout(context.Background())
+	defer cancel()
+	s.ask(ctx, []Node{replica}, marshalKVRequest(kvPut, key, nil, latest), 1, func(kvRecord) bool { return true })
+}
+
+// onMessage answers the requests sent to the current Node as a replica.
+func (s *KVStore) onMessage(msg Message) {
+	if len(msg.InReplyTo) > 0 {
+		// an answer whose request was given up on
+		return
+	}
+	answer := s.answer(msg.Value)
+	if answer == nil {
+		return
+	}
+	err := s.cluster.Reply(msg, answer)
+	if err != nil {
+		s.cluster.debug("Couldn't answer key-value store request from %s: %s", msg.Sender.ID, err)
+	}
+}
+
+// answer carries out a request as a replica, returning the encoded record stored under its key afterwards, or nil if the request is malformed.
+func (s *KVStore) answer(request []byte) []byte {
+	op, key, token, record, err := unmarshalKVRequest(request)
+	if err != nil {
+		s.cluster.warn("Discarding key-value store request: %s", err)
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stored := s.records[key]
+	if stored.expired(time.Now()) {
+		delete(s.records, key)
+		stored = kvRecord{}
+	}
+	if op != kvPut || !stored.leasedTo(token) {
+		return stored.marshal()
+	}
+	if resolved, changed := record.resolve(key, stored, s.merge); changed {
+		// the Value is part of the request, which isn't reused
+		s.records[key] = resolved
+		stored = resolved
+	}
+	return stored.marshal()
+}
+
+// Scan returns up to limit of the values the current Node stores as a replica, in order of key, starting after the key cursor, and the cursor to continue from with the next call, which is empty once every value has been returned. Passing an empty cursor starts from the first key. Deleted and expired values are skipped. Each value is marked with whether the current Node owns its key, and whether it's still one of the key's replicas, so Scan suits backing values up, and moving them to the replicas that should have them.
+//
+// Only the keys are sorted, and only values stored when a page is read are returned, so values written during a scan may or may not be included. An InvalidArgumentError is returned if limit is less than 1.
+func (s *KVStore) Scan(cursor string, limit int) ([]KVEntry, string, error) {
+	if limit < 1 {
+		return nil, "", throwInvalidArgumentError("A scan must return at least one value at a time.")
+	}
+	now := time.Now()
+	s.lock.RLock()
+	keys := make([]string, 0, len(s.records))
+	for key, record := range s.records {
+		if cursor != "" && key <= cursor {
+			continue
+		}
+		if record.Found && !record.Deleted && !record.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	entries := make([]KVEntry, 0, len(keys))
+	for _, key := range keys {
+		record := s.records[key]
+		entry := KVEntry{Key: key, Value: record.Value, Clock: record.Clock}
+		if record.Expires != 0 {
+			entry.Expires = time.Unix(0, record.Expires)
+		}
+		entries = append(entries, entry)
+	}
+	s.lock.RUnlock()
+	for i := range entries {
+		replicas, err := s.replicaSet(entries[i].Key)
+		if err != nil {
+			return nil, "", err
+		}
+		for j, replica := range replicas {
+			if replica.ID.Equals(s.cluster.self.ID) {
+				entries[i].Owner = j == 0
+				entries[i].Replica = true
+			}
+		}
+	}
+	if len(entries) < limit {
+		return entries, "", nil
+	}
+	return entries, entries[len(entries)-1].Key, nil
+}
+
+// sweep discards the expired records every kvSweepInterval, until the Cluster is killed.
+func (s *KVStore) sweep() {
+	ticker := time.NewTicker(kvSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.cluster.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
+
+// expire discards the records that have expired by now, returning how many there were.
+func (s *KVStore) expire(now time.Time) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	expired := 0
+	for key, record := range s.records {
+		if record.expired(now) {
+			delete(s.records, key)
+			expired++
+		}
+	}
+	if expired > 0 {
+		s.cluster.debug("Discarded %d expired key-value store records", expired)
+	}
+	return expired
+}
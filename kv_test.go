@@ -0,0 +1,421 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that the latest record wins, and deleting wins a tie
+func TestKVRecordNewer(t *testing.T) {
+	missing := kvRecord{}
+	old := kvRecord{Found: true, Version: 1, Value: []byte("old")}
+	latest := kvRecord{Found: true, Version: 2, Value: []byte("latest")}
+	deleted := kvRecord{Found: true, Deleted: true, Version: 2}
+	cases := []struct {
+		a, b  kvRecord
+		newer bool
+	}{
+		{old, missing, true},
+		{missing, old, false},
+		{latest, old, true},
+		{old, latest, false},
+		{deleted, latest, true},
+		{latest, deleted, false},
+		{latest, latest, false},
+	}
+	for i, c := range cases {
+		if c.a.newer(c.b) != c.newer {
+			t.Errorf("Case %d: expected newer to be %v for %+v and %+v.", i, c.newer, c.a, c.b)
+		}
+	}
+	op, key, token, record, err := unmarshalKVRequest(marshalKVRequest(kvPut, "key", []byte("token"), deleted))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if op != kvPut || key != "key" || string(token) != "token" || !record.Found || !record.Deleted || record.Version != 2 {
+		t.Errorf("Expected the request to survive a round trip, got %d, %q, %q, %+v.", op, key, token, record)
+	}
+	_, _, _, _, err = unmarshalKVRequest([]byte{kvGet, 0, 10, 'k'})
+	if err != kvMessageError {
+		t.Errorf("Expected %v for a truncated request, got %v.", kvMessageError, err)
+	}
+}
+
+// Test that a Node on its own stores every value itself
+func TestKVStoreLocal(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewKVStore(FirstUserPurpose, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := context.Background()
+	_, found, err := store.Get(ctx, "missing")
+	if err != nil || found {
+		t.Errorf("Expected no value for a key that was never stored, got %v, %v.", found, err)
+	}
+	err = store.Put(ctx, "key", []byte("value"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, found, err := store.Get(ctx, "key")
+	if err != nil || !found || string(value) != "value" {
+		t.Errorf("Expected value, got %s, %v, %v.", value, found, err)
+	}
+	err = store.Delete(ctx, "key")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, found, err = store.Get(ctx, "key")
+	if err != nil || found {
+		t.Errorf("Expected no value once it was deleted, got %v, %v.", found, err)
+	}
+	_, err = cluster.NewKVStore(FirstUserPurpose, 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a store without replicas, got %v.", err)
+	}
+}
+
+// Test that answers that arrive after their request was given up on, and malformed requests, aren't answered
+func TestKVStoreLateReply(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport := &countingTransport{}
+	one.SetTransport(transport)
+	_, err = one.NewKVStore(FirstUserPurpose, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	late := two.NewMessage(FirstUserPurpose, one.self.ID, kvRecord{Found: true, Value: []byte("value")}.marshal())
+	late.Direct = true
+	late.InReplyTo = []byte("given up on")
+	one.deliver(late)
+	one.deliver(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("malformed")))
+	time.Sleep(50 * time.Millisecond)
+	if dials, _ := transport.counts(); dials != 0 {
+		t.Errorf("Expected nothing to be sent back, got %d connections.", dials)
+	}
+}
+
+// Test that values are stored on their replicas, read from any Node, and repaired when a replica misses them
+func TestClusterKVStore(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	clusters := []*Cluster{}
+	stores := map[NodeID]*KVStore{}
+	for _, seed := range []string{"this is a test Node for testing purposes only.", "this is some other Node for testing purposes only.", "this is a third Node for testing purposes only."} {
+		cluster, err := makeCluster(seed)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		store, err := cluster.NewKVStore(FirstUserPurpose, 2)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		go cluster.Listen()
+		defer cluster.Kill()
+		clusters = append(clusters, cluster)
+		stores[cluster.self.ID] = store
+	}
+	waitListening(t, clusters...)
+	for _, cluster := range clusters {
+		for _, other := range clusters {
+			if other != cluster {
+				err := cluster.insert(*other.self, StateMask{Mask: all})
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := stores[clusters[0].self.ID].Put(ctx, "key", []byte("value"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	replicas, err := clusters[0].ReplicaSet(HashKey("key"), 2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, replica := range replicas {
+		store := stores[replica.ID]
+		store.lock.RLock()
+		record := store.records["key"]
+		store.lock.RUnlock()
+		if string(record.Value) != "value" {
+			t.Errorf("Expected replica %s to store the value, got %+v.", replica.ID, record)
+		}
+	}
+	// one replica loses the value, and is repaired by the next read
+	missed := stores[replicas[0].ID]
+	missed.lock.Lock()
+	delete(missed.records, "key")
+	missed.lock.Unlock()
+	for _, cluster := range clusters {
+		value, found, err := stores[cluster.self.ID].Get(ctx, "key")
+		if err != nil || !found || string(value) != "value" {
+			t.Errorf("Expected %s to read the value, got %s, %v, %v.", cluster.self.ID, value, found, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	missed.lock.RLock()
+	record := missed.records["key"]
+	missed.lock.RUnlock()
+	if string(record.Value) != "value" {
+		t.Errorf("Expected the replica that lost the value to be repaired, got %+v.", record)
+	}
+	// a read from one replica, without repair, leaves a replica that lost the value alone
+	missed.lock.Lock()
+	delete(missed.records, "key")
+	missed.lock.Unlock()
+	one := stores[replicas[1].ID].WithConsistency(Consistency{Read: 1, NoReadRepair: true})
+	value, found, err := one.Get(ctx, "key")
+	if err != nil || !found || string(value) != "value" {
+		t.Errorf("Expected a replica to read the value on its own, got %s, %v, %v.", value, found, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	missed.lock.RLock()
+	_, repaired := missed.records["key"]
+	missed.lock.RUnlock()
+	if repaired {
+		t.Errorf("Expected the replica that lost the value not to be repaired.")
+	}
+	err = stores[clusters[2].self.ID].Delete(ctx, "key")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, found, err = stores[clusters[1].self.ID].Get(ctx, "key")
+	if err != nil || found {
+		t.Errorf("Expected no value once it was deleted, got %v, %v.", found, err)
+	}
+	for _, cluster := range clusters {
+		cluster.lock.RLock()
+		remembered := len(cluster.delivered)
+		cluster.lock.RUnlock()
+		if remembered > 0 {
+			t.Errorf("Expected %s not to remember requests for the dedup window, remembered %d.", cluster.self.ID, remembered)
+		}
+	}
+}
+
+// Test that values with a TTL expire, and that leased keys can only be written with their Lease until it's released
+func TestKVStoreLeases(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewKVStore(FirstUserPurpose, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := context.Background()
+	err = store.PutTTL(ctx, "ttl", []byte("value"), 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, found, err := store.Get(ctx, "ttl")
+	if err != nil || !found {
+		t.Errorf("Expected the value before its TTL passed, got %v, %v.", found, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	_, found, err = store.Get(ctx, "ttl")
+	if err != nil || found {
+		t.Errorf("Expected no value once its TTL passed, got %v, %v.", found, err)
+	}
+	err = store.PutTTL(ctx, "swept", []byte("value"), time.Millisecond)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if expired := store.expire(time.Now().Add(time.Second)); expired != 1 {
+		t.Errorf("Expected 1 record to be swept, got %d.", expired)
+	}
+	err = store.PutTTL(ctx, "ttl", nil, 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a TTL of 0, got %v.", err)
+	}
+
+	lease, err := store.Acquire(ctx, "leader", []byte("me"), time.Minute)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, found, err := store.Get(ctx, "leader")
+	if err != nil || !found || string(value) != "me" {
+		t.Errorf("Expected the leased value, got %s, %v, %v.", value, found, err)
+	}
+	_, err = store.Acquire(ctx, "leader", []byte("you"), time.Minute)
+	if err != kvLeasedError {
+		t.Errorf("Expected %v acquiring a leased key, got %v.", kvLeasedError, err)
+	}
+	err = store.Put(ctx, "leader", []byte("you"))
+	if err != kvLeasedError {
+		t.Errorf("Expected %v writing a leased key, got %v.", kvLeasedError, err)
+	}
+	// answers carry the hash of the Lease's token, which can't be used in its place
+	answer, err := unmarshalKVRecord(store.answer(marshalKVRequest(kvGet, "leader", nil, kvRecord{})))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(answer.Holder) == 0 || string(answer.Holder) == string(lease.token) {
+		t.Errorf("Expected the answer to carry the hash of the lease's token, got %x.", answer.Holder)
+	}
+	err = store.Release(ctx, Lease{Key: "leader", token: answer.Holder})
+	if err != kvLeasedError {
+		t.Errorf("Expected %v releasing a lease with the Holder from an answer, got %v.", kvLeasedError, err)
+	}
+	renewed, err := store.Renew(ctx, lease, time.Hour)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !renewed.Expires.After(lease.Expires) {
+		t.Errorf("Expected the renewed lease to expire after %v, got %v.", lease.Expires, renewed.Expires)
+	}
+	err = store.Release(ctx, renewed)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, found, err = store.Get(ctx, "leader")
+	if err != nil || found {
+		t.Errorf("Expected no value once the lease was released, got %v, %v.", found, err)
+	}
+	_, err = store.Acquire(ctx, "leader", []byte("you"), 10*time.Millisecond)
+	if err != nil {
+		t.Errorf("Expected to acquire a released key, got %v.", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err = store.Acquire(ctx, "leader", []byte("me"), time.Minute)
+	if err != nil {
+		t.Errorf("Expected to acquire a key whose lease expired, got %v.", err)
+	}
+}
+
+// Test that a Consistency waits for as many replicas as it sets, or all of them if it sets more
+func TestKVConsistencyQuorum(t *testing.T) {
+	cases := []struct {
+		count, replicas, quorum int
+	}{
+		{0, 1, 1},
+		{0, 3, 2},
+		{0, 4, 3},
+		{1, 3, 1},
+		{3, 3, 3},
+		{5, 3, 3},
+	}
+	for i, c := range cases {
+		if quorum := (Consistency{}).quorum(c.count, c.replicas); quorum != c.quorum {
+			t.Errorf("Case %d: expected a quorum of %d for %d of %d replicas, got %d.", i, c.quorum, c.count, c.replicas, quorum)
+		}
+	}
+}
+
+// Test that a record replaces those it descends, and that concurrent records are merged
+func TestKVStoreMerge(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewKVStore(FirstUserPurpose, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store.SetMerge(func(key string, older, newer []byte) []byte {
+		return append(append([]byte{}, older...), newer...)
+	})
+	ctx := context.Background()
+	// two Nodes write the key without knowing of each other's writes
+	a, b := NodeID{0, 1}, NodeID{0, 2}
+	store.answer(marshalKVRequest(kvPut, "key", nil, kvRecord{Found: true, Version: 1, Clock: VectorClock{a: 1}, Value: []byte("a")}))
+	store.answer(marshalKVRequest(kvPut, "key", nil, kvRecord{Found: true, Version: 2, Clock: VectorClock{b: 1}, Value: []byte("b")}))
+	value, clock, found, err := store.GetVersion(ctx, "key")
+	if err != nil || !found || string(value) != "ab" {
+		t.Errorf("Expected the concurrent values to be merged, got %s, %v, %v.", value, found, err)
+	}
+	if !clock.Descends(VectorClock{a: 1, b: 1}) {
+		t.Errorf("Expected the merged clock to descend both writes, got %v.", clock)
+	}
+	// a write based on the merged value replaces it
+	err = store.PutVersion(ctx, "key", []byte("c"), clock)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	value, found, err = store.Get(ctx, "key")
+	if err != nil || !found || string(value) != "c" {
+		t.Errorf("Expected the value written with the clock read to replace it, got %s, %v, %v.", value, found, err)
+	}
+	// a write the stored value descends is ignored
+	store.answer(marshalKVRequest(kvPut, "key", nil, kvRecord{Found: true, Version: 3, Clock: VectorClock{a: 1}, Value: []byte("stale")}))
+	value, _, _ = store.Get(ctx, "key")
+	if string(value) != "c" {
+		t.Errorf("Expected a stale write to be ignored, got %s.", value)
+	}
+	// without a MergeFunc, the latest concurrent write wins
+	store.SetMerge(nil)
+	store.answer(marshalKVRequest(kvPut, "key", nil, kvRecord{Found: true, Version: time.Now().Add(time.Hour).UnixNano(), Clock: VectorClock{NodeID{0, 3}: 1}, Value: []byte("d")}))
+	value, _, _ = store.Get(ctx, "key")
+	if string(value) != "d" {
+		t.Errorf("Expected the latest concurrent write to win, got %s.", value)
+	}
+}
+
+// Test that Scan pages through the values stored locally, in order of key, skipping those that are deleted or expired
+func TestKVStoreScan(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewKVStore(FirstUserPurpose, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := context.Background()
+	for _, key := range []string{"e", "b", "d", "a", "c"} {
+		err = store.Put(ctx, key, []byte(key))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	err = store.Delete(ctx, "c")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = store.PutTTL(ctx, "f", []byte("f"), time.Millisecond)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(5 * time.Millisecond)
+	keys := ""
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("Expected to finish scanning within 3 pages, got to cursor %q.", cursor)
+		}
+		var entries []KVEntry
+		entries, cursor, err = store.Scan(cursor, 2)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		for _, entry := range entries {
+			keys += entry.Key
+			if string(entry.Value) != entry.Key || !entry.Owner || !entry.Replica || len(entry.Clock) != 1 {
+				t.Errorf("Expected %s to be owned and replicated with its value and clock, got %+v.", entry.Key, entry)
+			}
+		}
+	}
+	if keys != "abde" {
+		t.Errorf("Expected to scan abde, got %s.", keys)
+	}
+	_, _, err = store.Scan("", 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a limit of 0, got %v.", err)
+	}
+}
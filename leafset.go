@@ -8,16 +8,20 @@ import (
 )
 
 type leafSet struct {
-	self     *Node
-	left     [16]*Node
-	right    [16]*Node
-	log      *log.Logger
-	logLevel int
-	lock     *sync.RWMutex
+	self        *Node
+	left        [16]*Node
+	right       [16]*Node
+	predecessor *Node  // the closest Node below self on the ring, or nil if the leaf set is empty
+	successor   *Node  // the closest Node above self on the ring, or nil if the leaf set is empty
+	low         NodeID // the first key self is closest to
+	high        NodeID // the last key self is closest to
+	log         *log.Logger
+	logLevel    int
+	lock        *sync.RWMutex
 }
 
 func newLeafSet(self *Node) *leafSet {
-	return &leafSet{
+	l := &leafSet{
 		self:     self,
 		left:     [16]*Node{},
 		right:    [16]*Node{},
@@ -25,43 +29,76 @@ func newLeafSet(self *Node) *leafSet {
 		logLevel: LogLevelWarn,
 		lock:     new(sync.RWMutex),
 	}
+	l.updateRange()
+	return l
 }
 
 var lsDuplicateInsertError = errors.New("Node already exists in leaf set.")
 
 func (l *leafSet) insertNode(node Node) (*Node, error) {
-	return l.insertValues(node.ID, node.LocalIP, node.GlobalIP, node.Region, node.Port, node.routingTableVersion, node.leafsetVersion, node.neighborhoodSetVersion)
+	return l.insert(node.clone())
 }
 
 func (l *leafSet) insertValues(id NodeID, localIP, globalIP, region string, port int, rTVersion, lSVersion, nSVersion uint64) (*Node, error) {
-	l.lock.Lock()
-	defer l.lock.Unlock()
 	node := NewNode(id, localIP, globalIP, region, port)
 	node.updateVersions(rTVersion, lSVersion, nSVersion)
+	return l.insert(node)
+}
+
+func (l *leafSet) insert(node *Node) (*Node, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	node, err := l.add(node)
+	if node != nil {
+		l.self.incrementLSVersion()
+		node.tableVersion = l.self.leafsetVersion
+		l.updateRange()
+	}
+	return node, err
+}
+
+// insertBatch inserts each of the Nodes, taking the lock and incrementing the version of the leaf set only once. It returns the Nodes that were inserted, and the first error other than a duplicate insert.
+func (l *leafSet) insertBatch(nodes []*Node) ([]*Node, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	inserted := []*Node{}
+	var first error
+	for _, node := range nodes {
+		node, err := l.add(node)
+		if err != nil && err != lsDuplicateInsertError && first == nil {
+			first = err
+		}
+		if node != nil {
+			inserted = append(inserted, node)
+		}
+	}
+	if len(inserted) > 0 {
+		l.self.incrementLSVersion()
+		for _, node := range inserted {
+			node.tableVersion = l.self.leafsetVersion
+		}
+		l.updateRange()
+	}
+	return inserted, first
+}
+
+// add puts the Node into the leaf set, returning it if it was inserted. The caller must hold l.lock, and update the version of the leaf set.
+func (l *leafSet) add(node *Node) (*Node, error) {
 	side := l.self.ID.RelPos(node.ID)
 	var inserted, contained bool
 	if side == -1 {
 		l.left, contained, inserted = node.insertIntoArray(l.left, l.self)
-		if !contained {
-			return nil, nil
-		} else if !inserted {
-			return nil, lsDuplicateInsertError
-		} else {
-			l.self.incrementLSVersion()
-			return node, nil
-		}
 	} else if side == 1 {
 		l.right, contained, inserted = node.insertIntoArray(l.right, l.self)
-		if !contained {
-			return nil, nil
-		} else if !inserted {
-			return nil, lsDuplicateInsertError
-		} else {
-			l.self.incrementLSVersion()
-			return node, nil
-		}
+	} else {
+		return nil, throwIdentityError("insert", "into", "leaf set")
 	}
-	return nil, throwIdentityError("insert", "into", "leaf set")
+	if !contained {
+		return nil, nil
+	} else if !inserted {
+		return nil, lsDuplicateInsertError
+	}
+	return node, nil
 }
 
 func (l *leafSet) getNode(id NodeID) (*Node, error) {
@@ -139,7 +176,7 @@ func (l *leafSet) route(key NodeID) (*Node, error) {
 	l.lock.RLock()
 	defer l.lock.RUnlock()
 	side := l.self.ID.RelPos(key)
-	best_score := l.self.ID.Diff(key)
+	best_score := l.self.ID.distance(key)
 	best := l.self
 	biggest := l.self.ID
 	if side == -1 {
@@ -147,8 +184,8 @@ func (l *leafSet) route(key NodeID) (*Node, error) {
 			if node == nil {
 				break
 			}
-			diff := key.Diff(node.ID)
-			if diff.Cmp(best_score) == -1 || (diff.Cmp(best_score) == 0 && node.ID.Less(best.ID)) {
+			diff := key.distance(node.ID)
+			if cmp := diff.absCmp(best_score); cmp == -1 || (cmp == 0 && node.ID.Less(best.ID)) {
 				best = node
 				best_score = diff
 			}
@@ -159,8 +196,8 @@ func (l *leafSet) route(key NodeID) (*Node, error) {
 			if node == nil {
 				break
 			}
-			diff := key.Diff(node.ID)
-			if diff.Cmp(best_score) == -1 || (diff.Cmp(best_score) == 0 && node.ID.Less(best.ID)) {
+			diff := key.distance(node.ID)
+			if cmp := diff.absCmp(best_score); cmp == -1 || (cmp == 0 && node.ID.Less(best.ID)) {
 				best = node
 				best_score = diff
 			}
@@ -224,7 +261,7 @@ func (node *Node) insertIntoArray(array [16]*Node, center *Node) ([16]*Node, boo
 			src_index += 1
 			continue
 		}
-		if center.ID.Diff(node.ID).Cmp(center.ID.Diff(result[result_index].ID)) < 0 && pos < 0 {
+		if center.ID.distance(node.ID).absLess(center.ID.distance(result[result_index].ID)) && pos < 0 {
 			result[result_index] = node
 			pos = result_index
 			inserted = true
@@ -302,9 +339,36 @@ func (l *leafSet) removeNode(id NodeID) (*Node, error) {
 		}
 	}
 	l.self.incrementLSVersion()
+	l.updateRange()
 	return n, nil
 }
 
+// updateRange finds the Nodes on either side of self on the ring, and the range of keys self is closest to. Nodes above self are kept on the left, and Nodes below it on the right. When one side of the leaf set is empty, every Node in the Cluster is in the other side, so the furthest Node in it wraps around to be the closest on the empty side. The caller must hold l.lock.
+func (l *leafSet) updateRange() {
+	l.predecessor, l.successor = l.right[0], l.left[0]
+	for i := len(l.left) - 1; l.predecessor == nil && i >= 0; i-- {
+		l.predecessor = l.left[i]
+	}
+	for i := len(l.right) - 1; l.successor == nil && i >= 0; i-- {
+		l.successor = l.right[i]
+	}
+	if l.predecessor == nil {
+		// a Node on its own is closest to every key
+		l.low, l.high = l.self.ID.add(NodeID{0, 1}), l.self.ID
+		return
+	}
+	// keys past the midpoint from a neighbour are closer to self than to the neighbour
+	l.low = l.predecessor.ID.midpoint(l.self.ID).add(NodeID{0, 1})
+	l.high = l.self.ID.midpoint(l.successor.ID)
+}
+
+// neighbours returns the Nodes on either side of self on the ring, and the range of keys self is closest to.
+func (l *leafSet) neighbours() (predecessor, successor *Node, low, high NodeID) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.predecessor, l.successor, l.low, l.high
+}
+
 func (l *leafSet) debug(format string, v ...interface{}) {
 	if l.logLevel <= LogLevelDebug {
 		l.log.Printf(format, v...)
@@ -0,0 +1,112 @@
+package wendy
+
+import (
+	"sync/atomic"
+)
+
+// reconcileLeaves sends the leaf set to the Node's immediate neighbours, the closest Node on each side, which compare it with their own. Neighbours' leaf sets overlap almost entirely, so any Node one of them has that the other should, but doesn't, shows their views of the Cluster have diverged. Each neighbour inserts the Nodes it was missing, and replies with the Nodes the current Node was missing.
+func (c *Cluster) reconcileLeaves() {
+	leaves := c.leafset.export()
+	data, err := c.marshal(stateTables{LeafSet: &leaves})
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	msg := c.NewMessage(NODE_LEAVES, c.self.ID, data)
+	for _, neighbour := range []*Node{leaves[0][0], leaves[1][0]} {
+		if neighbour == nil {
+			continue
+		}
+		c.debug("Reconciling leaf set with %s", neighbour.ID)
+		err = c.send(msg, neighbour)
+		if err == deadNodeError {
+			err = c.suspect(neighbour)
+		}
+		if err != nil {
+			c.fanOutError(err)
+		}
+	}
+}
+
+// An immediate neighbour has sent us its leaf set. We need to insert the Nodes it knows of that belong in our leaf set, and send it the Nodes we know of that belong in its.
+func (c *Cluster) onLeafReconcile(msg Message) {
+	var state stateTables
+	err := c.unmarshal(msg.Value, &state)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	theirs := []*Node{}
+	if state.LeafSet != nil {
+		for _, side := range state.LeafSet {
+			for _, node := range side {
+				if node != nil {
+					theirs = append(theirs, node)
+				}
+			}
+		}
+	}
+	ours := c.leafset.list()
+	missingThere := missingLeaves(msg.Sender, theirs, ours)
+	missingHere := missingLeaves(*c.self, ours, append(theirs, &msg.Sender))
+	if len(missingThere) == 0 && len(missingHere) == 0 {
+		c.debug("Leaf set agrees with %s.", msg.Sender.ID)
+		return
+	}
+	c.debug("Leaf set disagrees with %s: %d missing here, %d missing there.", msg.Sender.ID, len(missingHere), len(missingThere))
+	atomic.AddUint64(&c.stats.LeafSetRepairs, uint64(len(missingHere)+len(missingThere)))
+	// the sender just contacted us, so it's alive even if we removed it recently
+	c.release(msg.Sender.ID)
+	updates := []stateUpdate{}
+	for _, node := range missingHere {
+		updates = append(updates, stateUpdate{*node, StateMask{Mask: lS | nS}})
+	}
+	err = c.insertBatch(updates)
+	if err != nil {
+		c.fanOutError(err)
+	}
+	if len(missingThere) == 0 {
+		return
+	}
+	var leaves [2][16]*Node
+	counts := [2]int{}
+	for _, node := range missingThere {
+		side := 0
+		if msg.Sender.ID.RelPos(node.ID) == 1 {
+			side = 1
+		}
+		leaves[side][counts[side]] = node
+		counts[side]++
+	}
+	data, err := c.marshal(stateTables{LeafSet: &leaves})
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	err = c.send(c.NewMessage(STAT_DATA, c.self.ID, data), &msg.Sender)
+	if err != nil && err != deadNodeError {
+		c.fanOutError(err)
+	}
+}
+
+// missingLeaves returns the Nodes from candidates that belong in the leaf set of center, whose leaf set holds leaves, but aren't in it.
+func missingLeaves(center Node, leaves, candidates []*Node) []*Node {
+	set := newLeafSet(center.clone())
+	known := map[NodeID]bool{center.ID: true}
+	for _, node := range leaves {
+		known[node.ID] = true
+		set.insertNode(*node)
+	}
+	for _, node := range candidates {
+		if node != nil && !known[node.ID] {
+			set.insertNode(*node)
+		}
+	}
+	missing := []*Node{}
+	for _, node := range set.list() {
+		if !known[node.ID] {
+			missing = append(missing, node)
+		}
+	}
+	return missing
+}
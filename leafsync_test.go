@@ -0,0 +1,73 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that immediate neighbours reconciling their leaf sets each gain the Nodes the other knew of
+func TestClusterReconcileLeaves(t *testing.T) {
+	clusters := []*Cluster{}
+	for _, id := range []string{
+		"this is a test Node for testing purposes only.",
+		"this is some other Node for testing purposes only.",
+		"this is Node three, for testing purposes only.",
+		"this is Node four, for testing purposes only.",
+	} {
+		cluster, err := makeCluster(id)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		go cluster.Listen()
+		defer cluster.Kill()
+		clusters = append(clusters, cluster)
+	}
+	one, two, three, four := clusters[0], clusters[1], clusters[2], clusters[3]
+	waitListening(t, clusters...)
+	for _, node := range []*Node{two.self, four.self} {
+		_, err := one.leafset.insertNode(*node)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for _, node := range []*Node{one.self, three.self} {
+		_, err := two.leafset.insertNode(*node)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if neighbours := one.leafset.export(); (neighbours[0][0] == nil || !neighbours[0][0].ID.Equals(two.self.ID)) && (neighbours[1][0] == nil || !neighbours[1][0].ID.Equals(two.self.ID)) {
+		t.Fatalf("Expected %s to be an immediate neighbour of %s.", two.self.ID, one.self.ID)
+	}
+	one.reconcileLeaves()
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, errOne := one.leafset.getNode(three.self.ID)
+		_, errTwo := two.leafset.getNode(four.self.ID)
+		if errOne == nil && errTwo == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the leaf sets to be reconciled, got %v and %v.", errOne, errTwo)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := two.Stats(); stats.LeafSetRepairs != 2 {
+		t.Errorf("Expected 2 leaf set repairs, got %d.", stats.LeafSetRepairs)
+	}
+}
+
+// Test that only the Nodes that belong in a leaf set are reported missing from it
+func TestMissingLeaves(t *testing.T) {
+	center := NewNode(NodeID{0x8000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555)
+	leaves := []*Node{}
+	for i := uint64(1); i <= 16; i++ {
+		leaves = append(leaves, NewNode(NodeID{0x8000000000000000 + i*0x100, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555))
+	}
+	near := NewNode(NodeID{0x8000000000000080, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555)
+	far := NewNode(NodeID{0x9000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555)
+	missing := missingLeaves(*center, leaves, append([]*Node{near, far, center}, leaves...))
+	if len(missing) != 1 || !missing[0].ID.Equals(near.ID) {
+		t.Errorf("Expected only %s to be missing, got %v.", near.ID, missing)
+	}
+}
@@ -0,0 +1,64 @@
+package wendy
+
+// SetLoad sets the Load the current Node advertises, as a percentage of its capacity between 0 and 100; values outside that range are clamped to it. The Load is sent to other Nodes with every Message, including heartbeats, and is kept in their state tables alongside the Node, so they can route around it when it is busy. Virtual Nodes added to the Cluster advertise the same Load.
+func (c *Cluster) SetLoad(load int) {
+	if load < 0 {
+		load = 0
+	}
+	if load > 100 {
+		load = 100
+	}
+	c.self.setLoad(load)
+	for _, v := range c.getVirtualNodes() {
+		v.self.setLoad(load)
+	}
+}
+
+// Load returns the Load the current Node advertises.
+func (c *Cluster) Load() int {
+	return c.self.getLoad()
+}
+
+// SetMaxLoad sets the Load above which Nodes are avoided when routing Messages. When the routing table's choice for the next hop advertises a higher Load, any other Node in the state tables that is at least as close to the Message's key is considered, and whichever advertises the lowest Load is used instead, as long as its Reputation isn't below the minimum set with SetMinReputation. Like SetMinReputation, Messages are never routed away from the leaf set Node closest to their key. A value of 0, the default, routes without regard to Load.
+func (c *Cluster) SetMaxLoad(load int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if load < 0 {
+		load = 0
+	}
+	c.maxLoad = load
+}
+
+func (c *Cluster) getMaxLoad() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maxLoad
+}
+
+// lightHop returns target, unless it advertises a Load above the maximum set with SetMaxLoad, in which case it returns the Node that advertises the lowest Load out of the alternate hops with a good enough Reputation.
+func (c *Cluster) lightHop(key NodeID, target *Node) *Node {
+	max := c.getMaxLoad()
+	if max <= 0 {
+		return target
+	}
+	best := target
+	bestLoad := target.getLoad()
+	if bestLoad <= max {
+		return target
+	}
+	min := c.getMinReputation()
+	for _, node := range c.alternateHops(key, target) {
+		if min > 0 && c.Reputation(node.ID).Score < min {
+			continue
+		}
+		load := node.getLoad()
+		if load < bestLoad {
+			best = node
+			bestLoad = load
+		}
+	}
+	if best != target {
+		c.debug("Routing %s through %s instead of %s, which is overloaded.", key, best.ID, target.ID)
+	}
+	return best
+}
@@ -0,0 +1,122 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that the Load a Node advertises is clamped, shared with its virtual Nodes, and sent with its Messages
+func TestClusterSetLoad(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is a virtual Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	virtual, err := cluster.AddVirtualNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetLoad(150)
+	if cluster.Load() != 100 {
+		t.Errorf("Expected a Load of 100, got %d.", cluster.Load())
+	}
+	cluster.SetLoad(-1)
+	if cluster.Load() != 0 {
+		t.Errorf("Expected a Load of 0, got %d.", cluster.Load())
+	}
+	cluster.SetLoad(40)
+	if virtual.Load() != 40 {
+		t.Errorf("Expected the virtual Node to advertise a Load of 40, got %d.", virtual.Load())
+	}
+	msg := cluster.NewMessage(HEARTBEAT, cluster.self.ID, []byte{})
+	if msg.Sender.Load != 40 {
+		t.Errorf("Expected the Message to advertise a Load of 40, got %d.", msg.Sender.Load)
+	}
+}
+
+// Test that Messages are routed around overloaded Nodes when another Node makes as much progress
+func TestClusterRouteLoad(t *testing.T) {
+	self := NewNode(NodeID{0x1000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(self, nil)
+	key := NodeID{0x1fff000000000000, 0}
+	target := NewNode(NodeID{0x1f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	target.Load = 90
+	other := NewNode(NodeID{0x1e00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 2)
+	other.Load = 50
+	// shares a shorter prefix with the key than self, so not a valid next hop
+	behind := NewNode(NodeID{0x0f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 3)
+	for _, node := range []*Node{target, other, behind} {
+		_, err := cluster.table.insertNode(*node, 1)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	next, err := cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(target.ID) {
+		t.Errorf("Expected %s to be routed through %s without a maximum load, got %s.", key, target.ID, next.ID)
+	}
+	cluster.SetMaxLoad(80)
+	next, err = cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(other.ID) {
+		t.Errorf("Expected %s to be routed through %s, got %s.", key, other.ID, next.ID)
+	}
+	cluster.SetMinReputation(0.5)
+	cluster.recordSend(other.ID, deadNodeError)
+	next, err = cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(target.ID) {
+		t.Errorf("Expected %s not to be routed through %s, which has a low reputation, got %s.", key, other.ID, next.ID)
+	}
+}
+
+// Test that the Load another Node advertises is updated in the state tables when it sends a heartbeat
+func TestClusterLoadHeartbeat(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	err = one.insert(*two.self.clone(), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self.clone(), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetLoad(60)
+	two.sendHeartbeats()
+	node, err := one.get(two.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(time.Second)
+	for node.getLoad() != 60 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to advertise a Load of 60, got %d.", two.self.ID, node.getLoad())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,86 @@
+package wendy
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaultMaintenanceFrequency is how often the routing table is refreshed, unless SetMaintenanceFrequency is called.
+const defaultMaintenanceFrequency = 20 * time.Minute
+
+// SetMaintenanceFrequency sets how often the Cluster refreshes its routing table while it's listening. Entries are only replaced when Nodes are found to be dead, so without maintenance, rows left sparse by Nodes that were removed, or that were never filled when the Node joined, stay that way until something else fills them, and routing takes more hops than it needs to. Each round, every row with a gap is filled by asking a Node that shares at least as long a prefix with the current Node for its entries for the missing columns. A value of 0 disables maintenance; by default, the routing table is refreshed every 20 minutes.
+func (c *Cluster) SetMaintenanceFrequency(freq time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if freq < 0 {
+		freq = 0
+	}
+	c.maintainFrequency = freq
+}
+
+func (c *Cluster) getMaintenanceFrequency() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maintainFrequency
+}
+
+// maintenanceTimer returns a channel that fires when the routing table is next due for maintenance, or nil if maintenance is disabled.
+func (c *Cluster) maintenanceTimer() <-chan time.Time {
+	freq := c.getMaintenanceFrequency()
+	if freq <= 0 {
+		return nil
+	}
+	return time.After(freq)
+}
+
+// maintainTable runs a round of routing table maintenance. Rows deeper than the deepest one with an entry are left alone, as there are usually too few Nodes in the Cluster to fill them. For each other row with missing columns, a Node is chosen at random from that row, or from a deeper row if it's empty, and asked for its entries for the missing columns. Any Node in those rows shares the row's prefix with the current Node, so its entries fit the current Node's table too. The entries it sends back are inserted like any other state tables.
+func (c *Cluster) maintainTable() {
+	rows := c.table.export([]int{}, []int{})
+	deepest := -1
+	for row := range rows {
+		for _, node := range rows[row] {
+			if node != nil {
+				deepest = row
+				break
+			}
+		}
+	}
+	if deepest < 0 {
+		c.debug("No Nodes in the routing table to maintain it with.")
+		return
+	}
+	for row := 0; row <= deepest; row++ {
+		self := c.table.col(c.self.ID, row)
+		missing := []int{}
+		for col, node := range rows[row] {
+			if node == nil && col != self {
+				missing = append(missing, col)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		peers := []*Node{}
+		for source := row; len(peers) == 0 && source <= deepest; source++ {
+			for _, node := range rows[source] {
+				if node != nil {
+					peers = append(peers, node)
+				}
+			}
+		}
+		peer := peers[rand.Intn(len(peers))]
+		data, err := c.marshal(StateMask{Mask: rT, Rows: []int{row}, Cols: missing})
+		if err != nil {
+			c.fanOutError(err)
+			return
+		}
+		c.debug("Asking %s for %d missing entries in routing table row %d", peer.ID, len(missing), row)
+		err = c.send(c.NewMessage(NODE_REPR, c.self.ID, data), peer)
+		if err == deadNodeError {
+			err = c.suspect(peer)
+		}
+		if err != nil {
+			c.fanOutError(err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that maintaining the routing table fills its gaps with the entries of the Nodes already in it
+func TestClusterMaintainTable(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is Node three, for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, cluster := range []*Cluster{one, two, three} {
+		go cluster.Listen()
+		defer cluster.Kill()
+	}
+	waitListening(t, one, two, three)
+	// all three share a 16 digit prefix, and differ in the next digit
+	_, err = one.table.insertNode(*two.self, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = two.table.insertNode(*three.self, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.maintainTable()
+	deadline := time.Now().Add(time.Second)
+	for _, err = one.table.getNode(three.self.ID); err != nil; _, err = one.table.getNode(three.self.ID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to be added to the routing table, got %v.", three.self.ID, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test that routing table maintenance can be disabled
+func TestClusterMaintenanceFrequency(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.getMaintenanceFrequency() != defaultMaintenanceFrequency || cluster.maintenanceTimer() == nil {
+		t.Errorf("Expected routing table maintenance to be enabled by default.")
+	}
+	cluster.SetMaintenanceFrequency(-time.Second)
+	if cluster.maintenanceTimer() != nil {
+		t.Errorf("Expected routing table maintenance to be disabled.")
+	}
+}
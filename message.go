@@ -1,27 +1,63 @@
 package wendy
 
+import (
+	"hash/crc32"
+	"time"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
 // Message represents the messages that are sent through the cluster of Nodes
 type Message struct {
 	Purpose     byte
-	Sender      Node   // The Node a message originated at
-	Key         NodeID // The message's ID
-	Value       []byte // The message being passed
-	Credentials []byte // The Credentials used to authenticate the Message
-	LSVersion   uint64 // The version of the leaf set, for join messages
-	RTVersion   uint64 // The version of the routing table, for join messages
-	NSVersion   uint64 // The version of the neighborhood set, for join messages
-	Hop         int    // The number of hops the message has taken
+	Sender      Node      // The Node a message originated at
+	Key         NodeID    // The message's ID
+	Value       []byte    // The message being passed
+	Credentials []byte    // The Credentials used to authenticate the Message
+	LSVersion   uint64    // The version of the leaf set, for join messages
+	RTVersion   uint64    // The version of the routing table, for join messages
+	NSVersion   uint64    // The version of the neighborhood set, for join messages
+	Hop         int       // The number of hops the message has taken
+	Destination NodeID    // The Node the message is being sent to, used to pick between virtual Nodes sharing an address
+	Signature   []byte    // An Ed25519 signature of the Message by its Sender, if it signs its Messages; see SetSigningKey
+	Checksum    uint32    // A CRC-32C checksum of the rest of the Message, set when the Message is sent
+	ClusterID   string    // The ID of the Cluster the Message's Sender belongs to; see SetClusterID
+	Epoch       uint64    // The epoch of the Cluster the Message's Sender belongs to; see SetClusterID
+	ID          []byte    // A unique ID for the Message, if it was sent with SendReliable
+	Ack         bool      // true if the Message acknowledges delivery of the Message sent with SendReliable with the same ID
+	Priority    Priority  // How urgently the Message is sent, and forwarded, when other Messages are waiting to be sent
+	Expires     time.Time // When the Message stops being worth delivering, after which Nodes discard it; zero if it never expires
+	Expired     bool      // true if the Message reports that the Message sent with SendReliable with the same ID expired before it was delivered
+	Seq         uint64    // The Message's place in the order its Sender sent Messages to its Key, if it was sent with ordered delivery; see SetOrderedDelivery
+	Direct      bool      // true if the Message was sent straight to the Node that delivers it, rather than routed towards its Key; see Multicast
+	InReplyTo   []byte    // The ID of the Message this Message replies to; see Reply
+	RequestID   []byte    // A unique ID for the Message, if it waits for a reply without being sent with SendReliable, so it isn't acknowledged or remembered like a Message sent with SendReliable; see Reply
+	RecordPath  bool      // true if each Node that routes or delivers the Message should add itself to its Path
+	// Metadata about the Message, such as a trace ID, a content type, or a tenant ID, for applications and middleware to read without decoding Value. Nodes forward Headers untouched.
+	Headers map[string]string
+	// The Nodes the Message has passed through, in order, and when each handled it, if RecordPath is set. Each Node adds itself before OnForward is called, so OnForward sees the current Node last, as does OnDeliver on the Node the Message is delivered to. A Node's Proximity is to the Node it forwarded the Message to, if it's been measured.
+	Path []TraceHop
+	// the Credentials the Message is sent with, instead of the Cluster's; see WithCredentials
+	credentials Credentials
 }
 
 const (
-	NODE_JOIN = byte(iota) // Used when a Node wishes to join the cluster
-	NODE_EXIT              // Used when a Node leaves the cluster
-	HEARTBEAT              // Used when a Node is being tested
-	STAT_DATA              // Used when a Node broadcasts state info
-	STAT_REQ               // Used when a Node is requesting state info
-	NODE_RACE              // Used when a Node hits a race condition
-	NODE_REPR              // Used when a Node needs to repair its LeafSet
-	NODE_ANN               // Used when a Node broadcasts its presence
+	NODE_JOIN   = byte(iota) // Used when a Node wishes to join the cluster
+	NODE_EXIT                // Used when a Node leaves the cluster
+	HEARTBEAT                // Used when a Node is being tested
+	STAT_DATA                // Used when a Node broadcasts state info
+	STAT_REQ                 // Used when a Node is requesting state info
+	NODE_RACE                // Used when a Node hits a race condition
+	NODE_REPR                // Used when a Node needs to repair its LeafSet
+	NODE_ANN                 // Used when a Node broadcasts its presence
+	NODE_SYNC                // Used when a Node sends a digest of its state tables for anti-entropy
+	NODE_PROBE               // Used when a Node asks another to check on a Node that failed to respond
+	NODE_ALIVE               // Used when a Node reports that a Node it was asked to check on responded
+	NODE_TRACE               // Used when a Node traces the path a key takes through the cluster
+	NODE_TRACED              // Used when a Node returns a completed trace to the Node that started it
+	NODE_LEAVES              // Used when a Node reconciles its leaf set with its immediate neighbours
+	NODE_CAPS                // Used when a Node routes a message that may only be delivered to a Node with a capability
+	NODE_AUTH                // Used when a Node challenges a joining Node to prove it has the credentials, when the joining Node answers, and when new credentials are distributed
 )
 
 // String returns a string representation of a message.
@@ -29,11 +65,56 @@ func (m *Message) String() string {
 	return m.Key.String() + ": " + string(m.Value)
 }
 
+// digest returns a canonical encoding of every field of the Message except its Checksum and Destination, which is the same no matter which Codec the Message is sent with. Destination is left out so Nodes that predate it can still verify Messages that set it, and the Signature, and the Load, Metadata, Capabilities, PublicKey, and Proof of the Sender, are left out for the same reason.
+func (m Message) digest() []byte {
+	m.Checksum = 0
+	m.Destination = NodeID{}
+	m.Signature = nil
+	m.Sender.Load = 0
+	m.Sender.Metadata = nil
+	m.Sender.Capabilities = nil
+	m.Sender.PublicKey = nil
+	m.Sender.Proof = nil
+	var buf protobufBuffer
+	buf.message(m)
+	return buf
+}
+
+// signingDigest returns the canonical encoding of the Message that its Sender signs: every field except those that change as it's sent and forwarded, which are its Checksum, Destination, Hop, Path, and Signature.
+func (m Message) signingDigest() []byte {
+	m.Checksum = 0
+	m.Destination = NodeID{}
+	m.Hop = 0
+	m.Path = nil
+	m.Signature = nil
+	var buf protobufBuffer
+	buf.message(m)
+	return buf
+}
+
+// checksum computes the Checksum of the Message.
+func (m Message) checksum() uint32 {
+	return crc32.Checksum(m.digest(), crc32c)
+}
+
+// verifyChecksum returns false if the Message has a Checksum that doesn't match its contents. Messages without a Checksum, sent by Nodes that predate them, are not verified.
+func (m Message) verifyChecksum() bool {
+	return m.Checksum == 0 || m.Checksum == m.checksum()
+}
+
+// WithCredentials returns a copy of the Message that is sent with credentials instead of the Cluster's Credentials, such as a token delegated to one tenant of a Cluster shared by several. If credentials fulfill MessageCredentials, they sign the Message just before it's sent, as the Cluster's would. The Message is only accepted by Nodes whose Credentials accept credentials, and Nodes that forward it keep them, as they do the Sender's own.
+func (m Message) WithCredentials(credentials Credentials) Message {
+	m.credentials = credentials
+	m.Credentials = credentials.Marshal()
+	return m
+}
+
 func (c *Cluster) NewMessage(purpose byte, key NodeID, value []byte) Message {
 	var credentials []byte
-	if c.credentials != nil {
-		credentials = c.credentials.Marshal()
+	if current := c.sendingCredentials(); current != nil {
+		credentials = current.Marshal()
 	}
+	clusterID, epoch := c.ClusterID()
 	return Message{
 		Purpose:     purpose,
 		Sender:      *c.self,
@@ -44,5 +125,7 @@ func (c *Cluster) NewMessage(purpose byte, key NodeID, value []byte) Message {
 		RTVersion:   c.self.routingTableVersion,
 		NSVersion:   c.self.neighborhoodSetVersion,
 		Hop:         0,
+		ClusterID:   clusterID,
+		Epoch:       epoch,
 	}
 }
@@ -0,0 +1,122 @@
+package wendy
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// Test that checksums detect modified Messages
+func TestMessageChecksum(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	if !msg.verifyChecksum() {
+		t.Fatalf("Expected a Message without a checksum to be accepted.")
+	}
+	msg.Checksum = msg.checksum()
+	if !msg.verifyChecksum() {
+		t.Fatalf("Expected the checksum to match.")
+	}
+	msg.Value = []byte("hello, world!")
+	if msg.verifyChecksum() {
+		t.Fatalf("Expected the checksum not to match a modified Message.")
+	}
+}
+
+// Test that a Cluster discards Messages whose checksum doesn't match
+func TestClusterDiscardsCorruptMessages(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	msg := one.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	msg.Checksum = msg.checksum() + 1
+	conn, err := net.Dial("tcp", one.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-oneCB.onDeliver:
+		t.Fatalf("Expected the corrupt message not to be delivered.")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if stats := one.Stats(); stats.CorruptMessages != 1 {
+		t.Fatalf("Expected %d corrupt message, got %d.", 1, stats.CorruptMessages)
+	}
+}
+
+// Test that Headers are covered by the checksum, whatever order they're iterated in
+func TestMessageHeadersChecksum(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	msg.Headers = map[string]string{"trace-id": "abc123", "tenant": "acme", "content-type": "text/plain"}
+	msg.Checksum = msg.checksum()
+	for i := 0; i < 10; i++ {
+		if !msg.verifyChecksum() {
+			t.Fatalf("Expected the checksum to match, whatever order the Headers are in.")
+		}
+	}
+	msg.Headers["tenant"] = "someone else"
+	if msg.verifyChecksum() {
+		t.Fatalf("Expected the checksum not to match a Message with modified Headers.")
+	}
+}
+
+// Test that Headers reach the Node a Message is delivered to
+func TestClusterMessageHeaders(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	time.Sleep(10 * time.Millisecond)
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		one.SetCodec(codec)
+		two.SetCodec(codec)
+		msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("hello, world"))
+		msg.Headers = map[string]string{"trace-id": "abc123"}
+		err = two.SendToIP(msg, two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case delivered := <-callback.onDeliver:
+			if len(delivered.Headers) != 1 || delivered.Headers["trace-id"] != "abc123" {
+				t.Errorf("Expected the Headers to be delivered with %T, got %v.", codec, delivered.Headers)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the Message to be delivered with %T.", codec)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package wendy
+
+// SetMetadata sets the Metadata of the current Node, replacing any set before. Metadata is sent to other Nodes with every Message, including heartbeats and the state tables exchanged when Nodes join, so Applications can label Nodes with their roles, versions, or shards, and read the labels of other Nodes from the Metadata of the Nodes in the state tables. It should be kept small, as it is sent so often.
+func (c *Cluster) SetMetadata(metadata map[string]string) {
+	c.self.setMetadata(metadata)
+}
+
+// Metadata returns a copy of the Metadata of the current Node.
+func (c *Cluster) Metadata() map[string]string {
+	return c.self.getMetadata()
+}
@@ -0,0 +1,79 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a Node's Metadata is copied when set, sent with its Messages, and left out of their Checksums
+func TestClusterSetMetadata(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	metadata := map[string]string{"role": "storage"}
+	cluster.SetMetadata(metadata)
+	metadata["role"] = "compute"
+	if cluster.Metadata()["role"] != "storage" {
+		t.Errorf("Expected changing the map passed to SetMetadata not to change the Node's Metadata, got %v.", cluster.Metadata())
+	}
+	cluster.Metadata()["role"] = "compute"
+	if cluster.Metadata()["role"] != "storage" {
+		t.Errorf("Expected changing the map returned by Metadata not to change the Node's Metadata, got %v.", cluster.Metadata())
+	}
+	msg := cluster.NewMessage(HEARTBEAT, cluster.self.ID, []byte{})
+	if msg.Sender.Metadata["role"] != "storage" {
+		t.Errorf("Expected the Message to carry the Node's Metadata, got %v.", msg.Sender.Metadata)
+	}
+	checksum := msg.checksum()
+	msg.Sender.Metadata = nil
+	if msg.checksum() != checksum {
+		t.Errorf("Expected the Checksum not to cover the Sender's Metadata, so Nodes that predate it can verify the Message.")
+	}
+	clone := cluster.self.clone()
+	clone.Metadata["role"] = "compute"
+	if cluster.Metadata()["role"] != "storage" {
+		t.Errorf("Expected changing a copy of the Node not to change its Metadata, got %v.", cluster.Metadata())
+	}
+}
+
+// Test that the Metadata another Node advertises is updated in the state tables when it sends a heartbeat
+func TestClusterMetadataHeartbeat(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	err = one.insert(*two.self.clone(), StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self.clone(), StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetMetadata(map[string]string{"shard": "7"})
+	two.sendHeartbeats()
+	deadline := time.Now().Add(time.Second)
+	for {
+		leaves := one.LeafSet()
+		if len(leaves) == 1 && leaves[0].Node.Metadata["shard"] == "7" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to advertise its shard in the leaf set, got %+v.", two.self.ID, leaves)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
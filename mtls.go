@@ -0,0 +1,175 @@
+package wendy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var mtlsNoCertificateError = errors.New("No certificate has been set for mutual TLS.")
+var mtlsPeerCertificateError = errors.New("The peer didn't present a certificate.")
+var mtlsCAError = errors.New("The certificate authority file had no certificates in it.")
+var mtlsNotLoadedError = errors.New("Mutual TLS wasn't loaded from files, so it can't be reloaded.")
+
+// MutualTLS is an implementation of both Transport and Credentials, for Clusters whose membership is granted by certificates issued by a certificate authority. Every connection between Nodes is made over TLS, and both ends must present a certificate signed by the configured CA, so a Node without one can neither send Messages to the Cluster nor receive them. Certificates are checked against the CA rather than the address being dialed, so they don't need to name the Nodes' IPs.
+//
+// Use the same MutualTLS as the Cluster's Credentials and its Transport:
+//
+//	membership, err := wendy.LoadMutualTLS("node.crt", "node.key", "ca.crt")
+//	cluster := wendy.NewCluster(node, membership)
+//	cluster.SetTransport(membership)
+//
+// The certificate and the CA can be replaced while the Cluster is running, with SetCertificate, SetCA, or Reload; connections made after that use them. Every Node in the Cluster must use a MutualTLS.
+type MutualTLS struct {
+	lock     *sync.RWMutex
+	cert     *tls.Certificate
+	ca       *x509.CertPool
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+// NewMutualTLS creates a MutualTLS that presents cert, and accepts Nodes presenting certificates signed by a certificate authority in ca.
+func NewMutualTLS(cert tls.Certificate, ca *x509.CertPool) *MutualTLS {
+	return &MutualTLS{
+		lock: new(sync.RWMutex),
+		cert: &cert,
+		ca:   ca,
+	}
+}
+
+// LoadMutualTLS creates a MutualTLS from PEM files holding the certificate to present, its private key, and the certificates of the certificate authorities to accept. Reload reads the files again.
+func LoadMutualTLS(certFile, keyFile, caFile string) (*MutualTLS, error) {
+	m := &MutualTLS{
+		lock:     new(sync.RWMutex),
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	return m, m.Reload()
+}
+
+// Reload reads the files the MutualTLS was loaded from again, so certificates can be rotated by replacing the files, without restarting the Node. If any of them can't be read, the certificate and CA in use are kept, and an error is returned.
+func (m *MutualTLS) Reload() error {
+	if m.certFile == "" {
+		return mtlsNotLoadedError
+	}
+	cert, err := tls.LoadX509KeyPair(m.certFile, m.keyFile)
+	if err != nil {
+		return err
+	}
+	pem, err := os.ReadFile(m.caFile)
+	if err != nil {
+		return err
+	}
+	ca := x509.NewCertPool()
+	if !ca.AppendCertsFromPEM(pem) {
+		return mtlsCAError
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cert = &cert
+	m.ca = ca
+	return nil
+}
+
+// SetCertificate replaces the certificate the Node presents. Connections made afterwards present the new certificate.
+func (m *MutualTLS) SetCertificate(cert tls.Certificate) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cert = &cert
+}
+
+// SetCA replaces the certificate authorities other Nodes' certificates must be signed by. Connections made afterwards are checked against the new CA. To rotate the CA, first set a pool holding both the old and new CAs on every Node, then issue certificates from the new CA, then remove the old one.
+func (m *MutualTLS) SetCA(ca *x509.CertPool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.ca = ca
+}
+
+func (m *MutualTLS) getCertificate() (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil {
+		return nil, mtlsNoCertificateError
+	}
+	return m.cert, nil
+}
+
+func (m *MutualTLS) getCA() *x509.CertPool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.ca
+}
+
+// config returns a TLS configuration that presents the current certificate and checks the peer's against the current CA, whichever end of the connection it's used for.
+func (m *MutualTLS) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.getCertificate()
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return m.getCertificate()
+		},
+		ClientAuth: tls.RequireAnyClientCert,
+		// the peer is checked against the CA by VerifyConnection instead, as Nodes are dialed by address rather than name
+		InsecureSkipVerify: true,
+		VerifyConnection:   m.verify,
+	}
+}
+
+// verify returns an error unless the peer presented a certificate signed by the current CA.
+func (m *MutualTLS) verify(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) < 1 {
+		return mtlsPeerCertificateError
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         m.getCA(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// Dial opens a TLS connection to the specified address, presenting the current certificate, and giving up if the connection and handshake haven't finished after timeout has elapsed.
+func (m *MutualTLS) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, m.config())
+}
+
+// Listen binds a TLS listener to the specified address, which only completes handshakes with peers presenting a certificate signed by the current CA.
+func (m *MutualTLS) Listen(address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, m.config()), nil
+}
+
+// Valid returns true, as membership is granted by the certificate the connection was made with, which ValidConn checks.
+func (m *MutualTLS) Valid(supplied []byte) bool {
+	return true
+}
+
+// Marshal returns nil, as no Credentials need to be sent with Messages.
+func (m *MutualTLS) Marshal() []byte {
+	return nil
+}
+
+// ValidConn returns true if conn is a TLS connection whose handshake has finished, which means its peer presented a certificate signed by the CA. Messages that arrive over any other kind of connection are refused.
+func (m *MutualTLS) ValidConn(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	state := tlsConn.ConnectionState()
+	return state.HandshakeComplete && len(state.PeerCertificates) > 0
+}
@@ -0,0 +1,234 @@
+package wendy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority for issuing Node certificates in tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) issue(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func testCAPool(cas ...testCA) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, ca := range cas {
+		pool.AddCert(ca.cert)
+	}
+	return pool
+}
+
+// Test that MutualTLS refuses Messages that didn't arrive over a TLS connection
+func TestMutualTLSValidConn(t *testing.T) {
+	ca := newTestCA(t, "wendy test CA")
+	membership := NewMutualTLS(ca.issue(t, "node"), testCAPool(ca))
+	if !membership.Valid(nil) {
+		t.Errorf("Expected Messages' own Credentials not to be checked.")
+	}
+	if membership.Marshal() != nil {
+		t.Errorf("Expected no Credentials to be sent with Messages.")
+	}
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	if membership.ValidConn(conn) {
+		t.Errorf("Expected a connection without TLS to be refused.")
+	}
+	if membership.Reload() != mtlsNotLoadedError {
+		t.Errorf("Expected an error reloading a MutualTLS that wasn't loaded from files.")
+	}
+}
+
+// Test that LoadMutualTLS reads the certificate, key, and CA from files, and Reload picks up new ones
+func TestLoadMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "node.crt")
+	keyFile := filepath.Join(dir, "node.key")
+	caFile := filepath.Join(dir, "ca.crt")
+	write := func(ca testCA) tls.Certificate {
+		cert := ca.issue(t, "node")
+		key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		files := map[string][]byte{
+			certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+			keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+			caFile:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}),
+		}
+		for path, contents := range files {
+			err = os.WriteFile(path, contents, 0600)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		return cert
+	}
+	first := write(newTestCA(t, "first CA"))
+	membership, err := LoadMutualTLS(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cert, err := membership.getCertificate()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(cert.Certificate[0]) != string(first.Certificate[0]) {
+		t.Errorf("Expected the certificate to be loaded from %s.", certFile)
+	}
+	second := write(newTestCA(t, "second CA"))
+	err = membership.Reload()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cert, err = membership.getCertificate()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(cert.Certificate[0]) != string(second.Certificate[0]) {
+		t.Errorf("Expected Reload to load the new certificate.")
+	}
+	err = os.WriteFile(caFile, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if membership.Reload() != mtlsCAError {
+		t.Errorf("Expected an error reloading a CA file without certificates.")
+	}
+	cert, err = membership.getCertificate()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(cert.Certificate[0]) != string(second.Certificate[0]) {
+		t.Errorf("Expected a failed Reload to keep the certificate in use.")
+	}
+}
+
+// Test that only Nodes with certificates from the CA can send Messages, and that certificates and CAs can be rotated while the Nodes run
+func TestClusterMutualTLS(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oldCA := newTestCA(t, "old CA")
+	newCA := newTestCA(t, "new CA")
+	oneTLS := NewMutualTLS(oldCA.issue(t, "one"), testCAPool(oldCA))
+	twoTLS := NewMutualTLS(oldCA.issue(t, "two"), testCAPool(oldCA))
+	threeTLS := NewMutualTLS(newCA.issue(t, "three"), testCAPool(newCA))
+	for cluster, membership := range map[*Cluster]*MutualTLS{one: oneTLS, two: twoTLS, three: threeTLS} {
+		cluster.credentials = membership
+		cluster.SetTransport(membership)
+	}
+	callback := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 10)}
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	expectDelivered := func(from *Cluster, value string) {
+		err := from.SendToIP(from.NewMessage(FirstUserPurpose, one.self.ID, []byte(value)), from.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case msg := <-callback.onDeliver:
+			if string(msg.Value) != value {
+				t.Errorf("Expected %q to be delivered, got %+v.", value, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %q to be delivered.", value)
+		}
+	}
+	expectDelivered(two, "member")
+	// three's certificate wasn't issued by the CA one trusts, so the handshake fails
+	three.SendToIP(three.NewMessage(FirstUserPurpose, one.self.ID, []byte("stranger")), three.GetIP(*one.self))
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message from the Node with an untrusted certificate to be refused, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case <-callback.errors:
+	default:
+		t.Errorf("Expected the failed handshake to be reported.")
+	}
+	// rotate to the new CA without restarting: trust both, then reissue from the new one
+	for _, membership := range []*MutualTLS{oneTLS, twoTLS, threeTLS} {
+		membership.SetCA(testCAPool(oldCA, newCA))
+	}
+	expectDelivered(three, "trusted")
+	twoTLS.SetCertificate(newCA.issue(t, "two"))
+	oneTLS.SetCertificate(newCA.issue(t, "one"))
+	for _, membership := range []*MutualTLS{oneTLS, twoTLS, threeTLS} {
+		membership.SetCA(testCAPool(newCA))
+	}
+	expectDelivered(two, "rotated")
+	expectDelivered(three, "rotated")
+}
@@ -0,0 +1,66 @@
+package wendy
+
+// Multicast sends a Message straight to each of the listed Nodes, rather than routing it towards its Key, and each of them delivers it. This suits Applications that already know which Nodes should hear about something, such as the replicas of a key. The Message is sent to all of the Nodes at once, using the Cluster's send workers, and Multicast returns once every send has finished.
+//
+// The Nodes must be in the current Node's state tables; the current Node may be listed too, and delivers the Message itself. If the Message couldn't be delivered to every Node, a MulticastError is returned listing the Nodes that missed it and why, and the Nodes that didn't respond are suspected. If an Outbox is set with SetOutbox, the Message is held for the Nodes that didn't respond, to be sent once they're heard from again, and they aren't counted as having missed it. The Message must have a purpose of FirstUserPurpose or above.
+func (c *Cluster) Multicast(nodes []NodeID, msg Message) error {
+	if msg.Purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	if msg.expired() {
+		return messageExpiredError
+	}
+	msg.Direct = true
+	failed := map[NodeID]error{}
+	sent := map[NodeID]<-chan error{}
+	targets := map[NodeID]*Node{}
+	local := false
+	for _, id := range nodes {
+		if _, ok := targets[id]; ok || failed[id] != nil {
+			continue
+		}
+		if id.Equals(c.self.ID) {
+			local = true
+			continue
+		}
+		node, err := c.get(id)
+		if err == nil && node == nil {
+			err = nodeNotFoundError
+		}
+		if err != nil {
+			failed[id] = err
+			continue
+		}
+		c.debug("Multicasting message %s to %s", msg.Key, id)
+		sent[id] = c.sendAsync(msg, node)
+		targets[id] = node
+	}
+	delivered := 0
+	if local {
+		c.deliver(msg)
+		delivered++
+	}
+	for id, result := range sent {
+		err := <-result
+		if err == nil {
+			delivered++
+			continue
+		}
+		if err == deadNodeError {
+			go func(node *Node) {
+				err := c.suspect(node)
+				if err != nil {
+					c.fanOutError(err)
+				}
+			}(targets[id])
+			if c.hold(targets[id], msg) {
+				continue
+			}
+		}
+		failed[id] = err
+	}
+	if len(failed) > 0 {
+		return MulticastError{Failed: failed, Delivered: delivered}
+	}
+	return nil
+}
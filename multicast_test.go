@@ -0,0 +1,74 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that Multicast delivers a Message to each listed Node, and reports the Nodes it couldn't
+func TestMulticast(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callbacks := map[*Cluster]*testCallback{}
+	for _, cluster := range []*Cluster{one, two, three} {
+		callbacks[cluster] = newTestCallback(t)
+		cluster.RegisterCallback(callbacks[cluster])
+	}
+	go one.Listen()
+	defer one.Kill()
+	go three.Listen()
+	defer three.Kill()
+	waitListening(t, one, three)
+	for _, node := range []*Node{one.self, three.self} {
+		err = two.insert(*node, StateMask{Mask: all})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	unknown, err := NodeIDFromBytes([]byte("this is an unknown Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// routed, the Message would only reach one of the Nodes
+	msg := two.NewMessage(FirstUserPurpose, unknown, []byte("replicate this"))
+	err = two.Multicast([]NodeID{one.self.ID, two.self.ID, three.self.ID, unknown, one.self.ID}, msg)
+	multicastErr, ok := err.(MulticastError)
+	if !ok {
+		t.Fatalf("Expected a MulticastError, got %v.", err)
+	}
+	if multicastErr.Delivered != 3 || len(multicastErr.Failed) != 1 || multicastErr.Failed[unknown] != nodeNotFoundError {
+		t.Errorf("Expected the Message to be delivered to 3 Nodes, and the unknown Node to be reported, got %+v.", multicastErr)
+	}
+	for cluster, callback := range callbacks {
+		select {
+		case delivered := <-callback.onDeliver:
+			if string(delivered.Value) != "replicate this" {
+				t.Errorf("Expected %s to deliver the Message, got %+v.", cluster.self.ID, delivered)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s to deliver the Message.", cluster.self.ID)
+		}
+		select {
+		case delivered := <-callback.onDeliver:
+			t.Errorf("Expected %s to deliver the Message once, got %+v again.", cluster.self.ID, delivered)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	err = two.Multicast([]NodeID{one.self.ID, three.self.ID}, msg)
+	if err != nil {
+		t.Errorf("Expected the Message to be delivered to every Node, got %v.", err)
+	}
+}
@@ -0,0 +1,565 @@
+package wendy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	muxOpen  = byte(iota) // Used when a stream is opened
+	muxData               // Used when a stream carries data
+	muxClose              // Used when one end of a stream is done writing
+	muxReset              // Used when a stream is refused or abandoned, and fails at both ends
+)
+
+const (
+	muxHeaderLen    = 9                // type (1 byte), stream ID (4 bytes), payload length (4 bytes)
+	muxMaxFrame     = 16 * 1024        // the largest payload written in one frame, so streams can interleave
+	muxAcceptBuffer = 64               // the number of opened streams that can wait to be accepted; streams opened while it's full are reset
+	muxMaxBuffered  = 16 * 1024 * 1024 // the most data a stream holds before it's read; a stream sent more is reset
+)
+
+var muxSessionClosedError = errors.New("Multiplexed session closed.")
+var muxStreamClosedError = errors.New("Multiplexed stream closed.")
+var muxFrameTooLargeError = errors.New("Multiplexed frame exceeded the maximum size.")
+var muxStreamResetError = errors.New("Multiplexed stream reset.")
+
+type muxTimeoutError struct{}
+
+func (e muxTimeoutError) Error() string   { return "Multiplexed stream timed out." }
+func (e muxTimeoutError) Timeout() bool   { return true }
+func (e muxTimeoutError) Temporary() bool { return true }
+
+// MuxTransport is an implementation of Transport that wraps another Transport, pooling a single connection to each address and multiplexing every Message sent over it as an independent stream.
+//
+// Because streams are written in small interleaved frames, concurrent Messages to the same Node don't block each other; a large state table transfer won't delay a heartbeat sent while it is in progress. A stream that can't be accepted because too many are waiting to be, or that is sent more data than it can hold before it's read, is reset, failing at both ends, rather than holding up the others. Every Node in the Cluster must use a MuxTransport wrapping the same underlying Transport.
+type MuxTransport struct {
+	inner    Transport
+	sessions map[string]*muxSession
+	dialing  map[string]chan struct{}
+	lock     *sync.Mutex
+}
+
+// NewMuxTransport creates a MuxTransport that opens its pooled connections using the specified Transport. If inner is nil, TCPTransport is used.
+func NewMuxTransport(inner Transport) *MuxTransport {
+	if inner == nil {
+		inner = TCPTransport{}
+	}
+	return &MuxTransport{
+		inner:    inner,
+		sessions: map[string]*muxSession{},
+		dialing:  map[string]chan struct{}{},
+		lock:     new(sync.Mutex),
+	}
+}
+
+// Dial opens a new stream to the specified address, reusing the pooled connection to that address if one is open. A new connection is dialed, giving up after timeout has elapsed, only if no usable connection exists.
+func (t *MuxTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	for {
+		t.lock.Lock()
+		if session, ok := t.sessions[address]; ok && !session.isClosed() {
+			t.lock.Unlock()
+			return session.open()
+		}
+		if wait, ok := t.dialing[address]; ok {
+			// another stream is already dialing this address; wait for it instead of opening a second connection
+			t.lock.Unlock()
+			<-wait
+			continue
+		}
+		wait := make(chan struct{})
+		t.dialing[address] = wait
+		t.lock.Unlock()
+		conn, err := t.inner.Dial(address, timeout)
+		t.lock.Lock()
+		delete(t.dialing, address)
+		close(wait)
+		if err != nil {
+			t.lock.Unlock()
+			return nil, err
+		}
+		session := newMuxSession(conn, true, nil)
+		session.onClose = func() {
+			t.lock.Lock()
+			defer t.lock.Unlock()
+			if t.sessions[address] == session {
+				delete(t.sessions, address)
+			}
+		}
+		t.sessions[address] = session
+		t.lock.Unlock()
+		go session.readLoop()
+		return session.open()
+	}
+}
+
+// Listen binds a listener to the specified address using the underlying Transport. Each connection it accepts is treated as a session, and every stream opened over those sessions is returned by the listener's Accept method.
+func (t *MuxTransport) Listen(address string) (net.Listener, error) {
+	ln, err := t.inner.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	l := &muxListener{
+		Listener: ln,
+		streams:  make(chan *muxStream, muxAcceptBuffer),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+		sessions: map[*muxSession]bool{},
+		lock:     new(sync.Mutex),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// Close closes every pooled connection the MuxTransport has open.
+func (t *MuxTransport) Close() error {
+	t.lock.Lock()
+	sessions := make([]*muxSession, 0, len(t.sessions))
+	for _, session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	t.lock.Unlock()
+	for _, session := range sessions {
+		session.close(muxSessionClosedError)
+	}
+	return nil
+}
+
+type muxListener struct {
+	net.Listener
+	streams   chan *muxStream
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+	sessions  map[*muxSession]bool
+	lock      *sync.Mutex
+}
+
+func (l *muxListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.errs <- err
+			return
+		}
+		var session *muxSession
+		session = newMuxSession(conn, false, func(stream *muxStream) bool {
+			// never block the session's readLoop, which every other stream over it is waiting on
+			select {
+			case <-l.done:
+				return false
+			default:
+			}
+			select {
+			case l.streams <- stream:
+				return true
+			default:
+				return false
+			}
+		})
+		session.onClose = func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			delete(l.sessions, session)
+		}
+		l.lock.Lock()
+		l.sessions[session] = true
+		l.lock.Unlock()
+		go session.readLoop()
+	}
+}
+
+// Accept waits for and returns the next stream opened by a remote MuxTransport.
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case stream := <-l.streams:
+		return stream, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, muxSessionClosedError
+	}
+}
+
+// Close stops the listener and closes every session it accepted.
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	err := l.Listener.Close()
+	l.lock.Lock()
+	sessions := make([]*muxSession, 0, len(l.sessions))
+	for session := range l.sessions {
+		sessions = append(sessions, session)
+	}
+	l.lock.Unlock()
+	for _, session := range sessions {
+		session.close(muxSessionClosedError)
+	}
+	return err
+}
+
+type muxSession struct {
+	conn      net.Conn
+	streams   map[uint32]*muxStream
+	nextID    uint32
+	accept    func(*muxStream) bool
+	onClose   func()
+	err       error
+	closed    bool
+	lock      *sync.Mutex
+	writeLock *sync.Mutex
+}
+
+func newMuxSession(conn net.Conn, client bool, accept func(*muxStream) bool) *muxSession {
+	s := &muxSession{
+		conn:      conn,
+		streams:   map[uint32]*muxStream{},
+		accept:    accept,
+		lock:      new(sync.Mutex),
+		writeLock: new(sync.Mutex),
+	}
+	// clients open odd-numbered streams, servers even-numbered ones
+	if client {
+		s.nextID = 1
+	} else {
+		s.nextID = 2
+	}
+	return s
+}
+
+func (s *muxSession) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+func (s *muxSession) open() (*muxStream, error) {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil, s.err
+	}
+	stream := newMuxStream(s.nextID, s)
+	s.nextID += 2
+	s.streams[stream.id] = stream
+	s.lock.Unlock()
+	err := s.writeFrame(muxOpen, stream.id, nil, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *muxSession) readLoop() {
+	header := make([]byte, muxHeaderLen)
+	for {
+		_, err := io.ReadFull(s.conn, header)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		frameType := header[0]
+		id := binary.BigEndian.Uint32(header[1:5])
+		length := binary.BigEndian.Uint32(header[5:9])
+		if length > muxMaxFrame {
+			s.close(muxFrameTooLargeError)
+			return
+		}
+		payload := make([]byte, length)
+		_, err = io.ReadFull(s.conn, payload)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		switch frameType {
+		case muxOpen:
+			stream := newMuxStream(id, s)
+			s.lock.Lock()
+			s.streams[id] = stream
+			s.lock.Unlock()
+			if s.accept == nil || !s.accept(stream) {
+				s.reset(stream)
+			}
+		case muxData:
+			s.lock.Lock()
+			stream := s.streams[id]
+			s.lock.Unlock()
+			if stream != nil && !stream.push(payload) {
+				s.reset(stream)
+			}
+		case muxClose:
+			s.lock.Lock()
+			stream := s.streams[id]
+			s.lock.Unlock()
+			if stream != nil {
+				stream.remoteClose()
+			}
+		case muxReset:
+			s.lock.Lock()
+			stream := s.streams[id]
+			s.lock.Unlock()
+			if stream != nil {
+				stream.reset()
+				s.forget(id)
+			}
+		}
+	}
+}
+
+func (s *muxSession) writeFrame(frameType byte, id uint32, payload []byte, deadline time.Time) error {
+	frame := make([]byte, muxHeaderLen+len(payload))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:5], id)
+	binary.BigEndian.PutUint32(frame[5:9], uint32(len(payload)))
+	copy(frame[muxHeaderLen:], payload)
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	s.conn.SetWriteDeadline(deadline)
+	_, err := s.conn.Write(frame)
+	if err != nil {
+		// part of the frame may have been written, so nothing written after it could be framed correctly
+		s.close(err)
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			return muxTimeoutError{}
+		}
+	}
+	return err
+}
+
+// reset fails a stream at both ends, telling the remote end to fail it too.
+func (s *muxSession) reset(stream *muxStream) {
+	stream.reset()
+	s.forget(stream.id)
+	s.writeFrame(muxReset, stream.id, nil, time.Time{})
+}
+
+func (s *muxSession) forget(id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.streams, id)
+}
+
+func (s *muxSession) close(err error) {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.err = err
+	streams := s.streams
+	s.streams = map[uint32]*muxStream{}
+	s.lock.Unlock()
+	s.conn.Close()
+	for _, stream := range streams {
+		stream.fail(err)
+	}
+	if s.onClose != nil {
+		s.onClose()
+	}
+}
+
+type muxStream struct {
+	id            uint32
+	session       *muxSession
+	buf           bytes.Buffer
+	remoteClosed  bool
+	localClosed   bool
+	writeClosed   bool
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+	timer         *time.Timer
+	lock          *sync.Mutex
+	cond          *sync.Cond
+}
+
+func newMuxStream(id uint32, session *muxSession) *muxStream {
+	lock := new(sync.Mutex)
+	return &muxStream{
+		id:      id,
+		session: session,
+		lock:    lock,
+		cond:    sync.NewCond(lock),
+	}
+}
+
+// push buffers data sent over the stream until it's read, returning false if the stream would hold more than muxMaxBuffered.
+func (s *muxStream) push(data []byte) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.localClosed {
+		return true
+	}
+	if s.buf.Len()+len(data) > muxMaxBuffered {
+		return false
+	}
+	s.buf.Write(data)
+	s.cond.Broadcast()
+	return true
+}
+
+func (s *muxStream) remoteClose() {
+	s.lock.Lock()
+	s.remoteClosed = true
+	done := s.localClosed
+	s.cond.Broadcast()
+	s.lock.Unlock()
+	if done {
+		s.session.forget(s.id)
+	}
+}
+
+// reset discards the data the stream holds, and fails its reads and writes.
+func (s *muxStream) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buf.Reset()
+	s.remoteClosed = true
+	s.writeClosed = true
+	s.err = muxStreamResetError
+	s.cond.Broadcast()
+}
+
+func (s *muxStream) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remoteClosed = true
+	s.err = err
+	s.cond.Broadcast()
+}
+
+// Read reads data sent over the stream, returning io.EOF once the remote end has closed it and all its data has been read.
+func (s *muxStream) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.buf.Len() == 0 {
+		if s.localClosed {
+			return 0, muxStreamClosedError
+		}
+		if s.err != nil && s.err != io.EOF {
+			return 0, s.err
+		}
+		if s.remoteClosed {
+			return 0, io.EOF
+		}
+		if !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline) {
+			return 0, muxTimeoutError{}
+		}
+		s.cond.Wait()
+	}
+	return s.buf.Read(p)
+}
+
+// Write sends p over the stream, splitting it into frames so other streams sharing the connection can interleave with it.
+func (s *muxStream) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	closed := s.localClosed || s.writeClosed
+	reset := s.err == muxStreamResetError
+	deadline := s.writeDeadline
+	s.lock.Unlock()
+	if reset {
+		return 0, muxStreamResetError
+	}
+	if closed {
+		return 0, muxStreamClosedError
+	}
+	written := 0
+	for written < len(p) {
+		end := written + muxMaxFrame
+		if end > len(p) {
+			end = len(p)
+		}
+		err := s.session.writeFrame(muxData, s.id, p[written:end], deadline)
+		if err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// Close tells the remote end that no more data will be written, and discards any data that hasn't been read.
+func (s *muxStream) Close() error {
+	s.lock.Lock()
+	if s.localClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.localClosed = true
+	s.buf.Reset()
+	done := s.remoteClosed
+	writeClosed := s.writeClosed
+	s.writeClosed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.cond.Broadcast()
+	s.lock.Unlock()
+	if done {
+		s.session.forget(s.id)
+	}
+	if writeClosed {
+		return nil
+	}
+	return s.session.writeFrame(muxClose, s.id, nil, time.Time{})
+}
+
+// CloseWrite tells the remote end that no more data will be written, while still allowing data to be read from the stream.
+func (s *muxStream) CloseWrite() error {
+	s.lock.Lock()
+	if s.writeClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	s.lock.Unlock()
+	return s.session.writeFrame(muxClose, s.id, nil, time.Time{})
+}
+
+func (s *muxStream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+func (s *muxStream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+func (s *muxStream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *muxStream) SetReadDeadline(t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.readDeadline = t
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !t.IsZero() {
+		s.timer = time.AfterFunc(t.Sub(time.Now()), func() {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.cond.Broadcast()
+		})
+	}
+	return nil
+}
+
+func (s *muxStream) SetWriteDeadline(t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writeDeadline = t
+	return nil
+}
@@ -0,0 +1,251 @@
+package wendy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func muxEchoServer(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			data, err := ioutil.ReadAll(conn)
+			if err != nil {
+				return
+			}
+			conn.Write(data)
+		}(conn)
+	}
+}
+
+// Test that concurrent streams to one address share a single pooled connection
+func TestMuxTransportPoolsConnections(t *testing.T) {
+	inner := &countingTransport{}
+	transport := NewMuxTransport(inner)
+	defer transport.Close()
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	go muxEchoServer(ln)
+	payloads := [][]byte{
+		bytes.Repeat([]byte("a large state table transfer "), 10000),
+		[]byte("a heartbeat"),
+		[]byte("another small message"),
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(payloads))
+	for _, payload := range payloads {
+		wg.Add(1)
+		go func(payload []byte) {
+			defer wg.Done()
+			conn, err := transport.Dial(ln.Addr().String(), time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			_, err = conn.Write(payload)
+			if err != nil {
+				errs <- err
+				return
+			}
+			err = conn.(*muxStream).CloseWrite()
+			if err != nil {
+				errs <- err
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			echo, err := ioutil.ReadAll(conn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(echo, payload) {
+				errs <- io.ErrUnexpectedEOF
+			}
+		}(payload)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf(err.Error())
+	}
+	dials, listens := inner.counts()
+	if dials != 1 {
+		t.Errorf("Expected 1 pooled connection, got %d.", dials)
+	}
+	if listens != 1 {
+		t.Errorf("Expected 1 call to Listen, got %d.", listens)
+	}
+}
+
+// Test that reads on a stream respect read deadlines
+func TestMuxStreamReadDeadline(t *testing.T) {
+	transport := NewMuxTransport(nil)
+	defer transport.Close()
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			time.Sleep(200 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+	conn, err := transport.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if neterr, ok := err.(net.Error); !ok || !neterr.Timeout() {
+		t.Fatalf("Expected a timeout error, got %v instead.", err)
+	}
+}
+
+// Test that a write timing out closes the session, since a partly written frame leaves nothing after it readable
+func TestMuxSessionWriteTimeout(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	session := newMuxSession(conn, true, nil)
+	// nothing reads from remote, so every write blocks until its deadline
+	err := session.writeFrame(muxData, 1, []byte("hello, world"), time.Now().Add(20*time.Millisecond))
+	if neterr, ok := err.(net.Error); !ok || !neterr.Timeout() {
+		t.Fatalf("Expected a timeout error, got %v instead.", err)
+	}
+	if !session.isClosed() {
+		t.Fatalf("Expected the session to be closed after a write timed out.")
+	}
+	if _, err = session.open(); err == nil {
+		t.Errorf("Expected opening a stream on a closed session to fail.")
+	}
+}
+
+// Test that streams opened while the accept queue is full are reset, without holding up the streams already open over the same connection
+func TestMuxStreamAcceptQueueFull(t *testing.T) {
+	transport := NewMuxTransport(nil)
+	defer transport.Close()
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	streams := []net.Conn{}
+	for i := 0; i <= muxAcceptBuffer; i++ {
+		conn, err := transport.Dial(ln.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer conn.Close()
+		streams = append(streams, conn)
+	}
+	refused := streams[muxAcceptBuffer]
+	refused.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = refused.Read(make([]byte, 1))
+	if err != muxStreamResetError {
+		t.Fatalf("Expected %v reading a stream opened while the accept queue was full, got %v.", muxStreamResetError, err)
+	}
+	_, err = refused.Write([]byte("refused"))
+	if err != muxStreamResetError {
+		t.Errorf("Expected %v writing a refused stream, got %v.", muxStreamResetError, err)
+	}
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer accepted.Close()
+	_, err = streams[0].Write([]byte("still open"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	accepted.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, len("still open"))
+	_, err = io.ReadFull(accepted, buf)
+	if err != nil || string(buf) != "still open" {
+		t.Errorf("Expected the accepted stream to read %q, got %q, %v.", "still open", buf, err)
+	}
+}
+
+// Test that a stream sent more than it can hold before it's read is reset at both ends
+func TestMuxStreamBufferLimit(t *testing.T) {
+	transport := NewMuxTransport(nil)
+	defer transport.Close()
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	conn, err := transport.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	accepted, err := ln.Accept()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer accepted.Close()
+	conn.Write(make([]byte, muxMaxBuffered+muxMaxFrame))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err != muxStreamResetError {
+		t.Errorf("Expected %v on the sending end, got %v.", muxStreamResetError, err)
+	}
+	_, err = accepted.Read(make([]byte, 1))
+	if err != muxStreamResetError {
+		t.Errorf("Expected %v on the receiving end, got %v.", muxStreamResetError, err)
+	}
+}
+
+// Test that a Cluster can deliver messages over a MuxTransport
+func TestClusterMuxTransport(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetTransport(NewMuxTransport(nil))
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetTransport(NewMuxTransport(nil))
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	for i := 0; i < 2; i++ {
+		msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+		err = two.SendToIP(msg, two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case received := <-oneCB.onDeliver:
+			if string(received.Value) != "hello, world" {
+				t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting on message delivery.")
+		}
+	}
+}
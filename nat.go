@@ -0,0 +1,100 @@
+package wendy
+
+import (
+	"net"
+	"time"
+)
+
+// natMappingLifetime is how long port mappings requested from a NAT device should last. Mappings are renewed when half their lifetime has elapsed.
+const natMappingLifetime = 1 * time.Hour
+
+// NAT is an interface that can be fulfilled to make a Node reachable through the network address translation device it sits behind.
+//
+// ExternalIP returns the IP address other Nodes should use to reach the NAT device from outside the local network.
+//
+// AddPortMapping asks the NAT device to forward TCP connections made to externalPort on its external address to internalPort on this machine, for the specified lifetime. It returns the external port that was actually mapped, which may differ from the port requested. Implementations that cannot map ports should return externalPort and a nil error, assuming the port has been forwarded manually.
+//
+// DeletePortMapping removes a mapping created by AddPortMapping.
+type NAT interface {
+	ExternalIP() (net.IP, error)
+	AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error)
+	DeletePortMapping(internalPort, externalPort int) error
+}
+
+// SetNAT sets the NAT that the Cluster will use to discover its external address and map its port before joining. When a NAT is set, Join will update the GlobalIP (and, if necessary, GlobalPort) of the current Node before contacting the Cluster.
+func (c *Cluster) SetNAT(nat NAT) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.nat = nat
+}
+
+func (c *Cluster) getNAT() NAT {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.nat
+}
+
+// configureNAT discovers the external address of the NAT device, maps the Node's port through it, and advertises the result as the Node's global address.
+func (c *Cluster) configureNAT() error {
+	nat := c.getNAT()
+	if nat == nil {
+		return nil
+	}
+	c.debug("Discovering external address.")
+	ip, err := nat.ExternalIP()
+	if err != nil {
+		return err
+	}
+	local := c.self.getPort()
+	port, err := nat.AddPortMapping(local, local, natMappingLifetime)
+	if err != nil {
+		return err
+	}
+	c.debug("Mapped port %d to %s:%d.", local, ip, port)
+	c.self.setGlobalAddress(ip.String(), port)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.natMappedPort = port
+	c.natRenewAt = time.Now().Add(natMappingLifetime / 2)
+	return nil
+}
+
+// renewNAT refreshes the port mapping if it is due to expire.
+func (c *Cluster) renewNAT() {
+	c.lock.RLock()
+	due := c.natMappedPort != 0 && time.Now().After(c.natRenewAt)
+	c.lock.RUnlock()
+	if !due {
+		return
+	}
+	err := c.configureNAT()
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
+
+// releaseNAT removes the port mapping, if one was made.
+func (c *Cluster) releaseNAT() {
+	nat := c.getNAT()
+	c.lock.Lock()
+	port := c.natMappedPort
+	c.natMappedPort = 0
+	c.lock.Unlock()
+	if nat == nil || port == 0 {
+		return
+	}
+	err := nat.DeletePortMapping(c.self.getPort(), port)
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
+
+// localIPFor returns the IP address of the local interface used to reach the specified host.
+func localIPFor(host string) (net.IP, error) {
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,75 @@
+package wendy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type testNAT struct {
+	ip      net.IP
+	port    int
+	deleted bool
+}
+
+func (n *testNAT) ExternalIP() (net.IP, error) {
+	return n.ip, nil
+}
+
+func (n *testNAT) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	return n.port, nil
+}
+
+func (n *testNAT) DeletePortMapping(internalPort, externalPort int) error {
+	n.deleted = true
+	return nil
+}
+
+// Test that configuring a NAT updates the global address of the Node
+func TestClusterConfigureNAT(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.self.Port = 55555
+	nat := &testNAT{ip: net.ParseIP("203.0.113.7"), port: 44444}
+	cluster.SetNAT(nat)
+	err = cluster.configureNAT()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.self.GlobalIP != "203.0.113.7" {
+		t.Errorf("Expected GlobalIP to be %s, got %s.", "203.0.113.7", cluster.self.GlobalIP)
+	}
+	if cluster.self.GlobalPort != 44444 {
+		t.Errorf("Expected GlobalPort to be %d, got %d.", 44444, cluster.self.GlobalPort)
+	}
+	other := NewNode(cluster.self.ID, "127.0.0.1", "127.0.0.1", "elsewhere", 55555)
+	if addr := other.GetIP(*cluster.self); addr != "203.0.113.7:44444" {
+		t.Errorf("Expected Nodes in other regions to use %s, got %s.", "203.0.113.7:44444", addr)
+	}
+	if addr := cluster.GetIP(*cluster.self); addr != "127.0.0.1:55555" {
+		t.Errorf("Expected Nodes in the same region to use %s, got %s.", "127.0.0.1:55555", addr)
+	}
+	cluster.releaseNAT()
+	if !nat.deleted {
+		t.Errorf("Expected the port mapping to be deleted.")
+	}
+}
+
+// Test that a NAT mapping to the same port doesn't set a GlobalPort
+func TestClusterConfigureNATSamePort(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.self.Port = 55555
+	cluster.SetNAT(&testNAT{ip: net.ParseIP("203.0.113.7"), port: 55555})
+	err = cluster.configureNAT()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.self.GlobalPort != 0 {
+		t.Errorf("Expected GlobalPort to be unset, got %d.", cluster.self.GlobalPort)
+	}
+}
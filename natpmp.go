@@ -0,0 +1,148 @@
+package wendy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const natPMPPort = 5351
+
+const (
+	natPMPOpExternalAddress = byte(0)
+	natPMPOpMapTCP          = byte(2)
+)
+
+var natPMPResponseError = errors.New("NAT-PMP gateway sent an invalid response.")
+var natPMPNoGatewayError = errors.New("Could not determine the default gateway.")
+
+// NATPMP is an implementation of NAT that uses the NAT Port Mapping Protocol (RFC 6886) supported by many home routers.
+//
+// Gateway is the address of the router. If it does not include a port, the standard NAT-PMP port is used.
+//
+// Timeout is how long to wait for the router to respond to each request. If it is zero, one second is used.
+type NATPMP struct {
+	Gateway string
+	Timeout time.Duration
+}
+
+// DiscoverNATPMP returns a NATPMP pointed at the default gateway of the machine. It is only able to find the gateway on Linux; on other platforms, the Gateway should be set manually.
+func DiscoverNATPMP() (*NATPMP, error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	return &NATPMP{Gateway: gateway.String()}, nil
+}
+
+// ExternalIP asks the router for its external IP address.
+func (n *NATPMP) ExternalIP() (net.IP, error) {
+	resp, err := n.request([]byte{0, natPMPOpExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+// AddPortMapping asks the router to forward externalPort to internalPort for the specified lifetime, returning the external port the router chose.
+func (n *NATPMP) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	req := make([]byte, 12)
+	req[1] = natPMPOpMapTCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err := n.request(req, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint16(resp[10:12])), nil
+}
+
+// DeletePortMapping asks the router to stop forwarding to internalPort.
+func (n *NATPMP) DeletePortMapping(internalPort, externalPort int) error {
+	req := make([]byte, 12)
+	req[1] = natPMPOpMapTCP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	_, err := n.request(req, 16)
+	return err
+}
+
+func (n *NATPMP) address() string {
+	if _, _, err := net.SplitHostPort(n.Gateway); err == nil {
+		return n.Gateway
+	}
+	return net.JoinHostPort(n.Gateway, strconv.Itoa(natPMPPort))
+}
+
+// request sends req to the gateway, retrying with exponential backoff until a response of the expected size arrives or the retries run out.
+func (n *NATPMP) request(req []byte, size int) ([]byte, error) {
+	conn, err := net.Dial("udp", n.address())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	wait := 250 * time.Millisecond
+	deadline := time.Now().Add(timeout)
+	resp := make([]byte, 16)
+	for time.Now().Before(deadline) {
+		_, err = conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		next := time.Now().Add(wait)
+		if next.After(deadline) {
+			next = deadline
+		}
+		conn.SetReadDeadline(next)
+		read, err := conn.Read(resp)
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				wait *= 2
+				continue
+			}
+			return nil, err
+		}
+		if read < size || resp[0] != 0 || resp[1] != req[1]+128 {
+			return nil, natPMPResponseError
+		}
+		if result := binary.BigEndian.Uint16(resp[2:4]); result != 0 {
+			return nil, fmt.Errorf("NAT-PMP gateway refused the request with result code %d.", result)
+		}
+		return resp[:read], nil
+	}
+	return nil, deadNodeError
+}
+
+// defaultGateway reads the default IPv4 gateway from the Linux routing table.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, natPMPNoGatewayError
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// the routing table stores addresses in little-endian byte order
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, natPMPNoGatewayError
+}
@@ -0,0 +1,89 @@
+package wendy
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeNATPMPGateway answers NAT-PMP requests the way a router would, mapping every port to 40000.
+func fakeNATPMPGateway(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			read, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if read < 2 {
+				continue
+			}
+			switch buf[1] {
+			case natPMPOpExternalAddress:
+				resp := make([]byte, 12)
+				resp[1] = 128
+				copy(resp[8:], net.ParseIP("198.51.100.1").To4())
+				conn.WriteTo(resp, addr)
+			case natPMPOpMapTCP:
+				resp := make([]byte, 16)
+				resp[1] = 130
+				copy(resp[8:10], buf[4:6])
+				binary.BigEndian.PutUint16(resp[10:12], 40000)
+				copy(resp[12:16], buf[8:12])
+				conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+// Test discovering the external address through NAT-PMP
+func TestNATPMPExternalIP(t *testing.T) {
+	gateway := fakeNATPMPGateway(t)
+	defer gateway.Close()
+	nat := &NATPMP{Gateway: gateway.LocalAddr().String()}
+	ip, err := nat.ExternalIP()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !ip.Equal(net.ParseIP("198.51.100.1")) {
+		t.Fatalf("Expected %s, got %s.", "198.51.100.1", ip)
+	}
+}
+
+// Test mapping a port through NAT-PMP
+func TestNATPMPAddPortMapping(t *testing.T) {
+	gateway := fakeNATPMPGateway(t)
+	defer gateway.Close()
+	nat := &NATPMP{Gateway: gateway.LocalAddr().String()}
+	port, err := nat.AddPortMapping(55555, 55555, time.Hour)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if port != 40000 {
+		t.Fatalf("Expected port %d, got %d.", 40000, port)
+	}
+	err = nat.DeletePortMapping(55555, port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
+
+// Test that an unresponsive gateway is reported as dead
+func TestNATPMPTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	nat := &NATPMP{Gateway: conn.LocalAddr().String(), Timeout: 100 * time.Millisecond}
+	_, err = nat.ExternalIP()
+	if err != deadNodeError {
+		t.Fatalf("Expected deadNodeError, got %v instead.", err)
+	}
+}
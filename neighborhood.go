@@ -28,18 +28,56 @@ func newNeighborhoodSet(self *Node) *neighborhoodSet {
 var nsDuplicateInsertError = errors.New("Node already exists in neighborhood set.")
 
 func (n *neighborhoodSet) insertNode(node Node, proximity int64) (*Node, error) {
-	return n.insertValues(node.ID, node.LocalIP, node.GlobalIP, node.Region, node.Port, node.routingTableVersion, node.leafsetVersion, node.neighborhoodSetVersion, proximity)
+	return n.insert(node.clone(), proximity)
 }
 
 func (n *neighborhoodSet) insertValues(id NodeID, localIP, globalIP, region string, port int, rTVersion, lSVersion, nSVersion uint64, proximity int64) (*Node, error) {
+	node := NewNode(id, localIP, globalIP, region, port)
+	node.updateVersions(rTVersion, lSVersion, nSVersion)
+	return n.insert(node, proximity)
+}
+
+func (n *neighborhoodSet) insert(insertNode *Node, proximity int64) (*Node, error) {
 	n.lock.Lock()
 	defer n.lock.Unlock()
-	if id.Equals(n.self.ID) {
+	insertNode.setProximity(proximity)
+	node, err := n.add(insertNode)
+	if node != nil {
+		n.self.incrementNSVersion()
+		node.tableVersion = n.self.neighborhoodSetVersion
+	}
+	return node, err
+}
+
+// insertBatch inserts each of the Nodes, with the proximity already set on it, taking the lock and incrementing the version of the neighborhood set only once. It returns the Nodes that were inserted, and the first error other than a duplicate insert.
+func (n *neighborhoodSet) insertBatch(nodes []*Node) ([]*Node, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	inserted := []*Node{}
+	var first error
+	for _, node := range nodes {
+		node, err := n.add(node)
+		if err != nil && err != nsDuplicateInsertError && first == nil {
+			first = err
+		}
+		if node != nil {
+			inserted = append(inserted, node)
+		}
+	}
+	if len(inserted) > 0 {
+		n.self.incrementNSVersion()
+		for _, node := range inserted {
+			node.tableVersion = n.self.neighborhoodSetVersion
+		}
+	}
+	return inserted, first
+}
+
+// add puts the Node into the neighborhood set, returning it if it was inserted. The caller must hold n.lock, and update the version of the neighborhood set.
+func (n *neighborhoodSet) add(insertNode *Node) (*Node, error) {
+	if insertNode.ID.Equals(n.self.ID) {
 		return nil, throwIdentityError("insert", "into", "neighborhood set")
 	}
-	insertNode := NewNode(id, localIP, globalIP, region, port)
-	insertNode.updateVersions(rTVersion, lSVersion, nSVersion)
-	insertNode.setProximity(proximity)
 	newNS := [32]*Node{}
 	newNSpos := 0
 	score := n.self.Proximity(insertNode)
@@ -57,6 +95,7 @@ func (n *neighborhoodSet) insertValues(id NodeID, localIP, globalIP, region stri
 		}
 		if node != nil && insertNode.ID.Equals(node.ID) {
 			insertNode.updateVersions(node.routingTableVersion, node.leafsetVersion, node.neighborhoodSetVersion)
+			insertNode.tableVersion = node.tableVersion
 			newNS[newNSpos] = insertNode
 			newNSpos++
 			dup = true
@@ -78,7 +117,6 @@ func (n *neighborhoodSet) insertValues(id NodeID, localIP, globalIP, region stri
 		return nil, nsDuplicateInsertError
 	}
 	if inserted {
-		n.self.incrementNSVersion()
 		return insertNode, nil
 	}
 	return nil, nil
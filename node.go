@@ -1,7 +1,11 @@
 package wendy
 
 import (
+	"crypto/ed25519"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,15 +15,24 @@ import (
 type Node struct {
 	LocalIP                string // The IP through which the Node should be accessed by other Nodes with an identical Region
 	GlobalIP               string // The IP through which the Node should be accessed by other Nodes whose Region differs
+	LocalIPv6              string // The IPv6 address through which the Node should be accessed by other Nodes with an identical Region, if it has one
+	GlobalIPv6             string // The IPv6 address through which the Node should be accessed by other Nodes whose Region differs, if it has one
 	Port                   int    // The port the Node is listening on
-	Region                 string // A string that allows you to intelligently route between local and global requests for, e.g., EC2 regions
+	GlobalPort             int    // The port through which the Node should be accessed by other Nodes whose Region differs, if it differs from Port
+	Region                 string // A path, from the broadest locality to the narrowest, e.g. "europe/eu-west-1/eu-west-1a", that allows you to intelligently route between local and global requests
+	Load                   int    // How heavily loaded the Node reports itself to be, as a percentage of its capacity
 	ID                     NodeID
+	Metadata               map[string]string // Labels attached to the Node by its Applications, such as its role, version, or shard
+	Capabilities           []string          // What the Node can do, such as "storage" or "gpu", for SendToCapable; kept sorted
+	PublicKey              ed25519.PublicKey // The key the Node signs its Messages with, if it has one; see SetSigningKey
+	Proof                  []byte            // The proof of work the Node's ID was derived from, if it has one; see SetIDDifficulty
 	proximity              int64
 	mutex                  *sync.RWMutex // lock and unlock a Node for concurrency safety
 	lastHeardFrom          time.Time     // The last time we heard from this node
 	leafsetVersion         uint64        // the version number of the leafset
 	routingTableVersion    uint64        // the version number of the routing table
 	neighborhoodSetVersion uint64        // the version number of the neighborhood set
+	tableVersion           uint64        // the version of the state table holding this Node when the Node was added to it
 }
 
 // NewNode initialises a new Node and its associated mutexes. It does *not* update the proximity of the Node.
@@ -41,28 +54,116 @@ func NewNode(id NodeID, local, global, region string, port int) *Node {
 
 // IsZero returns whether or the given Node has been initialised or if it's an empty Node struct. IsZero returns true if the Node has been initialised, false if it's an empty struct.
 func (self Node) IsZero() bool {
-	return self.LocalIP == "" && self.GlobalIP == "" && self.Port == 0
+	return self.LocalIP == "" && self.GlobalIP == "" && self.LocalIPv6 == "" && self.GlobalIPv6 == "" && self.Port == 0
 }
 
 // GetIP returns the IP and port that should be used when communicating with a Node, to respect Regions.
+//
+// IPv4 addresses are preferred. The IPv6 address of the other Node is used when it has no IPv4 address for the Region, or when the current Node only has an IPv6 address for the Region.
 func (self Node) GetIP(other Node) string {
+	return self.address(&other)
+}
+
+// address returns the IP and port to use to communicate with other, like GetIP, reading each Node's addresses under its lock, so either can be a Node that's being updated.
+func (self *Node) address(other *Node) string {
+	own, theirs := self.addresses(), other.addresses()
+	ip := ""
+	port := theirs.Port
+	if own.Region == theirs.Region {
+		ip = chooseIP(own.LocalIP, own.LocalIPv6, theirs.LocalIP, theirs.LocalIPv6)
+	} else {
+		ip = chooseIP(own.GlobalIP, own.GlobalIPv6, theirs.GlobalIP, theirs.GlobalIPv6)
+		if theirs.GlobalPort != 0 {
+			port = theirs.GlobalPort
+		}
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// addresses returns a copy of the Node's addressing information and Region, and nothing else, read under its lock.
+func (self *Node) addresses() Node {
+	if self.mutex != nil {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+	}
+	return Node{
+		LocalIP:    self.LocalIP,
+		GlobalIP:   self.GlobalIP,
+		LocalIPv6:  self.LocalIPv6,
+		GlobalIPv6: self.GlobalIPv6,
+		Port:       self.Port,
+		GlobalPort: self.GlobalPort,
+		Region:     self.Region,
+	}
+}
+
+// chooseIP picks which of another Node's addresses to use, based on the address families both Nodes have.
+func chooseIP(ownV4, ownV6, v4, v6 string) string {
+	if v6 == "" {
+		return v4
+	}
+	if v4 == "" || (ownV4 == "" && ownV6 != "") {
+		return v6
+	}
+	return v4
+}
+
+// clone returns a copy of the Node's addressing information, Load, Metadata, Capabilities, PublicKey, Proof, and state table versions, with its own mutex, read under the Node's lock. The proximity of the copy is not set.
+func (self *Node) clone() *Node {
+	if self.mutex != nil {
+		self.mutex.RLock()
+		defer self.mutex.RUnlock()
+	}
+	node := NewNode(self.ID, self.LocalIP, self.GlobalIP, self.Region, self.Port)
+	node.GlobalPort = self.GlobalPort
+	node.LocalIPv6 = self.LocalIPv6
+	node.GlobalIPv6 = self.GlobalIPv6
+	node.Load = self.Load
+	node.Metadata = copyMetadata(self.Metadata)
+	node.Capabilities = copyCapabilities(self.Capabilities)
+	node.PublicKey = self.PublicKey
+	node.Proof = self.Proof
+	node.updateVersions(atomic.LoadUint64(&self.routingTableVersion), atomic.LoadUint64(&self.leafsetVersion), atomic.LoadUint64(&self.neighborhoodSetVersion))
+	return node
+}
+
+func (self *Node) getPort() int {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
 	self.mutex.RLock()
 	defer self.mutex.RUnlock()
-	if other.mutex != nil {
-		other.mutex.RLock()
-		defer other.mutex.RUnlock()
+	return self.Port
+}
+
+func (self *Node) setPort(port int) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
 	}
-	ip := ""
-	if self.Region == other.Region {
-		ip = other.LocalIP
-	} else {
-		ip = other.GlobalIP
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.Port = port
+}
+
+func (self *Node) setGlobalAddress(ip string, port int) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.GlobalIP = ip
+	if port == self.Port {
+		port = 0
 	}
-	ip = ip + ":" + strconv.Itoa(other.Port)
-	return ip
+	self.GlobalPort = port
 }
 
-// Proximity returns the proximity score for the Node, adjusted for the Region. The proximity score of a Node reflects how close it is to the current Node; a lower proximity score means a closer Node. Nodes outside the current Region are penalised by a multiplier.
+// regionPenalty is added to the multiplier of a Node's proximity score for each level of the Region hierarchy it diverges at, so a Node whose Region differs by one level has its score multiplied by 5.
+const regionPenalty = 4
+
+// Proximity returns the proximity score for the Node, adjusted for the Region. The proximity score of a Node reflects how close it is to the current Node; a lower proximity score means a closer Node.
+//
+// Nodes outside the current Region are penalised by a multiplier, which grows with how far up the Region hierarchy the two Regions diverge: a Node in another zone of the same region is penalised less than one on another continent. Regions that aren't written as paths have a single level, and Nodes in a different Region are always penalised the same.
 func (self *Node) Proximity(n *Node) int64 {
 	if n == nil {
 		return -1
@@ -72,10 +173,7 @@ func (self *Node) Proximity(n *Node) int64 {
 	}
 	n.mutex.RLock()
 	defer n.mutex.RUnlock()
-	multiplier := int64(1)
-	if n.Region != self.Region {
-		multiplier = 5
-	}
+	multiplier := int64(1 + regionPenalty*regionDivergence(self.Region, n.Region))
 	score := n.proximity * multiplier
 	return score
 }
@@ -98,6 +196,75 @@ func (self *Node) setProximity(proximity int64) {
 	self.proximity = proximity
 }
 
+func (self *Node) getLoad() int {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return self.Load
+}
+
+func (self *Node) setLoad(load int) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.Load = load
+}
+
+func (self *Node) getMetadata() map[string]string {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	return copyMetadata(self.Metadata)
+}
+
+// setMetadata replaces the Node's Metadata with a copy of metadata. The map is replaced rather than changed, as copies of the Node made for Messages share it.
+func (self *Node) setMetadata(metadata map[string]string) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.Metadata = copyMetadata(metadata)
+}
+
+// HasCapability returns true if the Node advertises the capability.
+func (self *Node) HasCapability(capability string) bool {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+	i := sort.SearchStrings(self.Capabilities, capability)
+	return i < len(self.Capabilities) && self.Capabilities[i] == capability
+}
+
+func (self *Node) setCapabilities(capabilities []string) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.Capabilities = copyCapabilities(capabilities)
+}
+
+// advertise updates the Load, Metadata, and Capabilities of the Node to those it advertised in a Message it sent.
+func (self *Node) advertise(sender Node) {
+	if self.mutex == nil {
+		self.mutex = new(sync.RWMutex)
+	}
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+	self.Load = sender.Load
+	self.Metadata = copyMetadata(sender.Metadata)
+	self.Capabilities = copyCapabilities(sender.Capabilities)
+}
+
 func (self *Node) updateLastHeardFrom() {
 	if self.mutex == nil {
 		self.mutex = new(sync.RWMutex)
@@ -139,3 +306,48 @@ func (self *Node) updateVersions(RTVersion, LSVersion, NSVersion uint64) {
 		self.incrementNSVersion()
 	}
 }
+
+// regionDivergence returns the number of levels of the Region hierarchy at which the two Regions differ: 0 if they're the same, up to the depth of the deeper Region if they differ at the broadest level. Levels are separated by "/".
+func regionDivergence(a, b string) int {
+	if a == b {
+		return 0
+	}
+	left, right := strings.Split(a, "/"), strings.Split(b, "/")
+	depth := len(left)
+	if len(right) > depth {
+		depth = len(right)
+	}
+	common := 0
+	for common < len(left) && common < len(right) && left[common] == right[common] {
+		common++
+	}
+	return depth - common
+}
+
+// copyMetadata returns a copy of metadata, or nil if it's empty.
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// copyCapabilities returns a sorted copy of capabilities without duplicates, or nil if it's empty.
+func copyCapabilities(capabilities []string) []string {
+	if len(capabilities) == 0 {
+		return nil
+	}
+	copied := append([]string{}, capabilities...)
+	sort.Strings(copied)
+	unique := copied[:1]
+	for _, capability := range copied[1:] {
+		if capability != unique[len(unique)-1] {
+			unique = append(unique, capability)
+		}
+	}
+	return unique
+}
@@ -51,3 +51,66 @@ func TestNodeVersionUpdateMixed(t *testing.T) {
 		t.Errorf("Neighborhood Set version was supposed to be %d, was %d instead.", 4, self.neighborhoodSetVersion)
 	}
 }
+
+// Test that GetIP picks the right address family and brackets IPv6 addresses
+func TestNodeGetIPv6(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dual := NewNode(id, "10.0.0.1", "203.0.113.1", "here", 8080)
+	dual.LocalIPv6 = "fd00::1"
+	dual.GlobalIPv6 = "2001:db8::1"
+	v6only := NewNode(id, "", "", "here", 8080)
+	v6only.LocalIPv6 = "fd00::2"
+	v6only.GlobalIPv6 = "2001:db8::2"
+	v4only := NewNode(id, "10.0.0.3", "203.0.113.3", "elsewhere", 8080)
+	cases := []struct {
+		from, to *Node
+		expected string
+	}{
+		{dual, v6only, "[fd00::2]:8080"},
+		{v6only, dual, "[fd00::1]:8080"},
+		{dual, dual, "10.0.0.1:8080"},
+		{v4only, dual, "203.0.113.1:8080"},
+		{dual, v4only, "203.0.113.3:8080"},
+	}
+	for i, c := range cases {
+		if addr := c.from.GetIP(*c.to); addr != c.expected {
+			t.Errorf("Case %d: expected %s, got %s.", i, c.expected, addr)
+		}
+	}
+}
+
+// Test that Nodes are penalised more the further up the Region hierarchy their Regions diverge
+func TestNodeProximityRegions(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	self := NewNode(id, "127.0.0.1", "127.0.0.1", "europe/eu-west-1/eu-west-1a", 8080)
+	cases := []struct {
+		region   string
+		expected int64
+	}{
+		{"europe/eu-west-1/eu-west-1a", 10},
+		{"europe/eu-west-1/eu-west-1b", 50},
+		{"europe/eu-north-1/eu-north-1a", 90},
+		{"america/us-east-1/us-east-1a", 130},
+		{"europe/eu-west-1", 50},
+		{"europe", 90},
+	}
+	for _, c := range cases {
+		other := NewNode(id, "127.0.0.1", "127.0.0.1", c.region, 8080)
+		other.setProximity(10)
+		if score := self.Proximity(other); score != c.expected {
+			t.Errorf("Expected a proximity score of %d for %s, got %d.", c.expected, c.region, score)
+		}
+	}
+	flat := NewNode(id, "127.0.0.1", "127.0.0.1", "here", 8080)
+	other := NewNode(id, "127.0.0.1", "127.0.0.1", "elsewhere", 8080)
+	other.setProximity(10)
+	if score := flat.Proximity(other); score != 50 {
+		t.Errorf("Expected a Node in a different flat Region to be penalised 5 times, got a score of %d.", score)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"math/bits"
 )
 
 const idLen = 32
@@ -137,13 +138,51 @@ func (id NodeID) differences(other NodeID) (NodeID, NodeID) {
 	return d2, d1
 }
 
+// add returns the sum of the two NodeIDs, wrapping around the node space.
+func (id NodeID) add(other NodeID) NodeID {
+	lo, carry := bits.Add64(id[1], other[1], 0)
+	hi, _ := bits.Add64(id[0], other[0], carry)
+	return NodeID{hi, lo}
+}
+
+// sub returns the NodeID minus other, wrapping around the node space. It is the distance from other up to the NodeID.
+func (id NodeID) sub(other NodeID) NodeID {
+	lo, borrow := bits.Sub64(id[1], other[1], 0)
+	hi, _ := bits.Sub64(id[0], other[0], borrow)
+	return NodeID{hi, lo}
+}
+
+// midpoint returns the NodeID halfway between the NodeID and other, counting up from the NodeID and wrapping around the node space.
+func (id NodeID) midpoint(other NodeID) NodeID {
+	d := other.sub(id)
+	return id.add(NodeID{d[0] >> 1, d[1]>>1 | d[0]<<63})
+}
+
 // Diff returns the difference between two NodeIDs as an absolute value. It performs the modular arithmetic necessary to find the shortest distance between the IDs in the (2^128)-1 item nodespace.
+//
+// Diff allocates, so it's meant for display; routing compares distances with distance and absCmp instead.
 func (id NodeID) Diff(other NodeID) *big.Int {
-	d1, d2 := id.differences(other)
-	if d1.absLess(d2) {
-		return d1.Base10()
+	return id.distance(other).Base10()
+}
+
+// distance returns the shortest distance between two NodeIDs around the node space, as a 128-bit number in the form of a NodeID.
+func (id NodeID) distance(other NodeID) NodeID {
+	up, down := other.sub(id), id.sub(other)
+	if up.absLess(down) {
+		return up
 	}
-	return d2.Base10()
+	return down
+}
+
+// absCmp compares two NodeIDs as 128-bit numbers, disregarding modular arithmetic. It returns -1 if id < other, 0 if they're equal, and 1 if id > other.
+func (id NodeID) absCmp(other NodeID) int {
+	if id.absLess(other) {
+		return -1
+	}
+	if id.Equals(other) {
+		return 0
+	}
+	return 1
 }
 
 // RelPos uses modular arithmetic to determine whether the NodeID passed as an argument is to the left of the NodeID it is called on (-1), the same as the NodeID it is called on (0), or to the right of the NodeID it is called on (1) in the circular node space.
@@ -211,6 +250,38 @@ func (id *NodeID) UnmarshalJSON(source []byte) error {
 	return nil
 }
 
+// digitAt returns the ith digit in the NodeID, read as digits of the specified number of bits. If bits doesn't divide 128, the last digit is shorter than the others.
+func (id NodeID) digitAt(i, size int) int {
+	start := uint(i * size)
+	width := uint(size)
+	if start+width > 128 {
+		width = 128 - start
+	}
+	// shift the digit to the top of x; shifting a uint64 by 64 or more yields 0
+	x := id[1] << (start - 64)
+	if start < 64 {
+		x = id[0]<<start | id[1]>>(64-start)
+	}
+	return int(x >> (64 - width))
+}
+
+// commonPrefixLenBits returns the number of leading digits of the specified number of bits that are equal in the two NodeIDs.
+func (id NodeID) commonPrefixLenBits(other NodeID, size int) int {
+	common := bits.LeadingZeros64(id[0] ^ other[0])
+	if common == 64 {
+		common += bits.LeadingZeros64(id[1] ^ other[1])
+	}
+	if common == 128 {
+		return digitCount(size)
+	}
+	return common / size
+}
+
+// digitCount returns the number of digits of the specified number of bits in a NodeID.
+func digitCount(size int) int {
+	return (128 + size - 1) / size
+}
+
 // Digit returns the ith 4-bit digit in the NodeID. If i >= 32, Digit panics.
 func (id NodeID) Digit(i int) byte {
 	if uint(i) >= 32 {
@@ -2,6 +2,7 @@ package wendy
 
 import (
 	"bytes"
+	"math"
 	"math/big"
 	"testing"
 )
@@ -179,6 +180,54 @@ func TestNodeIDIterDigit(t *testing.T) {
 	}
 }
 
+// Make sure digits of any size are read correctly, including a short last digit.
+func TestNodeIDDigitBits(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	for i := 0; i < 32; i++ {
+		if digit := id.digitAt(i, 4); digit != int(id.Digit(i)) {
+			t.Errorf("expected 4-bit digit %d to be %#x, got %#x", i, id.Digit(i), digit)
+		}
+	}
+	for i, b := range []int{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0xfe, 0xdc, 0xba, 0x98, 0x76, 0x54, 0x32, 0x10} {
+		if digit := id.digitAt(i, 8); digit != b {
+			t.Errorf("expected 8-bit digit %d to be %#x, got %#x", i, b, digit)
+		}
+	}
+	// 0x0123456789abcdef starts 00000 00100 10001 10100...
+	for i, expected := range []int{0x00, 0x04, 0x11, 0x14} {
+		if digit := id.digitAt(i, 5); digit != expected {
+			t.Errorf("expected 5-bit digit %d to be %#x, got %#x", i, expected, digit)
+		}
+	}
+	// the 13th 5-bit digit straddles the two halves of the NodeID: the last 4 bits of 0xef, then the first bit of 0xfe
+	if digit := id.digitAt(12, 5); digit != 0x1f {
+		t.Errorf("expected 5-bit digit 12 to be 0x1f, got %#x", digit)
+	}
+	// the last 5-bit digit is only the 3 bits left over: the last 3 bits of 0x10
+	if digit := id.digitAt(25, 5); digit != 0 {
+		t.Errorf("expected 5-bit digit 25 to be 0, got %#x", digit)
+	}
+	other := id
+	other[1] ^= 1
+	if n := id.commonPrefixLenBits(other, 5); n != 25 {
+		t.Errorf("expected 25 common 5-bit digits, got %d", n)
+	}
+	if n := id.commonPrefixLenBits(id, 5); n != digitCount(5) {
+		t.Errorf("expected %d common 5-bit digits, got %d", digitCount(5), n)
+	}
+	other = id
+	other[0] ^= 1 << 60
+	if n := id.commonPrefixLenBits(other, 2); n != 1 {
+		t.Errorf("expected 1 common 2-bit digit, got %d", n)
+	}
+	if n, expected := id.commonPrefixLenBits(other, 4), id.CommonPrefixLen(other); n != expected {
+		t.Errorf("expected %d common 4-bit digits, got %d", expected, n)
+	}
+}
+
 // Make sure an error is thrown if a NodeID is created from less than 32 bytes
 func TestNodeIDFromBytesWithInsufficientBytes(t *testing.T) {
 	bytes := []byte("123456789012345")
@@ -299,3 +348,65 @@ func BenchmarkNodeIDDiff(b *testing.B) {
 		n1.Diff(n2)
 	}
 }
+
+// Make sure distances compare the same way as the differences Diff reports, including across the wrap and halfway around the circle
+func TestNodeIDDistance(t *testing.T) {
+	ids := []NodeID{
+		{0, 0},
+		{0, 1},
+		{0, math.MaxUint64},
+		{1, 0},
+		{1 << 63, 0},
+		{1 << 63, 1},
+		{math.MaxUint64, math.MaxUint64},
+		{0xfdfdfdfdfdfdfdfd, 0xfdfdfdfdfdfdfdfb},
+		{0x0123456789abcdef, 0xfedcba9876543210},
+	}
+	for _, a := range ids {
+		for _, b := range ids {
+			if d := a.distance(b); d.Base10().Cmp(a.Diff(b)) != 0 {
+				t.Errorf("Expected the distance between %s and %s to be %v, got %v.", a, b, a.Diff(b), d.Base10())
+			}
+			for _, c := range ids {
+				if cmp := a.distance(b).absCmp(a.distance(c)); cmp != a.Diff(b).Cmp(a.Diff(c)) {
+					t.Errorf("Expected comparing the distances from %s to %s and %s to give %d, got %d.", a, b, c, a.Diff(b).Cmp(a.Diff(c)), cmp)
+				}
+			}
+		}
+	}
+}
+
+// Quick benchmark to test how expensive finding the distance between nodes is
+func BenchmarkNodeIDDistance(b *testing.B) {
+	b.StopTimer()
+	n1, err := NodeIDFromBytes(make([]byte, 16))
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	n2, err := NodeIDFromBytes([]byte{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255})
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	b.StartTimer()
+
+	for i := 0; i < b.N; i++ {
+		n1.distance(n2)
+	}
+}
+
+// Test that midpoints are found counting up from the first NodeID, wrapping around the node space
+func TestNodeIDMidpoint(t *testing.T) {
+	cases := []struct {
+		from, to, expected NodeID
+	}{
+		{NodeID{0, 0}, NodeID{0, 10}, NodeID{0, 5}},
+		{NodeID{0, 0}, NodeID{1, 0}, NodeID{0, 1 << 63}},
+		{NodeID{0, 10}, NodeID{0, 0}, NodeID{1 << 63, 5}},
+		{NodeID{math.MaxUint64, math.MaxUint64 - 1}, NodeID{0, 2}, NodeID{0, 0}},
+	}
+	for _, c := range cases {
+		if mid := c.from.midpoint(c.to); !mid.Equals(c.expected) {
+			t.Errorf("Expected the midpoint from %s to %s to be %s, got %s.", c.from, c.to, c.expected, mid)
+		}
+	}
+}
@@ -0,0 +1,614 @@
+package wendy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// The handshake patterns a NoiseTransport uses, identified by the byte that starts each handshake.
+const (
+	noiseXX byte = iota // neither Node knows the other's static key
+	noiseIK             // the dialing Node knows the listening Node's static key from an earlier handshake
+)
+
+// noiseMaxMessage is the longest message the Noise framework allows, including its authentication tag.
+const noiseMaxMessage = 65535
+
+const noiseTagSize = 16
+
+// noisePrologue is mixed into every handshake, along with the pattern, so a handshake can't be passed off as one for another protocol or pattern.
+const noisePrologue = "wendy noise"
+
+// noiseIdentityDomain is signed along with a Node's static key, so the signature can't be used for anything else.
+const noiseIdentityDomain = "wendy noise static key:"
+
+var noiseDecryptError = errors.New("A Noise message couldn't be decrypted.")
+var noiseMessageError = errors.New("A Noise handshake message was malformed.")
+var noisePatternError = errors.New("The peer started an unknown Noise handshake pattern.")
+var noiseIdentityError = errors.New("The peer's static key wasn't signed by its identity.")
+var noisePeerError = errors.New("The peer isn't one of the Nodes set with SetPeers.")
+
+// noisePattern describes a Noise handshake: the protocol name, and the tokens in each of its messages, starting with the dialing Node's.
+type noisePattern struct {
+	name     string
+	messages [][]string
+}
+
+var noisePatterns = map[byte]noisePattern{
+	noiseXX: {"Noise_XX_25519_AESGCM_SHA256", [][]string{{"e"}, {"e", "ee", "s", "es"}, {"s", "se"}}},
+	noiseIK: {"Noise_IK_25519_AESGCM_SHA256", [][]string{{"e", "es", "s", "ss"}, {"e", "ee", "se"}}},
+}
+
+// NoiseTransport is an implementation of Transport that sends Messages over TCP connections encrypted and authenticated with the Noise protocol framework, using X25519, AES-256-GCM, and SHA-256. It gives Nodes encrypted sessions without a certificate authority. Each Node proves it holds an Ed25519 identity key, which should be the key set with SetSigningKey, and SetPeers can limit connections to the Nodes whose identity keys derive a known set of NodeIDs. That is the only check made of the identity proven: Dial isn't told which Node it expects to reach, and the Node at the other end of a connection isn't compared with the Sender of the Messages read from it, which may have been forwarded by any number of Nodes. To prove who sent a Message, use SetSigningKey.
+//
+// The first connection to an address uses the XX pattern, in which the Nodes exchange static keys. The listening Node's static key is remembered, and later connections to the address use the IK pattern, which takes one message fewer; if that fails, perhaps because the Node restarted with a new static key, the connection is made again with XX. Either way, each Node signs its static key with its identity key, so no Node can pass as one set with SetPeers without that Node's identity key.
+//
+// A NoiseTransport encrypts connections, but doesn't decide who may join the Cluster: unless SetPeers limits connections to a known set of Nodes, any Node with an identity key can connect, and the Cluster's Credentials should be used to keep strangers out. Every Node in the Cluster must use a NoiseTransport.
+type NoiseTransport struct {
+	static  *ecdh.PrivateKey
+	payload []byte // the identity key and its signature of the static key, sent during each handshake
+	lock    *sync.RWMutex
+	statics map[string]*ecdh.PublicKey // the static keys of the Nodes at the addresses we've dialed
+	peers   map[NodeID]bool
+}
+
+// NewNoiseTransport creates a NoiseTransport that proves the Node's identity with identity, an Ed25519 private key, generating a new static key for the handshakes.
+func NewNoiseTransport(identity ed25519.PrivateKey) (*NoiseTransport, error) {
+	if len(identity) != ed25519.PrivateKeySize {
+		return nil, throwInvalidArgumentError("The identity must be an Ed25519 private key.")
+	}
+	static, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	payload := append([]byte{}, identity.Public().(ed25519.PublicKey)...)
+	payload = append(payload, ed25519.Sign(identity, noiseIdentityMessage(static.PublicKey()))...)
+	return &NoiseTransport{
+		static:  static,
+		payload: payload,
+		lock:    new(sync.RWMutex),
+		statics: map[string]*ecdh.PublicKey{},
+		peers:   map[NodeID]bool{},
+	}, nil
+}
+
+// SetPeers limits the Nodes the NoiseTransport will finish a handshake with, in either direction, to those whose identity keys derive the specified NodeIDs with NodeIDFromPublicKey, replacing any set before. With the XX pattern, a Node that isn't a peer may finish its side of the handshake before it is refused, but nothing it sends is read. Calling SetPeers with no IDs lets any Node connect.
+func (n *NoiseTransport) SetPeers(ids ...NodeID) {
+	peers := map[NodeID]bool{}
+	for _, id := range ids {
+		peers[id] = true
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.peers = peers
+}
+
+// allowed returns true if the Node identified by key may connect.
+func (n *NoiseTransport) allowed(key ed25519.PublicKey) bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return len(n.peers) == 0 || n.peers[NodeIDFromPublicKey(key)]
+}
+
+func (n *NoiseTransport) knownStatic(address string) *ecdh.PublicKey {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.statics[address]
+}
+
+func (n *NoiseTransport) rememberStatic(address string, key *ecdh.PublicKey) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.statics[address] = key
+}
+
+func (n *NoiseTransport) forgetStatic(address string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.statics, address)
+}
+
+// Dial opens a TCP connection to the specified address and completes a Noise handshake over it, giving up if both haven't finished after timeout has elapsed.
+func (n *NoiseTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	known := n.knownStatic(address) != nil
+	conn, err := n.dial(address, deadline)
+	if err != nil && known {
+		// the Node may have a new static key, which IK can't recover from
+		n.forgetStatic(address)
+		conn, err = n.dial(address, deadline)
+	}
+	return conn, err
+}
+
+func (n *NoiseTransport) dial(address string, deadline time.Time) (net.Conn, error) {
+	raw, err := net.DialTimeout("tcp", address, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+	raw.SetDeadline(deadline)
+	conn := &noiseConn{Conn: raw, transport: n, initiator: true, address: address}
+	err = conn.handshake()
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Listen binds a TCP listener to the specified address. The Noise handshake for each connection it accepts is completed on the connection's first Read or Write, within the connection's deadlines.
+func (n *NoiseTransport) Listen(address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return noiseListener{Listener: ln, transport: n}, nil
+}
+
+type noiseListener struct {
+	net.Listener
+	transport *NoiseTransport
+}
+
+func (l noiseListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &noiseConn{Conn: conn, transport: l.transport}, nil
+}
+
+// noiseConn is a connection encrypted with the keys agreed in a Noise handshake. Each message is sent with its length, as two bytes, in front of it.
+type noiseConn struct {
+	net.Conn
+	transport     *NoiseTransport
+	initiator     bool
+	address       string // the address dialed, if the current Node dialed it
+	pattern       byte
+	handshakeLock sync.Mutex
+	handshakeDone bool
+	handshakeErr  error
+	readLock      sync.Mutex
+	writeLock     sync.Mutex
+	send          *noiseCipher
+	recv          *noiseCipher
+	buffered      []byte
+}
+
+// handshake completes the Noise handshake, if it hasn't been already.
+func (c *noiseConn) handshake() error {
+	c.handshakeLock.Lock()
+	defer c.handshakeLock.Unlock()
+	if c.handshakeDone || c.handshakeErr != nil {
+		return c.handshakeErr
+	}
+	c.handshakeErr = c.runHandshake()
+	c.handshakeDone = c.handshakeErr == nil
+	return c.handshakeErr
+}
+
+// runHandshake exchanges handshake messages until the pattern is complete. Every message but the first of XX carries its sender's identity key, signing the static key that was sent, or already known, by then.
+func (c *noiseConn) runHandshake() error {
+	var hs *noiseHandshake
+	var first []byte
+	var err error
+	if c.initiator {
+		rs := c.transport.knownStatic(c.address)
+		c.pattern = noiseXX
+		if rs != nil {
+			c.pattern = noiseIK
+		}
+		hs, err = newNoiseHandshake(c.pattern, true, c.transport.static, rs)
+	} else {
+		first, err = readNoiseFrame(c.Conn)
+		if err != nil {
+			return err
+		}
+		if len(first) < 1 {
+			return noiseMessageError
+		}
+		c.pattern = first[0]
+		first = first[1:]
+		hs, err = newNoiseHandshake(c.pattern, false, c.transport.static, nil)
+	}
+	if err != nil {
+		return err
+	}
+	for step := 0; !hs.done(); step++ {
+		identified := c.pattern != noiseXX || step > 0
+		if (step%2 == 0) == c.initiator {
+			payload := []byte{}
+			if identified {
+				payload = c.transport.payload
+			}
+			msg, err := hs.writeMessage(payload)
+			if err != nil {
+				return err
+			}
+			if step == 0 {
+				msg = append([]byte{c.pattern}, msg...)
+			}
+			err = writeNoiseFrame(c.Conn, msg)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		msg := first
+		if step > 0 {
+			msg, err = readNoiseFrame(c.Conn)
+			if err != nil {
+				return err
+			}
+		}
+		payload, err := hs.readMessage(msg)
+		if err != nil {
+			return err
+		}
+		if !identified {
+			continue
+		}
+		identity, err := noiseVerifyIdentity(payload, hs.rs)
+		if err != nil {
+			return err
+		}
+		if !c.transport.allowed(identity) {
+			return noisePeerError
+		}
+	}
+	c.send, c.recv = hs.symmetric.split()
+	if !c.initiator {
+		c.send, c.recv = c.recv, c.send
+	} else {
+		c.transport.rememberStatic(c.address, hs.rs)
+	}
+	return nil
+}
+
+// Read fulfills the net.Conn interface, completing the handshake if necessary, then decrypting what the other Node sent. Like a TLS connection, a Read with an empty buffer only completes the handshake.
+func (c *noiseConn) Read(b []byte) (int, error) {
+	err := c.handshake()
+	if err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	for len(c.buffered) == 0 {
+		frame, err := readNoiseFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		c.buffered, err = c.recv.open(nil, frame)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.buffered)
+	c.buffered = c.buffered[n:]
+	return n, nil
+}
+
+// Write fulfills the net.Conn interface, completing the handshake if necessary, then encrypting b, in as many messages as it takes.
+func (c *noiseConn) Write(b []byte) (int, error) {
+	err := c.handshake()
+	if err != nil {
+		return 0, err
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > noiseMaxMessage-noiseTagSize {
+			chunk = chunk[:noiseMaxMessage-noiseTagSize]
+		}
+		err = writeNoiseFrame(c.Conn, c.send.seal(nil, chunk))
+		if err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+	return written, nil
+}
+
+func writeNoiseFrame(w io.Writer, msg []byte) error {
+	if len(msg) > noiseMaxMessage {
+		return noiseMessageError
+	}
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readNoiseFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err = io.ReadFull(r, msg)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// noiseIdentityMessage is what a Node signs with its identity key to bind its static key to it.
+func noiseIdentityMessage(static *ecdh.PublicKey) []byte {
+	return append([]byte(noiseIdentityDomain), static.Bytes()...)
+}
+
+// noiseVerifyIdentity returns the identity key in a handshake payload, if its signature of the sender's static key is valid.
+func noiseVerifyIdentity(payload []byte, static *ecdh.PublicKey) (ed25519.PublicKey, error) {
+	if static == nil || len(payload) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, noiseIdentityError
+	}
+	key := ed25519.PublicKey(append([]byte{}, payload[:ed25519.PublicKeySize]...))
+	if !ed25519.Verify(key, noiseIdentityMessage(static), payload[ed25519.PublicKeySize:]) {
+		return nil, noiseIdentityError
+	}
+	return key, nil
+}
+
+// noiseCipher is a Noise CipherState: a key, and the nonce to use with it next. A nil noiseCipher has no key, and passes plaintext through.
+type noiseCipher struct {
+	aead cipher.AEAD
+	n    uint64
+}
+
+func newNoiseCipher(key []byte) *noiseCipher {
+	// keys are always derived with SHA-256, so they're always a valid AES-256 key
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	return &noiseCipher{aead: aead}
+}
+
+// nonce encodes n the way Noise does for AES-GCM: four zero bytes, then n, big-endian.
+func (c *noiseCipher) nonce() []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[4:], c.n)
+	return nonce
+}
+
+func (c *noiseCipher) seal(ad, plaintext []byte) []byte {
+	if c == nil {
+		return append([]byte{}, plaintext...)
+	}
+	ciphertext := c.aead.Seal(nil, c.nonce(), plaintext, ad)
+	c.n++
+	return ciphertext
+}
+
+func (c *noiseCipher) open(ad, ciphertext []byte) ([]byte, error) {
+	if c == nil {
+		return append([]byte{}, ciphertext...), nil
+	}
+	plaintext, err := c.aead.Open(nil, c.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, noiseDecryptError
+	}
+	c.n++
+	return plaintext, nil
+}
+
+// noiseSymmetric is a Noise SymmetricState: the chaining key and handshake hash, and the key derived from them so far.
+type noiseSymmetric struct {
+	ck     []byte
+	h      []byte
+	cipher *noiseCipher
+}
+
+func newNoiseSymmetric(name string) *noiseSymmetric {
+	h := make([]byte, sha256.Size)
+	if len(name) <= sha256.Size {
+		copy(h, name)
+	} else {
+		sum := sha256.Sum256([]byte(name))
+		h = sum[:]
+	}
+	return &noiseSymmetric{ck: append([]byte{}, h...), h: h}
+}
+
+func (s *noiseSymmetric) mixHash(data []byte) {
+	hash := sha256.New()
+	hash.Write(s.h)
+	hash.Write(data)
+	s.h = hash.Sum(nil)
+}
+
+func (s *noiseSymmetric) mixKey(ikm []byte) {
+	var key []byte
+	s.ck, key = noiseHKDF(s.ck, ikm)
+	s.cipher = newNoiseCipher(key)
+}
+
+func (s *noiseSymmetric) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := s.cipher.seal(s.h, plaintext)
+	s.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (s *noiseSymmetric) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.cipher.open(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the ciphers for the dialing Node's messages, and for the listening Node's.
+func (s *noiseSymmetric) split() (*noiseCipher, *noiseCipher) {
+	initiator, responder := noiseHKDF(s.ck, nil)
+	return newNoiseCipher(initiator), newNoiseCipher(responder)
+}
+
+// noiseHKDF derives two keys from the chaining key and input key material, as Noise's HKDF function does.
+func noiseHKDF(ck, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	first := mac.Sum(nil)
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(first)
+	mac.Write([]byte{2})
+	return first, mac.Sum(nil)
+}
+
+// noiseHandshake is a Noise HandshakeState: the keys each Node has, and how far through the pattern the handshake is.
+type noiseHandshake struct {
+	pattern   noisePattern
+	initiator bool
+	symmetric *noiseSymmetric
+	s         *ecdh.PrivateKey
+	e         *ecdh.PrivateKey
+	rs        *ecdh.PublicKey
+	re        *ecdh.PublicKey
+	step      int
+}
+
+// newNoiseHandshake starts a handshake. rs is the listening Node's static key, which the dialing Node must know for IK.
+func newNoiseHandshake(kind byte, initiator bool, s *ecdh.PrivateKey, rs *ecdh.PublicKey) (*noiseHandshake, error) {
+	pattern, ok := noisePatterns[kind]
+	if !ok {
+		return nil, noisePatternError
+	}
+	hs := &noiseHandshake{
+		pattern:   pattern,
+		initiator: initiator,
+		symmetric: newNoiseSymmetric(pattern.name),
+		s:         s,
+		rs:        rs,
+	}
+	hs.symmetric.mixHash(append([]byte(noisePrologue), kind))
+	if kind == noiseIK {
+		// IK's pre-message: the listening Node's static key
+		if initiator {
+			if rs == nil {
+				return nil, noiseMessageError
+			}
+			hs.symmetric.mixHash(rs.Bytes())
+		} else {
+			hs.symmetric.mixHash(s.PublicKey().Bytes())
+		}
+	}
+	return hs, nil
+}
+
+func (hs *noiseHandshake) done() bool {
+	return hs.step >= len(hs.pattern.messages)
+}
+
+// mixDH mixes the result of a Diffie-Hellman token, such as "es", into the key. The first letter names the dialing Node's key, the second the listening Node's.
+func (hs *noiseHandshake) mixDH(token string) error {
+	mine, theirs := token[0], token[1]
+	if !hs.initiator {
+		mine, theirs = theirs, mine
+	}
+	local, remote := hs.s, hs.rs
+	if mine == 'e' {
+		local = hs.e
+	}
+	if theirs == 'e' {
+		remote = hs.re
+	}
+	if local == nil || remote == nil {
+		return noiseMessageError
+	}
+	secret, err := local.ECDH(remote)
+	if err != nil {
+		return err
+	}
+	hs.symmetric.mixKey(secret)
+	return nil
+}
+
+func (hs *noiseHandshake) writeMessage(payload []byte) ([]byte, error) {
+	msg := []byte{}
+	for _, token := range hs.pattern.messages[hs.step] {
+		switch token {
+		case "e":
+			e, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				return nil, err
+			}
+			hs.e = e
+			msg = append(msg, e.PublicKey().Bytes()...)
+			hs.symmetric.mixHash(e.PublicKey().Bytes())
+		case "s":
+			msg = append(msg, hs.symmetric.encryptAndHash(hs.s.PublicKey().Bytes())...)
+		default:
+			err := hs.mixDH(token)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	hs.step++
+	return append(msg, hs.symmetric.encryptAndHash(payload)...), nil
+}
+
+func (hs *noiseHandshake) readMessage(msg []byte) ([]byte, error) {
+	const keySize = 32
+	for _, token := range hs.pattern.messages[hs.step] {
+		switch token {
+		case "e":
+			if len(msg) < keySize {
+				return nil, noiseMessageError
+			}
+			re, err := ecdh.X25519().NewPublicKey(msg[:keySize])
+			if err != nil {
+				return nil, err
+			}
+			hs.re = re
+			hs.symmetric.mixHash(msg[:keySize])
+			msg = msg[keySize:]
+		case "s":
+			size := keySize
+			if hs.symmetric.cipher != nil {
+				size += noiseTagSize
+			}
+			if len(msg) < size {
+				return nil, noiseMessageError
+			}
+			key, err := hs.symmetric.decryptAndHash(msg[:size])
+			if err != nil {
+				return nil, err
+			}
+			rs, err := ecdh.X25519().NewPublicKey(key)
+			if err != nil {
+				return nil, err
+			}
+			hs.rs = rs
+			msg = msg[size:]
+		default:
+			err := hs.mixDH(token)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	hs.step++
+	return hs.symmetric.decryptAndHash(msg)
+}
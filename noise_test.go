@@ -0,0 +1,193 @@
+package wendy
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestNoise creates a NoiseTransport with a new identity key, returning the NodeID it proves
+func newTestNoise(t *testing.T) (*NoiseTransport, NodeID) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport, err := NewNoiseTransport(private)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return transport, NodeIDFromPublicKey(public)
+}
+
+// echoNoise listens with transport, and echoes back whatever is sent on each connection it accepts
+func echoNoise(t *testing.T, transport *NoiseTransport) net.Listener {
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(time.Second))
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// Test that a NoiseTransport encrypts connections, proves each end's identity, and uses IK once it knows the listening Node's static key
+func TestNoiseTransport(t *testing.T) {
+	listening, listeningID := newTestNoise(t)
+	dialing, dialingID := newTestNoise(t)
+	ln := echoNoise(t, listening)
+	defer ln.Close()
+	// the listening Node must prove it is listeningID for the handshakes to finish
+	dialing.SetPeers(listeningID)
+	for _, pattern := range []byte{noiseXX, noiseIK} {
+		conn, err := dialing.Dial(ln.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		noise := conn.(*noiseConn)
+		if noise.pattern != pattern {
+			t.Errorf("Expected handshake pattern %d, got %d.", pattern, noise.pattern)
+		}
+		// more than fits in one Noise message
+		sent := bytes.Repeat([]byte(dialingID.String()), 5000)
+		go conn.Write(sent)
+		received := make([]byte, len(sent))
+		_, err = io.ReadFull(conn, received)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !bytes.Equal(sent, received) {
+			t.Errorf("Expected the echo to match what was sent.")
+		}
+		conn.Close()
+	}
+}
+
+// Test that a NoiseTransport falls back to XX when the listening Node's static key has changed
+func TestNoiseTransportNewStatic(t *testing.T) {
+	listening, _ := newTestNoise(t)
+	dialing, _ := newTestNoise(t)
+	ln := echoNoise(t, listening)
+	defer ln.Close()
+	stale, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dialing.rememberStatic(ln.Addr().String(), stale.PublicKey())
+	conn, err := dialing.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Expected the connection to fall back to XX, got %v.", err)
+	}
+	defer conn.Close()
+	if pattern := conn.(*noiseConn).pattern; pattern != noiseXX {
+		t.Errorf("Expected the XX pattern, got %d.", pattern)
+	}
+	if !dialing.knownStatic(ln.Addr().String()).Equal(listening.static.PublicKey()) {
+		t.Errorf("Expected the listening Node's new static key to be remembered.")
+	}
+}
+
+// Test that Nodes not set with SetPeers can't complete a handshake
+func TestNoiseTransportPeers(t *testing.T) {
+	listening, listeningID := newTestNoise(t)
+	dialing, dialingID := newTestNoise(t)
+	_, strangerID := newTestNoise(t)
+	ln := echoNoise(t, listening)
+	defer ln.Close()
+	listening.SetPeers(strangerID)
+	// with XX, the dialing Node finishes its side of the handshake before the listening Node checks it
+	conn, err := dialing.Dial(ln.Addr().String(), time.Second)
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("hello"))
+		_, err = conn.Read(make([]byte, 5))
+		conn.Close()
+	}
+	if err == nil {
+		t.Errorf("Expected a Node that isn't a peer to be refused.")
+	}
+	listening.SetPeers(strangerID, dialingID)
+	dialing.SetPeers(strangerID)
+	_, err = dialing.Dial(ln.Addr().String(), time.Second)
+	if err != noisePeerError {
+		t.Errorf("Expected the dialing Node to refuse a listening Node that isn't a peer, got %v.", err)
+	}
+	dialing.SetPeers(listeningID)
+	conn, err = dialing.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Expected peers to connect, got %v.", err)
+	}
+	conn.Close()
+}
+
+// Test that a static key is only accepted with a signature from the identity key sent with it
+func TestNoiseVerifyIdentity(t *testing.T) {
+	one, _ := newTestNoise(t)
+	two, _ := newTestNoise(t)
+	key, err := noiseVerifyIdentity(one.payload, one.static.PublicKey())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(key, one.payload[:ed25519.PublicKeySize]) {
+		t.Errorf("Expected the identity key to be returned.")
+	}
+	if _, err = noiseVerifyIdentity(one.payload, two.static.PublicKey()); err != noiseIdentityError {
+		t.Errorf("Expected another Node's static key to be refused, got %v.", err)
+	}
+	if _, err = noiseVerifyIdentity(one.payload[:40], one.static.PublicKey()); err != noiseIdentityError {
+		t.Errorf("Expected a truncated payload to be refused, got %v.", err)
+	}
+}
+
+// Test that Nodes using NoiseTransports can send each other Messages
+func TestClusterNoiseTransport(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneNoise, _ := newTestNoise(t)
+	twoNoise, _ := newTestNoise(t)
+	one.SetTransport(oneNoise)
+	two.SetTransport(twoNoise)
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	for _, value := range []string{"first", "second"} {
+		err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte(value)), two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case msg := <-callback.onDeliver:
+			if string(msg.Value) != value {
+				t.Errorf("Expected %q to be delivered, got %+v.", value, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %q to be delivered.", value)
+		}
+	}
+}
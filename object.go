@@ -0,0 +1,358 @@
+package wendy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var objectQuorumError = errors.New("Too few of the object's replicas stored it to reach a quorum.")
+var objectNotFoundError = errors.New("The object couldn't be found.")
+
+// defaultObjectCacheSize is how many objects an ObjectStore caches, unless SetCacheSize is called.
+const defaultObjectCacheSize = 1024
+
+const (
+	objectInsert = byte(iota) // stores an object as a replica
+	objectLookup              // asks for an object, routed towards its key
+	objectCache               // offers an object to a Node on its lookup's route, to cache
+)
+
+// ObjectKey is the key of an object in an ObjectStore: the SHA-256 hash of its content.
+type ObjectKey [sha256.Size]byte
+
+// String returns the ObjectKey in hexadecimal.
+func (k ObjectKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// NodeID returns the NodeID the object is stored under, and its lookups routed towards: the first 128 bits of its key.
+func (k ObjectKey) NodeID() NodeID {
+	id, _ := NodeIDFromBytes(k[:])
+	return id
+}
+
+// objectCacheEntry is an object cached by an ObjectStore.
+type objectCacheEntry struct {
+	key     ObjectKey
+	content []byte
+}
+
+// ObjectStore is an immutable, content-addressed object store, in the style of PAST. Each object's key is the hash of its content, so objects can't change once they're inserted, and every Node that returns one can be checked. Objects are stored on the Nodes in the ReplicaSet of their key, and lookups are routed through the Cluster towards them. Every Node on the route that has the object, as a replica or in its cache, answers the lookup itself instead of forwarding it, and the Node that answers offers the object to the Nodes the lookup passed through to cache, so the more an object is looked up, the closer to the Nodes looking it up it's cached, and the less its replicas are asked for it. Every Node in the Cluster must create an ObjectStore with the same purpose and number of replicas.
+//
+// Objects are kept in memory, and aren't moved when Nodes join or leave the Cluster, like a KVStore's values. Cached objects are evicted, least recently used first, once the cache is full.
+type ObjectStore struct {
+	cluster   *Cluster
+	purpose   byte
+	replicas  int
+	lock      sync.Mutex
+	objects   map[ObjectKey][]byte // the objects the current Node stores as a replica
+	cached    map[ObjectKey]*list.Element
+	order     *list.List // the cached objects, most recently used first
+	cacheSize int
+}
+
+// NewObjectStore returns an ObjectStore whose objects are stored on replicas Nodes each, and whose Messages have the specified purpose. The purpose is handled like a purpose passed to Handle, so its Messages aren't passed to OnDeliver, and the ObjectStore is registered for EventForward, to answer the lookups routed through the current Node.
+func (c *Cluster) NewObjectStore(purpose byte, replicas int) (*ObjectStore, error) {
+	if replicas < 1 {
+		return nil, throwInvalidArgumentError("An object store must keep at least one replica of each object.")
+	}
+	store := &ObjectStore{
+		cluster:   c,
+		purpose:   purpose,
+		replicas:  replicas,
+		objects:   map[ObjectKey][]byte{},
+		cached:    map[ObjectKey]*list.Element{},
+		order:     list.New(),
+		cacheSize: defaultObjectCacheSize,
+	}
+	err := c.Handle(purpose, store.onMessage)
+	if err != nil {
+		return nil, err
+	}
+	c.RegisterCallbackFor(objectRouter{store: store}, EventForward)
+	return store, nil
+}
+
+// SetCacheSize sets how many objects the current Node caches for lookups, evicting the least recently used objects if there are more. A size of 0 or less disables the cache. The default is 1024.
+func (s *ObjectStore) SetCacheSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cacheSize = size
+	s.trim()
+}
+
+// Insert stores content on its replicas, returning its key once a majority of them have stored it. Inserting content that's already stored stores it again. If ctx has no deadline, Insert waits for up to the network timeout set with SetNetworkTimeout.
+func (s *ObjectStore) Insert(ctx context.Context, content []byte) (ObjectKey, error) {
+	c := s.cluster
+	key := ObjectKey(sha256.Sum256(content))
+	replicas, err := c.ReplicaSet(key.NodeID(), s.replicas)
+	if err != nil {
+		return key, err
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	request := append([]byte{objectInsert}, content...)
+	stored := make(chan bool, len(replicas))
+	for i := range replicas {
+		if replicas[i].ID.Equals(c.self.ID) {
+			s.store(key, content)
+			stored <- true
+			continue
+		}
+		go func(node Node) {
+			_, err := c.requestNode(ctx, c.NewMessage(s.purpose, node.ID, request), &node)
+			if err != nil {
+				c.debug("Replica %s didn't store object %s: %s", node.ID, key, err)
+			}
+			stored <- err == nil
+		}(replicas[i])
+	}
+	count := 0
+	for range replicas {
+		if <-stored {
+			count++
+			if count >= len(replicas)/2+1 {
+				return key, nil
+			}
+		}
+	}
+	return key, objectQuorumError
+}
+
+// Lookup returns the content stored under key. If the current Node doesn't have it, the lookup is routed towards key, and answered by the first Node on the way that has it; if none does, the object's replicas are asked for it directly. Content that doesn't match key is discarded, and the object found is cached by the current Node. If ctx has no deadline, Lookup waits for up to the network timeout set with SetNetworkTimeout.
+func (s *ObjectStore) Lookup(ctx context.Context, key ObjectKey) ([]byte, error) {
+	if content, ok := s.local(key); ok {
+		return content, nil
+	}
+	c := s.cluster
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	request := append([]byte{objectLookup}, key[:]...)
+	msg := c.NewMessage(s.purpose, key.NodeID(), request)
+	msg.RecordPath = true
+	content, err := s.route(ctx, key, msg)
+	if err == nil {
+		return content, nil
+	}
+	c.debug("Routed lookup of object %s failed: %s", key, err)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	replicas, err := c.ReplicaSet(key.NodeID(), s.replicas)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range replicas {
+		if node.ID.Equals(c.self.ID) {
+			continue
+		}
+		reply, err := c.requestNode(ctx, c.NewMessage(s.purpose, node.ID, request), &node)
+		if err != nil {
+			c.debug("Replica %s didn't answer lookup of object %s: %s", node.ID, key, err)
+			continue
+		}
+		content, err := s.found(key, reply.Value)
+		if err == nil {
+			return content, nil
+		}
+	}
+	return nil, objectNotFoundError
+}
+
+// route routes a lookup towards its key, returning the content of the first Node on the way to answer that has it.
+func (s *ObjectStore) route(ctx context.Context, key ObjectKey, msg Message) ([]byte, error) {
+	c := s.cluster
+	id, err := newMessageID()
+	if err != nil {
+		return nil, err
+	}
+	msg.RequestID = id
+	replied, forget := c.awaitReply(id)
+	defer forget()
+	err = c.Send(msg)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.waitReply(ctx, replied)
+	if err != nil {
+		return nil, err
+	}
+	return s.found(key, reply.Value)
+}
+
+// found checks the answer to a lookup, caching and returning the object if it was found, and matches its key.
+func (s *ObjectStore) found(key ObjectKey, answer []byte) ([]byte, error) {
+	if len(answer) < 1 || answer[0] == 0 {
+		return nil, objectNotFoundError
+	}
+	content := answer[1:]
+	if sha256.Sum256(content) != key {
+		s.cluster.warn("Discarding object that doesn't match its key %s", key)
+		return nil, objectNotFoundError
+	}
+	s.cache(key, content)
+	return content, nil
+}
+
+// withTimeout gives ctx the network timeout as a deadline, if it doesn't have one.
+func (s *ObjectStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(s.cluster.getNetworkTimeout())*time.Second)
+}
+
+// local returns the object stored under key, if the current Node has it as a replica or in its cache.
+func (s *ObjectStore) local(key ObjectKey) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if content, ok := s.objects[key]; ok {
+		return content, true
+	}
+	if elem, ok := s.cached[key]; ok {
+		s.order.MoveToFront(elem)
+		return elem.Value.(*objectCacheEntry).content, true
+	}
+	return nil, false
+}
+
+// store stores an object as a replica.
+func (s *ObjectStore) store(key ObjectKey, content []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[key] = content
+}
+
+// cache caches an object, evicting the least recently used objects if the cache is full. Objects the current Node stores as a replica aren't cached.
+func (s *ObjectStore) cache(key ObjectKey, content []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.objects[key]; ok {
+		return
+	}
+	if elem, ok := s.cached[key]; ok {
+		s.order.MoveToFront(elem)
+		return
+	}
+	s.cached[key] = s.order.PushFront(&objectCacheEntry{key: key, content: content})
+	s.trim()
+}
+
+// trim evicts the least recently used objects until the cache is within its size. The caller must hold the lock.
+func (s *ObjectStore) trim() {
+	for s.order.Len() > s.cacheSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.cached, oldest.Value.(*objectCacheEntry).key)
+	}
+}
+
+// onMessage stores the objects sent to the current Node as a replica or to cache, and answers the lookups delivered to it.
+func (s *ObjectStore) onMessage(msg Message) {
+	c := s.cluster
+	if len(msg.InReplyTo) > 0 || len(msg.Value) < 1 {
+		// an answer whose request was given up on
+		return
+	}
+	switch msg.Value[0] {
+	case objectInsert, objectCache:
+		content := msg.Value[1:]
+		key := ObjectKey(sha256.Sum256(content))
+		if msg.Value[0] == objectCache {
+			s.cache(key, content)
+			return
+		}
+		s.store(key, content)
+		err := c.Reply(msg, nil)
+		if err != nil {
+			c.debug("Couldn't acknowledge object %s to %s: %s", key, msg.Sender.ID, err)
+		}
+	case objectLookup:
+		key, ok := lookupKey(msg)
+		if !ok {
+			return
+		}
+		content, found := s.local(key)
+		if !found {
+			err := c.Reply(msg, []byte{0})
+			if err != nil {
+				c.debug("Couldn't answer lookup of object %s from %s: %s", key, msg.Sender.ID, err)
+			}
+			return
+		}
+		s.answer(msg, content)
+	}
+}
+
+// lookupKey returns the key a lookup asks for, or false if the Message isn't a lookup.
+func lookupKey(msg Message) (ObjectKey, bool) {
+	var key ObjectKey
+	if len(msg.Value) != 1+len(key) || msg.Value[0] != objectLookup {
+		return key, false
+	}
+	copy(key[:], msg.Value[1:])
+	return key, true
+}
+
+// answer answers a lookup with the object, and offers it to the Nodes the lookup passed through on the way to the current Node to cache. The Node that sent the lookup caches the object itself.
+func (s *ObjectStore) answer(msg Message, content []byte) {
+	c := s.cluster
+	err := c.Reply(msg, append([]byte{1}, content...))
+	if err != nil {
+		c.debug("Couldn't answer lookup from %s: %s", msg.Sender.ID, err)
+	}
+	offer := append([]byte{objectCache}, content...)
+	for _, hop := range msg.Path {
+		if hop.ID.Equals(c.self.ID) || hop.ID.Equals(msg.Sender.ID) {
+			continue
+		}
+		node, err := c.get(hop.ID)
+		if err != nil || node == nil {
+			continue
+		}
+		cache := c.NewMessage(s.purpose, node.ID, offer)
+		cache.Direct = true
+		err = c.send(cache, node)
+		if err != nil {
+			c.debug("Couldn't offer object to %s to cache: %s", node.ID, err)
+		}
+	}
+}
+
+// objectRouter is the Application an ObjectStore registers for EventForward, to answer the lookups routed through the current Node for objects it has, instead of forwarding them.
+type objectRouter struct {
+	store *ObjectStore
+}
+
+func (r objectRouter) OnForward(msg *Message, next NodeID) bool {
+	if msg.Purpose != r.store.purpose {
+		return true
+	}
+	key, ok := lookupKey(*msg)
+	if !ok {
+		return true
+	}
+	content, found := r.store.local(key)
+	if !found {
+		return true
+	}
+	r.store.cluster.debug("Answering lookup of object %s instead of forwarding it to %s", key, next)
+	go r.store.answer(*msg, content)
+	return false
+}
+
+// The rest of the Application interface goes unused, as the objectRouter is only registered for EventForward.
+func (r objectRouter) OnError(err error)           {}
+func (r objectRouter) OnDeliver(msg Message)       {}
+func (r objectRouter) OnNewLeaves(leafset []*Node) {}
+func (r objectRouter) OnNodeJoin(node Node)        {}
+func (r objectRouter) OnNodeExit(node Node)        {}
+func (r objectRouter) OnHeartbeat(node Node)       {}
@@ -0,0 +1,143 @@
+package wendy
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// Test that a Node on its own stores objects under the hash of their content, and caches only up to its cache size
+func TestObjectStoreLocal(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	store, err := cluster.NewObjectStore(FirstUserPurpose, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx := context.Background()
+	key, err := store.Insert(ctx, []byte("content"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if key != ObjectKey(sha256.Sum256([]byte("content"))) {
+		t.Errorf("Expected the key to be the hash of the content, got %s.", key)
+	}
+	content, err := store.Lookup(ctx, key)
+	if err != nil || string(content) != "content" {
+		t.Errorf("Expected content, got %s, %v.", content, err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err = store.Lookup(ctx, ObjectKey(sha256.Sum256([]byte("missing"))))
+	if err != objectNotFoundError {
+		t.Errorf("Expected %v looking up an object that was never inserted, got %v.", objectNotFoundError, err)
+	}
+	_, err = store.found(key, append([]byte{1}, "forged"...))
+	if err != objectNotFoundError {
+		t.Errorf("Expected %v for content that doesn't match its key, got %v.", objectNotFoundError, err)
+	}
+	store.SetCacheSize(2)
+	for _, content := range []string{"a", "b", "c"} {
+		store.cache(ObjectKey(sha256.Sum256([]byte(content))), []byte(content))
+	}
+	if _, ok := store.local(ObjectKey(sha256.Sum256([]byte("a")))); ok {
+		t.Errorf("Expected the least recently used object to be evicted.")
+	}
+	if _, ok := store.local(ObjectKey(sha256.Sum256([]byte("c")))); !ok {
+		t.Errorf("Expected the latest object to be cached.")
+	}
+	_, err = cluster.NewObjectStore(FirstUserPurpose+1, 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a store without replicas, got %v.", err)
+	}
+}
+
+// Test that lookups find objects on other Nodes, cache them, and are answered by Nodes on their route that have them
+func TestClusterObjectStore(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	clusters := []*Cluster{}
+	stores := map[NodeID]*ObjectStore{}
+	for _, seed := range []string{"this is a test Node for testing purposes only.", "this is some other Node for testing purposes only.", "this is a third Node for testing purposes only."} {
+		cluster, err := makeCluster(seed)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		store, err := cluster.NewObjectStore(FirstUserPurpose, 1)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		go cluster.Listen()
+		defer cluster.Kill()
+		clusters = append(clusters, cluster)
+		stores[cluster.self.ID] = store
+	}
+	waitListening(t, clusters...)
+	for _, cluster := range clusters {
+		for _, other := range clusters {
+			if other != cluster {
+				err := cluster.insert(*other.self, StateMask{Mask: all})
+				if err != nil {
+					t.Fatalf(err.Error())
+				}
+			}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	key, err := stores[clusters[0].self.ID].Insert(ctx, []byte("content"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	replicas, err := clusters[0].ReplicaSet(key.NodeID(), 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	root := stores[replicas[0].ID]
+	var requester, middle *ObjectStore
+	for id, store := range stores {
+		if id.Equals(replicas[0].ID) {
+			continue
+		}
+		if requester == nil {
+			requester = store
+		} else {
+			middle = store
+		}
+	}
+	content, err := requester.Lookup(ctx, key)
+	if err != nil || string(content) != "content" {
+		t.Fatalf("Expected content, got %s, %v.", content, err)
+	}
+	if _, ok := requester.local(key); !ok {
+		t.Errorf("Expected the Node that looked the object up to cache it.")
+	}
+	for _, cluster := range clusters {
+		cluster.lock.RLock()
+		remembered := len(cluster.delivered)
+		cluster.lock.RUnlock()
+		if remembered > 0 {
+			t.Errorf("Expected %s not to remember lookups for the dedup window, remembered %d.", cluster.self.ID, remembered)
+		}
+	}
+	// the root answers a lookup that passed through the middle Node, which is offered the object to cache
+	lookup := requester.cluster.NewMessage(FirstUserPurpose, key.NodeID(), append([]byte{objectLookup}, key[:]...))
+	lookup.Path = []TraceHop{{ID: requester.cluster.self.ID}, {ID: middle.cluster.self.ID}, {ID: root.cluster.self.ID}}
+	root.answer(lookup, content)
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := middle.local(key); !ok {
+		t.Errorf("Expected the Node on the lookup's route to cache the object.")
+	}
+	// the middle Node answers lookups routed through it, instead of forwarding them
+	if (objectRouter{store: middle}).OnForward(&lookup, root.cluster.self.ID) {
+		t.Errorf("Expected a Node with the object cached not to forward its lookup.")
+	}
+	other := requester.cluster.NewMessage(FirstUserPurpose, key.NodeID(), []byte{objectLookup})
+	if !(objectRouter{store: middle}).OnForward(&other, root.cluster.self.ID) {
+		t.Errorf("Expected a Message that isn't a lookup to be forwarded.")
+	}
+}
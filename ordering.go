@@ -0,0 +1,190 @@
+package wendy
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReorderWait = 5 * time.Second
+
+// orderedIdle is how long a stream of ordered Messages can go unused before it's forgotten, and its sequence numbers start again from 1.
+const orderedIdle = 10 * time.Minute
+
+// sendSequence numbers the ordered Messages sent to a key.
+type sendSequence struct {
+	last uint64
+	used time.Time
+}
+
+// orderedStream holds the ordered Messages from one Sender to one key that arrived before the Messages sent ahead of them.
+type orderedStream struct {
+	lock    sync.Mutex
+	next    uint64             // the sequence number of the next Message to deliver
+	waiting map[uint64]Message // the Messages that arrived early, by sequence number
+	timer   *time.Timer        // gives up on the missing Messages if they take too long
+	wait    uint64             // counts the waits timer was started for, so a timer that fires late can tell it's been replaced
+	used    time.Time          // guarded by the Cluster's lock, rather than the stream's
+}
+
+// SetOrderedDelivery sets whether Messages sent with Send and SendReliable are numbered, so the Node each is delivered to can deliver the Messages from the current Node to the same key in the order they were sent. Messages can overtake each other when they're routed along different paths or resent, so the Node they're delivered to holds on to any that arrive early until the Messages sent before them arrive, for up to wait. After that, it gives up on the missing Messages, counts them in Stats, and delivers the rest; any that turn up later are delivered as they arrive. If wait is zero, it is 5 seconds.
+//
+// Every Node delivers numbered Messages in order, whether it numbers its own or not, using the wait it was set with. Ordered delivery is disabled by default.
+func (c *Cluster) SetOrderedDelivery(ordered bool, wait time.Duration) {
+	c.lock.Lock()
+	c.orderedDelivery = ordered
+	c.reorderWait = wait
+	c.lock.Unlock()
+	for _, v := range c.getVirtualNodes() {
+		v.SetOrderedDelivery(ordered, wait)
+	}
+}
+
+func (c *Cluster) getOrderedDelivery() (bool, time.Duration) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.reorderWait <= 0 {
+		return c.orderedDelivery, defaultReorderWait
+	}
+	return c.orderedDelivery, c.reorderWait
+}
+
+// sequence numbers msg, if ordered delivery is enabled, with the next sequence number for its key.
+func (c *Cluster) sequence(msg Message) Message {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.orderedDelivery {
+		return msg
+	}
+	if c.sequences == nil {
+		c.sequences = map[NodeID]*sendSequence{}
+	}
+	seq, ok := c.sequences[msg.Key]
+	if !ok || now.Sub(seq.used) > orderedIdle {
+		for key, s := range c.sequences {
+			if now.Sub(s.used) > orderedIdle {
+				delete(c.sequences, key)
+			}
+		}
+		seq = &sendSequence{}
+		c.sequences[msg.Key] = seq
+	}
+	seq.last++
+	seq.used = now
+	msg.Seq = seq.last
+	return msg
+}
+
+// getOrderedStream returns the stream msg belongs to, forgetting streams that have gone unused.
+func (c *Cluster) getOrderedStream(msg Message) *orderedStream {
+	key := msg.Sender.ID.String() + "/" + msg.Key.String()
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.streams == nil {
+		c.streams = map[string]*orderedStream{}
+	}
+	stream, ok := c.streams[key]
+	if !ok || now.Sub(stream.used) > orderedIdle {
+		for k, s := range c.streams {
+			if now.Sub(s.used) > orderedIdle {
+				delete(c.streams, k)
+			}
+		}
+		stream = &orderedStream{next: 1, waiting: map[uint64]Message{}}
+		c.streams[key] = stream
+	}
+	stream.used = now
+	return stream
+}
+
+// reorder delivers a numbered Message once the Messages sent to its key before it, by the same Sender, have been delivered.
+func (c *Cluster) reorder(msg Message) {
+	stream := c.getOrderedStream(msg)
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if msg.Seq == 1 && stream.next > 1 {
+		// the Sender started numbering again, so nothing still waiting will be followed
+		c.debug("Ordered messages from %s to %s started again.", msg.Sender.ID, msg.Key)
+		c.flushWaiting(stream)
+		stream.next = 1
+	}
+	if msg.Seq < stream.next {
+		c.debug("Delivering message %s from %s out of order: the messages sent after it were already delivered.", msg.Key, msg.Sender.ID)
+		c.deliverNow(msg)
+		return
+	}
+	stream.waiting[msg.Seq] = msg
+	c.deliverWaiting(stream)
+}
+
+// deliverWaiting delivers the waiting Messages that are next in order, and starts the wait for the Messages still missing, if any. The stream's lock must be held.
+func (c *Cluster) deliverWaiting(stream *orderedStream) {
+	progress := false
+	for {
+		msg, ok := stream.waiting[stream.next]
+		if !ok {
+			break
+		}
+		delete(stream.waiting, stream.next)
+		stream.next++
+		progress = true
+		c.deliverNow(msg)
+	}
+	if stream.timer != nil && (progress || len(stream.waiting) < 1) {
+		stream.timer.Stop()
+		stream.timer = nil
+	}
+	if len(stream.waiting) < 1 || stream.timer != nil {
+		return
+	}
+	_, wait := c.getOrderedDelivery()
+	stream.wait++
+	started := stream.wait
+	stream.timer = time.AfterFunc(wait, func() {
+		c.skipMissing(stream, started)
+	})
+}
+
+// skipMissing gives up on the Messages a stream is waiting for, delivering those that arrived after them.
+func (c *Cluster) skipMissing(stream *orderedStream, wait uint64) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.timer == nil || stream.wait != wait {
+		// the Messages arrived, or the wait started again, while the timer was firing
+		return
+	}
+	stream.timer = nil
+	first := uint64(0)
+	for seq := range stream.waiting {
+		if first == 0 || seq < first {
+			first = seq
+		}
+	}
+	if first == 0 {
+		return
+	}
+	atomic.AddUint64(&c.stats.SkippedMessages, first-stream.next)
+	c.warn("Gave up waiting for %d ordered messages.", first-stream.next)
+	stream.next = first
+	c.deliverWaiting(stream)
+}
+
+// flushWaiting delivers every waiting Message in order, without waiting for the Messages missing between them. The stream's lock must be held.
+func (c *Cluster) flushWaiting(stream *orderedStream) {
+	if stream.timer != nil {
+		stream.timer.Stop()
+		stream.timer = nil
+	}
+	seqs := make([]uint64, 0, len(stream.waiting))
+	for seq := range stream.waiting {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	for _, seq := range seqs {
+		c.deliverNow(stream.waiting[seq])
+		delete(stream.waiting, seq)
+	}
+}
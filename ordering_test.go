@@ -0,0 +1,123 @@
+package wendy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test that ordered Messages are numbered separately for each key, and only while ordered delivery is enabled
+func TestSequence(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if seq := cluster.sequence(cluster.NewMessage(FirstUserPurpose, other, nil)).Seq; seq != 0 {
+		t.Errorf("Expected Messages not to be numbered by default, got %d.", seq)
+	}
+	cluster.SetOrderedDelivery(true, 0)
+	for i, key := range []NodeID{other, other, cluster.self.ID, other} {
+		expected := []uint64{1, 2, 1, 3}[i]
+		if seq := cluster.sequence(cluster.NewMessage(FirstUserPurpose, key, nil)).Seq; seq != expected {
+			t.Errorf("Expected Message %d to be numbered %d, got %d.", i, expected, seq)
+		}
+	}
+}
+
+// Test that numbered Messages are delivered in the order they were sent, giving up on those that don't arrive in time
+func TestReorder(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sender, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	cluster.RegisterCallback(callback)
+	cluster.SetOrderedDelivery(false, 50*time.Millisecond)
+	send := func(seqs ...uint64) {
+		for _, seq := range seqs {
+			msg := sender.NewMessage(FirstUserPurpose, cluster.self.ID, []byte(strconv.FormatUint(seq, 10)))
+			msg.Seq = seq
+			cluster.deliver(msg)
+		}
+	}
+	expect := func(values ...string) {
+		for _, value := range values {
+			select {
+			case msg := <-callback.onDeliver:
+				if string(msg.Value) != value {
+					t.Fatalf("Expected Message %s to be delivered, got %s.", value, msg.Value)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for Message %s to be delivered.", value)
+			}
+		}
+	}
+	send(2, 3)
+	select {
+	case msg := <-callback.onDeliver:
+		t.Fatalf("Expected Messages to wait for the Message sent before them, got %s.", msg.Value)
+	case <-time.After(10 * time.Millisecond):
+	}
+	send(1)
+	expect("1", "2", "3")
+	// 4 goes missing, and is given up on
+	send(5)
+	expect("5")
+	if skipped := cluster.Stats().SkippedMessages; skipped != 1 {
+		t.Errorf("Expected 1 skipped Message, got %d.", skipped)
+	}
+	send(4)
+	expect("4")
+	// the sender started numbering again
+	send(7, 1)
+	expect("7", "1")
+}
+
+// Test that Messages sent with ordered delivery arrive in order
+func TestClusterOrderedDelivery(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetOrderedDelivery(true, 0)
+	for i := 0; i < 5; i++ {
+		err = two.Send(two.NewMessage(FirstUserPurpose, one.self.ID, []byte(strconv.Itoa(i))))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for i := 0; i < 5; i++ {
+		select {
+		case msg := <-callback.onDeliver:
+			if string(msg.Value) != strconv.Itoa(i) || msg.Seq != uint64(i+1) {
+				t.Errorf("Expected Message %d, numbered %d, got %s, numbered %d.", i, i+1, msg.Value, msg.Seq)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for Message %d to be delivered.", i)
+		}
+	}
+}
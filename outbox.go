@@ -0,0 +1,212 @@
+package wendy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// outboxExtension is the extension of the files a FileOutbox holds each Node's Messages in.
+const outboxExtension = ".outbox"
+
+// Outbox is an interface that can be fulfilled to hold the Messages sent straight to a Node, with Multicast or Reply, while that Node can't be reached, so they can be sent once it's heard from again instead of being lost.
+//
+// Hold is called with each Message that couldn't be sent, and the ID of the Node it was sent to. Release removes and returns every Message held for a Node, in the order they were held, or an empty slice if there are none. Held returns the IDs of the Nodes that have Messages held for them, so a Node that restarts with a durable Outbox carries on where it left off.
+type Outbox interface {
+	Hold(id NodeID, msg Message) error
+	Release(id NodeID) ([]Message, error)
+	Held() ([]NodeID, error)
+}
+
+// FileOutbox is an implementation of Outbox that keeps the Messages held for each Node in a file of its own, as JSON, one per line, so they survive the Node restarting.
+type FileOutbox struct {
+	lock sync.Mutex
+	dir  string
+}
+
+// OpenFileOutbox returns a FileOutbox that keeps its files in the directory at path, creating it, readable only by its owner, if it doesn't exist.
+func OpenFileOutbox(path string) (*FileOutbox, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileOutbox{dir: path}, nil
+}
+
+func (f *FileOutbox) path(id NodeID) string {
+	return filepath.Join(f.dir, id.String()+outboxExtension)
+}
+
+// Hold fulfills the Outbox interface, appending the Message to the Node's file.
+func (f *FileOutbox) Hold(id NodeID, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	file, err := os.OpenFile(f.path(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Release fulfills the Outbox interface, reading the Messages from the Node's file, and removing it.
+func (f *FileOutbox) Release(id NodeID) ([]Message, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	data, err := os.ReadFile(f.path(id))
+	if os.IsNotExist(err) {
+		return []Message{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	msgs := []Message{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var msg Message
+		err = json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return msgs, os.Remove(f.path(id))
+}
+
+// Held fulfills the Outbox interface, listing the Nodes that have a file in the directory.
+func (f *FileOutbox) Held() ([]NodeID, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []NodeID{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, outboxExtension) {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimSuffix(name, outboxExtension))
+		if err != nil {
+			continue
+		}
+		id, err := NodeIDFromBytes(raw)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SetOutbox sets the Outbox that Messages sent straight to a Node, with Multicast or Reply, are held in when the Node doesn't respond. Instead of being reported as missed, they're sent once the Node is heard from again, when it sends the current Node a Message or answers one. Messages that have expired by then are discarded. Routed Messages aren't held, because they're routed around Nodes that don't respond. By default, there is no Outbox, and the Messages are lost.
+//
+// The Nodes that already have Messages held for them in the Outbox are looked up, and any error doing so is returned. Passing nil removes the Outbox, without releasing the Messages in it.
+func (c *Cluster) SetOutbox(outbox Outbox) error {
+	held := map[NodeID]bool{}
+	if outbox != nil {
+		ids, err := outbox.Held()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			held[id] = true
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.outbox = outbox
+	c.outboxed = held
+	return nil
+}
+
+func (c *Cluster) getOutbox() Outbox {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.outbox
+}
+
+// hold holds a Message for a Node that didn't respond in the Outbox, returning false if there is no Outbox, or it couldn't hold the Message.
+func (c *Cluster) hold(node *Node, msg Message) bool {
+	outbox := c.getOutbox()
+	if outbox == nil {
+		return false
+	}
+	err := outbox.Hold(node.ID, msg)
+	if err != nil {
+		c.fanOutError(err)
+		return false
+	}
+	c.lock.Lock()
+	c.outboxed[node.ID] = true
+	c.lock.Unlock()
+	atomic.AddUint64(&c.stats.HeldMessages, 1)
+	c.debug("Holding message %s for %s until it's heard from.", msg.Key, node.ID)
+	return true
+}
+
+// holding returns whether the Outbox has Messages held for the Node.
+func (c *Cluster) holding(id NodeID) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.outboxed[id]
+}
+
+// releaseOutbox sends the Messages held for a Node that has been heard from. If it stops responding again, the Messages that weren't sent are held again.
+func (c *Cluster) releaseOutbox(node Node) {
+	outbox := c.getOutbox()
+	c.lock.Lock()
+	if outbox == nil || !c.outboxed[node.ID] {
+		c.lock.Unlock()
+		return
+	}
+	delete(c.outboxed, node.ID)
+	c.lock.Unlock()
+	msgs, err := outbox.Release(node.ID)
+	if err != nil {
+		c.lock.Lock()
+		c.outboxed[node.ID] = true
+		c.lock.Unlock()
+		c.fanOutError(err)
+		return
+	}
+	for i, msg := range msgs {
+		if msg.expired() {
+			c.deadLetter(msg, DeadLetterExpired, messageExpiredError)
+			continue
+		}
+		err = c.send(msg, &node)
+		if err == deadNodeError {
+			for _, unsent := range msgs[i:] {
+				if !c.hold(&node, unsent) {
+					c.deadLetter(unsent, DeadLetterUnreachable, err)
+				}
+			}
+			return
+		}
+		if err != nil {
+			c.debug("Couldn't send held message %s to %s: %s", msg.Key, node.ID, err)
+			c.deadLetter(msg, DeadLetterUnreachable, err)
+		}
+	}
+}
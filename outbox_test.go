@@ -0,0 +1,129 @@
+package wendy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a FileOutbox releases the Messages held for each Node, in the order they were held
+func TestFileOutbox(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	other, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	outbox, err := OpenFileOutbox(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, value := range []string{"first", "second"} {
+		msg := cluster.NewMessage(FirstUserPurpose, other, []byte(value))
+		msg.Headers = map[string]string{"trace-id": value}
+		err = outbox.Hold(other, msg)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	err = outbox.Hold(cluster.self.ID, cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("third")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	held, err := outbox.Held()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(held) != 2 {
+		t.Errorf("Expected Messages to be held for 2 Nodes, got %v.", held)
+	}
+	msgs, err := outbox.Release(other)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("Expected 2 Messages to be released, got %d.", len(msgs))
+	}
+	for i, value := range []string{"first", "second"} {
+		if string(msgs[i].Value) != value || msgs[i].Headers["trace-id"] != value || !msgs[i].Sender.ID.Equals(cluster.self.ID) {
+			t.Errorf("Expected Message %d to be %s, got %+v.", i, value, msgs[i])
+		}
+	}
+	msgs, err = outbox.Release(other)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(msgs) != 0 {
+		t.Errorf("Expected no Messages once they were released, got %d.", len(msgs))
+	}
+	held, err = outbox.Held()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(held) != 1 || !held[0].Equals(cluster.self.ID) {
+		t.Errorf("Expected Messages to be held for %s only, got %v.", cluster.self.ID, held)
+	}
+}
+
+// Test that Messages multicast to a Node that doesn't respond are sent once it's heard from
+func TestClusterOutbox(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	outbox, err := OpenFileOutbox(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.SetOutbox(outbox)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go two.Listen()
+	defer two.Kill()
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// one isn't listening yet, so the Message is held for it
+	err = two.Multicast([]NodeID{one.self.ID}, two.NewMessage(FirstUserPurpose, one.self.ID, []byte("held")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if held := two.Stats().HeldMessages; held != 1 {
+		t.Errorf("Expected 1 held Message, got %d.", held)
+	}
+	go one.Listen()
+	defer one.Kill()
+	time.Sleep(10 * time.Millisecond)
+	err = one.send(one.NewMessage(HEARTBEAT, two.self.ID, []byte{}), two.self)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "held" {
+			t.Errorf("Expected the held Message to be delivered, got %s.", msg.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the held Message to be delivered.")
+	}
+	held, err := outbox.Held()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(held) != 0 {
+		t.Errorf("Expected no Messages to be held once they were sent, got %v.", held)
+	}
+}
@@ -0,0 +1,33 @@
+package wendy
+
+// AddNode inserts a Node into the current Node's state tables, for operators and orchestration tooling that know of a peer before the protocol discovers it. The Node is inserted into each table it belongs in, measuring its proximity first, just as if another Node had listed it; the Node itself only learns of the current Node when it's next sent a Message. A Node removed recently is put back straight away rather than being checked on first, but banned Nodes can't be added.
+func (c *Cluster) AddNode(node Node) error {
+	if node.IsZero() {
+		return throwInvalidArgumentError("The Node must have an ID.")
+	}
+	if node.ID.Equals(c.self.ID) {
+		return throwInvalidArgumentError("A Node can't add itself.")
+	}
+	if c.banned(node.ID) {
+		return throwInvalidArgumentError("The Node is banned.")
+	}
+	c.release(node.ID)
+	return c.insert(*node.clone(), StateMask{Mask: all})
+}
+
+// EvictNode removes the Node with the specified ID from the current Node's state tables and repairs them, for operators and orchestration tooling that know a peer is misbehaving or gone before the protocol notices. Applications are told it exited with ExitEvicted. The Node is quarantined as if it had stopped responding, so state tables sent by other Nodes don't put it straight back; to keep it out for longer, use BanNode. An error is returned if the Node isn't in the state tables; errors repairing the state tables are passed to OnError instead.
+func (c *Cluster) EvictNode(id NodeID) error {
+	if id.Equals(c.self.ID) {
+		return throwInvalidArgumentError("A Node can't evict itself.")
+	}
+	_, err := c.get(id)
+	if err != nil {
+		return err
+	}
+	c.warn("Evicting node %s.", id)
+	err = c.remove(id, ExitEvicted)
+	if err != nil && err != nodeNotFoundError {
+		c.fanOutError(err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that AddNode inserts a Node into the state tables, even if it was removed recently, but refuses the current Node and banned Nodes
+func TestClusterAddNode(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetProbation(time.Minute)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	err = cluster.AddNode(*node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nil {
+		t.Errorf("Expected the Node to be added to the leaf set, got %v.", err)
+	}
+	cluster.remove(id, ExitTimeout)
+	err = cluster.AddNode(*node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nil {
+		t.Errorf("Expected a quarantined Node to be added straight away, got %v.", err)
+	}
+	err = cluster.AddNode(*cluster.self)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError adding the Node itself, got %v.", err)
+	}
+	cluster.BanNode(id, time.Minute)
+	err = cluster.AddNode(*node)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError adding a banned Node, got %v.", err)
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a banned Node not to be added, got %v.", err)
+	}
+}
+
+// Test that EvictNode removes a Node from every state table and quarantines it, and fails for Nodes that aren't in them
+func TestClusterEvictNode(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetProbation(time.Minute)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.EvictNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected %v evicting an unknown Node, got %v.", nodeNotFoundError, err)
+	}
+	err = cluster.AddNode(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.EvictNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.get(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected the Node to be removed from every state table, got %v.", err)
+	}
+	if !cluster.quarantined(id) {
+		t.Errorf("Expected an evicted Node to be quarantined.")
+	}
+	err = cluster.EvictNode(cluster.self.ID)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError evicting the Node itself, got %v.", err)
+	}
+}
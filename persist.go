@@ -0,0 +1,159 @@
+package wendy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var noSavedStateError = errors.New("No saved state to warm-start from.")
+var noLivePeersError = errors.New("None of the saved Nodes responded.")
+
+// StateStore is an interface that can be fulfilled to persist the Nodes in a Cluster's state tables, so a Node that restarts can warm-start from the Nodes it knew of instead of joining from scratch.
+//
+// SaveState is called with every Node in the state tables after each heartbeat sweep, and when the Cluster is stopped, as long as the state tables aren't empty. LoadState returns the Nodes last saved, or an empty slice if none have been.
+type StateStore interface {
+	SaveState(nodes []Node) error
+	LoadState() ([]Node, error)
+}
+
+// FileStateStore is an implementation of StateStore that keeps the Nodes as JSON in the file at the path it holds. The file is replaced atomically, so a crash while saving leaves the previous state intact.
+type FileStateStore string
+
+// SaveState writes the Nodes to the file, replacing its contents.
+func (f FileStateStore) SaveState(nodes []Node) error {
+	data, err := json.Marshal(nodes)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(string(f)), filepath.Base(string(f))+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), string(f))
+}
+
+// LoadState reads the Nodes from the file. If the file doesn't exist, no Nodes are returned.
+func (f FileStateStore) LoadState() ([]Node, error) {
+	data, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return []Node{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{}
+	err = json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// SetStateStore sets the StateStore the Cluster saves its state tables to, and warm-starts from with WarmStart. By default, state tables are not saved.
+func (c *Cluster) SetStateStore(store StateStore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stateStore = store
+}
+
+func (c *Cluster) getStateStore() StateStore {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.stateStore
+}
+
+// WarmStart joins the Cluster through the Nodes saved in the StateStore set with SetStateStore, instead of joining from scratch. Each saved Node is sent a heartbeat to check that it is still alive; those that respond are inserted into the state tables, and the Node announces its presence to them. The Cluster must be listening.
+//
+// An error is returned if there is no saved state, or if none of the saved Nodes respond, in which case the Node should join through its seeds instead.
+func (c *Cluster) WarmStart() error {
+	store := c.getStateStore()
+	if store == nil {
+		return noSavedStateError
+	}
+	nodes, err := store.LoadState()
+	if err != nil {
+		return err
+	}
+	msg := c.NewMessage(HEARTBEAT, c.self.ID, []byte{})
+	sent := map[NodeID]<-chan error{}
+	for _, node := range nodes {
+		if node.IsZero() || node.ID.Equals(c.self.ID) {
+			continue
+		}
+		if _, set := sent[node.ID]; set {
+			continue
+		}
+		c.debug("Verifying saved node %s", node.ID)
+		sent[node.ID] = c.sendAsync(msg, node.clone())
+	}
+	if len(sent) == 0 {
+		return noSavedStateError
+	}
+	live := 0
+	for _, node := range nodes {
+		result, ok := sent[node.ID]
+		if !ok {
+			continue
+		}
+		delete(sent, node.ID)
+		err = <-result
+		if err == deadNodeError {
+			c.debug("Saved node %s is gone.", node.ID)
+			continue
+		}
+		if err != nil {
+			c.fanOutError(err)
+			continue
+		}
+		c.release(node.ID)
+		err = c.insert(*node.clone(), StateMask{Mask: all})
+		if err != nil {
+			c.fanOutError(err)
+			continue
+		}
+		live++
+	}
+	if live == 0 {
+		return noLivePeersError
+	}
+	return c.announcePresence()
+}
+
+// saveState saves the Nodes in the state tables to the StateStore, if one is set. Empty state tables aren't saved, so a Node that loses contact with the Cluster keeps the Nodes it last knew of.
+func (c *Cluster) saveState() {
+	store := c.getStateStore()
+	if store == nil {
+		return
+	}
+	nodes := c.table.list([]int{}, []int{})
+	nodes = append(nodes, c.leafset.list()...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	saved := map[NodeID]bool{}
+	state := []Node{}
+	for _, node := range nodes {
+		if node == nil || saved[node.ID] {
+			continue
+		}
+		saved[node.ID] = true
+		state = append(state, *node.clone())
+	}
+	if len(state) == 0 {
+		return
+	}
+	err := store.SaveState(state)
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
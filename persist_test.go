@@ -0,0 +1,126 @@
+package wendy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a FileStateStore loads the Nodes it saved
+func TestFileStateStore(t *testing.T) {
+	store := FileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	nodes, err := store.LoadState()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(nodes) != 0 {
+		t.Errorf("Expected no Nodes before saving, got %d.", len(nodes))
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.self.GlobalPort = 9090
+	err = store.SaveState([]Node{*one.self, *two.self})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	nodes, err = store.LoadState()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("Expected %d Nodes, got %d.", 2, len(nodes))
+	}
+	for i, expected := range []*Node{one.self, two.self} {
+		if !nodes[i].ID.Equals(expected.ID) || nodes[i].LocalIP != expected.LocalIP || nodes[i].GlobalPort != expected.GlobalPort {
+			t.Errorf("Expected Node %d to be %+v, got %+v.", i, *expected, nodes[i])
+		}
+	}
+}
+
+// Test that WarmStart fails when there's nothing to warm-start from
+func TestClusterWarmStartUnavailable(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.WarmStart()
+	if err != noSavedStateError {
+		t.Errorf("Expected %v without a StateStore, got %v.", noSavedStateError, err)
+	}
+	store := FileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	cluster.SetStateStore(store)
+	err = cluster.WarmStart()
+	if err != noSavedStateError {
+		t.Errorf("Expected %v without saved state, got %v.", noSavedStateError, err)
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = store.SaveState([]Node{*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.WarmStart()
+	if err != noLivePeersError {
+		t.Errorf("Expected %v when no saved Node responds, got %v.", noLivePeersError, err)
+	}
+	if cluster.isJoined() {
+		t.Errorf("Expected the Node not to be joined after failing to warm-start.")
+	}
+}
+
+// Test that a restarted Node warm-starts from the state tables it saved
+func TestClusterWarmStart(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	store := FileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetStateStore(store)
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, []string{two.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.saveState()
+	two.Kill()
+	restarted, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	restarted.SetStateStore(store)
+	go restarted.Listen()
+	defer restarted.Kill()
+	time.Sleep(10 * time.Millisecond)
+	err = restarted.WarmStart()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !restarted.isJoined() {
+		t.Errorf("Expected the restarted Node to be joined.")
+	}
+	_, err = restarted.leafset.getNode(one.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
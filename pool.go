@@ -0,0 +1,73 @@
+package wendy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the largest buffer that will be returned to a pool. Larger buffers, left behind by unusually big Messages, are released to the garbage collector instead of being held onto.
+const maxPooledBuffer = 64 * 1024
+
+// encodeBuffer is a reusable buffer, along with an Encoder that writes to it and the pool it belongs to.
+type encodeBuffer struct {
+	bytes.Buffer
+	encoder Encoder
+	pool    *sync.Pool
+}
+
+// newEncodePool returns a pool of encodeBuffers whose Encoders are created by codec.
+func newEncodePool(codec Codec) *sync.Pool {
+	pool := new(sync.Pool)
+	pool.New = func() interface{} {
+		buf := &encodeBuffer{pool: pool}
+		buf.encoder = codec.NewEncoder(&buf.Buffer)
+		return buf
+	}
+	return pool
+}
+
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReader(nil)
+	},
+}
+
+func (c *Cluster) getEncodePool() *sync.Pool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.encoders
+}
+
+// encode encodes v with the Cluster's Codec into a pooled buffer. The buffer must be released once its contents have been used.
+func (c *Cluster) encode(v interface{}) (*encodeBuffer, error) {
+	buf := c.getEncodePool().Get().(*encodeBuffer)
+	buf.Reset()
+	err := buf.encoder.Encode(v)
+	if err != nil {
+		buf.release()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// release returns the buffer to its pool. The buffer must not be used afterwards.
+func (b *encodeBuffer) release() {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.pool.Put(b)
+}
+
+// getReader returns a pooled bufio.Reader reading from r. It must be returned with releaseReader when it is no longer needed.
+func getReader(r io.Reader) *bufio.Reader {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+	return reader
+}
+
+func releaseReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
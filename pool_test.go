@@ -0,0 +1,129 @@
+package wendy
+
+import (
+	"bytes"
+	"testing"
+)
+
+func benchmarkMessage(b *testing.B) Message {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	sender := NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080)
+	return Message{
+		Purpose: byte(16),
+		Sender:  *sender,
+		Key:     id,
+		Value:   bytes.Repeat([]byte("hello, world "), 64),
+	}
+}
+
+// Test that pooled buffers encode the same bytes as a new Encoder, even after the Codec changes
+func TestClusterEncode(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}, GobCodec{}} {
+		cluster.SetCodec(codec)
+		var expected bytes.Buffer
+		err = codec.NewEncoder(&expected).Encode(msg)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		for i := 0; i < 3; i++ {
+			buf, err := cluster.encode(msg)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+				t.Errorf("Expected %T to encode %q, got %q.", codec, expected.Bytes(), buf.Bytes())
+			}
+			buf.release()
+		}
+	}
+}
+
+// How many allocations does encoding a Message with a new Encoder take
+func BenchmarkEncodeUnpooled(b *testing.B) {
+	msg := benchmarkMessage(b)
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}, GobCodec{}} {
+		b.Run(codecName(codec), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var buf bytes.Buffer
+				err := codec.NewEncoder(&buf).Encode(msg)
+				if err != nil {
+					b.Fatalf(err.Error())
+				}
+			}
+		})
+	}
+}
+
+// How many allocations does encoding a Message with a pooled buffer take
+func BenchmarkEncodePooled(b *testing.B) {
+	msg := benchmarkMessage(b)
+	cluster := NewCluster(&msg.Sender, nil)
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}, GobCodec{}} {
+		cluster.SetCodec(codec)
+		b.Run(codecName(codec), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf, err := cluster.encode(msg)
+				if err != nil {
+					b.Fatalf(err.Error())
+				}
+				buf.release()
+			}
+		})
+	}
+}
+
+// How many allocations does decoding a Message through a new reader take
+func BenchmarkDecodeUnpooled(b *testing.B) {
+	benchmarkDecode(b, false)
+}
+
+// How many allocations does decoding a Message through a pooled reader take
+func BenchmarkDecodePooled(b *testing.B) {
+	benchmarkDecode(b, true)
+}
+
+func benchmarkDecode(b *testing.B, pooled bool) {
+	msg := benchmarkMessage(b)
+	codec := ProtobufCodec{}
+	var encoded bytes.Buffer
+	err := codec.NewEncoder(&encoded).Encode(msg)
+	if err != nil {
+		b.Fatalf(err.Error())
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var decoded Message
+		if pooled {
+			reader := getReader(bytes.NewReader(encoded.Bytes()))
+			err = codec.NewDecoder(reader).Decode(&decoded)
+			releaseReader(reader)
+		} else {
+			err = codec.NewDecoder(bytes.NewReader(encoded.Bytes())).Decode(&decoded)
+		}
+		if err != nil {
+			b.Fatalf(err.Error())
+		}
+	}
+}
+
+func codecName(codec Codec) string {
+	switch codec.(type) {
+	case JSONCodec:
+		return "json"
+	case ProtobufCodec:
+		return "protobuf"
+	case GobCodec:
+		return "gob"
+	}
+	return "unknown"
+}
@@ -0,0 +1,28 @@
+package wendy
+
+// Priority is how urgently a Message should be sent when the Cluster has more Messages to send than it has send workers; see SetSendWorkers. Messages with a higher Priority are sent first, so bulk transfers can't hold up more urgent Messages.
+type Priority int8
+
+const (
+	PriorityBulk     = Priority(-1) // Used for large or unhurried Messages, which are sent after every other Message waiting
+	PriorityNormal   = Priority(0)  // The default Priority
+	PriorityHigh     = Priority(1)  // Used for Messages that should be sent before Messages with a normal Priority
+	PriorityCritical = Priority(2)  // Used for Wendy's own Messages, such as heartbeats and repairs, which are sent before anything else
+)
+
+// priorityLevels is the number of distinct Priorities, from PriorityBulk to PriorityCritical.
+const priorityLevels = int(PriorityCritical-PriorityBulk) + 1
+
+// queueLevel returns the index of the send queue the Message should wait in, where higher indexes are sent first. Wendy's own Messages are always critical, and other Messages can be no more than PriorityHigh, so applications can't delay heartbeats or repairs.
+func (m Message) queueLevel() int {
+	priority := m.Priority
+	switch {
+	case m.Purpose < FirstUserPurpose:
+		priority = PriorityCritical
+	case priority > PriorityHigh:
+		priority = PriorityHigh
+	case priority < PriorityBulk:
+		priority = PriorityBulk
+	}
+	return int(priority - PriorityBulk)
+}
@@ -0,0 +1,121 @@
+package wendy
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the addresses it's asked to dial, in order, and holds the first dial until it is released.
+type recordingTransport struct {
+	TCPTransport
+	release chan struct{}
+	lock    *sync.Mutex
+	dialed  []string
+}
+
+func (t *recordingTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	t.lock.Lock()
+	t.dialed = append(t.dialed, address)
+	first := len(t.dialed) == 1
+	t.lock.Unlock()
+	if first {
+		<-t.release
+	}
+	return nil, deadNodeError
+}
+
+// Test that Wendy's own Messages are always critical, and other Messages can't be
+func TestMessageQueueLevel(t *testing.T) {
+	cases := []struct {
+		purpose  byte
+		priority Priority
+		level    int
+	}{
+		{HEARTBEAT, PriorityBulk, 3},
+		{NODE_REPR, PriorityNormal, 3},
+		{FirstUserPurpose, PriorityCritical, 2},
+		{FirstUserPurpose, PriorityHigh, 2},
+		{FirstUserPurpose, PriorityNormal, 1},
+		{FirstUserPurpose, PriorityBulk, 0},
+		{FirstUserPurpose, Priority(-100), 0},
+	}
+	for _, c := range cases {
+		msg := Message{Purpose: c.purpose, Priority: c.priority}
+		if level := msg.queueLevel(); level != c.level {
+			t.Errorf("Expected purpose %d with Priority %d to wait in queue %d, got %d.", c.purpose, c.priority, c.level, level)
+		}
+	}
+}
+
+// Test that queued Messages with a higher Priority are sent first
+func TestSendQueuePriority(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport := &recordingTransport{release: make(chan struct{}), lock: new(sync.Mutex)}
+	cluster.SetTransport(transport)
+	cluster.SetSendWorkers(1, 10)
+	node := func(port int) *Node {
+		return NewNode(cluster.self.ID, "127.0.0.1", "127.0.0.1", "testing", port)
+	}
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, nil)
+	// the only worker is held sending this, so the rest wait in the queue
+	results := []<-chan error{cluster.sendAsync(msg, node(1))}
+	time.Sleep(10 * time.Millisecond)
+	for port, priority := range map[int]Priority{2: PriorityBulk, 3: PriorityNormal, 4: PriorityHigh} {
+		msg.Priority = priority
+		results = append(results, cluster.sendAsync(msg, node(port)))
+	}
+	results = append(results, cluster.sendAsync(cluster.NewMessage(HEARTBEAT, cluster.self.ID, nil), node(5)))
+	close(transport.release)
+	for _, result := range results {
+		<-result
+	}
+	expected := []string{"127.0.0.1:1", "127.0.0.1:5", "127.0.0.1:4", "127.0.0.1:3", "127.0.0.1:2"}
+	for i, address := range expected {
+		if transport.dialed[i] != address {
+			t.Fatalf("Expected the Messages to be sent to ports 1, 5, 4, 3, then 2, got %v.", transport.dialed)
+		}
+	}
+}
+
+// Test that Messages keep their Priority when they're forwarded
+func TestClusterPriority(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("urgent"))
+	msg.Priority = PriorityHigh
+	err = two.Send(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if msg.Priority != PriorityHigh {
+			t.Errorf("Expected the Message to be delivered with a high Priority, got %d.", msg.Priority)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+}
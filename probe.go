@@ -0,0 +1,153 @@
+package wendy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// defaultIndirectProbes is the number of Nodes asked to check on a Node that fails to respond, unless SetIndirectProbes is called.
+const defaultIndirectProbes = 3
+
+// SetIndirectProbes sets how many other Nodes are asked to check on a Node that fails to respond, before it is considered dead and removed from the state tables. If any of them can reach it, it is kept, so a congested link between two Nodes doesn't cause either to drop the other. A value of 0 removes Nodes as soon as they fail to respond; by default, 3 Nodes are asked.
+func (c *Cluster) SetIndirectProbes(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	c.indirectProbes = n
+}
+
+func (c *Cluster) getIndirectProbes() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.indirectProbes
+}
+
+// suspect handles a Node that failed to respond, removing it from the state tables unless another Node reports that it can reach it.
+func (c *Cluster) suspect(node *Node) error {
+	if c.probeIndirectly(*node) {
+		c.debug("Node %s responded to another Node. Keeping it.", node.ID)
+		return nil
+	}
+	return c.remove(node.ID, ExitTimeout)
+}
+
+// awaitSends waits for the result of each send, then suspects the Nodes that didn't respond, and returns the number of sends that succeeded. Suspects are probed concurrently, so one dead Node doesn't hold up the others.
+func (c *Cluster) awaitSends(sent map[NodeID]<-chan error, targets map[NodeID]*Node) int {
+	var wg sync.WaitGroup
+	succeeded := 0
+	for id, result := range sent {
+		err := <-result
+		if err == nil {
+			succeeded++
+		}
+		if err != deadNodeError {
+			continue
+		}
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			err := c.suspect(node)
+			if err != nil {
+				c.fanOutError(err)
+			}
+		}(targets[id])
+	}
+	wg.Wait()
+	return succeeded
+}
+
+// probeIndirectly asks other Nodes, chosen at random, to send node a heartbeat, and returns true if any of them report that it responded. It waits for as long as a Node could take to give up on node.
+func (c *Cluster) probeIndirectly(node Node) bool {
+	n := c.getIndirectProbes()
+	if n < 1 {
+		return false
+	}
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	seen := map[NodeID]bool{node.ID: true}
+	helpers := []*Node{}
+	for _, helper := range nodes {
+		if helper == nil || seen[helper.ID] {
+			continue
+		}
+		seen[helper.ID] = true
+		helpers = append(helpers, helper)
+	}
+	if len(helpers) == 0 {
+		return false
+	}
+	rand.Shuffle(len(helpers), func(i, j int) {
+		helpers[i], helpers[j] = helpers[j], helpers[i]
+	})
+	if len(helpers) > n {
+		helpers = helpers[:n]
+	}
+	data, err := c.marshal(node)
+	if err != nil {
+		c.fanOutError(err)
+		return false
+	}
+	c.lock.Lock()
+	alive, probing := c.probes[node.ID]
+	if !probing {
+		alive = make(chan struct{})
+		c.probes[node.ID] = alive
+	}
+	c.lock.Unlock()
+	if !probing {
+		defer func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			if c.probes[node.ID] == alive {
+				delete(c.probes, node.ID)
+			}
+		}()
+		msg := c.NewMessage(NODE_PROBE, node.ID, data)
+		for _, helper := range helpers {
+			c.debug("Asking %s to check on %s", helper.ID, node.ID)
+			c.sendAsync(msg, helper)
+		}
+	}
+	connect, write, read := c.getTimeouts()
+	select {
+	case <-alive:
+		return true
+	case <-time.After(connect + write + read):
+		return false
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// Another Node couldn't reach a Node, and wants us to check on it. We only reply if it responds.
+func (c *Cluster) onProbeRequest(msg Message) {
+	var suspect Node
+	err := c.unmarshal(msg.Value, &suspect)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	err = c.send(c.NewMessage(HEARTBEAT, c.self.ID, []byte{}), &suspect)
+	if err != nil {
+		c.debug("Couldn't reach %s for %s either.", suspect.ID, msg.Sender.ID)
+		return
+	}
+	err = c.send(c.NewMessage(NODE_ALIVE, suspect.ID, []byte{}), &msg.Sender)
+	if err != nil && err != deadNodeError {
+		c.fanOutError(err)
+	}
+}
+
+// A Node we asked to check on another reports that it responded.
+func (c *Cluster) onProbeReply(msg Message) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if alive, ok := c.probes[msg.Key]; ok {
+		close(alive)
+		delete(c.probes, msg.Key)
+	}
+}
@@ -0,0 +1,106 @@
+package wendy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// partitionedTransport is a TCPTransport that can't reach one address, as if the network between them were congested
+type partitionedTransport struct {
+	TCPTransport
+	unreachable string
+}
+
+func (p partitionedTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	if address == p.unreachable {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: net.UnknownNetworkError("partitioned")}
+	}
+	return p.TCPTransport.Dial(address, timeout)
+}
+
+// Test that a Node that fails to respond is kept if another Node can reach it, and removed if none can
+func TestClusterSuspect(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	go three.Listen()
+	waitListening(t, one, two, three)
+	one.SetTransport(partitionedTransport{unreachable: one.GetIP(*three.self)})
+	for _, node := range []*Node{two.self, three.self} {
+		err = one.insert(*node, StateMask{Mask: all})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	suspect, err := one.leafset.getNode(three.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.send(one.NewMessage(HEARTBEAT, one.self.ID, []byte{}), suspect)
+	if err != deadNodeError {
+		t.Fatalf("Expected %v, got %v.", deadNodeError, err)
+	}
+	err = one.suspect(suspect)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = one.leafset.getNode(three.self.ID)
+	if err != nil {
+		t.Errorf("Expected a Node another Node can reach to be kept, got %v.", err)
+	}
+	three.Kill()
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	one.suspect(suspect)
+	_, err = one.leafset.getNode(three.self.ID)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a Node no other Node can reach to be removed, got %v.", err)
+	}
+	connect, write, read := one.getTimeouts()
+	if time.Since(start) < connect+write+read {
+		t.Errorf("Expected the Node to wait for the probes before removing the Node.")
+	}
+}
+
+// Test that Nodes are removed without asking others when indirect probes are disabled
+func TestClusterSuspectDisabled(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	for i, idBytes := range []string{"this is some other Node for testing purposes only.", "this is a third Node for testing purposes only."} {
+		id, err := NodeIDFromBytes([]byte(idBytes))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", i+1), StateMask{Mask: all})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	suspect := cluster.leafset.list()[0]
+	start := time.Now()
+	cluster.suspect(suspect)
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected the Node to be removed without waiting for probes.")
+	}
+	_, err = cluster.leafset.getNode(suspect.ID)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected the Node to be removed, got %v.", err)
+	}
+}
@@ -0,0 +1,789 @@
+package wendy
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// protobufMaxMessage is the largest encoded Message a ProtobufCodec will read.
+const protobufMaxMessage = 16 * 1024 * 1024
+
+const (
+	protobufVarint = 0
+	protobufBytes  = 2
+)
+
+var protobufMessageError = errors.New("Invalid protobuf message.")
+var protobufMessageTooLargeError = errors.New("Protobuf message exceeded the maximum size.")
+
+// ProtobufCodec is an implementation of Codec that encodes values using Protocol Buffers, which is considerably smaller and cheaper to encode and decode than JSON. Every Node in the Cluster must use a ProtobufCodec.
+//
+// Each value is written as a varint length followed by a message defined by the following schema:
+//
+//	package wendy;
+//
+//	message Message {
+//		uint32 purpose = 1;
+//		Node sender = 2;
+//		bytes key = 3;
+//		bytes value = 4;
+//		bytes credentials = 5;
+//		uint64 ls_version = 6;
+//		uint64 rt_version = 7;
+//		uint64 ns_version = 8;
+//		int64 hop = 9;
+//		uint32 checksum = 10;
+//		bytes destination = 11;
+//		bytes signature = 12;
+//		string cluster_id = 13;
+//		uint64 epoch = 14;
+//		bytes id = 15;
+//		bool ack = 16;
+//		int32 priority = 17;
+//		int64 expires = 18; // nanoseconds since the Unix epoch
+//		bool expired = 19;
+//		uint64 seq = 20;
+//		bool direct = 21;
+//		map<string, string> headers = 22;
+//		bytes in_reply_to = 23;
+//		bool record_path = 24;
+//		repeated Hop path = 25;
+//	}
+//
+//	message Node {
+//		string local_ip = 1;
+//		string global_ip = 2;
+//		int64 port = 3;
+//		int64 global_port = 4;
+//		string region = 5;
+//		bytes id = 6;
+//		string local_ipv6 = 7;
+//		string global_ipv6 = 8;
+//		int64 load = 9;
+//		map<string, string> metadata = 10;
+//		repeated string capabilities = 11;
+//		bytes public_key = 12;
+//		bytes proof = 13;
+//	}
+//
+//	message StateTables {
+//		Table routing_table = 1;
+//		Table leaf_set = 2;
+//		Table neighborhood_set = 3;
+//		bool eol = 4;
+//		bool delta = 5;
+//	}
+//
+//	message Table {
+//		repeated Entry entries = 1;
+//	}
+//
+//	message Entry {
+//		uint32 row = 1;
+//		uint32 col = 2;
+//		Node node = 3;
+//	}
+//
+//	message StateMask {
+//		uint32 mask = 1;
+//		repeated int64 rows = 2;
+//		repeated int64 cols = 3;
+//	}
+//
+//	message StateDigest {
+//		bytes filter = 1;
+//		uint64 seed = 2;
+//	}
+//
+//	message Trace {
+//		uint64 nonce = 1;
+//		Node origin = 2;
+//		repeated Hop hops = 3;
+//		bool incomplete = 4;
+//	}
+//
+//	message Hop {
+//		bytes id = 1;
+//		int64 proximity = 2; // nanoseconds
+//		int64 time = 3; // nanoseconds since the Unix epoch
+//	}
+//
+//	message Capable {
+//		string capability = 1;
+//		bool direct = 2;
+//		uint32 purpose = 3;
+//		bytes value = 4;
+//	}
+//
+// ProtobufCodec can only encode Messages, Nodes, state tables, StateMasks, state digests, traces, and the payloads of Messages sent with SendToCapable.
+type ProtobufCodec struct{}
+
+// NewEncoder returns an Encoder that writes length-delimited protobuf messages to w.
+func (p ProtobufCodec) NewEncoder(w io.Writer) Encoder {
+	return &protobufEncoder{w: w}
+}
+
+// NewDecoder returns a Decoder that reads length-delimited protobuf messages from r.
+func (p ProtobufCodec) NewDecoder(r io.Reader) Decoder {
+	return &protobufDecoder{r: bufio.NewReader(r)}
+}
+
+type protobufEncoder struct {
+	w   io.Writer
+	buf protobufBuffer // reused between calls to Encode
+}
+
+func (e *protobufEncoder) Encode(v interface{}) error {
+	// leave room at the front of the buffer for the length, which isn't known until the body is encoded
+	body := append(e.buf[:0], make([]byte, binary.MaxVarintLen64)...)
+	switch value := v.(type) {
+	case Message:
+		body.message(value)
+	case *Message:
+		body.message(*value)
+	case stateTables:
+		body.stateTables(value)
+	case *stateTables:
+		body.stateTables(*value)
+	case StateMask:
+		body.stateMask(value)
+	case *StateMask:
+		body.stateMask(*value)
+	case stateDigest:
+		body.stateDigest(value)
+	case *stateDigest:
+		body.stateDigest(*value)
+	case Node:
+		body.node(value)
+	case *Node:
+		body.node(*value)
+	case traceRoute:
+		body.trace(value)
+	case *traceRoute:
+		body.trace(*value)
+	case capableMessage:
+		body.capable(value)
+	case *capableMessage:
+		body.capable(*value)
+	default:
+		return fmt.Errorf("ProtobufCodec can't encode %T.", v)
+	}
+	e.buf = body
+	var length [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(length[:], uint64(len(body)-binary.MaxVarintLen64))
+	start := binary.MaxVarintLen64 - n
+	copy(body[start:], length[:n])
+	_, err := e.w.Write(body[start:])
+	return err
+}
+
+type protobufDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *protobufDecoder) Decode(v interface{}) error {
+	length, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return err
+	}
+	if length > protobufMaxMessage {
+		return protobufMessageTooLargeError
+	}
+	data := make([]byte, length)
+	_, err = io.ReadFull(d.r, data)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	switch value := v.(type) {
+	case *Message:
+		return decodeProtobufMessage(data, value)
+	case *stateTables:
+		return decodeProtobufStateTables(data, value)
+	case *StateMask:
+		return decodeProtobufStateMask(data, value)
+	case *stateDigest:
+		return decodeProtobufStateDigest(data, value)
+	case *Node:
+		return decodeProtobufNode(data, value)
+	case *traceRoute:
+		return decodeProtobufTrace(data, value)
+	case *capableMessage:
+		return decodeProtobufCapable(data, value)
+	}
+	return fmt.Errorf("ProtobufCodec can't decode into %T.", v)
+}
+
+// protobufBuffer accumulates an encoded protobuf message.
+type protobufBuffer []byte
+
+func (b *protobufBuffer) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	*b = append(*b, buf[:n]...)
+}
+
+func (b *protobufBuffer) key(field int, wire int) {
+	b.varint(uint64(field<<3 | wire))
+}
+
+func (b *protobufBuffer) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.key(field, protobufVarint)
+	b.varint(v)
+}
+
+func (b *protobufBuffer) int(field int, v int64) {
+	b.uint(field, uint64(v))
+}
+
+func (b *protobufBuffer) bool(field int, v bool) {
+	if v {
+		b.uint(field, 1)
+	}
+}
+
+func (b *protobufBuffer) bytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.key(field, protobufBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protobufBuffer) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+// embedded writes a nested message, even if it is empty, so its presence is preserved.
+func (b *protobufBuffer) embedded(field int, v protobufBuffer) {
+	b.key(field, protobufBytes)
+	b.varint(uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protobufBuffer) message(msg Message) {
+	b.uint(1, uint64(msg.Purpose))
+	var sender protobufBuffer
+	sender.node(msg.Sender)
+	b.embedded(2, sender)
+	b.bytes(3, nodeIDBytes(msg.Key))
+	b.bytes(4, msg.Value)
+	b.bytes(5, msg.Credentials)
+	b.uint(6, msg.LSVersion)
+	b.uint(7, msg.RTVersion)
+	b.uint(8, msg.NSVersion)
+	b.int(9, int64(msg.Hop))
+	b.uint(10, uint64(msg.Checksum))
+	if msg.Destination != (NodeID{}) {
+		b.bytes(11, nodeIDBytes(msg.Destination))
+	}
+	b.bytes(12, msg.Signature)
+	b.string(13, msg.ClusterID)
+	b.uint(14, msg.Epoch)
+	b.bytes(15, msg.ID)
+	b.bool(16, msg.Ack)
+	b.int(17, int64(msg.Priority))
+	if !msg.Expires.IsZero() {
+		b.int(18, msg.Expires.UnixNano())
+	}
+	b.bool(19, msg.Expired)
+	b.uint(20, msg.Seq)
+	b.bool(21, msg.Direct)
+	b.stringMap(22, msg.Headers)
+	b.bytes(23, msg.InReplyTo)
+	b.bool(24, msg.RecordPath)
+	for _, hop := range msg.Path {
+		b.traceHop(25, hop)
+	}
+	b.bytes(26, msg.RequestID)
+}
+
+// stringMap writes each entry of m as an embedded message of a key and a value. Entries are sorted, so a Message's digest doesn't depend on the order maps are iterated in.
+func (b *protobufBuffer) stringMap(field int, m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry protobufBuffer
+		entry.string(1, key)
+		entry.string(2, m[key])
+		b.embedded(field, entry)
+	}
+}
+
+// decodeProtobufMapEntry decodes an entry written by stringMap into m, creating m if it's nil.
+func decodeProtobufMapEntry(raw []byte, m *map[string]string) error {
+	if raw == nil {
+		return protobufMessageError
+	}
+	var key, value string
+	err := protobufFields(raw, func(field int, v uint64, raw []byte) error {
+		switch field {
+		case 1:
+			key = string(raw)
+		case 2:
+			value = string(raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = map[string]string{}
+	}
+	(*m)[key] = value
+	return nil
+}
+
+func (b *protobufBuffer) node(node Node) {
+	b.string(1, node.LocalIP)
+	b.string(2, node.GlobalIP)
+	b.int(3, int64(node.Port))
+	b.int(4, int64(node.GlobalPort))
+	b.string(5, node.Region)
+	b.bytes(6, nodeIDBytes(node.ID))
+	b.string(7, node.LocalIPv6)
+	b.string(8, node.GlobalIPv6)
+	b.int(9, int64(node.Load))
+	b.stringMap(10, node.Metadata)
+	for _, capability := range node.Capabilities {
+		b.string(11, capability)
+	}
+	b.bytes(12, node.PublicKey)
+	b.bytes(13, node.Proof)
+}
+
+func (b *protobufBuffer) entry(row, col int, node *Node) {
+	var entry, encoded protobufBuffer
+	entry.uint(1, uint64(row))
+	entry.uint(2, uint64(col))
+	encoded.node(*node)
+	entry.embedded(3, encoded)
+	b.embedded(1, entry)
+}
+
+func (b *protobufBuffer) stateTables(state stateTables) {
+	if state.RoutingTable != nil {
+		var table protobufBuffer
+		for row := range state.RoutingTable {
+			for col, node := range state.RoutingTable[row] {
+				if node != nil {
+					table.entry(row, col, node)
+				}
+			}
+		}
+		b.embedded(1, table)
+	}
+	if state.LeafSet != nil {
+		var table protobufBuffer
+		for side := range state.LeafSet {
+			for pos, node := range state.LeafSet[side] {
+				if node != nil {
+					table.entry(side, pos, node)
+				}
+			}
+		}
+		b.embedded(2, table)
+	}
+	if state.NeighborhoodSet != nil {
+		var table protobufBuffer
+		for pos, node := range state.NeighborhoodSet {
+			if node != nil {
+				table.entry(0, pos, node)
+			}
+		}
+		b.embedded(3, table)
+	}
+	b.bool(4, state.EOL)
+	b.bool(5, state.Delta)
+}
+
+func (b *protobufBuffer) stateMask(mask StateMask) {
+	b.uint(1, uint64(mask.Mask))
+	for _, row := range mask.Rows {
+		b.key(2, protobufVarint)
+		b.varint(uint64(int64(row)))
+	}
+	for _, col := range mask.Cols {
+		b.key(3, protobufVarint)
+		b.varint(uint64(int64(col)))
+	}
+}
+
+func (b *protobufBuffer) stateDigest(digest stateDigest) {
+	b.bytes(1, digest.Filter)
+	b.uint(2, digest.Seed)
+}
+
+func (b *protobufBuffer) trace(trace traceRoute) {
+	b.uint(1, trace.Nonce)
+	var origin protobufBuffer
+	origin.node(trace.Origin)
+	b.embedded(2, origin)
+	for _, hop := range trace.Hops {
+		b.traceHop(3, hop)
+	}
+	b.bool(4, trace.Incomplete)
+}
+
+func (b *protobufBuffer) traceHop(field int, hop TraceHop) {
+	var encoded protobufBuffer
+	encoded.bytes(1, nodeIDBytes(hop.ID))
+	encoded.int(2, int64(hop.Proximity))
+	encoded.int(3, hop.Time.UnixNano())
+	b.embedded(field, encoded)
+}
+
+func (b *protobufBuffer) capable(env capableMessage) {
+	b.string(1, env.Capability)
+	b.bool(2, env.Direct)
+	b.uint(3, uint64(env.Purpose))
+	b.bytes(4, env.Value)
+}
+
+func nodeIDBytes(id NodeID) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id[0])
+	binary.BigEndian.PutUint64(buf[8:], id[1])
+	return buf
+}
+
+// protobufFields calls fn for each field of an encoded message. For varint fields, raw is nil; for length-delimited fields, it holds the field's contents. Fields of other wire types are skipped.
+func protobufFields(data []byte, fn func(field int, varint uint64, raw []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return protobufMessageError
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case protobufVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return protobufMessageError
+			}
+			data = data[n:]
+			err := fn(field, v, nil)
+			if err != nil {
+				return err
+			}
+		case protobufBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return protobufMessageError
+			}
+			raw := data[n : n+int(length)]
+			data = data[n+int(length):]
+			// raw must be non-nil, even when empty, to be told apart from a varint
+			if raw == nil {
+				raw = []byte{}
+			}
+			err := fn(field, 0, raw)
+			if err != nil {
+				return err
+			}
+		case 1:
+			if len(data) < 8 {
+				return protobufMessageError
+			}
+			data = data[8:]
+		case 5:
+			if len(data) < 4 {
+				return protobufMessageError
+			}
+			data = data[4:]
+		default:
+			return protobufMessageError
+		}
+	}
+	return nil
+}
+
+func decodeProtobufMessage(data []byte, msg *Message) error {
+	*msg = Message{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		var err error
+		switch field {
+		case 1:
+			msg.Purpose = byte(v)
+		case 2:
+			err = decodeProtobufNode(raw, &msg.Sender)
+		case 3:
+			msg.Key, err = NodeIDFromBytes(raw)
+		case 4:
+			msg.Value = append([]byte{}, raw...)
+		case 5:
+			msg.Credentials = append([]byte{}, raw...)
+		case 6:
+			msg.LSVersion = v
+		case 7:
+			msg.RTVersion = v
+		case 8:
+			msg.NSVersion = v
+		case 9:
+			msg.Hop = int(int64(v))
+		case 10:
+			msg.Checksum = uint32(v)
+		case 11:
+			msg.Destination, err = NodeIDFromBytes(raw)
+		case 12:
+			msg.Signature = append([]byte{}, raw...)
+		case 13:
+			msg.ClusterID = string(raw)
+		case 14:
+			msg.Epoch = v
+		case 15:
+			msg.ID = append([]byte{}, raw...)
+		case 16:
+			msg.Ack = v != 0
+		case 17:
+			msg.Priority = Priority(int64(v))
+		case 18:
+			msg.Expires = time.Unix(0, int64(v))
+		case 19:
+			msg.Expired = v != 0
+		case 20:
+			msg.Seq = v
+		case 21:
+			msg.Direct = v != 0
+		case 22:
+			err = decodeProtobufMapEntry(raw, &msg.Headers)
+		case 23:
+			msg.InReplyTo = append([]byte{}, raw...)
+		case 24:
+			msg.RecordPath = v != 0
+		case 25:
+			err = decodeProtobufTraceHop(raw, &msg.Path)
+		case 26:
+			msg.RequestID = append([]byte{}, raw...)
+		}
+		return err
+	})
+}
+
+func decodeProtobufNode(data []byte, node *Node) error {
+	*node = Node{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		var err error
+		switch field {
+		case 1:
+			node.LocalIP = string(raw)
+		case 2:
+			node.GlobalIP = string(raw)
+		case 3:
+			node.Port = int(int64(v))
+		case 4:
+			node.GlobalPort = int(int64(v))
+		case 5:
+			node.Region = string(raw)
+		case 6:
+			node.ID, err = NodeIDFromBytes(raw)
+		case 7:
+			node.LocalIPv6 = string(raw)
+		case 8:
+			node.GlobalIPv6 = string(raw)
+		case 9:
+			node.Load = int(int64(v))
+		case 10:
+			err = decodeProtobufMapEntry(raw, &node.Metadata)
+		case 11:
+			node.Capabilities = append(node.Capabilities, string(raw))
+		case 12:
+			node.PublicKey = append(ed25519.PublicKey{}, raw...)
+		case 13:
+			node.Proof = append([]byte{}, raw...)
+		}
+		return err
+	})
+}
+
+// decodeProtobufTable calls set with the position and Node of each Entry in an encoded Table.
+func decodeProtobufTable(data []byte, set func(row, col int, node *Node) error) error {
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		if field != 1 || raw == nil {
+			return nil
+		}
+		var row, col int
+		var node *Node
+		err := protobufFields(raw, func(field int, v uint64, raw []byte) error {
+			switch field {
+			case 1:
+				row = int(v)
+			case 2:
+				col = int(v)
+			case 3:
+				node = new(Node)
+				return decodeProtobufNode(raw, node)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return protobufMessageError
+		}
+		return set(row, col, node)
+	})
+}
+
+func decodeProtobufStateTables(data []byte, state *stateTables) error {
+	*state = stateTables{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		switch field {
+		case 1:
+			state.RoutingTable = [][]*Node{}
+			return decodeProtobufTable(raw, func(row, col int, node *Node) error {
+				var ok bool
+				state.RoutingTable, ok = setTableEntry(state.RoutingTable, row, col, node)
+				if !ok {
+					return protobufMessageError
+				}
+				return nil
+			})
+		case 2:
+			state.LeafSet = new([2][16]*Node)
+			return decodeProtobufTable(raw, func(row, col int, node *Node) error {
+				if row >= len(state.LeafSet) || col >= len(state.LeafSet[row]) {
+					return protobufMessageError
+				}
+				state.LeafSet[row][col] = node
+				return nil
+			})
+		case 3:
+			state.NeighborhoodSet = new([32]*Node)
+			return decodeProtobufTable(raw, func(row, col int, node *Node) error {
+				if col >= len(state.NeighborhoodSet) {
+					return protobufMessageError
+				}
+				state.NeighborhoodSet[col] = node
+				return nil
+			})
+		case 4:
+			state.EOL = v != 0
+		case 5:
+			state.Delta = v != 0
+		}
+		return nil
+	})
+}
+
+func decodeProtobufStateMask(data []byte, mask *StateMask) error {
+	*mask = StateMask{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		if field != 2 && field != 3 {
+			if field == 1 {
+				mask.Mask = byte(v)
+			}
+			return nil
+		}
+		values := []int{}
+		if raw == nil {
+			values = append(values, int(int64(v)))
+		} else {
+			// packed repeated field
+			for len(raw) > 0 {
+				v, n := binary.Uvarint(raw)
+				if n <= 0 {
+					return protobufMessageError
+				}
+				values = append(values, int(int64(v)))
+				raw = raw[n:]
+			}
+		}
+		if field == 2 {
+			mask.Rows = append(mask.Rows, values...)
+		} else {
+			mask.Cols = append(mask.Cols, values...)
+		}
+		return nil
+	})
+}
+
+func decodeProtobufStateDigest(data []byte, digest *stateDigest) error {
+	*digest = stateDigest{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		switch field {
+		case 1:
+			digest.Filter = append([]byte{}, raw...)
+		case 2:
+			digest.Seed = v
+		}
+		return nil
+	})
+}
+
+func decodeProtobufTrace(data []byte, trace *traceRoute) error {
+	*trace = traceRoute{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		switch field {
+		case 1:
+			trace.Nonce = v
+		case 2:
+			return decodeProtobufNode(raw, &trace.Origin)
+		case 3:
+			return decodeProtobufTraceHop(raw, &trace.Hops)
+		case 4:
+			trace.Incomplete = v != 0
+		}
+		return nil
+	})
+}
+
+// decodeProtobufTraceHop decodes a Hop written by traceHop, appending it to hops.
+func decodeProtobufTraceHop(raw []byte, hops *[]TraceHop) error {
+	if raw == nil {
+		return protobufMessageError
+	}
+	var hop TraceHop
+	err := protobufFields(raw, func(field int, v uint64, raw []byte) error {
+		var err error
+		switch field {
+		case 1:
+			hop.ID, err = NodeIDFromBytes(raw)
+		case 2:
+			hop.Proximity = time.Duration(int64(v))
+		case 3:
+			hop.Time = time.Unix(0, int64(v))
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	*hops = append(*hops, hop)
+	return nil
+}
+
+func decodeProtobufCapable(data []byte, env *capableMessage) error {
+	*env = capableMessage{}
+	return protobufFields(data, func(field int, v uint64, raw []byte) error {
+		switch field {
+		case 1:
+			env.Capability = string(raw)
+		case 2:
+			env.Direct = v != 0
+		case 3:
+			env.Purpose = byte(v)
+		case 4:
+			env.Value = append([]byte{}, raw...)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,225 @@
+package wendy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Test that Messages survive a round trip through the ProtobufCodec
+func TestProtobufCodecMessage(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sender := NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080)
+	sender.GlobalPort = 9090
+	sender.LocalIPv6 = "fd00::1"
+	msg := Message{
+		Purpose:     NODE_ANN,
+		Sender:      *sender,
+		Key:         id,
+		Value:       []byte("hello, world"),
+		Credentials: []byte("secret"),
+		LSVersion:   1,
+		RTVersion:   2,
+		NSVersion:   3,
+		Hop:         4,
+		ClusterID:   "testing",
+		Epoch:       5,
+		ID:          []byte("message id"),
+		Ack:         true,
+		Priority:    PriorityBulk,
+		Expires:     time.Unix(1500000000, 5),
+		Expired:     true,
+		Seq:         6,
+		Direct:      true,
+		InReplyTo:   []byte("request id"),
+		RequestID:   []byte("reply id"),
+		RecordPath:  true,
+		Headers:     map[string]string{"trace-id": "abc123", "content-type": "application/json"},
+		Path:        []TraceHop{{ID: id, Proximity: 7, Time: time.Unix(1500000000, 8)}, {ID: id, Time: time.Unix(1500000001, 9)}},
+	}
+	var buf bytes.Buffer
+	codec := ProtobufCodec{}
+	err = codec.NewEncoder(&buf).Encode(msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = codec.NewEncoder(&buf).Encode(&msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	decoder := codec.NewDecoder(&buf)
+	for i := 0; i < 2; i++ {
+		var decoded Message
+		err = decoder.Decode(&decoded)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if decoded.Purpose != msg.Purpose || !decoded.Key.Equals(msg.Key) || string(decoded.Value) != string(msg.Value) || string(decoded.Credentials) != string(msg.Credentials) || decoded.LSVersion != 1 || decoded.RTVersion != 2 || decoded.NSVersion != 3 || decoded.Hop != 4 || decoded.ClusterID != "testing" || decoded.Epoch != 5 || string(decoded.ID) != "message id" || !decoded.Ack || decoded.Priority != PriorityBulk || !decoded.Expires.Equal(msg.Expires) || !decoded.Expired || decoded.Seq != 6 || !decoded.Direct || string(decoded.InReplyTo) != "request id" || string(decoded.RequestID) != "reply id" || len(decoded.Headers) != 2 || decoded.Headers["trace-id"] != "abc123" || !decoded.RecordPath || len(decoded.Path) != 2 || decoded.Path[0].Proximity != 7 || !decoded.Path[1].Time.Equal(msg.Path[1].Time) {
+			t.Fatalf("Expected %+v, got %+v.", msg, decoded)
+		}
+		s := decoded.Sender
+		if !s.ID.Equals(id) || s.LocalIP != "10.0.0.1" || s.GlobalIP != "203.0.113.1" || s.Region != "testing" || s.Port != 8080 || s.GlobalPort != 9090 || s.LocalIPv6 != "fd00::1" {
+			t.Fatalf("Expected sender %+v, got %+v.", *sender, s)
+		}
+	}
+}
+
+// Test that state tables and StateMasks survive a round trip through the ProtobufCodec
+func TestProtobufCodecState(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080)
+	state := stateTables{
+		RoutingTable: newTableRows(defaultDigitBits),
+		LeafSet:      new([2][16]*Node),
+		EOL:          true,
+	}
+	state.RoutingTable[3][7] = node
+	state.LeafSet[1][2] = node
+	var buf bytes.Buffer
+	codec := ProtobufCodec{}
+	err = codec.NewEncoder(&buf).Encode(state)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	mask := StateMask{Mask: rT | nS, Rows: []int{1, 2}, Cols: []int{3}}
+	err = codec.NewEncoder(&buf).Encode(mask)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded stateTables
+	decoder := codec.NewDecoder(&buf)
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !decoded.EOL || decoded.NeighborhoodSet != nil || decoded.RoutingTable == nil || decoded.LeafSet == nil {
+		t.Fatalf("Expected tables to be preserved, got %+v.", decoded)
+	}
+	if decoded.RoutingTable[3][7] == nil || !decoded.RoutingTable[3][7].ID.Equals(id) {
+		t.Fatalf("Expected the routing table entry to be preserved.")
+	}
+	if decoded.LeafSet[1][2] == nil || decoded.LeafSet[1][2].Port != 8080 {
+		t.Fatalf("Expected the leaf set entry to be preserved.")
+	}
+	var decodedMask StateMask
+	err = decoder.Decode(&decodedMask)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !reflect.DeepEqual(mask, decodedMask) {
+		t.Fatalf("Expected %+v, got %+v.", mask, decodedMask)
+	}
+}
+
+// Test that a Cluster can deliver messages using the ProtobufCodec
+func TestClusterProtobufCodec(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetCodec(ProtobufCodec{})
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetCodec(ProtobufCodec{})
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		if string(received.Value) != "hello, world" {
+			t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
+
+// Test that Nodes survive a round trip through the ProtobufCodec
+func TestProtobufCodecNode(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080)
+	node.GlobalIPv6 = "2001:db8::1"
+	node.Load = 75
+	node.Metadata = map[string]string{"role": "storage", "version": "1.2.0"}
+	node.Capabilities = []string{"gpu", "storage"}
+	node.Proof = []byte("proof of work")
+	var buf bytes.Buffer
+	codec := ProtobufCodec{}
+	err = codec.NewEncoder(&buf).Encode(node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded Node
+	err = codec.NewDecoder(&buf).Decode(&decoded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !decoded.ID.Equals(id) || decoded.LocalIP != node.LocalIP || decoded.GlobalIP != node.GlobalIP || decoded.Port != node.Port || decoded.GlobalIPv6 != node.GlobalIPv6 || decoded.Load != node.Load {
+		t.Errorf("Expected %+v, got %+v.", *node, decoded)
+	}
+	if len(decoded.Metadata) != 2 || decoded.Metadata["role"] != "storage" || decoded.Metadata["version"] != "1.2.0" {
+		t.Errorf("Expected metadata %v, got %v.", node.Metadata, decoded.Metadata)
+	}
+	if !decoded.HasCapability("gpu") || !decoded.HasCapability("storage") || len(decoded.Capabilities) != 2 {
+		t.Errorf("Expected capabilities %v, got %v.", node.Capabilities, decoded.Capabilities)
+	}
+	if string(decoded.Proof) != "proof of work" {
+		t.Errorf("Expected proof %q, got %q.", node.Proof, decoded.Proof)
+	}
+}
+
+// Test that traces survive a round trip through the ProtobufCodec
+func TestProtobufCodecTrace(t *testing.T) {
+	id, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	trace := traceRoute{
+		Nonce:      42,
+		Origin:     *NewNode(id, "10.0.0.1", "203.0.113.1", "testing", 8080),
+		Hops:       []TraceHop{{ID: id, Proximity: time.Millisecond, Time: time.Unix(0, 1234567890)}, {ID: id, Time: time.Unix(0, 987654321)}},
+		Incomplete: true,
+	}
+	var buf bytes.Buffer
+	codec := ProtobufCodec{}
+	err = codec.NewEncoder(&buf).Encode(trace)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var decoded traceRoute
+	err = codec.NewDecoder(&buf).Decode(&decoded)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if decoded.Nonce != trace.Nonce || !decoded.Origin.ID.Equals(id) || !decoded.Incomplete || len(decoded.Hops) != 2 {
+		t.Fatalf("Expected %+v, got %+v.", trace, decoded)
+	}
+	for i, hop := range trace.Hops {
+		if !decoded.Hops[i].ID.Equals(hop.ID) || decoded.Hops[i].Proximity != hop.Proximity || !decoded.Hops[i].Time.Equal(hop.Time) {
+			t.Errorf("Expected hop %d to be %+v, got %+v.", i, hop, decoded.Hops[i])
+		}
+	}
+}
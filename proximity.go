@@ -0,0 +1,105 @@
+package wendy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// The defaults for how long a proximity measurement is cached for, and how many are cached, unless SetProximityCache is called.
+const (
+	defaultProximityTTL       = time.Hour
+	defaultProximityCacheSize = 1024
+)
+
+// proximityEntry is the round trip time measured to a Node, in the proximity cache.
+type proximityEntry struct {
+	id      NodeID
+	rtt     RTT
+	tcpOnly bool // the Node didn't answer a UDP ping, so it's pinged over its Transport instead
+	expires time.Time
+}
+
+// proximityCache holds the round trip time measured to each Node, so Nodes heard of repeatedly aren't measured each time. Each Node's measurements expire on their own, and the least recently measured or used Node is evicted once the cache is full.
+type proximityCache struct {
+	entries map[NodeID]*list.Element
+	order   *list.List // the entries, most recently used first
+	ttl     time.Duration
+	size    int
+	*sync.Mutex
+}
+
+func newProximityCache() *proximityCache {
+	return &proximityCache{
+		entries: map[NodeID]*list.Element{},
+		order:   list.New(),
+		ttl:     defaultProximityTTL,
+		size:    defaultProximityCacheSize,
+		Mutex:   new(sync.Mutex),
+	}
+}
+
+// get returns the cache entry for the Node, or false if there is none or it has expired.
+func (p *proximityCache) get(id NodeID) (proximityEntry, bool) {
+	p.Lock()
+	defer p.Unlock()
+	elem, ok := p.entries[id]
+	if !ok {
+		return proximityEntry{}, false
+	}
+	entry := elem.Value.(*proximityEntry)
+	if time.Now().After(entry.expires) {
+		p.order.Remove(elem)
+		delete(p.entries, id)
+		return proximityEntry{}, false
+	}
+	p.order.MoveToFront(elem)
+	return *entry, true
+}
+
+// observe records a round trip time measured to the Node, folding it into the Node's smoothed RTT, and evicts the least recently used Nodes if the cache is full.
+func (p *proximityCache) observe(id NodeID, sample time.Duration, tcpOnly bool) {
+	p.Lock()
+	defer p.Unlock()
+	expires := time.Now().Add(p.ttl)
+	if elem, ok := p.entries[id]; ok {
+		entry := elem.Value.(*proximityEntry)
+		entry.rtt = entry.rtt.update(sample)
+		entry.tcpOnly = tcpOnly
+		entry.expires = expires
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.entries[id] = p.order.PushFront(&proximityEntry{id: id, rtt: RTT{}.update(sample), tcpOnly: tcpOnly, expires: expires})
+	p.trim()
+}
+
+// trim evicts the least recently used measurements until the cache is within its size. The caller must hold the lock.
+func (p *proximityCache) trim() {
+	for p.order.Len() > p.size {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*proximityEntry).id)
+	}
+}
+
+// SetProximityCache sets how long the round trip times measured to a Node are cached for, and how many Nodes' round trip times are cached. Each measurement resets the Node's expiry; a Node that expires, or is evicted to make room for another, starts a new smoothed RTT the next time it's measured, so the cache should be at least as large as the number of Nodes the state tables can hold. A ttl or size of 0 or less disables the cache; by default, measurements are cached for an hour, for up to 1024 Nodes.
+func (c *Cluster) SetProximityCache(ttl time.Duration, size int) {
+	if ttl <= 0 || size < 0 {
+		size = 0
+	}
+	p := c.proximityCache
+	p.Lock()
+	defer p.Unlock()
+	p.ttl = ttl
+	p.size = size
+	p.trim()
+}
+
+// getCachedProximity returns the Node's smoothed RTT, as a proximity score, or -1 if it needs to be measured.
+func (c *Cluster) getCachedProximity(id NodeID) int64 {
+	if entry, ok := c.proximityCache.get(id); ok {
+		return int64(entry.rtt.Smoothed)
+	}
+	return -1
+}
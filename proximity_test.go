@@ -0,0 +1,40 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that cached round trip times expire on their own, and the least recently used are evicted once the cache is full
+func TestClusterProximityCache(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetProximityCache(time.Hour, 2)
+	first, second, third := NodeID{0, 1}, NodeID{0, 2}, NodeID{0, 3}
+	cluster.proximityCache.observe(first, 10, false)
+	cluster.proximityCache.observe(second, 20, false)
+	// reading first makes second the least recently used
+	if proximity := cluster.getCachedProximity(first); proximity != 10 {
+		t.Errorf("Expected a cached proximity of 10, got %d.", proximity)
+	}
+	cluster.proximityCache.observe(third, 30, false)
+	if proximity := cluster.getCachedProximity(second); proximity != -1 {
+		t.Errorf("Expected %s to be evicted, got a proximity of %d.", second, proximity)
+	}
+	if cluster.getCachedProximity(first) != 10 || cluster.getCachedProximity(third) != 30 {
+		t.Errorf("Expected %s and %s to still be cached.", first, third)
+	}
+	cluster.SetProximityCache(time.Millisecond, 2)
+	cluster.proximityCache.observe(first, 15, false)
+	time.Sleep(5 * time.Millisecond)
+	if proximity := cluster.getCachedProximity(first); proximity != -1 {
+		t.Errorf("Expected the proximity of %s to expire, got %d.", first, proximity)
+	}
+	cluster.SetProximityCache(0, 2)
+	cluster.proximityCache.observe(first, 10, false)
+	if proximity := cluster.getCachedProximity(first); proximity != -1 {
+		t.Errorf("Expected nothing to be cached when the cache is disabled, got %d.", proximity)
+	}
+}
@@ -0,0 +1,47 @@
+package wendy
+
+import (
+	"fmt"
+)
+
+// FirstUserPurpose is the lowest Message purpose available to applications. Purposes below it are reserved for Wendy's own Messages, and can't be sent, handled, or registered.
+const FirstUserPurpose = byte(16)
+
+const reservedPurposeMessage = "Purposes below 16 are reserved for Wendy's own Messages."
+
+// RegisterPurpose claims a Message purpose for the named application, so that applications sharing a Cluster can't unknowingly use the same purpose for different Messages. It returns an InvalidArgumentError if the purpose is reserved, or if it was already registered under a different name. Registering a purpose again under the same name is not an error, so an application can safely register its purposes each time it starts.
+//
+// Registration is local to the Node; applications on other Nodes should register the same purposes.
+func (c *Cluster) RegisterPurpose(purpose byte, name string) error {
+	if purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	if name == "" {
+		return throwInvalidArgumentError("Purposes must be registered under a name.")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if owner, ok := c.purposes[purpose]; ok && owner != name {
+		return throwInvalidArgumentError(fmt.Sprintf("Purpose %d is already registered to %q.", purpose, owner))
+	}
+	c.purposes[purpose] = name
+	return nil
+}
+
+// UnregisterPurpose releases a purpose registered under the specified name, returning false if it isn't registered under that name.
+func (c *Cluster) UnregisterPurpose(purpose byte, name string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if owner, ok := c.purposes[purpose]; !ok || owner != name {
+		return false
+	}
+	delete(c.purposes, purpose)
+	return true
+}
+
+// PurposeName returns the name the specified purpose was registered under, or an empty string if it hasn't been registered.
+func (c *Cluster) PurposeName(purpose byte) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.purposes[purpose]
+}
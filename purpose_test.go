@@ -0,0 +1,56 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that purposes can only be registered once, and only above the reserved range
+func TestClusterRegisterPurpose(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.RegisterPurpose(FirstUserPurpose, "chat")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.RegisterPurpose(FirstUserPurpose, "chat")
+	if err != nil {
+		t.Errorf("Expected registering a purpose again under the same name to succeed, got %v.", err)
+	}
+	err = cluster.RegisterPurpose(FirstUserPurpose, "presence")
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError registering a claimed purpose, got %v.", err)
+	}
+	if name := cluster.PurposeName(FirstUserPurpose); name != "chat" {
+		t.Errorf("Expected purpose %d to be registered to %s, got %s.", FirstUserPurpose, "chat", name)
+	}
+	err = cluster.RegisterPurpose(NODE_SYNC, "sync")
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError registering a reserved purpose, got %v.", err)
+	}
+	if cluster.UnregisterPurpose(FirstUserPurpose, "presence") {
+		t.Errorf("Expected unregistering under the wrong name to fail.")
+	}
+	if !cluster.UnregisterPurpose(FirstUserPurpose, "chat") {
+		t.Errorf("Expected unregistering under the right name to succeed.")
+	}
+	err = cluster.RegisterPurpose(FirstUserPurpose, "presence")
+	if err != nil {
+		t.Errorf("Expected registering a released purpose to succeed, got %v.", err)
+	}
+}
+
+// Test that Send refuses Messages with reserved purposes
+func TestClusterSendReservedPurpose(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, purpose := range []byte{NODE_JOIN, NODE_EXIT, NODE_SYNC, FirstUserPurpose - 1} {
+		err = cluster.Send(cluster.NewMessage(purpose, cluster.self.ID, []byte{}))
+		if _, ok := err.(InvalidArgumentError); !ok {
+			t.Errorf("Expected an InvalidArgumentError sending purpose %d, got %v.", purpose, err)
+		}
+	}
+}
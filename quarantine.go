@@ -0,0 +1,88 @@
+package wendy
+
+import "time"
+
+// defaultProbation is how long removed Nodes are quarantined for, unless SetProbation is called.
+const defaultProbation = 10 * time.Minute
+
+// quarantineEntry records a Node that was removed from the state tables recently.
+type quarantineEntry struct {
+	until    time.Time
+	checking bool
+}
+
+// SetProbation sets how long a Node that was removed from the state tables, because it stopped responding or left the Cluster, is quarantined for. Other Nodes may not have noticed it's gone yet, and keep listing it in the state tables they send; while it's quarantined, those listings don't put it back in the state tables. Instead, the Node is sent a heartbeat, and is only inserted if it responds. A Node that contacts us directly is released from quarantine straight away. A value of 0 disables quarantine; by default, Nodes are quarantined for 10 minutes.
+func (c *Cluster) SetProbation(probation time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if probation < 0 {
+		probation = 0
+	}
+	c.probation = probation
+}
+
+func (c *Cluster) getProbation() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.probation
+}
+
+// quarantineNode quarantines the Node for the probation period.
+func (c *Cluster) quarantineNode(id NodeID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.probation <= 0 {
+		return
+	}
+	c.quarantine[id] = &quarantineEntry{until: time.Now().Add(c.probation)}
+}
+
+// quarantined returns true if the Node is quarantined, forgetting it if its probation is over.
+func (c *Cluster) quarantined(id NodeID) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.quarantine[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(entry.until) {
+		delete(c.quarantine, id)
+		return false
+	}
+	return true
+}
+
+// release ends the Node's quarantine early.
+func (c *Cluster) release(id NodeID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.quarantine, id)
+}
+
+// readmit sends a quarantined Node a heartbeat, and inserts it into the specified tables if it responds. Only one Node is checked at a time, so a Node listed by every state table we receive is only sent one heartbeat.
+func (c *Cluster) readmit(node Node, tables StateMask) {
+	c.lock.Lock()
+	entry, ok := c.quarantine[node.ID]
+	if !ok || entry.checking {
+		c.lock.Unlock()
+		return
+	}
+	entry.checking = true
+	c.lock.Unlock()
+	err := c.send(c.NewMessage(HEARTBEAT, c.self.ID, []byte{}), &node)
+	if err != nil {
+		c.lock.Lock()
+		entry.checking = false
+		c.lock.Unlock()
+		if err != deadNodeError {
+			c.fanOutError(err)
+		}
+		return
+	}
+	c.debug("Quarantined node %s responded. Inserting it.", node.ID)
+	c.release(node.ID)
+	err = c.insert(node, tables)
+	if err != nil {
+		c.fanOutError(err)
+	}
+}
@@ -0,0 +1,92 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that removed Nodes aren't re-inserted until their probation is over
+func TestClusterQuarantine(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetProbation(50 * time.Millisecond)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// nothing listens on port 1, so the Node can't be readmitted early
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.remove(id, ExitTimeout)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected a quarantined Node not to be inserted, got %v.", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	err = cluster.insert(*node, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.getNode(id)
+	if err != nil {
+		t.Errorf("Expected a Node to be inserted once its probation was over, got %v.", err)
+	}
+	cluster.SetProbation(0)
+	cluster.remove(id, ExitTimeout)
+	if cluster.quarantined(id) {
+		t.Errorf("Expected Nodes not to be quarantined when probation is disabled.")
+	}
+}
+
+// Test that a quarantined Node is inserted if it responds to a heartbeat, and released from quarantine when it contacts us
+func TestClusterReadmit(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	one.quarantineNode(two.self.ID)
+	err = one.insert(*two.self, StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(time.Second)
+	for _, err = one.leafset.getNode(two.self.ID); err != nil; _, err = one.leafset.getNode(two.self.ID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a quarantined Node that responds to be inserted, got %v.", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if one.quarantined(two.self.ID) {
+		t.Errorf("Expected a readmitted Node to be released from quarantine.")
+	}
+	one.quarantineNode(two.self.ID)
+	data, err := two.marshal(stateTables{})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.insertMessage(two.NewMessage(STAT_DATA, one.self.ID, data))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if one.quarantined(two.self.ID) {
+		t.Errorf("Expected a Node that contacted us to be released from quarantine.")
+	}
+}
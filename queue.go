@@ -0,0 +1,166 @@
+package wendy
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultSendWorkers   = 8
+	defaultSendQueueSize = 256
+)
+
+var sendWorkersStartedError = errors.New("The send workers have already started.")
+
+type sendJob struct {
+	msg    Message
+	node   *Node
+	result chan error
+}
+
+type sendQueue struct {
+	jobs    [priorityLevels]chan sendJob // a queue for each Priority, from PriorityBulk to PriorityCritical
+	workers int
+	started bool          // guarded by the Cluster's lock
+	closing *sync.RWMutex // held for reading while jobs are queued, and for writing while the jobs left when the Cluster is killed are failed
+	pending int64         // jobs queued or being sent
+}
+
+func newSendQueue(workers, size int) *sendQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+	queue := &sendQueue{
+		workers: workers,
+		closing: new(sync.RWMutex),
+	}
+	for i := range queue.jobs {
+		queue.jobs[i] = make(chan sendJob, size)
+	}
+	return queue
+}
+
+// next waits for a job to be queued, taking the job with the highest Priority if several are waiting. It returns false once done is closed.
+func (q *sendQueue) next(done <-chan struct{}) (sendJob, bool) {
+	select {
+	case <-done:
+		return sendJob{}, false
+	default:
+	}
+	for level := len(q.jobs) - 1; level >= 0; level-- {
+		select {
+		case job := <-q.jobs[level]:
+			return job, true
+		default:
+		}
+	}
+	// nothing is waiting, so wait on every Priority's queue at once, however many there are
+	cases := make([]reflect.SelectCase, 0, len(q.jobs)+1)
+	for _, jobs := range q.jobs {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(jobs)})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	chosen, job, _ := reflect.Select(cases)
+	if chosen == len(q.jobs) {
+		return sendJob{}, false
+	}
+	return job.Interface().(sendJob), true
+}
+
+// fail gives err as the result of every job still queued.
+func (q *sendQueue) fail(err error) {
+	q.closing.Lock()
+	defer q.closing.Unlock()
+	for level := range q.jobs {
+		for draining := true; draining; {
+			select {
+			case job := <-q.jobs[level]:
+				job.result <- err
+				atomic.AddInt64(&q.pending, -1)
+			default:
+				draining = false
+			}
+		}
+	}
+}
+
+// SetSendWorkers sets how many Messages the Cluster will send concurrently, both when it contacts many Nodes at once, such as when sending heartbeats or announcing its presence, and when it routes Messages, and how many Messages of each Priority may wait for a free worker before further sends of that Priority block. This keeps one slow Node from stalling the rest. When Messages are waiting, those with the highest Priority are sent first. It must be called before Listen or Join: once the Cluster has sent a Message, its workers are running, and an error is returned. By default, 8 workers share a queue of 256 Messages for each Priority.
+func (c *Cluster) SetSendWorkers(workers, queueSize int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.queue != nil && c.queue.started {
+		return sendWorkersStartedError
+	}
+	c.queue = newSendQueue(workers, queueSize)
+	return nil
+}
+
+func (c *Cluster) getSendQueue() *sendQueue {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sendQueue()
+}
+
+// sendQueue returns the queue Messages are sent from, creating it with the default settings if SetSendWorkers hasn't been called. The caller must hold c.lock.
+func (c *Cluster) sendQueue() *sendQueue {
+	if c.queue == nil {
+		c.queue = newSendQueue(defaultSendWorkers, defaultSendQueueSize)
+	}
+	return c.queue
+}
+
+// startSendQueue returns the queue Messages are sent from, starting its workers if they haven't been already. The workers run until the Cluster is killed.
+func (c *Cluster) startSendQueue() *sendQueue {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	queue := c.sendQueue()
+	if !queue.started {
+		queue.started = true
+		for i := 0; i < queue.workers; i++ {
+			go c.sendWorker(queue)
+		}
+	}
+	return queue
+}
+
+// sendAsync queues msg to be sent to node by the worker pool, blocking only while the queue for its Priority is full. The returned channel receives the result of the send, or the Cluster's context error if it is killed before msg is sent.
+func (c *Cluster) sendAsync(msg Message, node *Node) <-chan error {
+	queue := c.startSendQueue()
+	result := make(chan error, 1)
+	queue.closing.RLock()
+	defer queue.closing.RUnlock()
+	if c.ctx.Err() != nil {
+		result <- c.ctx.Err()
+		return result
+	}
+	atomic.AddInt64(&queue.pending, 1)
+	select {
+	case queue.jobs[msg.queueLevel()] <- sendJob{msg: msg, node: node, result: result}:
+	case <-c.ctx.Done():
+		atomic.AddInt64(&queue.pending, -1)
+		result <- c.ctx.Err()
+	}
+	return result
+}
+
+func (c *Cluster) sendWorker(queue *sendQueue) {
+	for {
+		job, ok := queue.next(c.ctx.Done())
+		if !ok {
+			queue.fail(c.ctx.Err())
+			return
+		}
+		job.result <- c.send(job.msg, job.node)
+		atomic.AddInt64(&queue.pending, -1)
+	}
+}
+
+// pendingSends returns the number of Messages that are queued or being sent.
+func (c *Cluster) pendingSends() int {
+	return int(atomic.LoadInt64(&c.getSendQueue().pending))
+}
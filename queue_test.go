@@ -0,0 +1,143 @@
+package wendy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingTransport holds dials to one address until it is released.
+type blockingTransport struct {
+	TCPTransport
+	slow    string
+	release chan struct{}
+}
+
+func (t *blockingTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	if address == t.slow {
+		<-t.release
+		return nil, deadNodeError
+	}
+	return t.TCPTransport.Dial(address, timeout)
+}
+
+// Test that a slow Node doesn't hold up sends to other Nodes
+func TestClusterSendQueue(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport := &blockingTransport{slow: "127.0.0.1:1", release: make(chan struct{})}
+	two.SetTransport(transport)
+	two.SetSendWorkers(2, 0)
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	slow := NewNode(one.self.ID, "127.0.0.1", "127.0.0.1", "testing", 1)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	slowResult := two.sendAsync(msg, slow)
+	fastResult := two.sendAsync(msg, one.self)
+	select {
+	case err := <-fastResult:
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on the send to the fast Node.")
+	}
+	close(transport.release)
+	if err := <-slowResult; err != deadNodeError {
+		t.Fatalf("Expected deadNodeError, got %v instead.", err)
+	}
+}
+
+// Test that the send workers can't be replaced once they've started, and that they stop, failing the Messages still queued, when the Cluster is killed
+func TestClusterSendQueueKill(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport := &blockingTransport{slow: "127.0.0.1:1", release: make(chan struct{})}
+	cluster.SetTransport(transport)
+	err = cluster.SetSendWorkers(1, 10)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	slow := NewNode(cluster.self.ID, "127.0.0.1", "127.0.0.1", "testing", 1)
+	msg := cluster.NewMessage(byte(16), cluster.self.ID, []byte("hello, world"))
+	sending := cluster.sendAsync(msg, slow)
+	queued := cluster.sendAsync(msg, slow)
+	if err = cluster.SetSendWorkers(2, 10); err != sendWorkersStartedError {
+		t.Errorf("Expected %v, got %v.", sendWorkersStartedError, err)
+	}
+	cluster.Kill()
+	close(transport.release)
+	// the worker may not have taken the first Message before the Cluster was killed
+	if err = <-sending; err != deadNodeError && err != context.Canceled {
+		t.Errorf("Expected deadNodeError or %v, got %v instead.", context.Canceled, err)
+	}
+	select {
+	case err = <-queued:
+		if err != context.Canceled {
+			t.Errorf("Expected %v, got %v.", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the queued send to fail.")
+	}
+	select {
+	case err = <-cluster.sendAsync(msg, slow):
+		if err != context.Canceled {
+			t.Errorf("Expected %v, got %v.", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for a send after the Cluster was killed to fail.")
+	}
+}
+
+// Test that a worker waiting for a job wakes for a job of any Priority, and when it's told to stop
+func TestSendQueueNext(t *testing.T) {
+	queue := newSendQueue(1, 0)
+	done := make(chan struct{})
+	for level := range queue.jobs {
+		taken := make(chan sendJob)
+		go func() {
+			job, _ := queue.next(done)
+			taken <- job
+		}()
+		node := NewNode(NodeID{uint64(level), 0}, "127.0.0.1", "127.0.0.1", "testing", level)
+		queue.jobs[level] <- sendJob{node: node}
+		select {
+		case job := <-taken:
+			if job.node != node {
+				t.Errorf("Expected the job queued with level %d.", level)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for the job queued with level %d.", level)
+		}
+	}
+	stopped := make(chan bool)
+	go func() {
+		_, ok := queue.next(done)
+		stopped <- ok
+	}()
+	close(done)
+	select {
+	case ok := <-stopped:
+		if ok {
+			t.Errorf("Expected no job once done was closed.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for next to return once done was closed.")
+	}
+}
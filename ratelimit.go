@@ -0,0 +1,143 @@
+package wendy
+
+import (
+	"container/list"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiterMaxBuckets is how many per-IP buckets a rateLimiter keeps; once it's full, the bucket of the IP that connected least recently is discarded to make room for another.
+const rateLimiterMaxBuckets = 1024
+
+// RateLimit describes how many connections may be accepted. Rate is the sustained number of connections allowed per second, and Burst is how many connections may be accepted at once before Rate applies. A Rate of zero means no limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// burst returns the capacity of a bucket enforcing the RateLimit, which is always at least one connection.
+func (r RateLimit) burst() float64 {
+	if r.Burst < 1 {
+		return 1
+	}
+	return float64(r.Burst)
+}
+
+type tokenBucket struct {
+	key    string // the IP, or other key, the bucket limits
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since it was last used and removes a token, returning false if there was none to remove.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) bool {
+	b.refill(limit, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) refill(limit RateLimit, now time.Time) {
+	burst := limit.burst()
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+type rateLimiter struct {
+	global  RateLimit
+	perIP   RateLimit
+	bucket  *tokenBucket
+	buckets map[string]*list.Element
+	order   *list.List // the per-IP buckets, most recently used first
+	lock    *sync.Mutex
+}
+
+func newRateLimiter(global, perIP RateLimit) *rateLimiter {
+	return &rateLimiter{
+		global:  global,
+		perIP:   perIP,
+		bucket:  &tokenBucket{tokens: global.burst(), last: time.Now()},
+		buckets: map[string]*list.Element{},
+		order:   list.New(),
+		lock:    new(sync.Mutex),
+	}
+}
+
+// allow decides whether a connection from ip should be accepted.
+func (l *rateLimiter) allow(ip string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.perIP.Rate > 0 && !l.bucketFor(ip, now).take(l.perIP, now) {
+		return false
+	}
+	if l.global.Rate > 0 && !l.bucket.take(l.global, now) {
+		return false
+	}
+	return true
+}
+
+// bucketFor returns the bucket limiting ip, creating a full one if there is none, and discarding the least recently used bucket if there are too many. The caller must hold the lock.
+func (l *rateLimiter) bucketFor(ip string, now time.Time) *tokenBucket {
+	if elem, ok := l.buckets[ip]; ok {
+		l.order.MoveToFront(elem)
+		return elem.Value.(*tokenBucket)
+	}
+	bucket := &tokenBucket{key: ip, tokens: l.perIP.burst(), last: now}
+	l.buckets[ip] = l.order.PushFront(bucket)
+	for l.order.Len() > rateLimiterMaxBuckets {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*tokenBucket).key)
+	}
+	return bucket
+}
+
+// SetAcceptRateLimit limits how quickly the Cluster will accept inbound connections, to protect the Node from connection floods. global limits connections from all sources combined, and perIP limits connections from each source IP address. Connections that exceed either limit are closed without being read, counted in Stats, and reported to any Application that fulfills RejectedConnectionHandler.
+//
+// A RateLimit with a Rate of zero disables that limit. By default, connections are not limited.
+func (c *Cluster) SetAcceptRateLimit(global, perIP RateLimit) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if global.Rate <= 0 && perIP.Rate <= 0 {
+		c.limiter = nil
+		return
+	}
+	c.limiter = newRateLimiter(global, perIP)
+}
+
+func (c *Cluster) getLimiter() *rateLimiter {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.limiter
+}
+
+func (c *Cluster) allowConnection(conn net.Conn) bool {
+	limiter := c.getLimiter()
+	if limiter == nil {
+		return true
+	}
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return limiter.allow(ip, time.Now())
+}
+
+func (c *Cluster) rejectConnection(conn net.Conn) {
+	addr := conn.RemoteAddr()
+	conn.Close()
+	atomic.AddUint64(&c.stats.RejectedConnections, 1)
+	c.warn("Rejected connection from %s: rate limit exceeded.", addr)
+	c.notify(EventRejectedConnection, func(app Application) {
+		if handler, ok := app.(RejectedConnectionHandler); ok {
+			handler.OnRejectedConnection(addr)
+		}
+	})
+}
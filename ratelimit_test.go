@@ -0,0 +1,105 @@
+package wendy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type rejectCallback struct {
+	*testCallback
+	rejected chan net.Addr
+}
+
+func (r *rejectCallback) OnRejectedConnection(addr net.Addr) {
+	r.rejected <- addr
+}
+
+// Test that the rate limiter enforces per-IP and global limits
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(RateLimit{Rate: 1, Burst: 3}, RateLimit{Rate: 1, Burst: 2})
+	limiter.bucket.last = now
+	if !limiter.allow("10.0.0.1", now) || !limiter.allow("10.0.0.1", now) {
+		t.Fatalf("Expected the burst to be allowed.")
+	}
+	if limiter.allow("10.0.0.1", now) {
+		t.Fatalf("Expected a connection over the per-IP burst to be refused.")
+	}
+	if !limiter.allow("10.0.0.2", now) {
+		t.Fatalf("Expected a connection from another IP to be allowed.")
+	}
+	if limiter.allow("10.0.0.3", now) {
+		t.Fatalf("Expected a connection over the global burst to be refused.")
+	}
+	now = now.Add(time.Second)
+	if !limiter.allow("10.0.0.1", now) {
+		t.Fatalf("Expected the per-IP bucket to refill.")
+	}
+}
+
+// Test that the rate limiter keeps a bounded number of per-IP buckets, discarding the least recently used
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := newRateLimiter(RateLimit{}, RateLimit{Rate: 1, Burst: 1})
+	if !limiter.allow("10.0.0.1", now) {
+		t.Fatalf("Expected the first connection to be allowed.")
+	}
+	for i := 0; i < rateLimiterMaxBuckets; i++ {
+		// using 10.0.0.1's bucket halfway through keeps it from being the least recently used
+		if i == rateLimiterMaxBuckets/2 && limiter.allow("10.0.0.1", now) {
+			t.Fatalf("Expected a connection over the per-IP burst to be refused.")
+		}
+		limiter.allow(net.IPv4(10, 1, byte(i>>8), byte(i)).String(), now)
+	}
+	if len(limiter.buckets) != rateLimiterMaxBuckets || limiter.order.Len() != rateLimiterMaxBuckets {
+		t.Errorf("Expected %d buckets, got %d.", rateLimiterMaxBuckets, len(limiter.buckets))
+	}
+	if limiter.allow("10.0.0.1", now) {
+		t.Errorf("Expected a recently used bucket to be kept.")
+	}
+	if !limiter.allow("10.1.0.0", now) {
+		t.Errorf("Expected the least recently used bucket to be discarded.")
+	}
+}
+
+// Test that a Cluster rejects connections over its rate limit
+func TestClusterAcceptRateLimit(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetAcceptRateLimit(RateLimit{}, RateLimit{Rate: 0.001, Burst: 1})
+	oneCB := &rejectCallback{testCallback: newTestCallback(t), rejected: make(chan net.Addr, 1)}
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-oneCB.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	two.SendToIP(msg, two.GetIP(*one.self))
+	select {
+	case <-oneCB.rejected:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on rejected connection.")
+	}
+	if stats := one.Stats(); stats.RejectedConnections != 1 {
+		t.Fatalf("Expected %d rejected connection, got %d.", 1, stats.RejectedConnections)
+	}
+}
@@ -0,0 +1,57 @@
+package wendy
+
+import (
+	"time"
+)
+
+// SetRejoinPolicy sets how the Node rejoins the Cluster if it loses contact with every other Node, for example after a network partition, or after every Node it knew of restarted. Once the Node has joined, each heartbeat sweep that leaves its state tables empty starts the join protocol again through the seeds set with SetSeeds, trying them up to policy.Attempts times, with the delays between tries set by the rest of the policy. If every try fails, the Node tries again after the next heartbeat sweep.
+//
+// While it is rejoining, the Node isn't Ready. Joined stays closed, and OnJoined isn't called again. By default, and if policy.Attempts is less than 1, the Node doesn't rejoin on its own.
+func (c *Cluster) SetRejoinPolicy(policy RetryPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rejoinPolicy = policy
+}
+
+// rejoinIfIsolated runs the join protocol again if the Node has joined the Cluster before but no longer knows of any other Node.
+func (c *Cluster) rejoinIfIsolated() {
+	if !c.hasJoined() || !c.isolated() {
+		return
+	}
+	c.lock.Lock()
+	policy := c.rejoinPolicy
+	seeds := c.seeds
+	if policy.Attempts < 1 || len(seeds) == 0 || c.rejoining {
+		c.lock.Unlock()
+		return
+	}
+	c.rejoining = true
+	c.joined = false
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.rejoining = false
+	}()
+	c.warn("Lost contact with every other Node. Rejoining through %d seeds.", len(seeds))
+	for attempt := 1; ; attempt++ {
+		err := c.joinAny(seeds)
+		if err == nil {
+			return
+		}
+		if attempt >= policy.Attempts {
+			c.fanOutError(err)
+			return
+		}
+		select {
+		case <-time.After(policy.delay(attempt)):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// isolated returns true if the Node's state tables are empty.
+func (c *Cluster) isolated() bool {
+	return countNodes(c.leafset.list()) == 0 && countNodes(c.table.list([]int{}, []int{})) == 0 && countNodes(c.neighborhoodset.list()) == 0
+}
@@ -0,0 +1,106 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that an isolated Node only rejoins if it has a rejoin policy and has joined before
+func TestClusterRejoinIfIsolatedDisabled(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetSeeds([]string{"127.0.0.1:1"})
+	cluster.SetRejoinPolicy(RetryPolicy{Attempts: 1})
+	cluster.rejoinIfIsolated()
+	if cluster.rejoining || cluster.joined {
+		t.Errorf("Expected a Node that never joined not to rejoin.")
+	}
+	cluster.SetRejoinPolicy(RetryPolicy{})
+	cluster.joined = true
+	close(cluster.joinedCh)
+	cluster.rejoinIfIsolated()
+	if !cluster.isJoined() {
+		t.Errorf("Expected a Node without a rejoin policy not to rejoin.")
+	}
+}
+
+// Test that an isolated Node reports an error once every attempt to rejoin has failed
+func TestClusterRejoinUnreachable(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 1)}
+	cluster.RegisterCallback(callback)
+	cluster.SetSeeds([]string{"127.0.0.1:1"})
+	cluster.SetRejoinPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Millisecond})
+	cluster.joined = true
+	close(cluster.joinedCh)
+	cluster.rejoinIfIsolated()
+	if cluster.isJoined() {
+		t.Errorf("Expected an isolated Node not to be joined while it rejoins.")
+	}
+	select {
+	case err = <-callback.errors:
+		if err != deadNodeError {
+			t.Errorf("Expected %v, got %v.", deadNodeError, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for the rejoin error.")
+	}
+	if cluster.rejoining {
+		t.Errorf("Expected the Node to be ready to rejoin again after the next heartbeat sweep.")
+	}
+}
+
+// Test that a Node that loses contact with every other Node rejoins through its seeds
+func TestClusterRejoin(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	seeds := []string{two.GetIP(*one.self)}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, seeds)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetRejoinPolicy(RetryPolicy{Attempts: 3, BaseDelay: 10 * time.Millisecond})
+	// repairing the state tables fails, as there's no other Node left to ask, and no seeds to bootstrap from yet
+	two.remove(one.self.ID, ExitTimeout)
+	if !two.isolated() {
+		t.Fatalf("Expected the Node to be isolated once it removed the only other Node.")
+	}
+	two.SetSeeds(seeds)
+	two.rejoinIfIsolated()
+	if two.Ready() {
+		t.Errorf("Expected a rejoining Node not to be Ready.")
+	}
+	deadline := time.Now().Add(3 * time.Duration(one.getNetworkTimeout()) * time.Second)
+	for !two.isJoined() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for the Node to rejoin.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = two.leafset.getNode(one.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+}
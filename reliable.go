@@ -0,0 +1,158 @@
+package wendy
+
+import (
+	"context"
+	"crypto/rand"
+	"sync/atomic"
+	"time"
+)
+
+const defaultDedupWindow = 10 * time.Minute
+
+// defaultReliableDelay is how long SendReliable waits for an acknowledgement before resending, if the RetryPolicy has no BaseDelay.
+const defaultReliableDelay = time.Second
+
+// SendReliable routes a Message through the Cluster like Send, but makes sure it's delivered: the Message is given a unique ID, and resent until the Node it's delivered to acknowledges it, or ctx is done. The time between resends follows the RetryPolicy set with SetRetryPolicy, or is a second if it has no BaseDelay.
+//
+// Because a Message can be delivered and its acknowledgement lost, the same Message may arrive more than once. Nodes remember the IDs of the Messages they've delivered for the window set with SetDedupWindow, and only deliver each one once, so OnDeliver is called at most once for each Message sent. The Message must have a purpose of FirstUserPurpose or above. If ctx is done before the Message is acknowledged, its error is returned, and the Message may or may not have been delivered. If the Message has an Expires time, it isn't resent after it, and an error is returned if it expires, or a Node reports that it expired on the way.
+func (c *Cluster) SendReliable(ctx context.Context, msg Message) error {
+	if msg.Purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	id, err := newMessageID()
+	if err != nil {
+		return err
+	}
+	msg.ID = id
+	return c.sendReliable(ctx, msg)
+}
+
+// newMessageID returns a random ID for a Message sent with SendReliable.
+func newMessageID() ([]byte, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	return id, err
+}
+
+// sendReliable sends a Message that has been given an ID, resending it until it's acknowledged.
+func (c *Cluster) sendReliable(ctx context.Context, msg Message) error {
+	msg.Ack = false
+	msg.Expired = false
+	msg = c.sequence(msg)
+	acked := make(chan error, 1)
+	c.lock.Lock()
+	if c.acks == nil {
+		c.acks = map[string]chan error{}
+	}
+	c.acks[string(msg.ID)] = acked
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.acks, string(msg.ID))
+		c.lock.Unlock()
+	}()
+	policy := c.getRetryPolicy()
+	for attempt := 1; ; attempt++ {
+		if msg.expired() {
+			return messageExpiredError
+		}
+		err := c.routeMessage(msg)
+		if err != nil {
+			c.debug("Couldn't send reliable message %s: %s", msg.Key, err)
+		}
+		delay := defaultReliableDelay
+		if policy.BaseDelay > 0 {
+			delay = policy.delay(attempt)
+		}
+		select {
+		case err := <-acked:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(delay):
+		}
+		atomic.AddUint64(&c.stats.ReliableResends, 1)
+		c.debug("Message %s wasn't acknowledged, resending.", msg.Key)
+	}
+}
+
+// SetDedupWindow sets how long the Cluster remembers the IDs of Messages sent with SendReliable after delivering them, so they aren't delivered again if they're resent. Resends that arrive after the window are delivered again, so it should be longer than the deadline given to SendReliable. The default is 10 minutes.
+func (c *Cluster) SetDedupWindow(window time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dedupWindow = window
+}
+
+func (c *Cluster) getDedupWindow() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.dedupWindow <= 0 {
+		return defaultDedupWindow
+	}
+	return c.dedupWindow
+}
+
+// firstDelivery records that the Message with the specified ID was delivered, returning false if it was already delivered within the dedup window.
+func (c *Cluster) firstDelivery(id []byte) bool {
+	window := c.getDedupWindow()
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// forget the IDs that have fallen out of the window, oldest first
+	for len(c.deliveredOrder) > 0 {
+		oldest := c.deliveredOrder[0]
+		if now.Sub(c.delivered[oldest]) < window {
+			break
+		}
+		delete(c.delivered, oldest)
+		c.deliveredOrder = c.deliveredOrder[1:]
+	}
+	if _, ok := c.delivered[string(id)]; ok {
+		return false
+	}
+	if c.delivered == nil {
+		c.delivered = map[string]time.Time{}
+	}
+	c.delivered[string(id)] = now
+	c.deliveredOrder = append(c.deliveredOrder, string(id))
+	return true
+}
+
+// acknowledge tells the Sender of a Message sent with SendReliable that it was delivered, or that it expired, and never will be.
+func (c *Cluster) acknowledge(msg Message, expired bool) {
+	ack := c.NewMessage(msg.Purpose, msg.Sender.ID, nil)
+	ack.ID = msg.ID
+	ack.Ack = true
+	ack.Expired = expired
+	if msg.Sender.ID.Equals(c.self.ID) {
+		c.onAck(ack)
+		return
+	}
+	sender := msg.Sender
+	go func() {
+		err := c.send(ack, &sender)
+		if err != nil {
+			c.debug("Couldn't acknowledge message %s to %s: %s", msg.Key, sender.ID, err)
+		}
+	}()
+}
+
+// onAck stops SendReliable resending the Message that was acknowledged, returning an error from it if the Message expired.
+func (c *Cluster) onAck(msg Message) {
+	c.lock.RLock()
+	acked, ok := c.acks[string(msg.ID)]
+	c.lock.RUnlock()
+	if !ok {
+		return
+	}
+	var err error
+	if msg.Expired {
+		err = messageExpiredError
+	}
+	select {
+	case acked <- err:
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that each Message ID is only delivered once within the dedup window
+func TestFirstDelivery(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetDedupWindow(50 * time.Millisecond)
+	if !cluster.firstDelivery([]byte("first")) {
+		t.Errorf("Expected the first delivery of a Message to be allowed.")
+	}
+	if cluster.firstDelivery([]byte("first")) {
+		t.Errorf("Expected a second delivery of a Message to be refused.")
+	}
+	if !cluster.firstDelivery([]byte("second")) {
+		t.Errorf("Expected the first delivery of another Message to be allowed.")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !cluster.firstDelivery([]byte("first")) {
+		t.Errorf("Expected a Message to be delivered again after the dedup window.")
+	}
+	if len(cluster.delivered) != 1 {
+		t.Errorf("Expected the IDs outside the dedup window to be forgotten, got %d IDs.", len(cluster.delivered))
+	}
+}
+
+// Test that a Message sent with SendReliable to the current Node is delivered once and acknowledged
+func TestSendReliableLocal(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	cluster.RegisterCallback(callback)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = cluster.SendReliable(ctx, cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("hello")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if len(msg.ID) != 16 {
+			t.Errorf("Expected the Message to be given an ID, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+	err = cluster.SendReliable(ctx, cluster.NewMessage(NODE_JOIN, cluster.self.ID, nil))
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected reserved purposes to be refused, got %v.", err)
+	}
+}
+
+// Test that SendReliable resends a Message until it's acknowledged, and the Node it's sent to only delivers it once
+func TestSendReliable(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	// two isn't listening yet, so the acknowledgements are lost and the Message is resent
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetRetryPolicy(RetryPolicy{BaseDelay: 20 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = two.SendReliable(ctx, two.NewMessage(FirstUserPurpose, one.self.ID, []byte("hello")))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the Message not to be acknowledged, got %v.", err)
+	}
+	if resends := two.Stats().ReliableResends; resends < 1 {
+		t.Errorf("Expected the Message to be resent.")
+	}
+	if duplicates := one.Stats().DuplicateMessages; duplicates < 1 {
+		t.Errorf("Expected the resent Messages to be discarded as duplicates.")
+	}
+	<-callback.onDeliver
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message to be delivered once, got %+v again.", msg)
+	default:
+	}
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = two.SendReliable(ctx, two.NewMessage(FirstUserPurpose, one.self.ID, []byte("hello again")))
+	if err != nil {
+		t.Fatalf("Expected the Message to be acknowledged, got %v.", err)
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "hello again" {
+			t.Errorf("Expected the second Message to be delivered, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+}
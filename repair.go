@@ -0,0 +1,154 @@
+package wendy
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var noRepairCandidatesError = errors.New("No Node was left to repair the state tables through.")
+
+// Repair asks other Nodes for the state tables selected by mask and inserts what they send back, for operators who suspect the current Node's view of the Cluster has diverged from the rest of it. The leaf set is repaired through every Node in it, and the neighborhood set through every Node in it. Each row of the routing table is repaired through the Nodes in that row, or, if it's empty, the next row down that isn't; Mask.Rows, if set, limits which rows are repaired, and Mask.Cols which columns are sent back. Nodes that don't respond are suspected. A table with no Node to ask, or whose Nodes all fail to respond, is repaired through the neighborhood set or the seeds, as it would be after a Node is removed, and the first error doing so is returned.
+//
+// Repair returns once the requests have been answered; the state tables sent back are inserted as they arrive.
+func (c *Cluster) Repair(mask StateMask) error {
+	if mask.Mask&all == 0 {
+		return throwInvalidArgumentError("The StateMask must select at least one state table.")
+	}
+	var repairErr error
+	record := func(err error) {
+		if err != nil && repairErr == nil {
+			repairErr = err
+		}
+	}
+	if mask.includeLS() {
+		record(c.repairThrough(StateMask{Mask: lS}, c.leafset.list()))
+	}
+	if mask.includeRT() {
+		record(c.repairRows(mask.Rows, mask.Cols))
+	}
+	if mask.includeNS() {
+		record(c.repairThrough(StateMask{Mask: nS}, c.neighborhoodset.list()))
+	}
+	return repairErr
+}
+
+// repairRows repairs the specified rows of the routing table, or every row if none are specified, each through the Nodes in the row, or the next row down that has any. Rows with no such Node are repaired by escalating.
+func (c *Cluster) repairRows(rows, cols []int) error {
+	if len(rows) < 1 {
+		for row := 0; row < c.table.rowCount(); row++ {
+			rows = append(rows, row)
+		}
+	}
+	// rows that would be repaired through the same Nodes are requested together
+	through := map[int][]int{}
+	order := []int{}
+	orphaned := []int{}
+	for _, row := range rows {
+		if row < 0 || row >= c.table.rowCount() {
+			continue
+		}
+		target := row
+		for target < c.table.rowCount() && countNodes(c.table.list([]int{target}, []int{})) < 1 {
+			target++
+		}
+		if target >= c.table.rowCount() {
+			orphaned = append(orphaned, row)
+			continue
+		}
+		if _, ok := through[target]; !ok {
+			order = append(order, target)
+		}
+		through[target] = append(through[target], row)
+	}
+	var repairErr error
+	for _, target := range order {
+		err := c.repairThrough(StateMask{Mask: rT, Rows: through[target], Cols: cols}, c.table.list([]int{target}, []int{}))
+		if err != nil && repairErr == nil {
+			repairErr = err
+		}
+	}
+	if len(orphaned) > 0 {
+		err := c.escalateRepair(StateMask{Mask: rT, Rows: orphaned, Cols: cols})
+		if err != nil && repairErr == nil {
+			repairErr = err
+		}
+	}
+	return repairErr
+}
+
+// repairThrough sends a repair request for mask to each of targets, concurrently, and suspects those that don't respond. If none of them respond, or there are none, the repair is escalated.
+func (c *Cluster) repairThrough(mask StateMask, targets []*Node) error {
+	data, err := c.marshal(mask)
+	if err != nil {
+		return err
+	}
+	msg := c.NewMessage(NODE_REPR, c.self.ID, data)
+	sent := map[NodeID]<-chan error{}
+	nodes := map[NodeID]*Node{}
+	for _, target := range targets {
+		if target == nil || nodes[target.ID] != nil {
+			continue
+		}
+		sent[target.ID] = c.sendAsync(msg, target)
+		nodes[target.ID] = target
+	}
+	if c.awaitSends(sent, nodes) < 1 {
+		return c.escalateRepair(mask)
+	}
+	return nil
+}
+
+// escalateRepair is used when no Node in the state table being repaired can help repair it. The repair request is sent to every Node in the neighborhood set instead; if none of them can be reached, the Node bootstraps its state tables again from the seeds set with SetSeeds, asking the first seed that responds for every table. If that fails too, the repair has failed: a RepairFailed event is emitted, it's counted in Stats, and noRepairCandidatesError is returned.
+func (c *Cluster) escalateRepair(mask StateMask) error {
+	data, err := c.marshal(mask)
+	if err != nil {
+		return err
+	}
+	msg := c.NewMessage(NODE_REPR, c.self.ID, data)
+	asked := false
+	for _, target := range c.neighborhoodset.list() {
+		if target == nil {
+			continue
+		}
+		err = c.send(msg, target)
+		if err == nil {
+			asked = true
+		}
+	}
+	if asked {
+		c.debug("Asked the neighborhood set to repair the state tables.")
+		return nil
+	}
+	if c.bootstrapFromSeeds() {
+		return nil
+	}
+	c.warn("No node left to repair the state tables through. Was there a catastrophe?")
+	atomic.AddUint64(&c.stats.FailedRepairs, 1)
+	c.emit(ClusterEvent{Type: RepairFailed, Err: noRepairCandidatesError})
+	return noRepairCandidatesError
+}
+
+// bootstrapFromSeeds asks the seeds set with SetSeeds, in order, for every state table, until one of them accepts the request. It returns false if there are no seeds, or none of them could be reached.
+func (c *Cluster) bootstrapFromSeeds() bool {
+	c.lock.RLock()
+	seeds := c.seeds
+	c.lock.RUnlock()
+	if len(seeds) == 0 {
+		return false
+	}
+	data, err := c.marshal(StateMask{Mask: all})
+	if err != nil {
+		c.fanOutError(err)
+		return false
+	}
+	msg := c.NewMessage(NODE_REPR, c.self.ID, data)
+	for _, address := range seeds {
+		err = c.SendToIP(msg, address)
+		if err == nil {
+			c.debug("Asked seed %s to repair the state tables.", address)
+			return true
+		}
+		c.warn("Couldn't repair the state tables through %s: %s", address, err.Error())
+	}
+	return false
+}
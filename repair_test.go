@@ -0,0 +1,143 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that a repair with no Node left to ask, and no seeds, fails and is reported
+func TestClusterRepairFailed(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	events := cluster.Events()
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: lS})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.remove(id, ExitTimeout)
+	if err != noRepairCandidatesError {
+		t.Errorf("Expected %v, got %v.", noRepairCandidatesError, err)
+	}
+	if cluster.Stats().FailedRepairs != 1 {
+		t.Errorf("Expected 1 failed repair, got %d.", cluster.Stats().FailedRepairs)
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != RepairFailed {
+				continue
+			}
+			if event.Err != noRepairCandidatesError {
+				t.Errorf("Expected the event to carry %v, got %v.", noRepairCandidatesError, event.Err)
+			}
+			return
+		case <-timeout:
+			t.Fatalf("Timeout waiting for a RepairFailed event.")
+		}
+	}
+}
+
+// Test that a repair with no Node left to ask bootstraps the state tables from the seeds
+func TestClusterRepairFromSeeds(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	one.SetSeeds([]string{"127.0.0.1:1", one.GetIP(*two.self)})
+	err = one.repairLeafset(two.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = one.leafset.getNode(two.self.ID)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for the seed to repair the state tables.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if one.Stats().FailedRepairs != 0 {
+		t.Errorf("Expected no failed repairs, got %d.", one.Stats().FailedRepairs)
+	}
+}
+
+// Test that Repair refuses a StateMask that selects no state tables
+func TestClusterRepairInvalidMask(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.Repair(StateMask{})
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError, got %v.", err)
+	}
+}
+
+// Test that Repair fills in Nodes the current Node's state tables are missing from the Nodes in them
+func TestClusterRepair(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, cluster := range []*Cluster{one, two, three} {
+		go cluster.Listen()
+		defer cluster.Kill()
+	}
+	waitListening(t, one, two, three)
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*three.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.Repair(StateMask{Mask: MaskAll})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err = one.leafset.getNode(three.self.ID)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for the repair to add %s.", three.self.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,43 @@
+package wendy
+
+import (
+	"sort"
+)
+
+// ReplicaSet returns the k Nodes closest to key in the node space, from the current Node's point of view, closest first. It is meant for storage layers built on Wendy that need to decide where to place replicas of the value stored under key.
+//
+// The current Node and every Node in its state tables are considered. In a Cluster that has stabilised, the leaf set holds the Nodes closest to any key the current Node would deliver, so for those keys the result matches what other Nodes would compute. Fewer than k Nodes are returned if the current Node doesn't know of k. An InvalidArgumentError is returned if k is less than 1.
+func (c *Cluster) ReplicaSet(key NodeID, k int) ([]Node, error) {
+	if k < 1 {
+		return nil, throwInvalidArgumentError("A replica set must have at least one Node.")
+	}
+	nodes := []*Node{c.self}
+	nodes = append(nodes, c.leafset.list()...)
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	seen := map[NodeID]bool{}
+	candidates := []*Node{}
+	for _, node := range nodes {
+		if node == nil || seen[node.ID] {
+			continue
+		}
+		seen[node.ID] = true
+		candidates = append(candidates, node)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		cmp := key.distance(candidates[i].ID).absCmp(key.distance(candidates[j].ID))
+		if cmp != 0 {
+			return cmp < 0
+		}
+		// a Node on either side of the key can be equally close; break the tie consistently
+		return candidates[i].ID.absLess(candidates[j].ID)
+	})
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	replicas := make([]Node, 0, len(candidates))
+	for _, node := range candidates {
+		replicas = append(replicas, *node.clone())
+	}
+	return replicas, nil
+}
@@ -0,0 +1,56 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that ReplicaSet returns the closest Nodes to the key, closest first
+func TestClusterReplicaSet(t *testing.T) {
+	self, err := NodeIDFromBytes([]byte{0x50, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster := NewCluster(NewNode(self, "127.0.0.1", "127.0.0.1", "testing", 8080), nil)
+	ids := []NodeID{}
+	for _, first := range []byte{0x10, 0x40, 0x48, 0x60, 0x90} {
+		id, err := NodeIDFromBytes([]byte{first, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		ids = append(ids, id)
+		node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 8080)
+		node.setProximity(1)
+		_, err = cluster.leafset.insertNode(*node)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	key, err := NodeIDFromBytes([]byte{0x47, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	replicas, err := cluster.ReplicaSet(key, 3)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []NodeID{ids[2], ids[1], self}
+	if len(replicas) != len(expected) {
+		t.Fatalf("Expected %d replicas, got %d.", len(expected), len(replicas))
+	}
+	for i, id := range expected {
+		if !replicas[i].ID.Equals(id) {
+			t.Errorf("Expected replica %d to be %s, got %s.", i, id, replicas[i].ID)
+		}
+	}
+	replicas, err = cluster.ReplicaSet(key, 10)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(replicas) != len(ids)+1 {
+		t.Errorf("Expected every known Node when k exceeds them, got %d replicas.", len(replicas))
+	}
+	_, err = cluster.ReplicaSet(key, 0)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for an empty replica set, got %v.", err)
+	}
+}
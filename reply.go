@@ -0,0 +1,109 @@
+package wendy
+
+import (
+	"context"
+)
+
+// Reply sends value straight back to the Node that sent msg, rather than routing it through the Cluster, with the same purpose as msg. The reply's InReplyTo is set to msg's RequestID, or its ID if it has none, so if msg was sent with Request, the reply is returned from Request; otherwise, it's delivered to the Sender's Applications like any other Message. The reply carries msg's Headers and Priority, so trace IDs and the like follow it back. If the Sender doesn't respond, and an Outbox is set with SetOutbox, the reply is held until the Sender is heard from again.
+func (c *Cluster) Reply(msg Message, value []byte) error {
+	if msg.Purpose < FirstUserPurpose {
+		return throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	reply := c.NewMessage(msg.Purpose, msg.Sender.ID, value)
+	reply.Direct = true
+	reply.InReplyTo = msg.ID
+	if len(msg.RequestID) > 0 {
+		reply.InReplyTo = msg.RequestID
+	}
+	reply.Headers = copyMetadata(msg.Headers)
+	reply.Priority = msg.Priority
+	if msg.Sender.ID.Equals(c.self.ID) {
+		c.deliver(reply)
+		return nil
+	}
+	sender := msg.Sender
+	err := c.send(reply, &sender)
+	if err == deadNodeError && c.hold(&sender, reply) {
+		return nil
+	}
+	return err
+}
+
+// Request sends a Message like SendReliable, and waits for the Node it's delivered to to answer it with Reply, returning the reply. If ctx is done before the reply arrives, its error is returned. The Message must have a purpose of FirstUserPurpose or above.
+func (c *Cluster) Request(ctx context.Context, msg Message) (Message, error) {
+	if msg.Purpose < FirstUserPurpose {
+		return Message{}, throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	id, err := newMessageID()
+	if err != nil {
+		return Message{}, err
+	}
+	msg.ID = id
+	replied, forget := c.awaitReply(id)
+	defer forget()
+	err = c.sendReliable(ctx, msg)
+	if err != nil {
+		return Message{}, err
+	}
+	return c.waitReply(ctx, replied)
+}
+
+// requestNode sends a Message straight to node, once, and waits for the node to answer it with Reply, returning the reply. The Message is given a RequestID rather than an ID, so node doesn't acknowledge it, or remember it for the dedup window, as it would a Message sent with SendReliable.
+func (c *Cluster) requestNode(ctx context.Context, msg Message, node *Node) (Message, error) {
+	id, err := newMessageID()
+	if err != nil {
+		return Message{}, err
+	}
+	msg.RequestID = id
+	msg.Direct = true
+	replied, forget := c.awaitReply(id)
+	defer forget()
+	err = <-c.sendAsync(msg, node)
+	if err != nil {
+		return Message{}, err
+	}
+	return c.waitReply(ctx, replied)
+}
+
+// awaitReply registers a channel for the reply to the Message with the specified ID, returning it with a function that unregisters it.
+func (c *Cluster) awaitReply(id []byte) (chan Message, func()) {
+	replied := make(chan Message, 1)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.replies == nil {
+		c.replies = map[string]chan Message{}
+	}
+	c.replies[string(id)] = replied
+	return replied, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.replies, string(id))
+	}
+}
+
+// waitReply waits for a reply to arrive on replied, until ctx is done, or the Cluster is killed.
+func (c *Cluster) waitReply(ctx context.Context, replied chan Message) (Message, error) {
+	select {
+	case reply := <-replied:
+		return reply, nil
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case <-c.ctx.Done():
+		return Message{}, c.ctx.Err()
+	}
+}
+
+// onReply passes a reply to the Request waiting for it, returning false if no Request is waiting for it.
+func (c *Cluster) onReply(msg Message) bool {
+	c.lock.RLock()
+	replied, ok := c.replies[string(msg.InReplyTo)]
+	c.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	select {
+	case replied <- msg:
+	default:
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that Request returns the reply to the Message it sent, and replies to other Messages are delivered
+func TestClusterRequest(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.Handle(FirstUserPurpose, func(msg Message) {
+		err := one.Reply(msg, append([]byte("re: "), msg.Value...))
+		if err != nil {
+			t.Errorf("Expected the reply to be sent, got %v.", err)
+		}
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	two.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("hello"))
+	msg.Headers = map[string]string{"trace-id": "abc123"}
+	reply, err := two.Request(ctx, msg)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(reply.Value) != "re: hello" || !reply.Sender.ID.Equals(one.self.ID) || reply.Headers["trace-id"] != "abc123" {
+		t.Errorf("Expected a reply from %s to the request, got %+v.", one.self.ID, reply)
+	}
+	select {
+	case delivered := <-callback.onDeliver:
+		t.Errorf("Expected the reply to be returned from Request, not delivered, got %+v.", delivered)
+	default:
+	}
+	err = two.Send(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("goodbye")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case delivered := <-callback.onDeliver:
+		if string(delivered.Value) != "re: goodbye" || len(delivered.InReplyTo) != 0 {
+			t.Errorf("Expected the reply to a Message sent with Send to be delivered, got %+v.", delivered)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the reply to be delivered.")
+	}
+}
+
+// Test that a Node can reply to Messages it sent itself
+func TestReplyLocal(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = cluster.Handle(FirstUserPurpose, func(msg Message) {
+		cluster.Reply(msg, []byte("pong"))
+	})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := cluster.Request(ctx, cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("ping")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(reply.Value) != "pong" {
+		t.Errorf("Expected pong, got %+v.", reply)
+	}
+}
@@ -0,0 +1,140 @@
+package wendy
+
+// maxReputations is the most Nodes a Cluster tracks the Reputation of; when a new Node would exceed it, the Reputation of another, chosen at random, is forgotten.
+const maxReputations = 4096
+
+// Reputation describes how reliably a Node has behaved in its exchanges with the current Node.
+type Reputation struct {
+	Successes uint64  // Messages sent to the Node that it acknowledged
+	Errors    uint64  // Messages sent to the Node that failed for reasons other than a timeout
+	Timeouts  uint64  // Messages sent to the Node that it didn't respond to in time, or at all
+	Malformed uint64  // Messages received from the Node that passed authentication but had to be discarded, like a wrong join challenge answer; failures before authentication can't be attributed to a Node, so they aren't counted
+	Score     float64 // The proportion of exchanges with the Node that went well, between 0 and 1; Nodes with no history score 0.5
+}
+
+// score calculates the Reputation's Score. A failure counts the same however it happened; one success and one failure are added to every Node's history, so a single exchange doesn't decide a Node's Score.
+func (r Reputation) score() float64 {
+	failures := r.Errors + r.Timeouts + r.Malformed
+	return float64(r.Successes+1) / float64(r.Successes+failures+2)
+}
+
+// Reputation returns the Reputation of the Node with the specified ID. Nodes the current Node hasn't exchanged Messages with have a Score of 0.5.
+func (c *Cluster) Reputation(id NodeID) Reputation {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var r Reputation
+	if rep, ok := c.reputations[id]; ok {
+		r = *rep
+	}
+	r.Score = r.score()
+	return r
+}
+
+// Reputations returns the Reputation of every Node the current Node has exchanged Messages with.
+func (c *Cluster) Reputations() map[NodeID]Reputation {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	reputations := map[NodeID]Reputation{}
+	for id, rep := range c.reputations {
+		r := *rep
+		r.Score = r.score()
+		reputations[id] = r
+	}
+	return reputations
+}
+
+// SetMinReputation sets the Score below which Nodes are avoided when routing Messages. When the routing table's choice for the next hop has a lower Score, any other Node in the state tables that is at least as close to the Message's key is considered, and whichever has the highest Score is used instead. Messages are never routed away from the leaf set Node closest to their key, so a Node's own keys always reach it. A value of 0, the default, routes without regard to Reputation.
+func (c *Cluster) SetMinReputation(score float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.minReputation = score
+}
+
+func (c *Cluster) getMinReputation() float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.minReputation
+}
+
+// recordSend updates the Reputation of the Node a Message was sent to, based on the result of sending it.
+func (c *Cluster) recordSend(id NodeID, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	rep := c.reputationFor(id)
+	switch err {
+	case nil:
+		rep.Successes++
+	case deadNodeError:
+		rep.Timeouts++
+	default:
+		rep.Errors++
+	}
+}
+
+// recordMalformed updates the Reputation of a Node that sent a Message that had to be discarded. It must only be called once the Message has been authenticated, or anyone could lower the Reputation of any Node by claiming its ID.
+func (c *Cluster) recordMalformed(id NodeID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reputationFor(id).Malformed++
+}
+
+// reputationFor returns the Reputation the Cluster is tracking for the Node, creating it if necessary. The caller must hold c.lock.
+func (c *Cluster) reputationFor(id NodeID) *Reputation {
+	rep, ok := c.reputations[id]
+	if !ok {
+		if len(c.reputations) >= maxReputations {
+			for other := range c.reputations {
+				delete(c.reputations, other)
+				break
+			}
+		}
+		rep = &Reputation{}
+		c.reputations[id] = rep
+	}
+	return rep
+}
+
+// reputableHop returns target, unless its Score is below the minimum set with SetMinReputation, in which case it returns the Node with the highest Score that shares at least as long a prefix with key as the current Node, and is numerically closer to it. Any such Node is a valid next hop.
+func (c *Cluster) reputableHop(key NodeID, target *Node) *Node {
+	min := c.getMinReputation()
+	if min <= 0 {
+		return target
+	}
+	best := target
+	bestScore := c.Reputation(target.ID).Score
+	if bestScore >= min {
+		return target
+	}
+	for _, node := range c.alternateHops(key, target) {
+		score := c.Reputation(node.ID).Score
+		if score > bestScore {
+			best = node
+			bestScore = score
+		}
+	}
+	if best != target {
+		c.debug("Routing %s through %s instead of %s, which has a low reputation.", key, best.ID, target.ID)
+	}
+	return best
+}
+
+// alternateHops returns the Nodes in the state tables, other than target, that share at least as long a prefix with key as the current Node, and are numerically closer to it. Any such Node is as valid a next hop as target.
+func (c *Cluster) alternateHops(key NodeID, target *Node) []*Node {
+	row := c.table.row(key)
+	diff := c.self.ID.distance(key)
+	nodes := c.table.list([]int{}, []int{})
+	nodes = append(nodes, c.leafset.list()...)
+	hops := []*Node{}
+	seen := map[NodeID]bool{target.ID: true}
+	for _, node := range nodes {
+		if node == nil || seen[node.ID] {
+			continue
+		}
+		seen[node.ID] = true
+		if node.ID.commonPrefixLenBits(key, c.table.bits) < row || !node.ID.distance(key).absLess(diff) {
+			continue
+		}
+		hops = append(hops, node)
+	}
+	return hops
+}
@@ -0,0 +1,140 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that sending Messages updates the Reputation of the Node they're sent to
+func TestClusterReputation(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if score := one.Reputation(two.self.ID).Score; score != 0.5 {
+		t.Errorf("Expected a Node with no history to score 0.5, got %v.", score)
+	}
+	// two isn't listening yet
+	err = one.send(one.NewMessage(HEARTBEAT, one.self.ID, []byte{}), two.self)
+	if err != deadNodeError {
+		t.Fatalf("Expected %v, got %v.", deadNodeError, err)
+	}
+	rep := one.Reputation(two.self.ID)
+	if rep.Timeouts != 1 || rep.Score >= 0.5 {
+		t.Errorf("Expected one timeout and a score below 0.5, got %+v.", rep)
+	}
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		err = one.send(one.NewMessage(HEARTBEAT, one.self.ID, []byte{}), two.self)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	rep = one.Reputations()[two.self.ID]
+	if rep.Successes != 3 || rep.Score != 4.0/6.0 {
+		t.Errorf("Expected three successes and a score of %v, got %+v.", 4.0/6.0, rep)
+	}
+	one.recordMalformed(two.self.ID)
+	if rep = one.Reputation(two.self.ID); rep.Malformed != 1 {
+		t.Errorf("Expected one malformed Message, got %+v.", rep)
+	}
+}
+
+// Test that a Message failing authentication doesn't count against the Node it claims to be from
+func TestClusterReputationForged(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.credentials = Passphrase("open sesame")
+	two.credentials = Passphrase("guess")
+	sink, records := auditRecorder()
+	one.SetAuditSinks(sink)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	victim := NodeID{0x1234, 0x5678}
+	msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("it wasn't me"))
+	msg.Sender.ID = victim
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-records:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the credential failure to be recorded.")
+	}
+	if _, ok := one.Reputations()[victim]; ok {
+		t.Errorf("Expected no Reputation for %s, got %+v.", victim, one.Reputation(victim))
+	}
+}
+
+// Test that the number of Reputations tracked is capped
+func TestClusterReputationCap(t *testing.T) {
+	self := NewNode(NodeID{0x1000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(self, nil)
+	for i := 0; i < maxReputations+10; i++ {
+		cluster.recordSend(NodeID{uint64(i), 1}, nil)
+	}
+	if n := len(cluster.Reputations()); n != maxReputations {
+		t.Errorf("Expected %d Reputations, got %d.", maxReputations, n)
+	}
+}
+
+// Test that Messages are routed around Nodes with a low Reputation when another Node makes as much progress
+func TestClusterRouteReputation(t *testing.T) {
+	self := NewNode(NodeID{0x1000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(self, nil)
+	key := NodeID{0x1fff000000000000, 0}
+	target := NewNode(NodeID{0x1f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	other := NewNode(NodeID{0x1e00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 2)
+	// shares a shorter prefix with the key than self, so not a valid next hop
+	behind := NewNode(NodeID{0x0f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 3)
+	for _, node := range []*Node{target, other, behind} {
+		_, err := cluster.table.insertNode(*node, 1)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	cluster.recordSend(target.ID, deadNodeError)
+	cluster.recordSend(behind.ID, nil)
+	cluster.recordSend(behind.ID, nil)
+	next, err := cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(target.ID) {
+		t.Errorf("Expected %s to be routed through %s without a minimum reputation, got %s.", key, target.ID, next.ID)
+	}
+	cluster.SetMinReputation(0.5)
+	next, err = cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(other.ID) {
+		t.Errorf("Expected %s to be routed through %s, got %s.", key, other.ID, next.ID)
+	}
+	cluster.recordSend(other.ID, deadNodeError)
+	cluster.recordSend(other.ID, deadNodeError)
+	next, err = cluster.Route(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(target.ID) {
+		t.Errorf("Expected %s to be routed through %s, which scores higher than the alternatives, got %s.", key, target.ID, next.ID)
+	}
+}
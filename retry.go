@@ -0,0 +1,55 @@
+package wendy
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how many times, and how patiently, the Cluster will try to reach a Node before declaring it dead.
+//
+// Attempts is the total number of times a Message will be sent before giving up. Values less than 1 are treated as 1, meaning the Message is sent once and never retried.
+//
+// BaseDelay is how long to wait before the first retry. Each subsequent retry waits twice as long as the one before it, up to MaxDelay. If MaxDelay is zero, the delay is not capped.
+//
+// Jitter is the fraction of each delay, between 0 and 1, that is randomised, so Nodes that lose contact at the same time don't retry in lockstep. A Jitter of 0.5 with a delay of one second waits between half a second and one second.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+// delay returns how long to wait before the specified retry, counting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
+
+// SetRetryPolicy sets the RetryPolicy the Cluster uses when a Node fails to respond to a Message, including heartbeats, presence announcements, and repair requests. By default, Messages are not retried, and a Node that fails to respond once is considered dead.
+func (c *Cluster) SetRetryPolicy(policy RetryPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.retryPolicy = policy
+}
+
+func (c *Cluster) getRetryPolicy() RetryPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.retryPolicy
+}
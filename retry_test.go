@@ -0,0 +1,83 @@
+package wendy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// failingTransport refuses the first failures dials, then behaves like TCPTransport.
+type failingTransport struct {
+	countingTransport
+	failures int
+}
+
+func (t *failingTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	t.lock.Lock()
+	t.dials++
+	fail := t.dials <= t.failures
+	t.lock.Unlock()
+	if fail {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: deadNodeError}
+	}
+	return t.TCPTransport.Dial(address, timeout)
+}
+
+// Test that retry delays back off exponentially and respect the maximum
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, delay := range expected {
+		if got := policy.delay(i + 1); got != delay {
+			t.Errorf("Expected retry %d to wait %s, got %s.", i+1, delay, got)
+		}
+	}
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.delay(1); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("Expected a jittered delay between %s and %s, got %s.", 50*time.Millisecond, 100*time.Millisecond, got)
+		}
+	}
+}
+
+// Test that sends are retried before a Node is declared dead
+func TestClusterSendRetries(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	transport := &failingTransport{failures: 2}
+	two.SetTransport(transport)
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.send(msg, one.self)
+	if err != deadNodeError {
+		t.Fatalf("Expected deadNodeError without retries, got %v instead.", err)
+	}
+	transport.failures = 3
+	two.SetRetryPolicy(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	err = two.send(msg, one.self)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if dials, _ := transport.counts(); dials != 4 {
+		t.Fatalf("Expected %d dials, got %d.", 4, dials)
+	}
+	select {
+	case <-oneCB.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
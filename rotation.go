@@ -0,0 +1,250 @@
+package wendy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// rotationKeyInfo is mixed into the key derived from the old secret to encrypt a new one while it's distributed.
+const rotationKeyInfo = "wendy credential rotation"
+
+var credentialsRotatingError = errors.New("The Credentials are still being rotated.")
+var rotationNoticeError = errors.New("New Credentials were distributed in a malformed message.")
+
+// credentialRotation describes the Credentials a Cluster is rotating away from, and when it stops using them.
+type credentialRotation struct {
+	previous       Credentials
+	previousCipher cipher.AEAD
+	switchAt       time.Time // Messages are sent with the previous Credentials until then, in case other Nodes haven't rotated yet
+	until          time.Time // Messages with the previous Credentials are accepted until then, in case other Nodes rotated later
+}
+
+// RotateCredentials replaces the Cluster's Credentials with next without refusing any Messages, as long as every Node in the Cluster rotates to next within overlap of the others. For overlap after it's called, the current Node accepts Messages with either its previous Credentials or next, but still sends its own with the previous ones, in case other Nodes haven't rotated yet. For overlap after that, it sends them with next, but still accepts the previous Credentials from Nodes that rotated later. After that, only next is accepted. If payload encryption is enabled, its key is rotated in the same way. Virtual Nodes are rotated too.
+//
+// RotateCredentials only rotates the current Node's Credentials; RotateSecret distributes a new secret to the whole Cluster. An error is returned if the last rotation hasn't finished, or if payload encryption is enabled and next has no secret to derive a key from.
+func (c *Cluster) RotateCredentials(next Credentials, overlap time.Duration) error {
+	if overlap <= 0 {
+		return throwInvalidArgumentError("The overlap window must be longer than 0.")
+	}
+	var nextCipher cipher.AEAD
+	if c.getPayloadCipher() != nil {
+		var err error
+		nextCipher, err = payloadCipherFor(next)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	c.lock.Lock()
+	if c.rotation != nil && now.Before(c.rotation.until) {
+		c.lock.Unlock()
+		return credentialsRotatingError
+	}
+	c.rotation = &credentialRotation{
+		previous:       c.credentials,
+		previousCipher: c.payloadCipher,
+		switchAt:       now.Add(overlap),
+		until:          now.Add(2 * overlap),
+	}
+	c.credentials = next
+	if c.payloadCipher != nil {
+		c.payloadCipher = nextCipher
+	}
+	c.lock.Unlock()
+	c.debug("Rotating Credentials. Sending the new ones from %s, and refusing the old ones from %s.", now.Add(overlap), now.Add(2*overlap))
+	for _, v := range c.getVirtualNodes() {
+		err := v.RotateCredentials(next, overlap)
+		if err != nil && err != credentialsRotatingError {
+			c.fanOutError(err)
+		}
+	}
+	return nil
+}
+
+// RotateSecret rotates the Cluster's Credentials to ones with a new secret, like RotateCredentials, and distributes the secret to the rest of the Cluster, so it only needs to be called on one Node. The Cluster's Credentials must be a Passphrase or HMACCredentials; they're replaced by Credentials of the same type, with secret in place of the old one.
+//
+// The secret is sent to every Node in the state tables, encrypted with a key derived from the old secret. Each Node that receives it rotates its own Credentials, with the same overlap, and sends it on to the Nodes in its state tables, so it spreads through the Cluster within moments. Nodes that can't be reached while it spreads need to be rotated by hand, before the overlap runs out.
+func (c *Cluster) RotateSecret(secret []byte, overlap time.Duration) error {
+	current := c.getCredentials()
+	next, err := withSecret(current, secret)
+	if err != nil {
+		return err
+	}
+	err = c.RotateCredentials(next, overlap)
+	if err != nil {
+		return err
+	}
+	c.distributeSecret(current, secret, overlap)
+	return nil
+}
+
+// distributeSecret sends a new secret to every Node in the state tables, encrypted with a key derived from the secret in previous. Nodes that don't respond are suspected.
+func (c *Cluster) distributeSecret(previous Credentials, secret []byte, overlap time.Duration) {
+	sealed, err := sealSecret(credentialSecret(previous), secret, c.self.ID)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	value := make([]byte, 9, 9+len(sealed))
+	value[0] = authRotate
+	binary.BigEndian.PutUint64(value[1:], uint64(overlap))
+	msg := c.NewMessage(NODE_AUTH, c.self.ID, append(value, sealed...))
+	nodes := c.leafset.list()
+	nodes = append(nodes, c.table.list([]int{}, []int{})...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	sent := map[NodeID]<-chan error{}
+	targets := map[NodeID]*Node{}
+	for _, node := range nodes {
+		if node == nil || targets[node.ID] != nil {
+			continue
+		}
+		sent[node.ID] = c.sendAsync(msg, node)
+		targets[node.ID] = node
+	}
+	c.awaitSends(sent, targets)
+}
+
+// A Node is distributing a new secret. If we haven't rotated to it yet, we should, and send it on.
+func (c *Cluster) onRotateSecret(sender Node, value []byte) {
+	if len(value) < 9 {
+		c.recordMalformed(sender.ID)
+		c.warn(rotationNoticeError.Error())
+		return
+	}
+	overlap := time.Duration(binary.BigEndian.Uint64(value))
+	var secret []byte
+	err := rotationNoticeError
+	for _, credentials := range c.acceptedCredentials() {
+		secret, err = openSecret(credentialSecret(credentials), value[8:], sender.ID)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		c.recordMalformed(sender.ID)
+		c.warn("Discarding new Credentials from %s: couldn't decrypt them with ours.", sender.ID)
+		return
+	}
+	current := c.getCredentials()
+	if bytes.Equal(credentialSecret(current), secret) {
+		return
+	}
+	next, err := withSecret(current, secret)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	err = c.RotateCredentials(next, overlap)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	c.debug("Rotated Credentials to the secret distributed by %s.", sender.ID)
+	c.distributeSecret(current, secret, overlap)
+}
+
+func (c *Cluster) getCredentials() Credentials {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.credentials
+}
+
+// sendingCredentials returns the Credentials the current Node sends its Messages with: the previous ones, early in a rotation, or the Cluster's.
+func (c *Cluster) sendingCredentials() Credentials {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.rotation != nil && time.Now().Before(c.rotation.switchAt) {
+		return c.rotation.previous
+	}
+	return c.credentials
+}
+
+// acceptedCredentials returns the Credentials the current Node accepts Messages with: the Cluster's, and the previous ones during a rotation.
+func (c *Cluster) acceptedCredentials() []Credentials {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.rotation != nil && time.Now().Before(c.rotation.until) {
+		return []Credentials{c.credentials, c.rotation.previous}
+	}
+	return []Credentials{c.credentials}
+}
+
+// credentialSecret returns the secret shared by Nodes with credentials, for keying answers to join challenges, and deriving encryption keys.
+func credentialSecret(credentials Credentials) []byte {
+	switch credentials := credentials.(type) {
+	case nil:
+		return nil
+	case Passphrase:
+		return []byte(credentials)
+	case HMACCredentials:
+		return credentials.Secret
+	case TokenCredentials:
+		// every Node has its own token
+		return nil
+	}
+	return credentials.Marshal()
+}
+
+// withSecret returns Credentials of the same type as credentials, with secret in place of theirs.
+func withSecret(credentials Credentials, secret []byte) (Credentials, error) {
+	if len(secret) < 1 {
+		return nil, throwInvalidArgumentError("The new secret must not be empty.")
+	}
+	switch credentials := credentials.(type) {
+	case Passphrase:
+		return Passphrase(secret), nil
+	case HMACCredentials:
+		credentials.Secret = append([]byte{}, secret...)
+		return credentials, nil
+	}
+	return nil, throwInvalidArgumentError("Only a Passphrase or HMACCredentials can be rotated to a new secret.")
+}
+
+// rotationCipher returns the cipher a new secret is encrypted with while it's distributed, derived from the old secret.
+func rotationCipher(previous []byte) (cipher.AEAD, error) {
+	if len(previous) < 1 {
+		return nil, throwInvalidArgumentError("The Cluster's Credentials have no secret to encrypt the new one with.")
+	}
+	key, err := hkdf.Key(sha256.New, previous, nil, rotationKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts a new secret with a key derived from the previous one, bound to the Node distributing it.
+func sealSecret(previous, secret []byte, sender NodeID) ([]byte, error) {
+	aead, err := rotationCipher(previous)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, secret, nodeIDBytes(sender)), nil
+}
+
+// openSecret decrypts a secret encrypted by sealSecret.
+func openSecret(previous, sealed []byte, sender NodeID) ([]byte, error) {
+	aead, err := rotationCipher(previous)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, rotationNoticeError
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nodeIDBytes(sender))
+}
@@ -0,0 +1,177 @@
+package wendy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Test that rotated Credentials are sent after the first overlap, and the previous ones accepted until the second
+func TestRotateCredentials(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.credentials = Passphrase("old secret")
+	if cluster.RotateCredentials(Passphrase("new secret"), 0) == nil {
+		t.Errorf("Expected an error rotating Credentials without an overlap.")
+	}
+	overlap := 100 * time.Millisecond
+	err = cluster.RotateCredentials(Passphrase("new secret"), overlap)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.RotateCredentials(Passphrase("newer secret"), overlap) != credentialsRotatingError {
+		t.Errorf("Expected an error rotating Credentials during a rotation.")
+	}
+	accepts := func(secret string) bool {
+		msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, nil)
+		msg.Credentials = []byte(secret)
+		return cluster.validCredentials(nil, msg)
+	}
+	if sending := cluster.sendingCredentials(); sending != Passphrase("old secret") {
+		t.Errorf("Expected the old Credentials to be sent during the first overlap, got %v.", sending)
+	}
+	if !accepts("old secret") || !accepts("new secret") || accepts("wrong secret") {
+		t.Errorf("Expected only the old and new Credentials to be accepted during the first overlap.")
+	}
+	time.Sleep(overlap + overlap/2)
+	if sending := cluster.sendingCredentials(); sending != Passphrase("new secret") {
+		t.Errorf("Expected the new Credentials to be sent during the second overlap, got %v.", sending)
+	}
+	if !accepts("old secret") || !accepts("new secret") {
+		t.Errorf("Expected the old and new Credentials to be accepted during the second overlap.")
+	}
+	time.Sleep(overlap)
+	if accepts("old secret") || !accepts("new secret") {
+		t.Errorf("Expected only the new Credentials to be accepted after the rotation.")
+	}
+	err = cluster.RotateCredentials(Passphrase("newer secret"), overlap)
+	if err != nil {
+		t.Errorf("Expected to be able to rotate again after the rotation, got %v.", err)
+	}
+}
+
+// Test that only a Passphrase or HMACCredentials can be rotated to a new secret, and that distributed secrets only open with the old one
+func TestRotateSecretCredentials(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cluster.RotateSecret([]byte("new secret"), time.Second) == nil {
+		t.Errorf("Expected an error rotating the secret without Credentials.")
+	}
+	if _, err = withSecret(Passphrase("old secret"), nil); err == nil {
+		t.Errorf("Expected an error rotating to an empty secret.")
+	}
+	next, err := withSecret(HMACCredentials{Secret: []byte("old secret"), MaxSkew: time.Minute}, []byte("new secret"))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if hmacCredentials := next.(HMACCredentials); string(hmacCredentials.Secret) != "new secret" || hmacCredentials.MaxSkew != time.Minute {
+		t.Errorf("Expected HMACCredentials with the new secret and the same MaxSkew, got %+v.", hmacCredentials)
+	}
+	sealed, err := sealSecret([]byte("old secret"), []byte("new secret"), cluster.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if bytes.Contains(sealed, []byte("new secret")) {
+		t.Errorf("Expected the new secret to be encrypted.")
+	}
+	opened, err := openSecret([]byte("old secret"), sealed, cluster.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if string(opened) != "new secret" {
+		t.Errorf("Expected %q, got %q.", "new secret", opened)
+	}
+	if _, err = openSecret([]byte("wrong secret"), sealed, cluster.self.ID); err == nil {
+		t.Errorf("Expected the new secret not to open with the wrong secret.")
+	}
+	if _, err = openSecret([]byte("old secret"), sealed, NodeID{1, 2}); err == nil {
+		t.Errorf("Expected the new secret not to open as if another Node sent it.")
+	}
+}
+
+// Test that a secret rotated on one Node spreads through the Cluster, without refusing Nodes that haven't rotated until the overlap is over
+func TestClusterRotateSecret(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	four, err := makeCluster("this is a fourth Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, cluster := range []*Cluster{one, two, three, four} {
+		cluster.credentials = HMACCredentials{Secret: []byte("old secret")}
+		err = cluster.SetPayloadEncryption(true)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	callback := newTestCallback(t)
+	three.RegisterCallback(callback)
+	for _, cluster := range []*Cluster{one, two, three} {
+		go cluster.Listen()
+		defer cluster.Kill()
+	}
+	waitListening(t, one, two, three)
+	// the secret has to pass through two to reach three
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*three.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	overlap := 300 * time.Millisecond
+	err = one.RotateSecret([]byte("new secret"), overlap)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, cluster := range []*Cluster{one, two, three} {
+		if secret := credentialSecret(cluster.getCredentials()); string(secret) != "new secret" {
+			t.Errorf("Expected %s to have rotated to the new secret, got %q.", cluster.self.ID, secret)
+		}
+	}
+	expectDelivered := func(from *Cluster, value string, delivered bool) {
+		err := from.SendToIP(from.NewMessage(FirstUserPurpose, three.self.ID, []byte(value)), from.GetIP(*three.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case msg := <-callback.onDeliver:
+			if !delivered {
+				t.Errorf("Expected %q to be refused, got %+v.", value, msg)
+			} else if string(msg.Value) != value {
+				t.Errorf("Expected %q to be delivered, got %q.", value, msg.Value)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if delivered {
+				t.Errorf("Timed out waiting for %q to be delivered.", value)
+			}
+		}
+	}
+	expectDelivered(four, "not rotated yet", true)
+	expectDelivered(one, "still sending the old secret", true)
+	time.Sleep(overlap)
+	expectDelivered(one, "sending the new secret", true)
+	expectDelivered(four, "still not rotated", true)
+	time.Sleep(overlap)
+	expectDelivered(one, "rotated", true)
+	expectDelivered(four, "never rotated", false)
+}
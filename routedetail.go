@@ -0,0 +1,68 @@
+package wendy
+
+import (
+	"math/big"
+	"sort"
+)
+
+// RouteCandidate describes a Node that was considered as the next hop for a key.
+type RouteCandidate struct {
+	Node      Node
+	Source    string   // The state table the Node was found in: "leaf set" or "routing table"
+	Distance  *big.Int // The numerical distance between the Node's ID and the key
+	PrefixLen int      // The number of leading digits the Node's ID shares with the key, in the digit size set with SetDigitBits
+	Proximity int64    // The Node's proximity to the current Node, or 0 if it hasn't been measured
+	Score     float64  // The Node's Reputation Score
+}
+
+// RouteDetail describes how the current Node routes a key, for debugging routing decisions.
+type RouteDetail struct {
+	Key          NodeID
+	Next         *Node            // The Node Route chose as the next hop, or nil if the current Node is responsible for the key
+	SelfDistance *big.Int         // The numerical distance between the current Node's ID and the key
+	Candidates   []RouteCandidate // Every Node in the leaf set, and every Node in the routing table that shares at least as long a prefix with the key as the current Node, nearest to the key first
+}
+
+// RouteDetail returns the next hop Route chooses for the key, along with the other Nodes from the state tables it could have considered. The returned Nodes are copies, and can be inspected safely while the state tables change.
+func (c *Cluster) RouteDetail(key NodeID) (RouteDetail, error) {
+	detail := RouteDetail{
+		Key:          key,
+		SelfDistance: c.self.ID.Diff(key),
+		Candidates:   []RouteCandidate{},
+	}
+	next, err := c.Route(key)
+	if err != nil {
+		return detail, err
+	}
+	if next != nil {
+		detail.Next = next.clone()
+	}
+	row := c.table.row(key)
+	seen := map[NodeID]bool{}
+	add := func(node *Node, source string) {
+		if node == nil || seen[node.ID] {
+			return
+		}
+		seen[node.ID] = true
+		detail.Candidates = append(detail.Candidates, RouteCandidate{
+			Node:      *node.clone(),
+			Source:    source,
+			Distance:  node.ID.Diff(key),
+			PrefixLen: node.ID.commonPrefixLenBits(key, c.table.bits),
+			Proximity: node.getRawProximity(),
+			Score:     c.Reputation(node.ID).Score,
+		})
+	}
+	for _, node := range c.leafset.list() {
+		add(node, "leaf set")
+	}
+	for _, node := range c.table.list([]int{}, []int{}) {
+		if node != nil && node.ID.commonPrefixLenBits(key, c.table.bits) >= row {
+			add(node, "routing table")
+		}
+	}
+	sort.SliceStable(detail.Candidates, func(i, j int) bool {
+		return detail.Candidates[i].Distance.Cmp(detail.Candidates[j].Distance) < 0
+	})
+	return detail, nil
+}
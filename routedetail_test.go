@@ -0,0 +1,56 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that RouteDetail reports the next hop and the Nodes that could have been chosen instead, nearest first
+func TestClusterRouteDetail(t *testing.T) {
+	self := NewNode(NodeID{0x1000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 0)
+	cluster := NewCluster(self, nil)
+	key := NodeID{0x1fff000000000000, 0}
+	target := NewNode(NodeID{0x1f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 1)
+	other := NewNode(NodeID{0x1e00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 2)
+	unrelated := NewNode(NodeID{0x0f00000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 3)
+	for _, node := range []*Node{other, target, unrelated} {
+		_, err := cluster.table.insertNode(*node, 1)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	detail, err := cluster.RouteDetail(key)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if detail.Next == nil || !detail.Next.ID.Equals(target.ID) {
+		t.Fatalf("Expected next hop %s, got %v.", target.ID, detail.Next)
+	}
+	if detail.SelfDistance.Cmp(self.ID.Diff(key)) != 0 {
+		t.Errorf("Expected self distance %s, got %s.", self.ID.Diff(key), detail.SelfDistance)
+	}
+	if len(detail.Candidates) != 2 {
+		t.Fatalf("Expected 2 candidates, got %d.", len(detail.Candidates))
+	}
+	for i, expected := range []*Node{target, other} {
+		candidate := detail.Candidates[i]
+		if !candidate.Node.ID.Equals(expected.ID) {
+			t.Errorf("Expected candidate %d to be %s, got %s.", i, expected.ID, candidate.Node.ID)
+		}
+		if candidate.Source != "routing table" {
+			t.Errorf("Expected candidate %d to come from the routing table, got %s.", i, candidate.Source)
+		}
+		if candidate.Distance.Cmp(expected.ID.Diff(key)) != 0 {
+			t.Errorf("Expected candidate %d to be %s from the key, got %s.", i, expected.ID.Diff(key), candidate.Distance)
+		}
+		if candidate.PrefixLen != expected.ID.CommonPrefixLen(key) {
+			t.Errorf("Expected candidate %d to share %d digits with the key, got %d.", i, expected.ID.CommonPrefixLen(key), candidate.PrefixLen)
+		}
+	}
+	detail, err = cluster.RouteDetail(self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if detail.Next != nil {
+		t.Errorf("Expected the current Node to be responsible for its own ID, got %s.", detail.Next.ID)
+	}
+}
@@ -0,0 +1,159 @@
+package wendy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDP pings are a magic number, a type, and a nonce the reply echoes back. Replies are the same size as pings, so answering them can't be used to amplify traffic.
+const (
+	pingMagic   = "WPNG"
+	pingRequest = byte(1)
+	pingReply   = byte(2)
+	pingLen     = len(pingMagic) + 1 + 8
+)
+
+// udpPingTimeout is how long to wait for a reply to a UDP ping before pinging the Node over its Transport instead.
+const udpPingTimeout = 500 * time.Millisecond
+
+// RTT describes the round trip time to a Node, smoothed over the pings sent to it the way TCP smooths its round trip times (RFC 6298), so a single slow ping doesn't make a Node seem far away.
+type RTT struct {
+	Smoothed time.Duration // the smoothed round trip time, used as the Node's proximity
+	Variance time.Duration // the smoothed mean deviation of the round trip times
+	Samples  int           // the number of pings the RTT was smoothed over
+}
+
+// update returns the RTT with a new sample folded in.
+func (r RTT) update(sample time.Duration) RTT {
+	if r.Samples == 0 {
+		return RTT{Smoothed: sample, Variance: sample / 2, Samples: 1}
+	}
+	deviation := r.Smoothed - sample
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	r.Variance = (3*r.Variance + deviation) / 4
+	r.Smoothed = (7*r.Smoothed + sample) / 8
+	r.Samples++
+	return r
+}
+
+// RTT returns the round trip time measured to the Node with the specified ID, or false if it hasn't been measured since it was last evicted from the proximity cache.
+func (c *Cluster) RTT(id NodeID) (RTT, bool) {
+	entry, ok := c.proximityCache.get(id)
+	return entry.rtt, ok
+}
+
+// ping measures the round trip time to a Node and records it. Nodes are pinged over UDP, on the port they listen on, so the time taken to set up a connection isn't counted. A Node that doesn't answer a UDP ping, because it predates them or UDP is blocked, is sent a heartbeat over the Cluster's Transport instead, and isn't pinged over UDP again while it's in the proximity cache.
+func (c *Cluster) ping(node *Node) (time.Duration, error) {
+	address := c.self.address(node)
+	entry, cached := c.proximityCache.get(node.ID)
+	if !cached || !entry.tcpOnly {
+		rtt, err := c.pingUDP(address)
+		if err == nil {
+			c.proximityCache.observe(node.ID, rtt, false)
+			return rtt, nil
+		}
+		c.debug("No UDP ping reply from %s: %s", node.ID, err.Error())
+	}
+	start := time.Now()
+	err := c.SendToIP(c.NewMessage(HEARTBEAT, c.self.ID, []byte{}), address)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	c.proximityCache.observe(node.ID, rtt, true)
+	return rtt, nil
+}
+
+// pingUDP sends a UDP ping to the address, and returns the time taken for the reply to arrive.
+func (c *Cluster) pingUDP(address string) (time.Duration, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	packet := make([]byte, pingLen)
+	copy(packet, pingMagic)
+	packet[len(pingMagic)] = pingRequest
+	binary.BigEndian.PutUint64(packet[len(pingMagic)+1:], rand.Uint64())
+	start := time.Now()
+	conn.SetDeadline(start.Add(udpPingTimeout))
+	_, err = conn.Write(packet)
+	if err != nil {
+		return 0, err
+	}
+	reply := make([]byte, pingLen+1)
+	for {
+		n, err := conn.Read(reply)
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				return 0, deadNodeError
+			}
+			return 0, err
+		}
+		// ignore anything that isn't the reply to this ping
+		if n == pingLen && reply[len(pingMagic)] == pingReply && bytes.Equal(reply[:len(pingMagic)], packet[:len(pingMagic)]) && bytes.Equal(reply[len(pingMagic)+1:n], packet[len(pingMagic)+1:]) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// answerPings replies to the UDP pings sent to the address until ctx is done. If the address can't be bound, pings aren't answered, and other Nodes ping the Node over its Transport instead.
+func (c *Cluster) answerPings(ctx context.Context, address string) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		c.warn("Couldn't listen for UDP pings on %s: %s", address, err.Error())
+		return
+	}
+	context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	go func() {
+		packet := make([]byte, pingLen+1)
+		for {
+			n, addr, err := conn.ReadFrom(packet)
+			if err != nil {
+				if ctx.Err() == nil {
+					c.debug("Stopped answering UDP pings: %s", err.Error())
+				}
+				return
+			}
+			if n != pingLen || packet[len(pingMagic)] != pingRequest || string(packet[:len(pingMagic)]) != pingMagic {
+				continue
+			}
+			packet[len(pingMagic)] = pingReply
+			conn.WriteTo(packet[:pingLen], addr)
+		}
+	}()
+}
+
+// probeRTTs pings every Node in the routing table and neighborhood set, whose proximities decide which Nodes they keep, and updates their proximities with their smoothed RTTs.
+func (c *Cluster) probeRTTs() {
+	nodes := c.table.list([]int{}, []int{})
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			_, err := c.ping(node)
+			if err != nil {
+				c.debug("Couldn't ping %s: %s", node.ID, err.Error())
+				return
+			}
+			if rtt, ok := c.RTT(node.ID); ok {
+				node.setProximity(int64(rtt.Smoothed))
+			}
+		}(node)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+package wendy
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test that round trip times are smoothed the way TCP smooths them
+func TestRTTUpdate(t *testing.T) {
+	rtt := RTT{}.update(100 * time.Millisecond)
+	if rtt.Smoothed != 100*time.Millisecond || rtt.Variance != 50*time.Millisecond || rtt.Samples != 1 {
+		t.Errorf("Expected the first sample to set the smoothed RTT, got %+v.", rtt)
+	}
+	rtt = rtt.update(20 * time.Millisecond)
+	// 7/8 * 100ms + 1/8 * 20ms, and 3/4 * 50ms + 1/4 * 80ms
+	if rtt.Smoothed != 90*time.Millisecond || rtt.Variance != 57500*time.Microsecond || rtt.Samples != 2 {
+		t.Errorf("Expected a smoothed RTT of 90ms with a variance of 57.5ms, got %+v.", rtt)
+	}
+}
+
+// Test that Nodes are pinged over UDP, and that their proximity is their smoothed RTT
+func TestClusterPing(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go two.Listen()
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	node := two.self.clone()
+	err = one.updateProximity(node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	rtt, ok := one.RTT(two.self.ID)
+	if !ok || rtt.Samples != 1 || rtt.Smoothed <= 0 {
+		t.Fatalf("Expected one RTT sample for %s, got %+v.", two.self.ID, rtt)
+	}
+	if entry, _ := one.proximityCache.get(two.self.ID); entry.tcpOnly {
+		t.Errorf("Expected %s to be pinged over UDP.", two.self.ID)
+	}
+	if node.getRawProximity() != int64(rtt.Smoothed) {
+		t.Errorf("Expected a proximity of %d, got %d.", int64(rtt.Smoothed), node.getRawProximity())
+	}
+	_, err = one.ping(node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rtt, _ = one.RTT(two.self.ID); rtt.Samples != 2 {
+		t.Errorf("Expected the second ping to be smoothed into the RTT, got %+v.", rtt)
+	}
+}
+
+// Test that Nodes that don't answer UDP pings are pinged over their Transport instead
+func TestClusterPingFallback(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// take the UDP port first, so two can't answer pings, and they go unanswered
+	port := ln.Addr().(*net.TCPAddr).Port
+	udp, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer udp.Close()
+	go two.ListenOn(ln)
+	defer two.Kill()
+	time.Sleep(10 * time.Millisecond)
+	node := two.self.clone()
+	_, err = one.ping(node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	entry, ok := one.proximityCache.get(two.self.ID)
+	if !ok || !entry.tcpOnly || entry.rtt.Samples != 1 {
+		t.Errorf("Expected %s to be pinged over its Transport, got %+v.", two.self.ID, entry)
+	}
+	start := time.Now()
+	_, err = one.ping(node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if time.Since(start) >= udpPingTimeout {
+		t.Errorf("Expected %s not to be pinged over UDP again.", two.self.ID)
+	}
+}
@@ -0,0 +1,236 @@
+package wendy
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduledExtension is the extension of the files a FileScheduleStore keeps each scheduled send in.
+const scheduledExtension = ".scheduled"
+
+// ScheduledSend is a Message waiting to be sent by SendAt or SendAfter.
+type ScheduledSend struct {
+	ID      uint64 // identifies the send, to cancel it with CancelScheduled
+	At      time.Time
+	Message Message
+}
+
+// ScheduleStore is an interface that can be fulfilled to persist the Messages waiting to be sent by SendAt and SendAfter, so they're still sent if the Node restarts before they're due.
+//
+// SaveScheduled is called when a send is scheduled, and DeleteScheduled once it has been sent or canceled. LoadScheduled returns every send saved and not yet deleted, or an empty slice if there are none.
+type ScheduleStore interface {
+	SaveScheduled(send ScheduledSend) error
+	DeleteScheduled(id uint64) error
+	LoadScheduled() ([]ScheduledSend, error)
+}
+
+// FileScheduleStore is an implementation of ScheduleStore that keeps each scheduled send as JSON in a file of its own, in the directory at the path it holds. Files are replaced atomically, so a crash while saving leaves no half-written sends behind.
+type FileScheduleStore string
+
+func (f FileScheduleStore) path(id uint64) string {
+	return filepath.Join(string(f), strconv.FormatUint(id, 16)+scheduledExtension)
+}
+
+// SaveScheduled writes the send to its file, creating the directory if it doesn't exist.
+func (f FileScheduleStore) SaveScheduled(send ScheduledSend) error {
+	data, err := json.Marshal(send)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(string(f), 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(string(f), "send.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(send.ID))
+}
+
+// DeleteScheduled removes the send's file. Sends that were never saved are ignored.
+func (f FileScheduleStore) DeleteScheduled(id uint64) error {
+	err := os.Remove(f.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// LoadScheduled reads every send from the directory. If the directory doesn't exist, no sends are returned.
+func (f FileScheduleStore) LoadScheduled() ([]ScheduledSend, error) {
+	entries, err := os.ReadDir(string(f))
+	if os.IsNotExist(err) {
+		return []ScheduledSend{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sends := []ScheduledSend{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), scheduledExtension) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(string(f), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var send ScheduledSend
+		err = json.Unmarshal(data, &send)
+		if err != nil {
+			return nil, err
+		}
+		sends = append(sends, send)
+	}
+	return sends, nil
+}
+
+// SendAt sends a Message with Send at the specified time, or straight away if it has passed, returning an ID that can be passed to CancelScheduled. This suits retries, leases, and timed steps of protocols built on the Cluster. If a ScheduleStore is set with SetScheduleStore, the send is saved to it, and still happens if the Node restarts and sets the same ScheduleStore before it's due; otherwise, it's forgotten if the Node is killed first.
+//
+// An error sending the Message when it's due is passed to Applications' OnError. The Message must have a purpose of FirstUserPurpose or above.
+func (c *Cluster) SendAt(at time.Time, msg Message) (uint64, error) {
+	if msg.Purpose < FirstUserPurpose {
+		return 0, throwInvalidArgumentError(reservedPurposeMessage)
+	}
+	c.lock.Lock()
+	if c.scheduled == nil {
+		c.scheduled = map[uint64]*time.Timer{}
+	}
+	id := uint64(rand.Int63())
+	for _, taken := c.scheduled[id]; taken; _, taken = c.scheduled[id] {
+		id = uint64(rand.Int63())
+	}
+	// reserved until the timer is started, so the ID isn't taken twice
+	c.scheduled[id] = nil
+	store := c.scheduleStore
+	c.lock.Unlock()
+	send := ScheduledSend{ID: id, At: at, Message: msg}
+	if store != nil {
+		err := store.SaveScheduled(send)
+		if err != nil {
+			c.lock.Lock()
+			delete(c.scheduled, id)
+			c.lock.Unlock()
+			return 0, err
+		}
+	}
+	c.schedule(send)
+	return id, nil
+}
+
+// SendAfter sends a Message with Send once delay has passed, like SendAt.
+func (c *Cluster) SendAfter(delay time.Duration, msg Message) (uint64, error) {
+	return c.SendAt(time.Now().Add(delay), msg)
+}
+
+// CancelScheduled cancels a send scheduled with SendAt or SendAfter, returning false if it has already happened, or there's no such send.
+func (c *Cluster) CancelScheduled(id uint64) bool {
+	c.lock.Lock()
+	timer, ok := c.scheduled[id]
+	if ok {
+		delete(c.scheduled, id)
+	}
+	store := c.scheduleStore
+	c.lock.Unlock()
+	if !ok {
+		return false
+	}
+	// a send without a timer hasn't been started yet, and won't be now
+	if timer != nil {
+		timer.Stop()
+	}
+	if store != nil {
+		err := store.DeleteScheduled(id)
+		if err != nil {
+			c.fanOutError(err)
+		}
+	}
+	return true
+}
+
+// SetScheduleStore sets the ScheduleStore that sends scheduled with SendAt and SendAfter are saved to, and schedules the sends already saved in it, sending those that came due while the Node was down straight away. Any error loading them is returned. By default, scheduled sends are only kept in memory.
+func (c *Cluster) SetScheduleStore(store ScheduleStore) error {
+	sends := []ScheduledSend{}
+	if store != nil {
+		var err error
+		sends, err = store.LoadScheduled()
+		if err != nil {
+			return err
+		}
+	}
+	c.lock.Lock()
+	c.scheduleStore = store
+	if c.scheduled == nil {
+		c.scheduled = map[uint64]*time.Timer{}
+	}
+	loaded := sends[:0]
+	for _, send := range sends {
+		if _, ok := c.scheduled[send.ID]; ok {
+			continue
+		}
+		c.scheduled[send.ID] = nil
+		loaded = append(loaded, send)
+	}
+	c.lock.Unlock()
+	for _, send := range loaded {
+		c.schedule(send)
+	}
+	return nil
+}
+
+// schedule starts the timer for a send whose ID has been reserved in c.scheduled, unless it was canceled in the meantime.
+func (c *Cluster) schedule(send ScheduledSend) {
+	timer := time.AfterFunc(time.Until(send.At), func() {
+		c.sendScheduled(send)
+	})
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.scheduled[send.ID]; !ok {
+		// canceled before the timer was started, or already sent
+		timer.Stop()
+		return
+	}
+	c.scheduled[send.ID] = timer
+}
+
+// sendScheduled sends a scheduled Message that has come due. Once the Cluster is killed, sends are left in the ScheduleStore for the Node to pick up when it restarts.
+func (c *Cluster) sendScheduled(send ScheduledSend) {
+	if c.ctx.Err() != nil {
+		return
+	}
+	c.lock.Lock()
+	_, ok := c.scheduled[send.ID]
+	delete(c.scheduled, send.ID)
+	store := c.scheduleStore
+	c.lock.Unlock()
+	if !ok {
+		// canceled while the timer was firing
+		return
+	}
+	c.debug("Sending scheduled message %s", send.Message.Key)
+	err := c.Send(send.Message)
+	if err != nil {
+		c.fanOutError(err)
+	}
+	if store != nil {
+		err = store.DeleteScheduled(send.ID)
+		if err != nil {
+			c.fanOutError(err)
+		}
+	}
+}
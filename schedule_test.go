@@ -0,0 +1,104 @@
+package wendy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that scheduled Messages are sent once they're due, unless they're canceled
+func TestSendAfter(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	cluster.RegisterCallback(callback)
+	start := time.Now()
+	_, err = cluster.SendAfter(20*time.Millisecond, cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("later")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	canceled, err := cluster.SendAfter(10*time.Millisecond, cluster.NewMessage(FirstUserPurpose, cluster.self.ID, []byte("canceled")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !cluster.CancelScheduled(canceled) {
+		t.Errorf("Expected the send to be canceled.")
+	}
+	if cluster.CancelScheduled(canceled) {
+		t.Errorf("Expected a canceled send not to be canceled again.")
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "later" {
+			t.Errorf("Expected the scheduled Message to be delivered, got %s.", msg.Value)
+		}
+		if time.Since(start) < 20*time.Millisecond {
+			t.Errorf("Expected the Message to be sent after 20ms, was sent after %s.", time.Since(start))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the scheduled Message to be delivered.")
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the canceled Message not to be delivered, got %s.", msg.Value)
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, err = cluster.SendAt(time.Now(), cluster.NewMessage(HEARTBEAT, cluster.self.ID, nil))
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError scheduling one of Wendy's own Messages, got %v.", err)
+	}
+}
+
+// Test that scheduled sends saved to a ScheduleStore are picked up by a Node that restarts
+func TestScheduleStore(t *testing.T) {
+	store := FileScheduleStore(filepath.Join(t.TempDir(), "scheduled"))
+	before, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = before.SetScheduleStore(store)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	later, err := before.SendAfter(time.Hour, before.NewMessage(FirstUserPurpose, before.self.ID, []byte("later")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// due while the Node was down
+	err = store.SaveScheduled(ScheduledSend{ID: later + 1, At: time.Now().Add(-time.Minute), Message: before.NewMessage(FirstUserPurpose, before.self.ID, []byte("overdue"))})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	before.Kill()
+	after, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	callback := newTestCallback(t)
+	after.RegisterCallback(callback)
+	err = after.SetScheduleStore(store)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "overdue" {
+			t.Errorf("Expected the overdue Message to be delivered, got %s.", msg.Value)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the overdue Message to be delivered.")
+	}
+	if !after.CancelScheduled(later) {
+		t.Errorf("Expected the saved send to be scheduled again.")
+	}
+	time.Sleep(10 * time.Millisecond)
+	sends, err := store.LoadScheduled()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(sends) != 0 {
+		t.Errorf("Expected no sends to be left once they were sent or canceled, got %+v.", sends)
+	}
+}
@@ -0,0 +1,111 @@
+package wendy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TableEntry is a copy of a Node in one of the Cluster's state tables, along with where in the table it was found. Changing a TableEntry has no effect on the Cluster.
+type TableEntry struct {
+	Node          Node
+	Row           int   // The routing table row; in the leaf set, 0 for Nodes above the current Node on the ring and 1 for Nodes below it; always 0 in the neighborhood set
+	Col           int   // The routing table column, or the Node's position in its side of the leaf set or in the neighborhood set
+	Proximity     int64 // The raw proximity score of the Node, or -1 if it hasn't been measured
+	LastHeardFrom time.Time
+}
+
+func newTableEntry(row, col int, node *Node) TableEntry {
+	return TableEntry{
+		Node:          *node.clone(),
+		Row:           row,
+		Col:           col,
+		Proximity:     node.getRawProximity(),
+		LastHeardFrom: node.LastHeardFrom(),
+	}
+}
+
+// LeafSet returns a snapshot of the Nodes in the Cluster's leaf set.
+func (c *Cluster) LeafSet() []TableEntry {
+	return leafSetEntries(c.leafset.export())
+}
+
+// RoutingTable returns a snapshot of the Nodes in the Cluster's routing table.
+func (c *Cluster) RoutingTable() []TableEntry {
+	return routingTableEntries(c.table.export([]int{}, []int{}))
+}
+
+// Neighborhood returns a snapshot of the Nodes in the Cluster's neighborhood set.
+func (c *Cluster) Neighborhood() []TableEntry {
+	return neighborhoodEntries(c.neighborhoodset.export())
+}
+
+func leafSetEntries(leafSet [2][16]*Node) []TableEntry {
+	entries := []TableEntry{}
+	for side, nodes := range leafSet {
+		for pos, node := range nodes {
+			if node != nil {
+				entries = append(entries, newTableEntry(side, pos, node))
+			}
+		}
+	}
+	return entries
+}
+
+func routingTableEntries(table [][]*Node) []TableEntry {
+	entries := []TableEntry{}
+	for row, nodes := range table {
+		for col, node := range nodes {
+			if node != nil {
+				entries = append(entries, newTableEntry(row, col, node))
+			}
+		}
+	}
+	return entries
+}
+
+func neighborhoodEntries(neighborhoodSet [32]*Node) []TableEntry {
+	entries := []TableEntry{}
+	for pos, node := range neighborhoodSet {
+		if node != nil {
+			entries = append(entries, newTableEntry(0, pos, node))
+		}
+	}
+	return entries
+}
+
+// stateSnapshot is a copy of the state tables taken at a single point in time, along with the version of each table at that point.
+type stateSnapshot struct {
+	state     stateTables
+	rtVersion uint64
+	lsVersion uint64
+	nsVersion uint64
+}
+
+// snapshot copies the specified state tables and their versions while no table is changing, so the copies are consistent with each other and with the versions. Each table locks on its own, so reading them one after another without this could see a Node removed from one table but not yet from the next.
+func (c *Cluster) snapshot(tables StateMask) stateSnapshot {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	var snapshot stateSnapshot
+	if tables.includeRT() {
+		snapshot.state.RoutingTable = c.table.export(tables.Rows, tables.Cols)
+	}
+	if tables.includeLS() {
+		leafSet := c.leafset.export()
+		snapshot.state.LeafSet = &leafSet
+	}
+	if tables.includeNS() {
+		neighborhoodSet := c.neighborhoodset.export()
+		snapshot.state.NeighborhoodSet = &neighborhoodSet
+	}
+	snapshot.rtVersion = atomic.LoadUint64(&c.self.routingTableVersion)
+	snapshot.lsVersion = atomic.LoadUint64(&c.self.leafsetVersion)
+	snapshot.nsVersion = atomic.LoadUint64(&c.self.neighborhoodSetVersion)
+	return snapshot
+}
+
+// stamp sets the versions of the state tables a Message claims to carry to those of the snapshot, so a Node that receives it doesn't assume it has seen changes made after the snapshot was taken.
+func (s stateSnapshot) stamp(msg *Message) {
+	msg.RTVersion = s.rtVersion
+	msg.LSVersion = s.lsVersion
+	msg.NSVersion = s.nsVersion
+}
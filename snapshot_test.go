@@ -0,0 +1,110 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that state table snapshots describe the Nodes in the tables and can't modify them
+func TestClusterSnapshots(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	_, err = cluster.table.insertNode(*node, 5)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.insertNode(*node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.neighborhoodset.insertNode(*node, 5)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	row := cluster.self.ID.CommonPrefixLen(id)
+	col := int(id.Digit(row))
+	rt := cluster.RoutingTable()
+	if len(rt) != 1 || !rt[0].Node.ID.Equals(id) || rt[0].Row != row || rt[0].Col != col || rt[0].Proximity != 5 {
+		t.Errorf("Expected %s at row %d, column %d, got %+v.", id, row, col, rt)
+	}
+	ls := cluster.LeafSet()
+	side := 0
+	if cluster.self.ID.RelPos(id) == 1 {
+		side = 1
+	}
+	if len(ls) != 1 || !ls[0].Node.ID.Equals(id) || ls[0].Row != side || ls[0].Col != 0 {
+		t.Errorf("Expected %s on side %d of the leaf set, got %+v.", id, side, ls)
+	}
+	ns := cluster.Neighborhood()
+	if len(ns) != 1 || !ns[0].Node.ID.Equals(id) || ns[0].Row != 0 {
+		t.Errorf("Expected %s in the neighborhood set, got %+v.", id, ns)
+	}
+	rt[0].Node.Port = 2
+	stored, err := cluster.table.getNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if stored.Port != 1 {
+		t.Errorf("Expected changing a snapshot not to change the routing table.")
+	}
+}
+
+// Test that a snapshot of the state tables sees a Node being removed either in every table or in none
+func TestClusterSnapshotConsistent(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	for i := 0; i < 20; i++ {
+		_, err = cluster.table.insertNode(*node, 5)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		_, err = cluster.leafset.insertNode(*node)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		_, err = cluster.neighborhoodset.insertNode(*node, 5)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			// repairing the state tables fails, as there's no other Node left to ask
+			cluster.remove(id, ExitTimeout)
+		}()
+		for removed := false; !removed; {
+			select {
+			case <-done:
+				removed = true
+			default:
+			}
+			snapshot := cluster.snapshot(StateMask{Mask: all})
+			found := 0
+			if len(routingTableEntries(snapshot.state.RoutingTable)) > 0 {
+				found++
+			}
+			if len(leafSetEntries(*snapshot.state.LeafSet)) > 0 {
+				found++
+			}
+			if len(neighborhoodEntries(*snapshot.state.NeighborhoodSet)) > 0 {
+				found++
+			}
+			if found != 0 && found != 3 {
+				t.Fatalf("Expected the snapshot to see %s in every table or none, found it in %d.", id, found)
+			}
+		}
+	}
+}
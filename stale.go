@@ -0,0 +1,70 @@
+package wendy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetStaleTimeout sets how long a Node in the state tables can go without sending the current Node anything before it is checked on. On each heartbeat sweep, every Node that hasn't been heard from in that long is sent a heartbeat; if it doesn't respond, and no other Node asked with SetIndirectProbes can reach it either, it is evicted from every state table, and Applications are told it exited with ExitStale. A Node that responds counts as heard from. This keeps the state tables fresh when Nodes fail silently, such as behind a firewall that drops their traffic. A timeout of 0, the default, never evicts Nodes for being stale.
+func (c *Cluster) SetStaleTimeout(timeout time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if timeout < 0 {
+		timeout = 0
+	}
+	c.staleTimeout = timeout
+}
+
+func (c *Cluster) getStaleTimeout() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.staleTimeout
+}
+
+// staleNodes returns the Nodes in the state tables that haven't been heard from within the stale timeout.
+func (c *Cluster) staleNodes(timeout time.Duration) []*Node {
+	nodes := c.table.list([]int{}, []int{})
+	nodes = append(nodes, c.leafset.list()...)
+	nodes = append(nodes, c.neighborhoodset.list()...)
+	seen := map[NodeID]bool{}
+	stale := []*Node{}
+	for _, node := range nodes {
+		if node == nil || seen[node.ID] {
+			continue
+		}
+		seen[node.ID] = true
+		if time.Since(node.LastHeardFrom()) > timeout {
+			stale = append(stale, node)
+		}
+	}
+	return stale
+}
+
+// evictStale checks on each Node that hasn't been heard from within the stale timeout, concurrently, and evicts those that can't be reached.
+func (c *Cluster) evictStale() {
+	timeout := c.getStaleTimeout()
+	if timeout <= 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, node := range c.staleNodes(timeout) {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			c.debug("Haven't heard from %s since %s. Checking on it.", node.ID, node.LastHeardFrom())
+			err := c.send(c.NewMessage(HEARTBEAT, c.self.ID, []byte{}), node)
+			if err == nil || c.probeIndirectly(*node) {
+				node.updateLastHeardFrom()
+				return
+			}
+			c.debug("Evicting stale node %s.", node.ID)
+			atomic.AddUint64(&c.stats.StaleEvictions, 1)
+			err = c.remove(node.ID, ExitStale)
+			if err != nil {
+				c.fanOutError(err)
+			}
+		}(node)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that Nodes that haven't been heard from within the stale timeout are evicted if they can't be reached, and kept if they can
+func TestClusterEvictStale(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	one.SetIndirectProbes(0)
+	deadID, err := NodeIDFromBytes([]byte("this is a third Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	freshID, err := NodeIDFromBytes([]byte("this is a fourth Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// nothing listens on port 1, so the dead Nodes never respond
+	for _, node := range []*Node{two.self, NewNode(deadID, "127.0.0.1", "127.0.0.1", "testing", 1), NewNode(freshID, "127.0.0.1", "127.0.0.1", "testing", 1)} {
+		_, err = one.leafset.insertNode(*node)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	one.evictStale()
+	if len(one.leafset.list()) != 3 {
+		t.Errorf("Expected no Nodes to be evicted without a stale timeout, got %d Nodes.", len(one.leafset.list()))
+	}
+	for _, id := range []NodeID{two.self.ID, deadID} {
+		node, err := one.leafset.getNode(id)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		node.mutex.Lock()
+		node.lastHeardFrom = time.Now().Add(-time.Hour)
+		node.mutex.Unlock()
+	}
+	one.SetStaleTimeout(time.Minute)
+	one.evictStale()
+	if _, err = one.leafset.getNode(deadID); err != nodeNotFoundError {
+		t.Errorf("Expected the stale, unreachable Node to be evicted, got %v.", err)
+	}
+	live, err := one.leafset.getNode(two.self.ID)
+	if err != nil {
+		t.Fatalf("Expected the stale Node that responded to be kept, got %v.", err)
+	}
+	if time.Since(live.LastHeardFrom()) > time.Minute {
+		t.Errorf("Expected the stale Node that responded to count as heard from, last heard from %s.", live.LastHeardFrom())
+	}
+	if _, err = one.leafset.getNode(freshID); err != nil {
+		t.Errorf("Expected the unreachable Node that was heard from recently to be kept, got %v.", err)
+	}
+	if one.Stats().StaleEvictions != 1 {
+		t.Errorf("Expected 1 stale eviction, got %d.", one.Stats().StaleEvictions)
+	}
+}
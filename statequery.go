@@ -0,0 +1,91 @@
+package wendy
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var stateRequestTimeoutError = errors.New("Timed out waiting for the Node to send its state tables.")
+
+// The state tables a StateMask can select, to be combined with a bitwise or.
+const (
+	MaskRoutingTable    = rT
+	MaskLeafSet         = lS
+	MaskNeighborhoodSet = nS
+	MaskAll             = all
+)
+
+// StateTables is a Node's view of the Cluster: the Nodes in its state tables, in the positions they occupy. Tables that weren't requested are nil, and empty positions hold nil Nodes. A routing table sent by another Node may leave out trailing empty rows and columns.
+type StateTables struct {
+	RoutingTable    [][]*Node     // a row for each digit of a NodeID, and a column for each value of a digit
+	LeafSet         *[2][16]*Node // the Nodes with lower IDs, then those with higher IDs, nearest first
+	NeighborhoodSet *[32]*Node
+}
+
+// RequestState asks the Node with the specified ID for the state tables selected by mask, and waits for up to the network timeout set with SetNetworkTimeout for it to send them. Mask.Rows and Mask.Cols, if set, limit which rows and columns of the routing table are sent. The Node must be in the current Node's state tables; if it is the current Node, its own state tables are returned.
+//
+// The state tables returned are not inserted into the current Node's state tables.
+func (c *Cluster) RequestState(id NodeID, mask StateMask) (StateTables, error) {
+	if mask.Mask&all == 0 {
+		return StateTables{}, throwInvalidArgumentError("The StateMask must select at least one state table.")
+	}
+	node, err := c.get(id)
+	if _, ok := err.(IdentityError); ok {
+		state, err := c.dumpStateTables(mask)
+		return exportStateTables(state), err
+	}
+	if err != nil {
+		return StateTables{}, err
+	}
+	data, err := c.marshal(mask)
+	if err != nil {
+		return StateTables{}, err
+	}
+	// the reply is sent with the request's key, so it can be told apart from state tables sent for other reasons
+	nonce := NodeID{uint64(rand.Int63()), uint64(rand.Int63())}
+	reply := make(chan stateTables, 1)
+	c.lock.Lock()
+	c.stateRequests[nonce] = reply
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.stateRequests, nonce)
+	}()
+	err = c.send(c.NewMessage(STAT_REQ, nonce, data), node)
+	if err != nil {
+		return StateTables{}, err
+	}
+	select {
+	case state := <-reply:
+		return exportStateTables(state), nil
+	case <-time.After(time.Duration(c.getNetworkTimeout()) * time.Second):
+		return StateTables{}, stateRequestTimeoutError
+	case <-c.ctx.Done():
+		return StateTables{}, deadNodeError
+	}
+}
+
+// onStateReply passes state tables to the RequestState call waiting on them, and returns false if they weren't requested.
+func (c *Cluster) onStateReply(msg Message, state stateTables) bool {
+	c.lock.RLock()
+	reply, ok := c.stateRequests[msg.Key]
+	c.lock.RUnlock()
+	if !ok {
+		return false
+	}
+	select {
+	case reply <- state:
+	default:
+	}
+	return true
+}
+
+func exportStateTables(state stateTables) StateTables {
+	return StateTables{
+		RoutingTable:    state.RoutingTable,
+		LeafSet:         state.LeafSet,
+		NeighborhoodSet: state.NeighborhoodSet,
+	}
+}
@@ -0,0 +1,137 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that a Node's state tables can be requested, without being inserted into the requesting Node's
+func TestClusterRequestState(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is a third Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = two.leafset.insertNode(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	state, err := one.RequestState(two.self.ID, StateMask{Mask: MaskLeafSet})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if state.RoutingTable != nil || state.NeighborhoodSet != nil {
+		t.Errorf("Expected only the leaf set, got %+v.", state)
+	}
+	if state.LeafSet == nil {
+		t.Fatalf("Expected the leaf set.")
+	}
+	found := false
+	for _, side := range state.LeafSet {
+		for _, node := range side {
+			if node != nil && node.ID.Equals(id) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected %s in the leaf set, got %+v.", id, *state.LeafSet)
+	}
+	_, err = one.leafset.getNode(id)
+	if err != nodeNotFoundError {
+		t.Errorf("Expected the requested state tables not to be inserted, got %v.", err)
+	}
+	state, err = one.RequestState(one.self.ID, StateMask{Mask: MaskAll})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if state.RoutingTable == nil || state.LeafSet == nil || state.NeighborhoodSet == nil {
+		t.Errorf("Expected the current Node's own state tables, got %+v.", state)
+	}
+}
+
+// Test that state tables can only be requested from known Nodes, and a table must be selected
+func TestClusterRequestStateInvalid(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.RequestState(id, StateMask{})
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for an empty StateMask, got %v.", err)
+	}
+	_, err = cluster.RequestState(id, StateMask{Mask: MaskAll})
+	if err != nodeNotFoundError {
+		t.Errorf("Expected %v, got %v.", nodeNotFoundError, err)
+	}
+}
+
+// Test that Nodes using a different digit size route to and exchange state tables with each other
+func TestClusterDigitBits(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, b := range []int{0, 9} {
+		err = one.SetDigitBits(b)
+		if _, ok := err.(InvalidArgumentError); !ok {
+			t.Errorf("Expected an InvalidArgumentError for %d-bit digits, got %v.", b, err)
+		}
+	}
+	for _, cluster := range []*Cluster{one, two} {
+		err = cluster.SetDigitBits(6)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cluster.SetCodec(ProtobufCodec{})
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = one.SetDigitBits(4)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError changing the digit size after inserting Nodes, got %v.", err)
+	}
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	state, err := one.RequestState(two.self.ID, StateMask{Mask: MaskRoutingTable})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	row := two.self.ID.commonPrefixLenBits(one.self.ID, 6)
+	col := one.self.ID.digitAt(row, 6)
+	if row >= len(state.RoutingTable) || col >= len(state.RoutingTable[row]) || state.RoutingTable[row][col] == nil || !state.RoutingTable[row][col].ID.Equals(one.self.ID) {
+		t.Errorf("Expected %s at row %d, column %d of %s's routing table, got %+v.", one.self.ID, row, col, two.self.ID, state.RoutingTable)
+	}
+}
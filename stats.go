@@ -0,0 +1,63 @@
+package wendy
+
+import (
+	"sync/atomic"
+)
+
+// Stats holds counters describing the activity of a Cluster since it was created.
+type Stats struct {
+	RejectedConnections uint64 // Inbound connections closed because they exceeded a rate limit
+	CorruptMessages     uint64 // Inbound Messages discarded because their Checksum didn't match
+	HopLimitExceeded    uint64 // Messages dropped because they took more hops than the limit set with SetMaxHops
+	RoutingLoops        uint64 // Messages dropped because they came back to the Node after it forwarded them
+	DroppedEvents       uint64 // ClusterEvents dropped because the channel returned by Events was full
+	RouteFailovers      uint64 // Messages routed through another Node because the Node they were first routed to didn't respond
+	LeafSetRepairs      uint64 // Leaf set entries found missing from this Node or one of its immediate neighbours when reconciling their leaf sets
+	StaleEvictions      uint64 // Nodes evicted from the state tables because they weren't heard from within the stale timeout and couldn't be reached
+	FailedRepairs       uint64 // State table repairs that failed because no Node in the state tables or the seeds could be asked
+	BadSignatures       uint64 // Inbound Messages discarded because they weren't signed by their Sender, while the Node signs its Messages
+	FailedChallenges    uint64 // Joins dropped because the joining Node didn't answer its challenge correctly, while SetJoinChallenge is enabled
+	DecryptionFailures  uint64 // Inbound Messages discarded because their Value couldn't be decrypted, while SetPayloadEncryption is enabled
+	RefusedJoins        uint64 // Join messages dropped because an Application's OnJoinRequest refused them
+	InsufficientWork    uint64 // Join messages dropped because the joining Node's ID didn't meet the difficulty set with SetIDDifficulty
+	AuditFailures       uint64 // AuditRecords that a sink set with SetAuditSinks failed to write
+	ThrottledMessages   uint64 // Inbound Messages discarded because their Sender went over the limit set with SetSenderRateLimit, or was muted for it
+	ForeignMessages     uint64 // Inbound Messages discarded because they were sent with another Cluster ID or epoch than the one set with SetClusterID
+	ReliableResends     uint64 // Messages resent by SendReliable because they weren't acknowledged in time
+	DuplicateMessages   uint64 // Messages sent with SendReliable that weren't delivered again because they already had been, within the dedup window
+	ExpiredMessages     uint64 // Inbound Messages discarded because their Expires time had passed
+	SkippedMessages     uint64 // Ordered Messages given up on because they didn't arrive within the wait set with SetOrderedDelivery
+	AggregateMisses     uint64 // Nodes left out of the answer to an aggregate query, with the Nodes below them, because they didn't answer in time
+	HeldMessages        uint64 // Messages held in the Outbox set with SetOutbox because the Node they were sent straight to didn't respond
+	DeadLetters         uint64 // Messages the Node gave up on while forwarding or holding them, and passed to DeadLetterHandlers
+}
+
+// Stats returns a snapshot of the Cluster's counters.
+func (c *Cluster) Stats() Stats {
+	return Stats{
+		RejectedConnections: atomic.LoadUint64(&c.stats.RejectedConnections),
+		CorruptMessages:     atomic.LoadUint64(&c.stats.CorruptMessages),
+		HopLimitExceeded:    atomic.LoadUint64(&c.stats.HopLimitExceeded),
+		RoutingLoops:        atomic.LoadUint64(&c.stats.RoutingLoops),
+		DroppedEvents:       atomic.LoadUint64(&c.stats.DroppedEvents),
+		RouteFailovers:      atomic.LoadUint64(&c.stats.RouteFailovers),
+		LeafSetRepairs:      atomic.LoadUint64(&c.stats.LeafSetRepairs),
+		StaleEvictions:      atomic.LoadUint64(&c.stats.StaleEvictions),
+		FailedRepairs:       atomic.LoadUint64(&c.stats.FailedRepairs),
+		BadSignatures:       atomic.LoadUint64(&c.stats.BadSignatures),
+		FailedChallenges:    atomic.LoadUint64(&c.stats.FailedChallenges),
+		DecryptionFailures:  atomic.LoadUint64(&c.stats.DecryptionFailures),
+		RefusedJoins:        atomic.LoadUint64(&c.stats.RefusedJoins),
+		InsufficientWork:    atomic.LoadUint64(&c.stats.InsufficientWork),
+		AuditFailures:       atomic.LoadUint64(&c.stats.AuditFailures),
+		ThrottledMessages:   atomic.LoadUint64(&c.stats.ThrottledMessages),
+		ForeignMessages:     atomic.LoadUint64(&c.stats.ForeignMessages),
+		ReliableResends:     atomic.LoadUint64(&c.stats.ReliableResends),
+		DuplicateMessages:   atomic.LoadUint64(&c.stats.DuplicateMessages),
+		ExpiredMessages:     atomic.LoadUint64(&c.stats.ExpiredMessages),
+		SkippedMessages:     atomic.LoadUint64(&c.stats.SkippedMessages),
+		AggregateMisses:     atomic.LoadUint64(&c.stats.AggregateMisses),
+		HeldMessages:        atomic.LoadUint64(&c.stats.HeldMessages),
+		DeadLetters:         atomic.LoadUint64(&c.stats.DeadLetters),
+	}
+}
@@ -0,0 +1,159 @@
+package wendy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderLen       = 20
+)
+
+const (
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+var stunResponseError = errors.New("STUN server sent an invalid response.")
+
+// STUN is an implementation of NAT that discovers the external address of a Node using a STUN server (RFC 5389).
+//
+// STUN can only discover addresses; it cannot ask the NAT device to forward ports. AddPortMapping therefore assumes the port has been forwarded manually and returns the requested port.
+//
+// Server is the address of the STUN server, as "host:port".
+//
+// Timeout is how long to wait for the server to respond. If it is zero, one second is used.
+type STUN struct {
+	Server  string
+	Timeout time.Duration
+}
+
+// ExternalIP asks the STUN server which address our requests appear to come from.
+func (s *STUN) ExternalIP() (net.IP, error) {
+	addr, err := s.MappedAddress()
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+// MappedAddress asks the STUN server which address and port our requests appear to come from.
+func (s *STUN) MappedAddress() (*net.UDPAddr, error) {
+	conn, err := net.Dial("udp", s.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	req := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	_, err = rand.Read(req[8:20])
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 1500)
+	read, err := conn.Read(resp)
+	if err != nil {
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			return nil, deadNodeError
+		}
+		return nil, err
+	}
+	return parseSTUNResponse(resp[:read], req[8:20])
+}
+
+// AddPortMapping returns externalPort unchanged, as STUN cannot map ports.
+func (s *STUN) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	return externalPort, nil
+}
+
+// DeletePortMapping does nothing, as STUN cannot map ports.
+func (s *STUN) DeletePortMapping(internalPort, externalPort int) error {
+	return nil
+}
+
+func parseSTUNResponse(resp, transaction []byte) (*net.UDPAddr, error) {
+	if len(resp) < stunHeaderLen || binary.BigEndian.Uint16(resp[0:2]) != stunBindingResponse || binary.BigEndian.Uint32(resp[4:8]) != stunMagicCookie || !bytes.Equal(resp[8:20], transaction) {
+		return nil, stunResponseError
+	}
+	length := int(binary.BigEndian.Uint16(resp[2:4]))
+	if stunHeaderLen+length > len(resp) {
+		return nil, stunResponseError
+	}
+	attrs := resp[stunHeaderLen : stunHeaderLen+length]
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			return nil, stunResponseError
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			return parseSTUNAddress(value, resp[4:20])
+		case stunAttrMappedAddress:
+			addr, err := parseSTUNAddress(value, nil)
+			if err == nil {
+				mapped = addr
+			}
+		}
+		// attributes are padded to a multiple of four bytes
+		padded := (attrLen + 3) &^ 3
+		if 4+padded > len(attrs) {
+			break
+		}
+		attrs = attrs[4+padded:]
+	}
+	if mapped == nil {
+		return nil, stunResponseError
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress decodes a (XOR-)MAPPED-ADDRESS attribute. If key is not nil, the port and address are XORed with it, as XOR-MAPPED-ADDRESS requires.
+func parseSTUNAddress(value, key []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, stunResponseError
+	}
+	family := value[1]
+	port := binary.BigEndian.Uint16(value[2:4])
+	var ip net.IP
+	switch family {
+	case 0x01:
+		if len(value) < 8 {
+			return nil, stunResponseError
+		}
+		ip = net.IP(append([]byte{}, value[4:8]...))
+	case 0x02:
+		if len(value) < 20 {
+			return nil, stunResponseError
+		}
+		ip = net.IP(append([]byte{}, value[4:20]...))
+	default:
+		return nil, stunResponseError
+	}
+	if key != nil {
+		port ^= binary.BigEndian.Uint16(key[0:2])
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,73 @@
+package wendy
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// fakeSTUNServer answers binding requests with an XOR-MAPPED-ADDRESS of 192.0.2.10:32000.
+func fakeSTUNServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			read, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if read < stunHeaderLen {
+				continue
+			}
+			resp := make([]byte, stunHeaderLen+12)
+			binary.BigEndian.PutUint16(resp[0:2], stunBindingResponse)
+			binary.BigEndian.PutUint16(resp[2:4], 12)
+			copy(resp[4:20], buf[4:20])
+			binary.BigEndian.PutUint16(resp[20:22], stunAttrXorMappedAddress)
+			binary.BigEndian.PutUint16(resp[22:24], 8)
+			resp[25] = 0x01
+			binary.BigEndian.PutUint16(resp[26:28], 32000^uint16(stunMagicCookie>>16))
+			ip := net.ParseIP("192.0.2.10").To4()
+			for i := range ip {
+				resp[28+i] = ip[i] ^ resp[4+i]
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn
+}
+
+// Test discovering the external address through STUN
+func TestSTUNMappedAddress(t *testing.T) {
+	server := fakeSTUNServer(t)
+	defer server.Close()
+	stun := &STUN{Server: server.LocalAddr().String()}
+	addr, err := stun.MappedAddress()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !addr.IP.Equal(net.ParseIP("192.0.2.10")) || addr.Port != 32000 {
+		t.Fatalf("Expected %s, got %s.", "192.0.2.10:32000", addr)
+	}
+	port, err := stun.AddPortMapping(55555, 55555, 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if port != 55555 {
+		t.Fatalf("Expected STUN to leave the port unchanged, got %d.", port)
+	}
+}
+
+// Test that responses to other transactions are rejected
+func TestSTUNRejectsWrongTransaction(t *testing.T) {
+	resp := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(resp[0:2], stunBindingResponse)
+	binary.BigEndian.PutUint32(resp[4:8], stunMagicCookie)
+	_, err := parseSTUNResponse(resp, []byte("not the right"))
+	if err != stunResponseError {
+		t.Fatalf("Expected stunResponseError, got %v instead.", err)
+	}
+}
@@ -5,114 +5,225 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
+// defaultDigitBits is the number of bits in each digit NodeIDs are routed by, unless SetDigitBits is called: NodeIDs are routed as 32 hexadecimal digits.
+const defaultDigitBits = 4
+
+// The smallest and largest number of bits a digit can have. Tables for larger digits would have more columns than could be filled.
+const (
+	minDigitBits = 1
+	maxDigitBits = 8
+)
+
+// routingTable is copied on write: its rows are never changed once they're stored, only replaced, so routing reads them without locking, and never waits for Nodes being inserted, as they are in bulk while Nodes join.
 type routingTable struct {
 	self     *Node
-	nodes    [32][16]*Node
+	bits     int          // the number of bits in each digit; each row has a column for each value of a digit
+	nodes    atomic.Value // holds [][]*Node, a row for each digit of a NodeID
 	log      *log.Logger
 	logLevel int
-	lock     *sync.RWMutex
+	lock     *sync.Mutex // held while the rows are replaced, so concurrent changes aren't lost
 }
 
 func newRoutingTable(self *Node) *routingTable {
-	return &routingTable{
+	return newRoutingTableBits(self, defaultDigitBits)
+}
+
+func newRoutingTableBits(self *Node, bits int) *routingTable {
+	t := &routingTable{
 		self:     self,
-		nodes:    [32][16]*Node{},
+		bits:     bits,
 		log:      log.New(os.Stdout, "wendy#routingTable("+self.ID.String()+")", log.LstdFlags),
 		logLevel: LogLevelWarn,
-		lock:     new(sync.RWMutex),
+		lock:     new(sync.Mutex),
+	}
+	t.nodes.Store(newTableRows(bits))
+	return t
+}
+
+// rows returns the rows of the routing table. They must not be modified.
+func (t *routingTable) rows() [][]*Node {
+	return t.nodes.Load().([][]*Node)
+}
+
+// rowCount returns the number of rows in the routing table, one for each digit of a NodeID.
+func (t *routingTable) rowCount() int {
+	return digitCount(t.bits)
+}
+
+// setEntry stores a copy of the rows with the Node at the specified position. Only the changed row is copied; readers still holding the previous rows are unaffected. The caller must hold t.lock.
+func (t *routingTable) setEntry(nodes [][]*Node, row, col int, node *Node) {
+	updated := append([][]*Node{}, nodes...)
+	updated[row] = append([]*Node{}, nodes[row]...)
+	updated[row][col] = node
+	t.nodes.Store(updated)
+}
+
+// newTableRows creates an empty routing table for digits of the specified number of bits.
+func newTableRows(bits int) [][]*Node {
+	nodes := make([][]*Node, digitCount(bits))
+	for row := range nodes {
+		nodes[row] = make([]*Node, 1<<uint(bits))
+	}
+	return nodes
+}
+
+// setTableEntry puts a Node at the specified position in a routing table received from another Node, growing the table as needed, and returns the table. It returns false if the position is outside the largest routing table any digit size allows.
+func setTableEntry(table [][]*Node, row, col int, node *Node) ([][]*Node, bool) {
+	if row < 0 || row >= digitCount(minDigitBits) || col < 0 || col >= 1<<maxDigitBits {
+		return table, false
+	}
+	for len(table) <= row {
+		table = append(table, []*Node{})
+	}
+	for len(table[row]) <= col {
+		table[row] = append(table[row], nil)
 	}
+	table[row][col] = node
+	return table, true
+}
+
+// row returns the row of the routing table id belongs in: the number of digits it shares with the current Node's ID.
+func (t *routingTable) row(id NodeID) int {
+	return t.self.ID.commonPrefixLenBits(id, t.bits)
+}
+
+// col returns the column of the routing table id belongs in, within the specified row: the value of its first digit that differs from the current Node's ID.
+func (t *routingTable) col(id NodeID, row int) int {
+	return id.digitAt(row, t.bits)
 }
 
 var rtDuplicateInsertError = errors.New("Node already exists in routing table.")
 
 func (t *routingTable) insertNode(node Node, proximity int64) (*Node, error) {
-	return t.insertValues(node.ID, node.LocalIP, node.GlobalIP, node.Region, node.Port, node.routingTableVersion, node.leafsetVersion, node.neighborhoodSetVersion, proximity)
+	return t.insert(node.clone(), proximity)
 }
 
 func (t *routingTable) insertValues(id NodeID, localIP, globalIP, region string, port int, rtVersion, lsVersion, nsVersion uint64, proximity int64) (*Node, error) {
-	t.lock.Lock()
-	defer t.lock.Unlock()
 	node := NewNode(id, localIP, globalIP, region, port)
 	node.updateVersions(rtVersion, lsVersion, nsVersion)
+	return t.insert(node, proximity)
+}
+
+func (t *routingTable) insert(node *Node, proximity int64) (*Node, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 	node.setProximity(proximity)
-	row := t.self.ID.CommonPrefixLen(node.ID)
-	if row >= len(t.nodes) {
-		return nil, throwIdentityError("insert", "into", "routing table")
+	node, filled, err := t.add(node)
+	if filled {
+		t.self.incrementRTVersion()
 	}
-	col := int(node.ID.Digit(row))
-	if col >= len(t.nodes[row]) {
-		return nil, impossibleError
+	if node != nil {
+		node.tableVersion = t.self.routingTableVersion
+	}
+	return node, err
+}
+
+// insertBatch inserts each of the Nodes, with the proximity already set on it, taking the lock and incrementing the version of the routing table only once. It returns the Nodes that were inserted, and the first error other than a duplicate insert.
+func (t *routingTable) insertBatch(nodes []*Node) ([]*Node, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	inserted := []*Node{}
+	var first error
+	grew := false
+	for _, node := range nodes {
+		node, filled, err := t.add(node)
+		if err != nil && err != rtDuplicateInsertError && first == nil {
+			first = err
+		}
+		if node != nil {
+			inserted = append(inserted, node)
+		}
+		grew = grew || filled
 	}
-	if t.nodes[row][col] != nil {
-		if node.ID.Equals(t.nodes[row][col].ID) {
-			t.debug("Node %s already in routing table. Versions before insert:\nrouting table: %d\nleaf set: %d\nneighborhood set: %d\n", t.nodes[row][col].ID.String(), t.nodes[row][col].routingTableVersion, t.nodes[row][col].leafsetVersion, t.nodes[row][col].neighborhoodSetVersion)
-			node.updateVersions(t.nodes[row][col].routingTableVersion, t.nodes[row][col].leafsetVersion, t.nodes[row][col].neighborhoodSetVersion)
-			t.nodes[row][col] = node
-			t.debug("Versions after insert:\nrouting table: %d\nleaf set: %d\nneighborhood set: %d\n", t.nodes[row][col].routingTableVersion, t.nodes[row][col].leafsetVersion, t.nodes[row][col].neighborhoodSetVersion)
-			return nil, rtDuplicateInsertError
+	if grew {
+		t.self.incrementRTVersion()
+	}
+	for _, node := range inserted {
+		node.tableVersion = t.self.routingTableVersion
+	}
+	return inserted, first
+}
+
+// add puts the Node into the routing table, returning it if it was inserted, and whether it filled an empty entry rather than replacing a more distant Node. Only filling an entry changes the version of the routing table. The caller must hold t.lock, and update the version.
+func (t *routingTable) add(node *Node) (*Node, bool, error) {
+	nodes := t.rows()
+	row := t.row(node.ID)
+	if row >= len(nodes) {
+		return nil, false, throwIdentityError("insert", "into", "routing table")
+	}
+	col := t.col(node.ID, row)
+	if col >= len(nodes[row]) {
+		return nil, false, impossibleError
+	}
+	if existing := nodes[row][col]; existing != nil {
+		if node.ID.Equals(existing.ID) {
+			t.debug("Node %s already in routing table. Versions before insert:\nrouting table: %d\nleaf set: %d\nneighborhood set: %d\n", existing.ID.String(), existing.routingTableVersion, existing.leafsetVersion, existing.neighborhoodSetVersion)
+			node.updateVersions(existing.routingTableVersion, existing.leafsetVersion, existing.neighborhoodSetVersion)
+			node.tableVersion = existing.tableVersion
+			t.setEntry(nodes, row, col, node)
+			t.debug("Versions after insert:\nrouting table: %d\nleaf set: %d\nneighborhood set: %d\n", node.routingTableVersion, node.leafsetVersion, node.neighborhoodSetVersion)
+			return nil, false, rtDuplicateInsertError
 		}
 		// keep the node that has the closest proximity
-		if t.self.Proximity(t.nodes[row][col]) > t.self.Proximity(node) {
-			t.nodes[row][col] = node
+		if t.self.Proximity(existing) > t.self.Proximity(node) {
+			t.setEntry(nodes, row, col, node)
 			t.debug("Inserted node %s into routing table.", node.ID.String())
-			return node, nil
+			return node, false, nil
 		}
 	} else {
-		t.nodes[row][col] = node
+		t.setEntry(nodes, row, col, node)
 		t.debug("Inserted node %s into routing table.", node.ID.String())
-		t.self.incrementRTVersion()
-		return node, nil
+		return node, true, nil
 	}
-	return nil, nil
+	return nil, false, nil
 }
 
 func (t *routingTable) getNode(id NodeID) (*Node, error) {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-	row := t.self.ID.CommonPrefixLen(id)
-	if row >= idLen {
+	nodes := t.rows()
+	row := t.row(id)
+	if row >= len(nodes) {
 		return nil, throwIdentityError("get", "from", "routing table")
 	}
-	col := int(id.Digit(row))
-	if col >= len(t.nodes[row]) {
+	col := t.col(id, row)
+	if col >= len(nodes[row]) {
 		return nil, impossibleError
 	}
-	if t.nodes[row][col] == nil {
+	if nodes[row][col] == nil {
 		return nil, nodeNotFoundError
 	}
-	if !t.nodes[row][col].ID.Equals(id) {
-		t.debug("Node not found. Expected %s, got %s.", id.String(), t.nodes[row][col].ID.String())
+	if !nodes[row][col].ID.Equals(id) {
+		t.debug("Node not found. Expected %s, got %s.", id.String(), nodes[row][col].ID.String())
 		return nil, nodeNotFoundError
 	}
-	return t.nodes[row][col], nil
+	return nodes[row][col], nil
 }
 
 func (t *routingTable) route(id NodeID) (*Node, error) {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-	row := t.self.ID.CommonPrefixLen(id)
-	if row >= idLen {
+	nodes := t.rows()
+	row := t.row(id)
+	if row >= len(nodes) {
 		return nil, throwIdentityError("route to", "in", "routing table")
 	}
-	col := int(id.Digit(row))
-	if col >= len(t.nodes[row]) {
+	col := t.col(id, row)
+	if col >= len(nodes[row]) {
 		return nil, impossibleError
 	}
-	if t.nodes[row][col] != nil {
-		return t.nodes[row][col], nil
+	if nodes[row][col] != nil {
+		return nodes[row][col], nil
 	}
-	diff := t.self.ID.Diff(id)
-	for scan_row := row; scan_row < len(t.nodes); scan_row++ {
-		for c, n := range t.nodes[scan_row] {
-			if c == int(t.self.ID.Digit(row)) {
+	diff := t.self.ID.distance(id)
+	for scan_row := row; scan_row < len(nodes); scan_row++ {
+		for c, n := range nodes[scan_row] {
+			if c == t.col(t.self.ID, row) {
 				continue
 			}
 			if n == nil {
 				continue
 			}
-			entry_diff := n.ID.Diff(id).Cmp(diff)
+			entry_diff := n.ID.distance(id).absCmp(diff)
 			if entry_diff == -1 || (entry_diff == 0 && !t.self.ID.Less(n.ID)) {
 				return n, nil
 			}
@@ -124,17 +235,18 @@ func (t *routingTable) route(id NodeID) (*Node, error) {
 func (t *routingTable) removeNode(id NodeID) (*Node, error) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
-	row := t.self.ID.CommonPrefixLen(id)
-	if row >= idLen {
+	nodes := t.rows()
+	row := t.row(id)
+	if row >= len(nodes) {
 		return nil, throwIdentityError("remove", "from", "routing table")
 	}
-	col := int(id.Digit(row))
-	if col > len(t.nodes[row]) {
+	col := t.col(id, row)
+	if col >= len(nodes[row]) {
 		return nil, impossibleError
 	}
-	if t.nodes[row][col] != nil && t.nodes[row][col].ID.Equals(id) {
-		resp := t.nodes[row][col]
-		t.nodes[row][col] = nil
+	if nodes[row][col] != nil && nodes[row][col].ID.Equals(id) {
+		resp := nodes[row][col]
+		t.setEntry(nodes, row, col, nil)
 		t.self.incrementRTVersion()
 		return resp, nil
 	} else {
@@ -144,19 +256,21 @@ func (t *routingTable) removeNode(id NodeID) (*Node, error) {
 }
 
 func (t *routingTable) list(rows, cols []int) []*Node {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
+	table := t.rows()
 	nodes := []*Node{}
 	if len(rows) > 0 {
 		for _, row := range rows {
+			if row < 0 || row >= len(table) {
+				continue
+			}
 			if len(cols) > 0 {
 				for _, col := range cols {
-					if t.nodes[row][col] != nil {
-						nodes = append(nodes, t.nodes[row][col])
+					if col >= 0 && col < len(table[row]) && table[row][col] != nil {
+						nodes = append(nodes, table[row][col])
 					}
 				}
 			} else {
-				for _, col := range t.nodes[row] {
+				for _, col := range table[row] {
 					if col != nil {
 						nodes = append(nodes, col)
 					}
@@ -164,7 +278,7 @@ func (t *routingTable) list(rows, cols []int) []*Node {
 			}
 		}
 	} else {
-		for _, row := range t.nodes {
+		for _, row := range table {
 			for _, col := range row {
 				if col != nil {
 					nodes = append(nodes, col)
@@ -175,20 +289,23 @@ func (t *routingTable) list(rows, cols []int) []*Node {
 	return nodes
 }
 
-func (t *routingTable) export(rows, cols []int) [32][16]*Node {
-	t.lock.RLock()
-	defer t.lock.RUnlock()
-	nodes := [32][16]*Node{}
+func (t *routingTable) export(rows, cols []int) [][]*Node {
+	table := t.rows()
+	nodes := newTableRows(t.bits)
 	if len(rows) > 0 {
 		for _, row := range rows {
+			// rows and columns come from other Nodes, so they may be out of range
+			if row < 0 || row >= len(table) {
+				continue
+			}
 			if len(cols) > 0 {
 				for _, col := range cols {
-					if t.nodes[row][col] != nil {
-						nodes[row][col] = t.nodes[row][col]
+					if col >= 0 && col < len(table[row]) && table[row][col] != nil {
+						nodes[row][col] = table[row][col]
 					}
 				}
 			} else {
-				for col, node := range t.nodes[row] {
+				for col, node := range table[row] {
 					if node != nil {
 						nodes[row][col] = node
 					}
@@ -196,7 +313,7 @@ func (t *routingTable) export(rows, cols []int) [32][16]*Node {
 			}
 		}
 	} else {
-		for rowNo, row := range t.nodes {
+		for rowNo, row := range table {
 			for colNo, node := range row {
 				if node != nil {
 					nodes[rowNo][colNo] = node
@@ -3,6 +3,7 @@ package wendy
 import (
 	"math/rand"
 	"testing"
+	"time"
 )
 
 // Test insertion of a node into the routing table
@@ -468,3 +469,66 @@ func BenchmarkRoutingTableExportPartial(b *testing.B) {
 		benchTable.export([]int{0, 1, 2, 3, 4, 5, 6}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
 	}
 }
+
+// Test that the routing table has a row for each digit and a column for each value of a digit, whatever the digit size
+func TestRoutingTableDigitBits(t *testing.T) {
+	self := NewNode(NodeID{0x0000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555)
+	table := newRoutingTableBits(self, 2)
+	if len(table.rows()) != 64 || len(table.rows()[0]) != 4 {
+		t.Fatalf("Expected 64 rows of 4 columns, got %d rows of %d columns.", len(table.rows()), len(table.rows()[0]))
+	}
+	// 0x3... is 0011 in binary: two 2-bit digits, 0 then 3
+	other := NewNode(NodeID{0x3000000000000000, 0}, "127.0.0.2", "127.0.0.2", "testing", 55555)
+	_, err := table.insertNode(*other, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if table.rows()[1][3] == nil || !table.rows()[1][3].ID.Equals(other.ID) {
+		t.Errorf("Expected %s at row 1, column 3.", other.ID)
+	}
+	next, err := table.route(NodeID{0x3f00000000000000, 0})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !next.ID.Equals(other.ID) {
+		t.Errorf("Expected to route through %s, got %s.", other.ID, next.ID)
+	}
+}
+
+// Test that routing doesn't wait for Nodes being inserted, and that a change doesn't affect rows already read
+func TestRoutingTableRouteWhileInserting(t *testing.T) {
+	self := NewNode(NodeID{0x0000000000000000, 0}, "127.0.0.1", "127.0.0.1", "testing", 55555)
+	table := newRoutingTable(self)
+	other := NewNode(NodeID{0x3000000000000000, 0}, "127.0.0.2", "127.0.0.2", "testing", 55555)
+	_, err := table.insertNode(*other, 1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	before := table.rows()
+	// hold the lock, as an insert would
+	table.lock.Lock()
+	routed := make(chan *Node, 1)
+	go func() {
+		node, _ := table.route(other.ID)
+		routed <- node
+	}()
+	select {
+	case node := <-routed:
+		if node == nil || !node.ID.Equals(other.ID) {
+			t.Errorf("Expected to route to %s, got %v.", other.ID, node)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Timeout waiting for a route while the routing table was locked.")
+	}
+	table.lock.Unlock()
+	_, err = table.removeNode(other.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if before[0][3] == nil || !before[0][3].ID.Equals(other.ID) {
+		t.Errorf("Expected rows read before %s was removed to still hold it.", other.ID)
+	}
+	if table.rows()[0][3] != nil {
+		t.Errorf("Expected %s to be removed.", other.ID)
+	}
+}
@@ -0,0 +1,119 @@
+package wendy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// senderThrottle limits how many Messages each sender may send the current Node, and mutes the senders that send too many.
+type senderThrottle struct {
+	limiter    *rateLimiter // keyed by the sender's ID, rather than an IP
+	mute       time.Duration
+	muted      map[NodeID]time.Time
+	throttling map[NodeID]bool // senders whose Messages have been dropped since they were last allowed one
+	lock       *sync.Mutex
+}
+
+func newSenderThrottle(limit RateLimit, mute time.Duration) *senderThrottle {
+	return &senderThrottle{
+		limiter:    newRateLimiter(RateLimit{}, limit),
+		mute:       mute,
+		muted:      map[NodeID]time.Time{},
+		throttling: map[NodeID]bool{},
+		lock:       new(sync.Mutex),
+	}
+}
+
+// allow decides whether a Message from id should be handled. If not, started is true when the sender has only now gone over the limit.
+func (t *senderThrottle) allow(id NodeID, now time.Time) (allowed, started bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if until, ok := t.muted[id]; ok {
+		if now.Before(until) {
+			return false, false
+		}
+		delete(t.muted, id)
+	}
+	if t.limiter.allow(id.String(), now) {
+		delete(t.throttling, id)
+		return true, false
+	}
+	if t.mute > 0 {
+		t.muted[id] = now.Add(t.mute)
+	}
+	started = !t.throttling[id]
+	t.throttling[id] = true
+	return false, started
+}
+
+// SetSenderRateLimit limits how many Messages each Node may send the current Node, to protect it from Nodes that are misbehaving or have been compromised. Rate is the sustained number of Messages allowed per second from each sender, and Burst is how many it may send at once before Rate applies. Messages over the limit are discarded and counted in Stats. If mute is positive, a Node that goes over the limit is muted for that long: every Message it sends is discarded until the time is up, however slowly it sends them.
+//
+// Whenever a Node goes over the limit, a SenderThrottled event is emitted, and a warning is logged, so operators can spot it. Messages are counted against the Node that sent them, not the Nodes that forwarded them. A RateLimit with a Rate of zero, the default, doesn't limit senders.
+func (c *Cluster) SetSenderRateLimit(limit RateLimit, mute time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if limit.Rate <= 0 {
+		c.throttle = nil
+		return
+	}
+	c.throttle = newSenderThrottle(limit, mute)
+}
+
+func (c *Cluster) getThrottle() *senderThrottle {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.throttle
+}
+
+// MutedSenders returns the IDs of the Nodes that are muted for going over the limit set with SetSenderRateLimit, and when each will be heard again.
+func (c *Cluster) MutedSenders() map[NodeID]time.Time {
+	muted := map[NodeID]time.Time{}
+	throttle := c.getThrottle()
+	if throttle == nil {
+		return muted
+	}
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	now := time.Now()
+	for id, until := range throttle.muted {
+		if now.After(until) {
+			delete(throttle.muted, id)
+			continue
+		}
+		muted[id] = until
+	}
+	return muted
+}
+
+// UnmuteSender lets the Node with the specified ID be heard again before its mute ends. It returns false if the Node wasn't muted.
+func (c *Cluster) UnmuteSender(id NodeID) bool {
+	throttle := c.getThrottle()
+	if throttle == nil {
+		return false
+	}
+	throttle.lock.Lock()
+	defer throttle.lock.Unlock()
+	_, ok := throttle.muted[id]
+	delete(throttle.muted, id)
+	delete(throttle.throttling, id)
+	return ok
+}
+
+// allowSender returns false if a Message from sender should be discarded for going over the limit set with SetSenderRateLimit. Messages the current Node sent itself are never limited.
+func (c *Cluster) allowSender(sender Node) bool {
+	throttle := c.getThrottle()
+	if throttle == nil || sender.ID.Equals(c.self.ID) {
+		return true
+	}
+	allowed, started := throttle.allow(sender.ID, time.Now())
+	if allowed {
+		return true
+	}
+	atomic.AddUint64(&c.stats.ThrottledMessages, 1)
+	if started {
+		c.warn("Node %s is sending too many messages. Throttling it.", sender.ID)
+		c.emit(ClusterEvent{Type: SenderThrottled, Node: sender.clone()})
+	}
+	return false
+}
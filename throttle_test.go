@@ -0,0 +1,115 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that senders over the limit are throttled, muted for the mute period, and only reported when they start going over it
+func TestSenderThrottleAllow(t *testing.T) {
+	now := time.Now()
+	one, err := NodeIDFromBytes([]byte("this is a test Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	throttle := newSenderThrottle(RateLimit{Rate: 1, Burst: 2}, time.Minute)
+	for i := 0; i < 2; i++ {
+		if allowed, _ := throttle.allow(one, now); !allowed {
+			t.Fatalf("Expected the burst to be allowed.")
+		}
+	}
+	if allowed, started := throttle.allow(one, now); allowed || !started {
+		t.Fatalf("Expected a Message over the burst to start throttling the sender, got %v, %v.", allowed, started)
+	}
+	if allowed, _ := throttle.allow(two, now); !allowed {
+		t.Fatalf("Expected a Message from another sender to be allowed.")
+	}
+	now = now.Add(time.Second)
+	if allowed, started := throttle.allow(one, now); allowed || started {
+		t.Fatalf("Expected the muted sender to be discarded quietly, got %v, %v.", allowed, started)
+	}
+	now = now.Add(time.Minute)
+	if allowed, _ := throttle.allow(one, now); !allowed {
+		t.Fatalf("Expected the sender to be heard once its mute ended.")
+	}
+
+	throttle = newSenderThrottle(RateLimit{Rate: 1, Burst: 1}, 0)
+	throttle.allow(one, now)
+	if _, started := throttle.allow(one, now); !started {
+		t.Fatalf("Expected the sender to start being throttled.")
+	}
+	if allowed, started := throttle.allow(one, now); allowed || started {
+		t.Fatalf("Expected the sender to still be throttled, without being reported again, got %v, %v.", allowed, started)
+	}
+	now = now.Add(time.Second)
+	if allowed, _ := throttle.allow(one, now); !allowed {
+		t.Fatalf("Expected the sender to be allowed once its bucket refilled, without being muted.")
+	}
+}
+
+// Test that a Cluster discards Messages from a sender over its limit, mutes it, and emits an event
+func TestClusterSenderRateLimit(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetSenderRateLimit(RateLimit{Rate: 0.001, Burst: 2}, time.Minute)
+	events := one.Events()
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	for i := 0; i < 4; i++ {
+		err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("hello")), two.GetIP(*one.self))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-callback.onDeliver:
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the Messages within the limit.")
+		}
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected Messages over the limit to be discarded, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if throttled := one.Stats().ThrottledMessages; throttled != 2 {
+		t.Errorf("Expected 2 throttled Messages, got %d.", throttled)
+	}
+	throttledEvents := 0
+	for len(events) > 0 {
+		event := <-events
+		if event.Type != SenderThrottled {
+			continue
+		}
+		throttledEvents++
+		if !event.Node.ID.Equals(two.self.ID) {
+			t.Errorf("Expected %s to be throttled, got %s.", two.self.ID, event.Node.ID)
+		}
+	}
+	if throttledEvents != 1 {
+		t.Errorf("Expected 1 SenderThrottled event, got %d.", throttledEvents)
+	}
+	if _, ok := one.MutedSenders()[two.self.ID]; !ok {
+		t.Errorf("Expected %s to be muted.", two.self.ID)
+	}
+	if !one.UnmuteSender(two.self.ID) {
+		t.Errorf("Expected %s to be unmuted.", two.self.ID)
+	}
+	if len(one.MutedSenders()) != 0 {
+		t.Errorf("Expected no muted senders, got %v.", one.MutedSenders())
+	}
+}
@@ -0,0 +1,72 @@
+package wendy
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// tokenLen is the length of a token: the Node's ID, when it expires, and the issuer's signature of both.
+const tokenLen = 16 + 8 + ed25519.SignatureSize
+
+var tokenMalformedError = errors.New("The token is malformed.")
+
+// TokenCredentials is an implementation of Credentials that grants access to the Cluster with tokens issued to each Node, which expire. A token names the Node it was issued to and when it expires, and is signed with the issuer's Ed25519 private key, which only the operator holds; Nodes only need the issuer's public key to check tokens. Messages are accepted only if the token they're sent with was issued to their Sender and hasn't expired, so a Node that's compromised loses access once its token expires, instead of holding it for good. Tokens are renewed by issuing a new one, and switching to it with RotateCredentials before the old one expires.
+//
+// Tokens are sent with every Message, so anyone who captures one can claim to be its Node until it expires; use SetSigningKey, or a Transport that encrypts connections, to rule that out. As tokens differ from Node to Node, they share no secret that join challenges, payload encryption, or RotateSecret could use. Virtual Nodes are added with the Cluster's Credentials, and so can't send Messages with TokenCredentials.
+type TokenCredentials struct {
+	Token  []byte            // the token issued to the current Node by IssueToken
+	Issuer ed25519.PublicKey // the public key tokens must be signed with
+}
+
+// IssueToken issues a token to the Node with the specified ID, which expires at expires, signed with issuer.
+func IssueToken(issuer ed25519.PrivateKey, id NodeID, expires time.Time) ([]byte, error) {
+	if len(issuer) != ed25519.PrivateKeySize {
+		return nil, throwInvalidArgumentError("The issuer's key must be an Ed25519 private key.")
+	}
+	token := make([]byte, 24, tokenLen)
+	copy(token, nodeIDBytes(id))
+	binary.BigEndian.PutUint64(token[16:], uint64(expires.Unix()))
+	return append(token, ed25519.Sign(issuer, token)...), nil
+}
+
+// Expires returns when the current Node's token expires, so it can be renewed in time.
+func (t TokenCredentials) Expires() (time.Time, error) {
+	_, expires, err := t.parse(t.Token)
+	return expires, err
+}
+
+// Valid returns true if supplied is a token signed by the Issuer that hasn't expired, whichever Node it was issued to.
+func (t TokenCredentials) Valid(supplied []byte) bool {
+	_, expires, err := t.parse(supplied)
+	return err == nil && time.Now().Before(expires)
+}
+
+// Marshal returns the current Node's token.
+func (t TokenCredentials) Marshal() []byte {
+	return t.Token
+}
+
+// SignMessage returns the current Node's token, to be sent with the Message.
+func (t TokenCredentials) SignMessage(msg Message) []byte {
+	return t.Token
+}
+
+// VerifyMessage returns true if supplied is a token signed by the Issuer that hasn't expired, and was issued to the Message's Sender.
+func (t TokenCredentials) VerifyMessage(msg Message, supplied []byte) bool {
+	id, expires, err := t.parse(supplied)
+	return err == nil && time.Now().Before(expires) && id.Equals(msg.Sender.ID)
+}
+
+// parse returns the ID of the Node a token was issued to, and when it expires, or an error if it wasn't signed by the Issuer.
+func (t TokenCredentials) parse(token []byte) (NodeID, time.Time, error) {
+	if len(token) != tokenLen || len(t.Issuer) != ed25519.PublicKeySize {
+		return NodeID{}, time.Time{}, tokenMalformedError
+	}
+	if !ed25519.Verify(t.Issuer, token[:24], token[24:]) {
+		return NodeID{}, time.Time{}, tokenMalformedError
+	}
+	id := NodeID{binary.BigEndian.Uint64(token), binary.BigEndian.Uint64(token[8:])}
+	return id, time.Unix(int64(binary.BigEndian.Uint64(token[16:])), 0), nil
+}
@@ -0,0 +1,127 @@
+package wendy
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+// Test that tokens are only accepted from the Node they were issued to, signed by the issuer, until they expire
+func TestTokenCredentials(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, impostor, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = IssueToken(private[:10], cluster.self.ID, time.Now()); err == nil {
+		t.Errorf("Expected an error issuing a token with an invalid key.")
+	}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, err := IssueToken(private, cluster.self.ID, expires)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	credentials := TokenCredentials{Token: token, Issuer: public}
+	if when, err := credentials.Expires(); err != nil || !when.Equal(expires) {
+		t.Errorf("Expected the token to expire at %s, got %s (%v).", expires, when, err)
+	}
+	msg := cluster.NewMessage(FirstUserPurpose, cluster.self.ID, nil)
+	if !credentials.VerifyMessage(msg, credentials.SignMessage(msg)) {
+		t.Errorf("Expected the token to be accepted from the Node it was issued to.")
+	}
+	if !credentials.Valid(credentials.Marshal()) {
+		t.Errorf("Expected the token to be valid.")
+	}
+	other := msg
+	other.Sender.ID = NodeID{1, 2}
+	if credentials.VerifyMessage(other, token) {
+		t.Errorf("Expected the token to be refused from another Node.")
+	}
+	expired, err := IssueToken(private, cluster.self.ID, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if credentials.VerifyMessage(msg, expired) || credentials.Valid(expired) {
+		t.Errorf("Expected an expired token to be refused.")
+	}
+	forged, err := IssueToken(impostor, cluster.self.ID, expires)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if credentials.VerifyMessage(msg, forged) {
+		t.Errorf("Expected a token signed by another issuer to be refused.")
+	}
+	tampered := append([]byte{}, token...)
+	tampered[16]++
+	if credentials.VerifyMessage(msg, tampered) || credentials.VerifyMessage(msg, token[1:]) {
+		t.Errorf("Expected a tampered token to be refused.")
+	}
+	cluster.credentials = credentials
+	if cluster.SetJoinChallenge(true) == nil {
+		t.Errorf("Expected tokens to share no secret for join challenges.")
+	}
+}
+
+// Test that Nodes with tokens from the same issuer exchange Messages, and refuse expired tokens
+func TestClusterTokenCredentials(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expires := map[*Cluster]time.Time{one: time.Now().Add(time.Hour), two: time.Now().Add(time.Hour), three: time.Now().Add(-time.Second)}
+	for cluster, when := range expires {
+		token, err := IssueToken(private, cluster.self.ID, when)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		cluster.credentials = TokenCredentials{Token: token, Issuer: public}
+	}
+	callback := newTestCallback(t)
+	one.RegisterCallback(callback)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	err = two.SendToIP(two.NewMessage(FirstUserPurpose, one.self.ID, []byte("valid token")), two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		if string(msg.Value) != "valid token" {
+			t.Errorf("Expected the Message with a valid token, got %+v.", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for the Message to be delivered.")
+	}
+	err = three.SendToIP(three.NewMessage(FirstUserPurpose, one.self.ID, []byte("expired token")), three.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-callback.onDeliver:
+		t.Errorf("Expected the Message with an expired token to be refused, got %+v.", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,110 @@
+package wendy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Topology describes the current Node and the Nodes in its state tables, as exported by ExportTopology.
+type Topology struct {
+	ID              string         `json:"id"`
+	Region          string         `json:"region,omitempty"`
+	LeafSet         []TopologyNode `json:"leaf_set"`
+	RoutingTable    []TopologyNode `json:"routing_table"`
+	NeighborhoodSet []TopologyNode `json:"neighborhood_set"`
+}
+
+// TopologyNode describes a Node in one of the current Node's state tables, and where in the table it was found. Row and Col have the same meaning as in a TableEntry.
+type TopologyNode struct {
+	ID            string            `json:"id"`
+	Address       string            `json:"address"` // the address the current Node uses to reach the Node
+	Region        string            `json:"region,omitempty"`
+	Row           int               `json:"row"`
+	Col           int               `json:"col"`
+	Proximity     int64             `json:"proximity"` // the raw proximity score of the Node, in nanoseconds, or -1 if it hasn't been measured
+	Load          int               `json:"load"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	LastHeardFrom time.Time         `json:"last_heard_from"`
+}
+
+// ExportTopology returns the current Node's state tables as JSON, in the form of a Topology, for use in dashboards and debugging. The three tables are captured together, so the export is consistent even while Nodes are being inserted and removed.
+func (c *Cluster) ExportTopology() ([]byte, error) {
+	return json.Marshal(c.topology())
+}
+
+// ExportTopologyDOT returns the current Node's state tables as a Graphviz DOT graph, with an edge from the current Node to each Node it knows of, labelled with the table it is in, its position there, and its proximity. Leaf set edges are solid, routing table edges are dashed, and neighborhood set edges are dotted.
+func (c *Cluster) ExportTopologyDOT() []byte {
+	topology := c.topology()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %q {\n", "wendy-"+topology.ID)
+	fmt.Fprintf(&buf, "\t%q [label=%q, shape=doublecircle];\n", topology.ID, dotLabel(topology.ID, topology.Region))
+	seen := map[string]bool{topology.ID: true}
+	tables := []struct {
+		name  string
+		style string
+		nodes []TopologyNode
+	}{
+		{"ls", "solid", topology.LeafSet},
+		{"rt", "dashed", topology.RoutingTable},
+		{"ns", "dotted", topology.NeighborhoodSet},
+	}
+	for _, table := range tables {
+		for _, node := range table.nodes {
+			if !seen[node.ID] {
+				seen[node.ID] = true
+				fmt.Fprintf(&buf, "\t%q [label=%q];\n", node.ID, dotLabel(node.ID, node.Region))
+			}
+			proximity := "?"
+			if node.Proximity >= 0 {
+				proximity = time.Duration(node.Proximity).String()
+			}
+			label := fmt.Sprintf("%s %d,%d %s", table.name, node.Row, node.Col, proximity)
+			fmt.Fprintf(&buf, "\t%q -> %q [label=%q, style=%s];\n", topology.ID, node.ID, label, table.style)
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// topology builds a Topology from a snapshot of the state tables.
+func (c *Cluster) topology() Topology {
+	state := c.snapshot(StateMask{Mask: all}).state
+	return Topology{
+		ID:              c.self.ID.String(),
+		Region:          c.self.Region,
+		LeafSet:         c.topologyNodes(leafSetEntries(*state.LeafSet)),
+		RoutingTable:    c.topologyNodes(routingTableEntries(state.RoutingTable)),
+		NeighborhoodSet: c.topologyNodes(neighborhoodEntries(*state.NeighborhoodSet)),
+	}
+}
+
+func (c *Cluster) topologyNodes(entries []TableEntry) []TopologyNode {
+	nodes := make([]TopologyNode, 0, len(entries))
+	for _, entry := range entries {
+		nodes = append(nodes, TopologyNode{
+			ID:            entry.Node.ID.String(),
+			Address:       c.self.address(&entry.Node),
+			Region:        entry.Node.Region,
+			Row:           entry.Row,
+			Col:           entry.Col,
+			Proximity:     entry.Proximity,
+			Load:          entry.Node.Load,
+			Metadata:      entry.Node.Metadata,
+			LastHeardFrom: entry.LastHeardFrom,
+		})
+	}
+	return nodes
+}
+
+// dotLabel abbreviates a NodeID for use as a label in a DOT graph, along with its Region.
+func dotLabel(id, region string) string {
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	if region == "" {
+		return id
+	}
+	return id + "\n" + region
+}
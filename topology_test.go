@@ -0,0 +1,62 @@
+package wendy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Test that the exported topology describes the Nodes in each state table, as JSON and as a DOT graph
+func TestClusterExportTopology(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1)
+	node.Load = 30
+	_, err = cluster.table.insertNode(*node, 5)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = cluster.leafset.insertNode(*node)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, err := cluster.ExportTopology()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var topology Topology
+	err = json.Unmarshal(data, &topology)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if topology.ID != cluster.self.ID.String() {
+		t.Errorf("Expected the topology of %s, got %s.", cluster.self.ID, topology.ID)
+	}
+	row := cluster.self.ID.CommonPrefixLen(id)
+	rt := topology.RoutingTable
+	if len(rt) != 1 || rt[0].ID != id.String() || rt[0].Row != row || rt[0].Proximity != 5 || rt[0].Load != 30 || rt[0].Address != "127.0.0.1:1" {
+		t.Errorf("Expected %s in row %d of the routing table, got %+v.", id, row, rt)
+	}
+	if len(topology.LeafSet) != 1 || topology.LeafSet[0].ID != id.String() || topology.LeafSet[0].Proximity != -1 {
+		t.Errorf("Expected %s in the leaf set, without a proximity, got %+v.", id, topology.LeafSet)
+	}
+	if topology.NeighborhoodSet == nil || len(topology.NeighborhoodSet) != 0 {
+		t.Errorf("Expected an empty neighborhood set, got %+v.", topology.NeighborhoodSet)
+	}
+	dot := string(cluster.ExportTopologyDOT())
+	if !strings.HasPrefix(dot, "digraph ") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("Expected a DOT digraph, got %s.", dot)
+	}
+	if strings.Count(dot, "\""+cluster.self.ID.String()+"\" -> \""+id.String()+"\"") != 2 {
+		t.Errorf("Expected an edge to %s for each table it is in, got %s.", id, dot)
+	}
+	if !strings.Contains(dot, "style=dashed") || !strings.Contains(dot, "5ns") {
+		t.Errorf("Expected a dashed routing table edge labelled with its proximity, got %s.", dot)
+	}
+}
@@ -0,0 +1,148 @@
+package wendy
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var traceTimeoutError = errors.New("Timed out waiting for the trace to reach the Node responsible for the key.")
+var traceIncompleteError = errors.New("A Node on the path didn't respond, so the trace couldn't reach the Node responsible for the key.")
+
+// TraceHop describes a Node a trace, or a Message with RecordPath set, passed through.
+type TraceHop struct {
+	ID        NodeID        // The Node's ID
+	Proximity time.Duration // The Node's last measured proximity to the next hop; 0 for the last hop, or if it hasn't been measured
+	Time      time.Time     // When the Node handled the trace or Message, according to its own clock
+}
+
+// traceRoute is the payload of trace Messages: the path the trace has taken so far, and where to send it when it's done.
+type traceRoute struct {
+	Nonce      uint64
+	Origin     Node
+	Hops       []TraceHop
+	Incomplete bool
+}
+
+// Trace returns the path a Message with the specified key takes through the Cluster, starting at the current Node and ending at the Node responsible for the key. A trace Message is routed like any other, except it isn't passed to Applications; each Node it passes through adds itself to the path, and the last sends the path back. Trace waits for up to the network timeout set with SetNetworkTimeout.
+//
+// If a Node on the path doesn't respond, the path up to the Node that couldn't reach it is returned, along with an error.
+func (c *Cluster) Trace(key NodeID) ([]TraceHop, error) {
+	trace := traceRoute{
+		Nonce:  uint64(rand.Int63()),
+		Origin: *c.self.clone(),
+	}
+	done := make(chan traceRoute, 1)
+	c.lock.Lock()
+	c.traces[trace.Nonce] = done
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		delete(c.traces, trace.Nonce)
+	}()
+	c.forwardTrace(key, trace)
+	select {
+	case trace = <-done:
+	case <-time.After(time.Duration(c.getNetworkTimeout()) * time.Second):
+		return nil, traceTimeoutError
+	case <-c.ctx.Done():
+		return nil, deadNodeError
+	}
+	if trace.Incomplete {
+		return trace.Hops, traceIncompleteError
+	}
+	return trace.Hops, nil
+}
+
+// forwardTrace adds the current Node to the trace and sends it on to the next hop, or back to the Node that started it if the current Node is responsible for the key or can't reach the next hop.
+func (c *Cluster) forwardTrace(key NodeID, trace traceRoute) {
+	hop := TraceHop{ID: c.self.ID, Time: time.Now()}
+	next, err := c.Route(key)
+	if err != nil {
+		c.fanOutError(err)
+		trace.Incomplete = true
+		next = nil
+	}
+	if next != nil {
+		hop.Proximity = time.Duration(next.getRawProximity())
+		trace.Hops = append(trace.Hops, hop)
+		err = c.sendTrace(NODE_TRACE, key, trace, next)
+		if err == nil {
+			return
+		}
+		if err == deadNodeError {
+			go c.suspect(next)
+		} else {
+			c.fanOutError(err)
+		}
+		trace.Incomplete = true
+	} else {
+		trace.Hops = append(trace.Hops, hop)
+	}
+	if trace.Origin.ID.Equals(c.self.ID) {
+		c.onTraceDone(trace)
+		return
+	}
+	err = c.sendTrace(NODE_TRACED, key, trace, &trace.Origin)
+	if err != nil && err != deadNodeError {
+		c.fanOutError(err)
+	}
+}
+
+func (c *Cluster) sendTrace(purpose byte, key NodeID, trace traceRoute, destination *Node) error {
+	data, err := c.marshal(trace)
+	if err != nil {
+		return err
+	}
+	return c.send(c.NewMessage(purpose, key, data), destination)
+}
+
+// A Node is tracing the path to a key through us.
+func (c *Cluster) onTrace(msg Message) {
+	var trace traceRoute
+	err := c.unmarshal(msg.Value, &trace)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	c.forwardTrace(msg.Key, trace)
+}
+
+// A trace we started has reached the Node responsible for its key, or a Node that couldn't reach the next hop.
+func (c *Cluster) onTraceReceived(msg Message) {
+	var trace traceRoute
+	err := c.unmarshal(msg.Value, &trace)
+	if err != nil {
+		c.fanOutError(err)
+		return
+	}
+	c.onTraceDone(trace)
+}
+
+func (c *Cluster) onTraceDone(trace traceRoute) {
+	c.lock.RLock()
+	done, ok := c.traces[trace.Nonce]
+	c.lock.RUnlock()
+	if !ok {
+		c.debug("Discarding trace %d, which isn't being waited on.", trace.Nonce)
+		return
+	}
+	select {
+	case done <- trace:
+	default:
+	}
+}
+
+// recordHop adds the current Node to the Path of a Message with RecordPath set, with its proximity to next, if the Message is being forwarded. The Path is copied, so copies of the Message sent elsewhere are left alone.
+func (c *Cluster) recordHop(msg Message, next *Node) Message {
+	if !msg.RecordPath {
+		return msg
+	}
+	hop := TraceHop{ID: c.self.ID, Time: time.Now()}
+	if next != nil {
+		hop.Proximity = time.Duration(next.getRawProximity())
+	}
+	msg.Path = append(append(make([]TraceHop, 0, len(msg.Path)+1), msg.Path...), hop)
+	return msg
+}
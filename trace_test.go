@@ -0,0 +1,153 @@
+package wendy
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that tracing a key the current Node is responsible for returns only the current Node
+func TestClusterTraceLocal(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hops, err := cluster.Trace(cluster.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(hops) != 1 || !hops[0].ID.Equals(cluster.self.ID) {
+		t.Fatalf("Expected a path of just %s, got %+v.", cluster.self.ID, hops)
+	}
+	if hops[0].Time.IsZero() {
+		t.Errorf("Expected the hop to be timestamped.")
+	}
+}
+
+// Test that a trace passes through each Node on the path to a key, and is returned to the Node that started it
+func TestClusterTrace(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	err = one.insert(*two.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	start := time.Now()
+	hops, err := one.Trace(two.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(hops) != 2 {
+		t.Fatalf("Expected 2 hops, got %+v.", hops)
+	}
+	for i, id := range []NodeID{one.self.ID, two.self.ID} {
+		if !hops[i].ID.Equals(id) {
+			t.Errorf("Expected hop %d to be %s, got %s.", i, id, hops[i].ID)
+		}
+		if hops[i].Time.Before(start) {
+			t.Errorf("Expected hop %d to be timestamped after the trace started, got %s.", i, hops[i].Time)
+		}
+	}
+	if hops[1].Proximity != 0 {
+		t.Errorf("Expected the last hop to have no proximity, got %s.", hops[1].Proximity)
+	}
+}
+
+// Test that a trace that can't reach the next hop returns the path so far
+func TestClusterTraceIncomplete(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	cluster.SetIndirectProbes(0)
+	id, err := NodeIDFromBytes([]byte("this is some other Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// nothing listens on port 1
+	err = cluster.insert(*NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 1), StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hops, err := cluster.Trace(id)
+	if err != traceIncompleteError {
+		t.Fatalf("Expected %v, got %v.", traceIncompleteError, err)
+	}
+	if len(hops) != 1 || !hops[0].ID.Equals(cluster.self.ID) {
+		t.Errorf("Expected a path of just %s, got %+v.", cluster.self.ID, hops)
+	}
+}
+
+// Test that Messages with RecordPath set record each Node they pass through, and Messages without it don't
+func TestClusterRecordPath(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	delivered := newTestCallback(t)
+	one.RegisterCallback(delivered)
+	go one.Listen()
+	defer one.Kill()
+	waitListening(t, one)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	forwarded := newTestCallback(t)
+	two.RegisterCallback(forwarded)
+	err = two.insert(*one.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, record := range []bool{true, false} {
+		msg := two.NewMessage(FirstUserPurpose, one.self.ID, []byte("testing"))
+		msg.RecordPath = record
+		start := time.Now()
+		err = two.Send(msg)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		select {
+		case data := <-forwarded.onForward:
+			if record && (len(data.msg.Path) != 1 || !data.msg.Path[0].ID.Equals(two.self.ID)) {
+				t.Errorf("Expected OnForward to see the Path %s, got %+v.", two.self.ID, data.msg.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the Message to be forwarded.")
+		}
+		select {
+		case msg := <-delivered.onDeliver:
+			if !record {
+				if msg.Path != nil {
+					t.Errorf("Expected no Path without RecordPath, got %+v.", msg.Path)
+				}
+				continue
+			}
+			if len(msg.Path) != 2 || !msg.Path[0].ID.Equals(two.self.ID) || !msg.Path[1].ID.Equals(one.self.ID) {
+				t.Fatalf("Expected the Path %s, %s, got %+v.", two.self.ID, one.self.ID, msg.Path)
+			}
+			if msg.Path[0].Time.Before(start) || msg.Path[1].Time.Before(msg.Path[0].Time) {
+				t.Errorf("Expected each hop to be recorded in order, as it happened, got %+v.", msg.Path)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the Message to be delivered.")
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package wendy
+
+import (
+	"net"
+	"time"
+)
+
+// Transport is an interface that can be fulfilled to control how Nodes in the Cluster connect to each other.
+//
+// Dial is called whenever the Cluster needs to send a Message to another Node. It receives the address of the Node (as returned by Node.GetIP) and the maximum amount of time the connection should take to establish.
+//
+// Listen is called when the Cluster starts listening for Messages. It receives the address the Cluster should bind to, and must return a net.Listener that yields a net.Conn for each inbound connection.
+type Transport interface {
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+	Listen(address string) (net.Listener, error)
+}
+
+// TCPTransport is an implementation of Transport that sends Messages over plain TCP connections. It is the Transport used by a Cluster unless another is specified with SetTransport.
+type TCPTransport struct{}
+
+// Dial opens a TCP connection to the specified address, giving up after timeout has elapsed.
+func (t TCPTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// Listen binds a TCP listener to the specified address.
+func (t TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
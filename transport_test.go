@@ -0,0 +1,284 @@
+package wendy
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingTransport struct {
+	TCPTransport
+	dials   int
+	listens int
+	lock    sync.Mutex
+}
+
+func (t *countingTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	t.lock.Lock()
+	t.dials++
+	t.lock.Unlock()
+	return t.TCPTransport.Dial(address, timeout)
+}
+
+func (t *countingTransport) Listen(address string) (net.Listener, error) {
+	t.lock.Lock()
+	t.listens++
+	t.lock.Unlock()
+	return t.TCPTransport.Listen(address)
+}
+
+func (t *countingTransport) counts() (int, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.dials, t.listens
+}
+
+// Test that a Cluster uses TCPTransport unless told otherwise
+func TestClusterDefaultTransport(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := cluster.getTransport().(TCPTransport); !ok {
+		t.Fatalf("Expected TCPTransport, got %T instead.", cluster.getTransport())
+	}
+}
+
+// Test that sending and listening both go through the configured Transport
+func TestClusterCustomTransport(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	oneTransport := &countingTransport{}
+	one.SetTransport(oneTransport)
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	twoTransport := &countingTransport{}
+	two.SetTransport(twoTransport)
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	_, listens := oneTransport.counts()
+	if listens != 1 {
+		t.Fatalf("Expected 1 call to Listen, got %d.", listens)
+	}
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	dials, _ := twoTransport.counts()
+	if dials != 1 {
+		t.Fatalf("Expected 1 call to Dial, got %d.", dials)
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		if string(received.Value) != "hello, world" {
+			t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
+
+// Test that a Cluster listens on the bind address it was given
+func TestClusterBindAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available.")
+	}
+	ln.Close()
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.self.LocalIP = ""
+	one.self.LocalIPv6 = "::1"
+	one.SetBindAddress("::1")
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	address := two.GetIP(*one.self)
+	if !strings.HasPrefix(address, "[::1]:") {
+		t.Fatalf("Expected an IPv6 address, got %s.", address)
+	}
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, address)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-oneCB.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
+
+// Test that a Cluster can listen on a listener it didn't create
+func TestClusterListenOn(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	inner := &countingTransport{}
+	one.SetTransport(inner)
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	go func() {
+		err := one.ListenOn(ln)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	if one.self.Port != port {
+		t.Fatalf("Expected port %d to be recorded, got %d.", port, one.self.Port)
+	}
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-oneCB.onDeliver:
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+	if _, listens := inner.counts(); listens != 0 {
+		t.Fatalf("Expected the Transport not to be asked to listen, got %d calls.", listens)
+	}
+}
+
+type errorCallback struct {
+	*testCallback
+	errors chan error
+}
+
+func (e *errorCallback) OnError(err error) {
+	select {
+	case e.errors <- err:
+	default:
+	}
+}
+
+// Test that timeouts fall back to the network timeout until they're set
+func TestClusterTimeouts(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	connect, write, read := cluster.getTimeouts()
+	if connect != time.Second || write != time.Second || read != time.Second {
+		t.Fatalf("Expected all timeouts to be %s, got %s, %s, and %s.", time.Second, connect, write, read)
+	}
+	cluster.SetTimeouts(100*time.Millisecond, 0, 3*time.Second)
+	connect, write, read = cluster.getTimeouts()
+	if connect != 100*time.Millisecond || write != time.Second || read != 3*time.Second {
+		t.Fatalf("Expected timeouts of %s, %s, and %s, got %s, %s, and %s.", 100*time.Millisecond, time.Second, 3*time.Second, connect, write, read)
+	}
+}
+
+// Test that inbound connections that never send a Message are closed after the read timeout
+func TestClusterReadTimeout(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetTimeouts(0, 0, 50*time.Millisecond)
+	oneCB := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 1)}
+	one.RegisterCallback(oneCB)
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	conn, err := net.Dial("tcp", one.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	select {
+	case err := <-oneCB.errors:
+		if neterr, ok := err.(net.Error); !ok || !neterr.Timeout() {
+			t.Fatalf("Expected a timeout error, got %v instead.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on the connection to time out.")
+	}
+}
+
+// Test that connections beyond the handler limit wait until a handler is free
+func TestClusterMaxHandlers(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetMaxHandlers(1)
+	one.SetTimeouts(0, 0, 200*time.Millisecond)
+	oneCB := &errorCallback{testCallback: newTestCallback(t), errors: make(chan error, 1)}
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	// occupy the only handler with a connection that never sends a Message
+	idle, err := net.Dial("tcp", one.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer idle.Close()
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case <-oneCB.onDeliver:
+		if time.Since(start) < 100*time.Millisecond {
+			t.Fatalf("Expected the message to wait for the idle connection to time out.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
@@ -0,0 +1,223 @@
+package wendy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const upnpSSDPAddress = "239.255.255.250:1900"
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+var upnpNoGatewayError = errors.New("No UPnP Internet gateway device found.")
+var upnpResponseError = errors.New("UPnP gateway sent an invalid response.")
+
+// UPnP is an implementation of NAT that uses the UPnP Internet Gateway Device protocol supported by many home routers. Use DiscoverUPnP to find the router on the local network.
+type UPnP struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	client      *http.Client
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+func (d upnpDevice) findService() (upnpService, bool) {
+	for _, service := range d.Services {
+		for _, serviceType := range upnpServiceTypes {
+			if service.ServiceType == serviceType {
+				return service, true
+			}
+		}
+	}
+	for _, device := range d.Devices {
+		if service, ok := device.findService(); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// DiscoverUPnP searches the local network for a UPnP Internet gateway device, waiting up to timeout for one to respond.
+func DiscoverUPnP(timeout time.Duration) (*UPnP, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ssdp, err := net.ResolveUDPAddr("udp4", upnpSSDPAddress)
+	if err != nil {
+		return nil, err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + upnpSSDPAddress + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	_, err = conn.WriteTo([]byte(search), ssdp)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	for {
+		read, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+				return nil, upnpNoGatewayError
+			}
+			return nil, err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:read])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		gateway, err := newUPnP(location, timeout)
+		if err != nil {
+			continue
+		}
+		return gateway, nil
+	}
+}
+
+// newUPnP fetches the device description at location and finds the service that controls port mappings.
+func newUPnP(location string, timeout time.Duration) (*UPnP, error) {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var desc upnpDescription
+	err = xml.NewDecoder(resp.Body).Decode(&desc)
+	if err != nil {
+		return nil, err
+	}
+	service, ok := desc.Device.findService()
+	if !ok {
+		return nil, upnpNoGatewayError
+	}
+	base := location
+	if desc.URLBase != "" {
+		base = desc.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	controlURL, err := baseURL.Parse(service.ControlURL)
+	if err != nil {
+		return nil, err
+	}
+	localIP, err := localIPFor(controlURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return &UPnP{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		localIP:     localIP,
+		client:      client,
+	}, nil
+}
+
+// ExternalIP asks the gateway for its external IP address.
+func (u *UPnP) ExternalIP() (net.IP, error) {
+	var resp struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err := u.call("GetExternalIPAddress", "", &resp)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(resp.IP))
+	if ip == nil {
+		return nil, upnpResponseError
+	}
+	return ip, nil
+}
+
+// AddPortMapping asks the gateway to forward externalPort to internalPort on this machine for the specified lifetime.
+func (u *UPnP) AddPortMapping(internalPort, externalPort int, lifetime time.Duration) (int, error) {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>TCP</NewProtocol>" +
+		"<NewInternalPort>" + strconv.Itoa(internalPort) + "</NewInternalPort>" +
+		"<NewInternalClient>" + u.localIP.String() + "</NewInternalClient>" +
+		"<NewEnabled>1</NewEnabled>" +
+		"<NewPortMappingDescription>wendy</NewPortMappingDescription>" +
+		"<NewLeaseDuration>" + strconv.Itoa(int(lifetime/time.Second)) + "</NewLeaseDuration>"
+	err := u.call("AddPortMapping", args, nil)
+	if err != nil {
+		return 0, err
+	}
+	return externalPort, nil
+}
+
+// DeletePortMapping asks the gateway to stop forwarding externalPort.
+func (u *UPnP) DeletePortMapping(internalPort, externalPort int) error {
+	args := "<NewRemoteHost></NewRemoteHost>" +
+		"<NewExternalPort>" + strconv.Itoa(externalPort) + "</NewExternalPort>" +
+		"<NewProtocol>TCP</NewProtocol>"
+	return u.call("DeletePortMapping", args, nil)
+}
+
+// call invokes a SOAP action on the gateway's control URL, decoding the response into result if it is not nil.
+func (u *UPnP) call(action, args string, result interface{}) error {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + u.serviceType + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+	req, err := http.NewRequest("POST", u.controlURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("UPnP gateway refused %s: %s", action, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
@@ -0,0 +1,82 @@
+package wendy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testUPnPDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<device>
+		<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+		<deviceList>
+			<device>
+				<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+				<deviceList>
+					<device>
+						<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+						<serviceList>
+							<service>
+								<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+								<controlURL>/control</controlURL>
+							</service>
+						</serviceList>
+					</device>
+				</deviceList>
+			</device>
+		</deviceList>
+	</device>
+</root>`
+
+// Test finding the control URL and calling actions on a UPnP gateway
+func TestUPnP(t *testing.T) {
+	actions := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/desc.xml" {
+			w.Write([]byte(testUPnPDescription))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		actions <- r.Header.Get("SOAPAction")
+		if strings.Contains(string(body), "GetExternalIPAddress") {
+			w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"><NewExternalIPAddress>198.51.100.2</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`))
+			return
+		}
+		w.Write([]byte(`<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`))
+	}))
+	defer server.Close()
+	gateway, err := newUPnP(server.URL+"/desc.xml", time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ip, err := gateway.ExternalIP()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !ip.Equal(net.ParseIP("198.51.100.2")) {
+		t.Fatalf("Expected %s, got %s.", "198.51.100.2", ip)
+	}
+	port, err := gateway.AddPortMapping(55555, 55555, time.Hour)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if port != 55555 {
+		t.Fatalf("Expected port %d, got %d.", 55555, port)
+	}
+	err = gateway.DeletePortMapping(55555, port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := []string{"GetExternalIPAddress", "AddPortMapping", "DeletePortMapping"}
+	for _, action := range expected {
+		got := <-actions
+		if got != `"urn:schemas-upnp-org:service:WANIPConnection:1#`+action+`"` {
+			t.Errorf("Expected action %s, got %s.", action, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package wendy
+
+import (
+	"encoding/binary"
+)
+
+// vectorClockEntrySize is the size of each encoded entry of a VectorClock: the NodeID and its counter.
+const vectorClockEntrySize = 16 + 8
+
+// VectorClock tracks the history of a value written by more than one Node: it maps each Node that wrote the value to a counter that grows with each of its writes. Comparing two VectorClocks shows whether one value was written with knowledge of the other, or whether they were written concurrently, and neither knew of the other.
+type VectorClock map[NodeID]uint64
+
+// Descends returns true if the value with the VectorClock was written with knowledge of the one with other: every counter in other is at or below the same counter in the VectorClock. Every VectorClock descends itself, and the empty VectorClock.
+func (v VectorClock) Descends(other VectorClock) bool {
+	for id, counter := range other {
+		if v[id] < counter {
+			return false
+		}
+	}
+	return true
+}
+
+// Concurrent returns true if neither VectorClock descends the other, so the values they belong to were written without knowledge of each other.
+func (v VectorClock) Concurrent(other VectorClock) bool {
+	return !v.Descends(other) && !other.Descends(v)
+}
+
+// Join returns a VectorClock that descends both the VectorClock and other, holding the highest of each of their counters.
+func (v VectorClock) Join(other VectorClock) VectorClock {
+	joined := make(VectorClock, len(v))
+	for id, counter := range v {
+		joined[id] = counter
+	}
+	for id, counter := range other {
+		if counter > joined[id] {
+			joined[id] = counter
+		}
+	}
+	return joined
+}
+
+// tick returns a copy of the VectorClock that descends it, with the counter of the Node with the specified ID moved past both its old value and now, in nanoseconds since the Unix epoch, so the Node's writes descend its earlier ones even when they weren't based on them.
+func (v VectorClock) tick(id NodeID, now int64) VectorClock {
+	ticked := v.Join(nil)
+	ticked[id]++
+	if uint64(now) > ticked[id] {
+		ticked[id] = uint64(now)
+	}
+	return ticked
+}
+
+func (v VectorClock) marshal() []byte {
+	data := make([]byte, 0, len(v)*vectorClockEntrySize)
+	entry := make([]byte, vectorClockEntrySize)
+	for id, counter := range v {
+		binary.BigEndian.PutUint64(entry, id[0])
+		binary.BigEndian.PutUint64(entry[8:], id[1])
+		binary.BigEndian.PutUint64(entry[16:], counter)
+		data = append(data, entry...)
+	}
+	return data
+}
+
+func unmarshalVectorClock(data []byte) (VectorClock, error) {
+	if len(data)%vectorClockEntrySize != 0 {
+		return nil, kvMessageError
+	}
+	clock := make(VectorClock, len(data)/vectorClockEntrySize)
+	for ; len(data) > 0; data = data[vectorClockEntrySize:] {
+		id := NodeID{binary.BigEndian.Uint64(data), binary.BigEndian.Uint64(data[8:])}
+		clock[id] = binary.BigEndian.Uint64(data[16:])
+	}
+	return clock, nil
+}
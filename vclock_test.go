@@ -0,0 +1,53 @@
+package wendy
+
+import (
+	"testing"
+)
+
+// Test that VectorClocks descend the clocks they know of, and are concurrent with those they don't
+func TestVectorClockDescends(t *testing.T) {
+	a, b := NodeID{0, 1}, NodeID{0, 2}
+	first := VectorClock{a: 1}
+	second := VectorClock{a: 2}
+	other := VectorClock{b: 1}
+	joined := second.Join(other)
+	cases := []struct {
+		v, other             VectorClock
+		descends, concurrent bool
+	}{
+		{first, nil, true, false},
+		{first, first, true, false},
+		{second, first, true, false},
+		{first, second, false, false},
+		{first, other, false, true},
+		{joined, first, true, false},
+		{joined, other, true, false},
+		{other, joined, false, false},
+	}
+	for i, c := range cases {
+		if c.v.Descends(c.other) != c.descends {
+			t.Errorf("Case %d: expected Descends to be %v for %v and %v.", i, c.descends, c.v, c.other)
+		}
+		if c.v.Concurrent(c.other) != c.concurrent {
+			t.Errorf("Case %d: expected Concurrent to be %v for %v and %v.", i, c.concurrent, c.v, c.other)
+		}
+	}
+	ticked := joined.tick(a, 0)
+	if ticked[a] != 3 || ticked[b] != 1 || joined[a] != 2 {
+		t.Errorf("Expected tick to increment a copy of the clock, got %v from %v.", ticked, joined)
+	}
+	if ticked = ticked.tick(b, 100); ticked[b] != 100 {
+		t.Errorf("Expected tick to move the counter up to the time, got %v.", ticked)
+	}
+	decoded, err := unmarshalVectorClock(joined.marshal())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(decoded) != 2 || decoded[a] != 2 || decoded[b] != 1 {
+		t.Errorf("Expected the clock to survive a round trip, got %v.", decoded)
+	}
+	_, err = unmarshalVectorClock(make([]byte, vectorClockEntrySize-1))
+	if err != kvMessageError {
+		t.Errorf("Expected %v for a truncated clock, got %v.", kvMessageError, err)
+	}
+}
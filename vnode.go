@@ -0,0 +1,112 @@
+package wendy
+
+import (
+	"context"
+	"errors"
+)
+
+var virtualNodeListenError = errors.New("Virtual Nodes are served by the Cluster they were added to, and can't listen.")
+
+// AddVirtualNode creates a virtual Node with the specified ID, owned by the same process as the current Node. Like virtual nodes in consistent hashing, giving each process several NodeIDs spreads keys more evenly between processes, which matters most in small Clusters.
+//
+// The returned Cluster is a Node of its own, with its own state tables, Applications, and Handlers, and must be joined to the Cluster separately. It shares the current Node's address and listener: the current Cluster accepts every connection, and passes each Message to the virtual Node it was sent to. The virtual Node copies the current Cluster's Credentials, Codec, Transport, timeouts, retry policy, and Interceptors when it is created, so those should be configured first; it must not be given a different Codec. Its heartbeats are sent, and its routing table maintained, on the current Cluster's schedule, and it is killed when the current Cluster is, or can be stopped or killed on its own.
+func (c *Cluster) AddVirtualNode(id NodeID) (*Cluster, error) {
+	c.lock.RLock()
+	self := c.self.clone()
+	host := c.host
+	level := c.logLevel
+	heartbeatFrequency := c.heartbeatFrequency
+	networkTimeout := c.networkTimeout
+	connect, write, read := c.connectTimeout, c.writeTimeout, c.readTimeout
+	interceptors := append([]Interceptor{}, c.interceptors...)
+	c.lock.RUnlock()
+	if host != nil {
+		return nil, throwInvalidArgumentError("Virtual Nodes can't have virtual Nodes of their own.")
+	}
+	if id.Equals(self.ID) {
+		return nil, throwInvalidArgumentError("A virtual Node can't have the same ID as the Node it was added to.")
+	}
+	for _, v := range c.getVirtualNodes() {
+		if id.Equals(v.self.ID) {
+			return nil, throwInvalidArgumentError("A virtual Node with ID " + id.String() + " already exists.")
+		}
+	}
+	node := NewNode(id, self.LocalIP, self.GlobalIP, self.Region, self.Port)
+	node.LocalIPv6 = self.LocalIPv6
+	node.GlobalIPv6 = self.GlobalIPv6
+	node.GlobalPort = self.GlobalPort
+	node.Load = self.Load
+	node.Metadata = copyMetadata(self.Metadata)
+	node.Capabilities = copyCapabilities(self.Capabilities)
+	v := NewCluster(node, c.getCredentials())
+	v.SetLogLevel(level)
+	v.SetHeartbeatFrequency(heartbeatFrequency)
+	v.SetNetworkTimeout(networkTimeout)
+	v.SetTimeouts(connect, write, read)
+	v.SetTransport(c.getTransport())
+	v.SetCodec(c.getCodec())
+	v.SetRetryPolicy(c.getRetryPolicy())
+	v.SetDigitBits(c.table.bits)
+	v.SetClusterID(c.ClusterID())
+	v.SetDedupWindow(c.getDedupWindow())
+	v.SetOrderedDelivery(c.getOrderedDelivery())
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// replace the virtual Node's context, so killing the current Cluster kills it too
+	v.kill()
+	v.ctx, v.kill = context.WithCancel(c.ctx)
+	v.host = c
+	v.interceptors = interceptors
+	v.active = c.active
+	v.listeningSince = c.listeningSince
+	c.virtualNodes = append(c.virtualNodes, v)
+	return v, nil
+}
+
+// VirtualNodes returns the virtual Nodes added to the Cluster with AddVirtualNode that haven't been killed.
+func (c *Cluster) VirtualNodes() []*Cluster {
+	return c.getVirtualNodes()
+}
+
+// getVirtualNodes returns the virtual Nodes that haven't been killed, forgetting any that have.
+func (c *Cluster) getVirtualNodes() []*Cluster {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	live := c.virtualNodes[:0]
+	for _, v := range c.virtualNodes {
+		if v.ctx.Err() == nil {
+			live = append(live, v)
+		}
+	}
+	c.virtualNodes = live
+	return append([]*Cluster{}, live...)
+}
+
+// virtualNodeFor returns the Node a received Message should be handled by: the virtual Node, or the current Node, it was sent to. Messages that don't name a Destination, because they were sent with SendToIP or by a Node that predates virtual Nodes, are handled by whichever Node other than their sender has the ID closest to their key.
+func (c *Cluster) virtualNodeFor(msg Message) *Cluster {
+	nodes := c.getVirtualNodes()
+	if len(nodes) == 0 {
+		return c
+	}
+	nodes = append(nodes, c)
+	if msg.Destination != (NodeID{}) {
+		for _, node := range nodes {
+			if node.self.ID.Equals(msg.Destination) {
+				return node
+			}
+		}
+	}
+	var best *Cluster
+	for _, node := range nodes {
+		if node.self.ID.Equals(msg.Sender.ID) {
+			continue
+		}
+		if best == nil || msg.Key.distance(node.self.ID).absLess(msg.Key.distance(best.self.ID)) {
+			best = node
+		}
+	}
+	if best == nil {
+		return c
+	}
+	return best
+}
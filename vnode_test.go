@@ -0,0 +1,154 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Test that received Messages are handled by the virtual Node they were sent to, or the closest one if they don't say
+func TestClusterVirtualNodeFor(t *testing.T) {
+	host, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is a virtual Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	virtual, err := host.AddVirtualNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	msg := host.NewMessage(byte(16), host.self.ID, []byte{})
+	msg.Destination = virtual.self.ID
+	if target := host.virtualNodeFor(msg); target != virtual {
+		t.Errorf("Expected a Message sent to %s to be handled by it, got %s.", virtual.self.ID, target.self.ID)
+	}
+	msg.Destination = NodeID{}
+	msg.Key = virtual.self.ID
+	if target := host.virtualNodeFor(msg); target != virtual {
+		t.Errorf("Expected a Message without a Destination to be handled by %s, got %s.", virtual.self.ID, target.self.ID)
+	}
+	msg = virtual.NewMessage(NODE_JOIN, virtual.self.ID, []byte{})
+	if target := host.virtualNodeFor(msg); target != host {
+		t.Errorf("Expected a Message not to be handled by its sender, got %s.", target.self.ID)
+	}
+	virtual.Kill()
+	msg.Destination = virtual.self.ID
+	if target := host.virtualNodeFor(msg); target != host {
+		t.Errorf("Expected a killed virtual Node not to handle Messages, got %s.", target.self.ID)
+	}
+	if len(host.VirtualNodes()) != 0 {
+		t.Errorf("Expected a killed virtual Node to be forgotten, got %d virtual Nodes.", len(host.VirtualNodes()))
+	}
+}
+
+// Test that virtual Nodes can't share IDs, have virtual Nodes of their own, or listen
+func TestClusterAddVirtualNodeInvalid(t *testing.T) {
+	host, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = host.AddVirtualNode(host.self.ID)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError adding a virtual Node with the host's ID, got %v.", err)
+	}
+	id, err := NodeIDFromBytes([]byte("this is a virtual Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	virtual, err := host.AddVirtualNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = host.AddVirtualNode(id)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError adding a duplicate virtual Node, got %v.", err)
+	}
+	other, err := NodeIDFromBytes([]byte("this is another virtual Node for testing purposes."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = virtual.AddVirtualNode(other)
+	if _, ok := err.(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError adding a virtual Node to a virtual Node, got %v.", err)
+	}
+	err = virtual.Listen()
+	if err != virtualNodeListenError {
+		t.Errorf("Expected %v, got %v.", virtualNodeListenError, err)
+	}
+}
+
+// Test that a virtual Node joins the Cluster and receives Messages through the listener it shares
+func TestClusterVirtualNodeJoin(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	id, err := NodeIDFromBytes([]byte("this is a virtual Node for testing purposes only."))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	virtual, err := one.AddVirtualNode(id)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	virtualCB := newTestCallback(t)
+	virtual.RegisterCallback(virtualCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	go one.Listen()
+	defer one.Kill()
+	go two.Listen()
+	defer two.Kill()
+	waitListening(t, one, two)
+	if virtual.self.Port != one.self.Port {
+		t.Fatalf("Expected virtual Node to share port %d, got %d.", one.self.Port, virtual.self.Port)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, []string{two.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = virtual.JoinAndWait(ctx, []string{virtual.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = virtual.leafset.getNode(one.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, err = virtual.leafset.getNode(two.self.ID)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// the announcement is acknowledged before it's handled, so give two a moment to insert the virtual Node
+	deadline := time.Now().Add(time.Second)
+	for _, err = two.leafset.getNode(virtual.self.ID); err != nil; _, err = two.leafset.getNode(virtual.self.ID) {
+		if time.Now().After(deadline) {
+			t.Fatalf(err.Error())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = two.Send(two.NewMessage(byte(16), virtual.self.ID, []byte("hello, virtual Node")))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case msg := <-virtualCB.onDeliver:
+		if string(msg.Value) != "hello, virtual Node" {
+			t.Errorf("Expected virtual Node to receive %s, got %s.", "hello, virtual Node", string(msg.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery to the virtual Node.")
+	}
+}
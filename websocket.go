@@ -0,0 +1,417 @@
+package wendy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// the GUID RFC 6455 uses to derive Sec-WebSocket-Accept from Sec-WebSocket-Key
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsHandshakeTimeout limits how long an inbound connection may take to complete its WebSocket handshake.
+const wsHandshakeTimeout = 10 * time.Second
+
+var wsHandshakeError = errors.New("WebSocket handshake failed.")
+var wsListenerClosedError = errors.New("WebSocket listener closed.")
+
+// WebSocketTransport is an implementation of Transport that tunnels Messages over WebSocket connections. It is useful when Nodes sit behind firewalls or proxies that only allow HTTP traffic through.
+//
+// Path is the HTTP path WebSocket connections are made to and accepted on. If it is empty, "/" is used. Every Node in the Cluster must use the same Path.
+//
+// Proxy is the URL of an HTTP proxy that outbound connections should be tunnelled through using the CONNECT method. If it is nil, connections are made directly. If the URL contains a username and password, they are sent to the proxy using basic authentication.
+type WebSocketTransport struct {
+	Path  string
+	Proxy *url.URL
+}
+
+func (t WebSocketTransport) path() string {
+	if t.Path == "" {
+		return "/"
+	}
+	return t.Path
+}
+
+// Dial opens a WebSocket connection to the specified address, giving up after timeout has elapsed.
+func (t WebSocketTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	target := address
+	if t.Proxy != nil {
+		target = t.Proxy.Host
+	}
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	reader := bufio.NewReader(conn)
+	if t.Proxy != nil {
+		err = t.connectProxy(conn, reader, address)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	key, err := wsNewKey()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req := &http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Scheme: "http", Host: address, Path: t.path()},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       address,
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, wsHandshakeError
+	}
+	conn.SetDeadline(time.Time{})
+	return newWebSocketConn(conn, reader, true), nil
+}
+
+func (t WebSocketTransport) connectProxy(conn net.Conn, reader *bufio.Reader, address string) error {
+	req := &http.Request{
+		Method:     "CONNECT",
+		URL:        &url.URL{Opaque: address},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       address,
+	}
+	if t.Proxy.User != nil {
+		password, _ := t.Proxy.User.Password()
+		req.SetBasicAuth(t.Proxy.User.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Proxy refused CONNECT: " + resp.Status)
+	}
+	return nil
+}
+
+// Listen binds a listener to the specified address that accepts WebSocket connections on the Transport's Path.
+func (t WebSocketTransport) Listen(address string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &webSocketListener{
+		Listener: ln,
+		path:     t.path(),
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+type webSocketListener struct {
+	net.Listener
+	path      string
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *webSocketListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.errs <- err
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *webSocketListener) handshake(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != "GET" || req.URL.Path != l.path {
+		conn.Write([]byte("HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"))
+		conn.Close()
+		return
+	}
+	if key == "" || req.Header.Get("Sec-WebSocket-Version") != "13" || !wsHeaderContains(req.Header, "Upgrade", "websocket") || !wsHeaderContains(req.Header, "Connection", "upgrade") {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"))
+		conn.Close()
+		return
+	}
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	select {
+	case l.conns <- newWebSocketConn(conn, reader, false):
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept waits for and returns the next connection that has completed the WebSocket handshake.
+func (l *webSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, wsListenerClosedError
+	}
+}
+
+// Close stops the listener from accepting any more connections.
+func (l *webSocketListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
+
+type webSocketConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	client    bool // clients must mask every frame they send
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	closeSent bool
+	readLock  *sync.Mutex
+	writeLock *sync.Mutex
+}
+
+func newWebSocketConn(conn net.Conn, reader *bufio.Reader, client bool) *webSocketConn {
+	return &webSocketConn{
+		Conn:      conn,
+		reader:    reader,
+		client:    client,
+		readLock:  new(sync.Mutex),
+		writeLock: new(sync.Mutex),
+	}
+}
+
+// Read reads the payload of data frames from the connection, transparently answering control frames. It returns io.EOF once the remote end closes the WebSocket.
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+	for c.remaining == 0 {
+		err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos%4]
+			c.maskPos++
+		}
+	}
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+func (c *webSocketConn) nextFrame() error {
+	var header [2]byte
+	_, err := io.ReadFull(c.reader, header[:])
+	if err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	c.masked = header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.reader, ext[:])
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return err
+	}
+	c.maskPos = 0
+	if c.masked {
+		_, err = io.ReadFull(c.reader, c.mask[:])
+		if err != nil {
+			return err
+		}
+	}
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.remaining = length
+		return nil
+	}
+	if length > 125 {
+		return wsHandshakeError
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return err
+	}
+	if c.masked {
+		for i := range payload {
+			payload[i] ^= c.mask[i%4]
+		}
+	}
+	switch opcode {
+	case wsOpClose:
+		c.writeFrame(wsOpClose, payload)
+		return io.EOF
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	}
+	return nil
+}
+
+// Write sends p to the remote end as a single binary frame.
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	err := c.writeFrame(wsOpBinary, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return io.ErrClosedPipe
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+	}
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	if c.client {
+		var mask [4]byte
+		_, err := rand.Read(mask[:])
+		if err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame to the remote end and closes the underlying connection.
+func (c *webSocketConn) Close() error {
+	c.writeFrame(wsOpClose, []byte{})
+	return c.Conn.Close()
+}
+
+func wsNewKey() (string, error) {
+	key := make([]byte, 16)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func wsHeaderContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package wendy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Test that data written over a WebSocket connection arrives intact in both directions
+func TestWebSocketTransportRoundTrip(t *testing.T) {
+	transport := WebSocketTransport{Path: "/wendy"}
+	ln, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	// large enough to need the 64-bit length encoding
+	payload := bytes.Repeat([]byte("wendy"), 20000)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, len(payload))
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			return
+		}
+		conn.Write(buf)
+	}()
+	conn, err := transport.Dial(ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer conn.Close()
+	_, err = conn.Write(payload)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	echo, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !bytes.Equal(echo, payload) {
+		t.Fatalf("Expected %d bytes echoed back, got %d.", len(payload), len(echo))
+	}
+}
+
+// Test that connections to the wrong path are refused
+func TestWebSocketTransportWrongPath(t *testing.T) {
+	ln, err := WebSocketTransport{Path: "/wendy"}.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer ln.Close()
+	_, err = WebSocketTransport{Path: "/elsewhere"}.Dial(ln.Addr().String(), time.Second)
+	if err != wsHandshakeError {
+		t.Fatalf("Expected wsHandshakeError, got %v instead.", err)
+	}
+}
+
+// Test that a Cluster can deliver messages over WebSockets
+func TestClusterWebSocketTransport(t *testing.T) {
+	one, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	one.SetTransport(WebSocketTransport{})
+	oneCB := newTestCallback(t)
+	one.RegisterCallback(oneCB)
+	two, err := makeCluster("this is some other Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two.SetTransport(WebSocketTransport{})
+	go func() {
+		err := one.Listen()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+	}()
+	waitListening(t, one)
+	msg := two.NewMessage(byte(16), one.self.ID, []byte("hello, world"))
+	err = two.SendToIP(msg, two.GetIP(*one.self))
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	select {
+	case received := <-oneCB.onDeliver:
+		if string(received.Value) != "hello, world" {
+			t.Fatalf("Expected %s, got %s.", "hello, world", string(received.Value))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting on message delivery.")
+	}
+}
@@ -1,8 +1,10 @@
 package wendy
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 )
 
 const (
@@ -23,7 +25,7 @@ const (
 //
 // OnNodeJoin is called when the current Node learns of a new Node in the Cluster. It receives the Node that just joined.
 //
-// OnNodeExit is called when a Node is discovered to no longer be participating in the Cluster. It is passed the Node that just left the Cluster. Note that by the time this method is called, the Node is no longer reachable.
+// OnNodeExit is called when a Node is removed from the current Node's state tables, because it left the Cluster, stopped responding, or was banned. It is passed the Node that just left the Cluster. Note that by the time this method is called, the Node may no longer be reachable. Applications that need to know why the Node was removed can fulfill ExitReasonHandler.
 //
 // OnHeartbeat is called when the current Node receives a heartbeat from another Node. Heartbeats are sent at a configurable interval, if no messages have been sent between the Nodes, and serve the purpose of a health check.
 type Application interface {
@@ -36,12 +38,101 @@ type Application interface {
 	OnHeartbeat(node Node)
 }
 
+// ExitReason describes why a Node was removed from the state tables.
+type ExitReason byte
+
+const (
+	ExitGraceful ExitReason = iota // The Node announced that it was leaving the Cluster
+	ExitTimeout                    // The Node stopped responding, and no other Node could reach it
+	ExitBanned                     // The Node was banned with BanNode
+	ExitStale                      // The Node wasn't heard from within the stale timeout, and no Node could reach it
+	ExitEvicted                    // The Node was evicted with EvictNode
+)
+
+// String returns a description of the ExitReason.
+func (r ExitReason) String() string {
+	switch r {
+	case ExitGraceful:
+		return "graceful"
+	case ExitTimeout:
+		return "timeout"
+	case ExitBanned:
+		return "banned"
+	case ExitStale:
+		return "stale"
+	case ExitEvicted:
+		return "evicted"
+	}
+	return fmt.Sprintf("ExitReason(%d)", byte(r))
+}
+
+// ExitReasonHandler is an interface that an Application can optionally fulfill to learn why Nodes leave the Cluster.
+//
+// OnNodeExitWithReason is called instead of OnNodeExit for Applications that fulfill ExitReasonHandler. It receives the Node that was removed from the state tables, and the reason it was removed.
+type ExitReasonHandler interface {
+	OnNodeExitWithReason(node Node, reason ExitReason)
+}
+
+// RejectedConnectionHandler is an interface that an Application can optionally fulfill to be notified when the Cluster refuses an inbound connection.
+//
+// OnRejectedConnection is called when a connection is closed without being read because it exceeded the limits set by SetAcceptRateLimit. It receives the address the connection came from.
+type RejectedConnectionHandler interface {
+	OnRejectedConnection(addr net.Addr)
+}
+
+// JoinedHandler is an interface that an Application can optionally fulfill to be notified when the Node has joined the Cluster.
+//
+// OnJoined is called once, after the Node has populated its state tables and announced its presence to the Cluster. From then on, the Node can begin serving traffic.
+type JoinedHandler interface {
+	OnJoined()
+}
+
+// JoinRequestHandler is an interface that an Application can optionally fulfill to decide which Nodes may join the Cluster through the current Node, beyond checking their Credentials.
+//
+// OnJoinRequest is called when a join message reaches the current Node, after its Credentials have been checked, and before any state tables are sent. It receives the joining Node and the Credentials its join message was sent with, and returns false to refuse the join; the message is then dropped, and counted in Stats. It is called synchronously, on every Node the join message is routed through, so it should return quickly. If it panics, the join is refused.
+type JoinRequestHandler interface {
+	OnJoinRequest(node Node, credentials []byte) bool
+}
+
+// HandoffHandler is an interface that an Application can optionally fulfill to hand off the keys it is responsible for before the Node leaves the Cluster.
+//
+// OnHandoff is called by Stop, before the other Nodes are told of the exit. It receives the ranges of keys the current Node is closest to, each with the Node that will be closest to them once it has left, and should return once the data stored under those keys has been handed to their new owners. Stop waits for OnHandoff to return, for up to the drain timeout; ctx is canceled when that runs out. An error returned by OnHandoff is passed to OnError.
+type HandoffHandler interface {
+	OnHandoff(ctx context.Context, ranges []KeyRange) error
+}
+
 // Credentials is an interface that can be fulfilled to limit access to the Cluster.
 type Credentials interface {
 	Valid([]byte) bool
 	Marshal() []byte
 }
 
+// MessageCredentials is an interface that Credentials can optionally fulfill to authenticate each Message, rather than sending the same bytes with every one. When the Cluster's Credentials fulfill it, SignMessage is called for each Message the current Node sends, just before it's sent, and its result is sent as the Message's Credentials; each Message received is passed to VerifyMessage instead of Valid. Messages forwarded for other Nodes keep their Sender's Credentials.
+type MessageCredentials interface {
+	Credentials
+	SignMessage(msg Message) []byte
+	VerifyMessage(msg Message, supplied []byte) bool
+}
+
+// ConnCredentials is an interface that Credentials can optionally fulfill to grant access to the Cluster based on the connection a Message arrived over, such as the certificate presented when it was established. When the Cluster's Credentials fulfill it, each Message received is refused unless ValidConn returns true for the connection it was read from, as well as its Credentials being valid.
+type ConnCredentials interface {
+	Credentials
+	ValidConn(conn net.Conn) bool
+}
+
+// CredentialContext describes a Message whose Credentials are being checked: where it came from, who it claims to be from, and what it's for.
+type CredentialContext struct {
+	RemoteAddr net.Addr // the address of the connection the Message was read from, which is the last Node to forward it
+	NodeID     NodeID   // the ID of the Message's Sender, as it claims it
+	Purpose    byte
+}
+
+// ContextCredentials is an interface that Credentials can optionally fulfill to decide whether to grant access based on more than the Credentials sent, such as allowing only certain IP addresses, or requiring a different secret for each purpose. When the Cluster's Credentials fulfill it, each Message received is passed to ValidContext, with the Credentials it was sent with, instead of Valid. If they also fulfill MessageCredentials or ConnCredentials, those checks must pass too; Credentials that send a different secret for each purpose can pick it in SignMessage.
+type ContextCredentials interface {
+	Credentials
+	ValidContext(ctx CredentialContext, supplied []byte) bool
+}
+
 // Passphrase is an implementation of Credentials that grants access to the Cluster if the Node has the same Passphrase set
 type Passphrase string
 
@@ -57,6 +148,8 @@ func (p Passphrase) Marshal() []byte {
 var deadNodeError = errors.New("Node did not respond to heartbeat.")
 var nodeNotFoundError = errors.New("Node not found.")
 var impossibleError = errors.New("This error should never be reached. It's logically impossible.")
+var noInterfaceAddressError = errors.New("Network interface has no usable address.")
+var noSeedsError = errors.New("No seed addresses to join through.")
 
 // IdentityError represents an error that was raised when a Node attempted to perform actions on its state tables using its own ID, which is problematic. It is its own type for the purposes of handling the error.
 type IdentityError struct {
@@ -78,6 +171,46 @@ func throwIdentityError(action, prep, container string) IdentityError {
 	}
 }
 
+// DrainError represents an error that is raised when Stop's drain timeout elapses before the Cluster's work is finished. It is its own type for the purposes of handling the error, and records how much work was abandoned.
+type DrainError struct {
+	Handlers int // inbound connections that were still being handled
+	Sends    int // queued Messages that hadn't been sent
+	Exits    int // Nodes that weren't told of the exit
+	Handoffs int // OnHandoff calls that failed or hadn't returned
+}
+
+// Error returns the DrainError as a string and fulfills the error interface.
+func (e DrainError) Error() string {
+	return fmt.Sprintf("DrainError: Stopped with %d handlers, %d queued sends, %d handoffs, and %d exit notices unfinished.", e.Handlers, e.Sends, e.Handoffs, e.Exits)
+}
+
+// RoutingError represents an error that is raised when a Message is dropped instead of being forwarded, because it has taken more hops than the limit set with SetMaxHops, or because it came back to a Node that had already forwarded it. It is its own type for the purposes of handling the error.
+type RoutingError struct {
+	Key     NodeID // the Message's key
+	Purpose byte   // the Message's purpose
+	Hop     int    // the number of hops the Message had taken
+	Loop    bool   // true if the Message came back to the Node, false if it exceeded the hop limit
+}
+
+// Error returns the RoutingError as a string and fulfills the error interface.
+func (e RoutingError) Error() string {
+	if e.Loop {
+		return fmt.Sprintf("RoutingError: Message %s with purpose %d came back after %d hops.", e.Key, e.Purpose, e.Hop)
+	}
+	return fmt.Sprintf("RoutingError: Message %s with purpose %d exceeded the hop limit after %d hops.", e.Key, e.Purpose, e.Hop)
+}
+
+// MulticastError represents an error that is raised when a Message sent with Multicast couldn't be delivered to some of the Nodes it was sent to. It is its own type for the purposes of handling the error, and records why each of those Nodes missed the Message.
+type MulticastError struct {
+	Failed    map[NodeID]error // the Nodes the Message wasn't delivered to, and why
+	Delivered int              // the number of Nodes the Message was delivered to
+}
+
+// Error returns the MulticastError as a string and fulfills the error interface.
+func (e MulticastError) Error() string {
+	return fmt.Sprintf("MulticastError: Message wasn't delivered to %d of %d Nodes.", len(e.Failed), len(e.Failed)+e.Delivered)
+}
+
 // InvalidArgumentError represents an error that is raised when arguments that are invalid are passed to a function that depends on those arguments. It is its own type for the purposes of handling the error.
 type InvalidArgumentError string
 
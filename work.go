@@ -0,0 +1,87 @@
+package wendy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// maxIDDifficulty is the most leading zero bits SetIDDifficulty can require, which would already take far too long to generate.
+const maxIDDifficulty = 64
+
+// idProofDomain is hashed with every proof, so IDs earned for Wendy can't be reused from other proofs of work.
+const idProofDomain = "wendy node id"
+
+// GenerateNodeID does the work of earning a NodeID for a Cluster that requires it with SetIDDifficulty. It tries random proofs until it finds one whose work meets difficulty, which takes about 2^difficulty hashes, and returns the ID derived from it, along with the proof, which must be set as the Node's Proof.
+func GenerateNodeID(difficulty int) (NodeID, []byte, error) {
+	if difficulty < 0 || difficulty > maxIDDifficulty {
+		return NodeID{}, nil, throwInvalidArgumentError("The difficulty must be between 0 and 64 bits.")
+	}
+	proof := make([]byte, 24)
+	_, err := rand.Read(proof[:16])
+	if err != nil {
+		return NodeID{}, nil, err
+	}
+	for counter := uint64(0); ; counter++ {
+		binary.BigEndian.PutUint64(proof[16:], counter)
+		if proofWork(proof) >= difficulty {
+			return NodeIDFromProof(proof), proof, nil
+		}
+	}
+}
+
+// NodeIDFromProof derives the NodeID a proof of work earned, as the first 16 bytes of the proof's SHA-256 hash.
+func NodeIDFromProof(proof []byte) NodeID {
+	sum := proofHash(proof)
+	id, _ := NodeIDFromBytes(sum[:])
+	return id
+}
+
+func proofHash(proof []byte) [sha256.Size]byte {
+	return sha256.Sum256(append([]byte(idProofDomain), proof...))
+}
+
+// proofWork returns the number of leading zero bits in the SHA-256 hash of the proof's hash, which is how much work it took to find. It's the hash of the hash, so the IDs themselves aren't clustered in one corner of the keyspace.
+func proofWork(proof []byte) int {
+	sum := proofHash(proof)
+	work := sha256.Sum256(sum[:])
+	zeros := 0
+	for _, b := range work {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// SetIDDifficulty requires every Node in the Cluster to have earned its NodeID with proof of work, raising the cost of Sybil attacks, where an attacker floods the Cluster with Nodes, or picks IDs to surround a key. Each Node's ID must be generated with GenerateNodeID, using at least difficulty, and the proof it returns set as the Node's Proof. Each bit of difficulty doubles the work of generating an ID, which is only done once, while checking one takes two hashes. Join messages from Nodes whose IDs don't meet the difficulty are refused, and counted in Stats, and such Nodes are never inserted into the state tables. Every Node should set the same difficulty. A difficulty of 0, the default, doesn't check Nodes' IDs.
+//
+// IDs earned with proof of work can't be derived from a signing key, so SetIDDifficulty can't be combined with SetSigningKey. Virtual Nodes need IDs earned with proofs of their own.
+func (c *Cluster) SetIDDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > maxIDDifficulty {
+		return throwInvalidArgumentError("The difficulty must be between 0 and 64 bits.")
+	}
+	if !c.self.hasWork(difficulty) {
+		return throwInvalidArgumentError("The Node's ID must be generated with GenerateNodeID, with its Proof set, to meet the difficulty.")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.idDifficulty = difficulty
+	return nil
+}
+
+func (c *Cluster) getIDDifficulty() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.idDifficulty
+}
+
+// hasWork returns true if the Node's ID was derived from its Proof, and the Proof's work meets difficulty, or if difficulty is 0.
+func (self Node) hasWork(difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+	return len(self.Proof) > 0 && NodeIDFromProof(self.Proof).Equals(self.ID) && proofWork(self.Proof) >= difficulty
+}
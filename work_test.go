@@ -0,0 +1,133 @@
+package wendy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func makeWorkedCluster(difficulty int) (*Cluster, error) {
+	id, proof, err := GenerateNodeID(difficulty)
+	if err != nil {
+		return nil, err
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 0)
+	node.Proof = proof
+	cluster := NewCluster(node, nil)
+	cluster.SetHeartbeatFrequency(10)
+	cluster.SetNetworkTimeout(1)
+	cluster.SetLogLevel(LogLevelDebug)
+	return cluster, cluster.SetIDDifficulty(difficulty)
+}
+
+// Test that generated IDs meet the difficulty, and only match their own proof
+func TestGenerateNodeID(t *testing.T) {
+	if _, _, err := GenerateNodeID(-1); err == nil {
+		t.Errorf("Expected an error generating an ID with a negative difficulty.")
+	}
+	id, proof, err := GenerateNodeID(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !NodeIDFromProof(proof).Equals(id) {
+		t.Errorf("Expected the ID to be derived from the proof.")
+	}
+	if work := proofWork(proof); work < 8 {
+		t.Errorf("Expected at least 8 bits of work, got %d.", work)
+	}
+	node := NewNode(id, "127.0.0.1", "127.0.0.1", "testing", 0)
+	if node.hasWork(8) {
+		t.Errorf("Expected a Node without its Proof not to meet the difficulty.")
+	}
+	node.Proof = proof
+	if !node.hasWork(8) || !node.hasWork(0) {
+		t.Errorf("Expected the Node to meet the difficulty it was generated with.")
+	}
+	if node.hasWork(64) {
+		t.Errorf("Expected the Node not to meet a far higher difficulty.")
+	}
+	other, _, err := GenerateNodeID(0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	node.ID = other
+	if node.hasWork(8) {
+		t.Errorf("Expected a Node with someone else's Proof not to meet the difficulty.")
+	}
+}
+
+// Test that the difficulty can only be set if the current Node's ID meets it, and that Nodes that don't aren't inserted
+func TestClusterSetIDDifficulty(t *testing.T) {
+	cluster, err := makeCluster("this is a test Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := cluster.SetIDDifficulty(8).(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a Node whose ID wasn't generated.")
+	}
+	worked, err := makeWorkedCluster(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, ok := worked.SetIDDifficulty(maxIDDifficulty + 1).(InvalidArgumentError); !ok {
+		t.Errorf("Expected an InvalidArgumentError for a difficulty that's too high.")
+	}
+	err = worked.insert(*cluster.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = worked.get(cluster.self.ID); err != nodeNotFoundError {
+		t.Errorf("Expected a Node whose ID doesn't meet the difficulty not to be inserted, got %v.", err)
+	}
+	other, err := makeWorkedCluster(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	err = worked.insert(*other.self, StateMask{Mask: all})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err = worked.get(other.self.ID); err != nil {
+		t.Errorf("Expected a Node whose ID meets the difficulty to be inserted, got %v.", err)
+	}
+}
+
+// Test that Nodes whose IDs meet the difficulty can join, and others are refused
+func TestClusterIDDifficultyJoin(t *testing.T) {
+	if testing.Short() {
+		return
+	}
+	one, err := makeWorkedCluster(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	two, err := makeWorkedCluster(8)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	three, err := makeCluster("this is a third Node for testing purposes only.")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, cluster := range []*Cluster{one, two, three} {
+		go cluster.Listen()
+		defer cluster.Kill()
+	}
+	waitListening(t, one, two, three)
+	err = three.Join(one.self.LocalIP, one.self.Port)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Duration(one.getNetworkTimeout())*time.Second)
+	defer cancel()
+	err = two.JoinAndWait(ctx, []string{two.GetIP(*one.self)})
+	if err != nil {
+		t.Fatalf("Expected the Node whose ID meets the difficulty to join, got %v.", err)
+	}
+	if three.isJoined() {
+		t.Errorf("Expected the Node whose ID doesn't meet the difficulty not to join.")
+	}
+	if refused := one.Stats().InsufficientWork; refused != 1 {
+		t.Errorf("Expected 1 join refused for insufficient work, got %d.", refused)
+	}
+}